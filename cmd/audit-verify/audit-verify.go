@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/version"
+)
+
+var (
+	fileFlag    = flag.String("file", "audit.log", "Path to the audit log file.")
+	versionFlag = flag.Bool("version", false, "Show version information.")
+)
+
+// Checks the hash chain of an audit log, the exit code is 1 when the log is
+// edited, has missing entries or can not be read
+func main() {
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Fprintln(os.Stdout, version.Print("tcp-message-audit-verify"))
+		os.Exit(0)
+	}
+
+	f, err := os.Open(*fileFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	count, err := audit.Verify(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit log is not valid after %d entries: %v\n", count, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "audit log is valid: %d entries\n", count)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/bot"
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk"
+	"github.com/Selahattinn/picus-tcp-message/pkg/version"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	addrFlag      = flag.String("addr", "", "Address of the server.")
+	nameFlag      = flag.String("name", "echo-bot", "Name of the bot.")
+	prefixFlag    = flag.String("prefix", bot.DefaultPrefix, "Prefix of the commands of the bot.")
+	tlsFlag       = flag.Bool("tls", false, "Connect to the server over TLS.")
+	caFlag        = flag.String("tls.ca", "", "Path to the CA certificate of the server, system roots are used when empty.")
+	heartbeatFlag = flag.Duration("heartbeat", 15*time.Second, "Interval of pings sent to the server, disabled when 0.")
+	versionFlag   = flag.Bool("version", false, "Show version information.")
+)
+
+// tlsConfig returns the TLS configuration of the flags, nil without TLS
+func tlsConfig() (*tls.Config, error) {
+	if !*tlsFlag {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if *caFlag != "" {
+		pem, err := ioutil.ReadFile(*caFlag)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + *caFlag)
+		}
+	}
+	return cfg, nil
+}
+
+// Runs an example bot, which answers the echo, ping and time commands
+// until it is interrupted
+func main() {
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Fprintln(os.Stdout, version.Print("tcp-message-bot"))
+		os.Exit(0)
+	}
+	if *addrFlag == "" {
+		fmt.Fprintln(os.Stderr, "Pls write a server adress\nExample:\n\t-addr localhost:8080")
+		os.Exit(1)
+	}
+	tlsCfg, err := tlsConfig()
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not load the TLS configuration")
+	}
+
+	b := bot.New(bot.Config{
+		Addr:   *addrFlag,
+		Name:   *nameFlag,
+		Prefix: *prefixFlag,
+		SDK:    &sdk.Config{TLS: tlsCfg, Heartbeat: *heartbeatFlag},
+	})
+	b.Handle("echo", "repeat the arguments", func(ctx context.Context, m *bot.Message) error {
+		if len(m.Args) == 0 {
+			return errors.New("nothing to repeat")
+		}
+		return m.Reply(ctx, strings.Join(m.Args, " "))
+	})
+	b.Handle("ping", "check the bot is alive", func(ctx context.Context, m *bot.Message) error {
+		return m.Reply(ctx, "pong")
+	})
+	b.Handle("time", "show the time of the bot", func(ctx context.Context, m *bot.Message) error {
+		return m.Reply(ctx, time.Now().Format(time.RFC3339))
+	})
+	b.HandleMessage(func(ctx context.Context, m *bot.Message) error {
+		return m.Replyf(ctx, "I am a bot, try %shelp", *prefixFlag)
+	})
+
+	// leave the server on interrupt
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	logrus.WithFields(logrus.Fields{"addr": *addrFlag, "name": *nameFlag}).Info("running bot...")
+	if err := b.Run(ctx); err != nil {
+		logrus.WithError(err).Fatal("Bot stopped")
+	}
+	logrus.Info("bot stopped")
+}
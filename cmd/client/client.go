@@ -2,106 +2,322 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
-	"net"
+	"math/rand"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk"
 )
 
 const (
 	MSG_DISCONNECT = "Disconnected from the server.\n"
+
+	// limit of a command, including the time the server needs to answer it
+	requestTimeout = 30 * time.Second
 )
 
 var (
-	wg       sync.WaitGroup
-	addrFlag = flag.String("addr", "", "Show debug information.")
-	NameFlag = flag.String("name", "", "Path to the log file.")
+	wg                   sync.WaitGroup
+	addrFlag             = flag.String("addr", "", "Show debug information.")
+	NameFlag             = flag.String("name", "", "Path to the log file.")
+	tlsFlag              = flag.Bool("tls", false, "Connect to the server over TLS.")
+	caFlag               = flag.String("tls.ca", "", "Path to the CA certificate of the server, system roots are used when empty.")
+	tuiFlag              = flag.Bool("tui", false, "Run the full-screen terminal UI.")
+	heartbeatFlag        = flag.Duration("heartbeat", 15*time.Second, "Interval of pings sent to the server, disabled when 0.")
+	heartbeatTimeoutFlag = flag.Duration("heartbeat.timeout", 45*time.Second, "Time without any line from the server before the connection is considered lost.")
+
+	// console is where the client prints its output, the message pane of the terminal UI when it runs
+	console io.Writer = os.Stdout
+
+	// ui is the terminal UI, nil when the client reads lines from stdin
+	ui *tui
 )
 
-// Reads from the socket and outputs to the console.
-func Read(conn net.Conn) {
-	flag.Parse()
-	reader := bufio.NewReader(conn)
-	for {
-		str, err := reader.ReadString('\n')
+// exit stops the terminal UI, so the terminal is usable again, and exits
+func exit(code int) {
+	if ui != nil {
+		ui.app.Stop()
+	}
+	os.Exit(code)
+}
+
+// fatal prints a message once the terminal UI is stopped and exits
+func fatal(msg string) {
+	if ui != nil {
+		ui.app.Stop()
+	}
+	fmt.Println(msg)
+	os.Exit(1)
+}
+
+// config returns the configuration of the connection from the flags
+func config() (*sdk.Config, error) {
+	cfg := &sdk.Config{
+		Heartbeat:        *heartbeatFlag,
+		HeartbeatTimeout: *heartbeatTimeoutFlag,
+	}
+	if !*tlsFlag {
+		return cfg, nil
+	}
+	cfg.TLS = &tls.Config{}
+	if *caFlag != "" {
+		pem, err := ioutil.ReadFile(*caFlag)
 		if err != nil {
-			fmt.Printf(MSG_DISCONNECT)
+			return nil, err
+		}
+		cfg.TLS.RootCAs = x509.NewCertPool()
+		if !cfg.TLS.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + *caFlag)
+		}
+	}
+	return cfg, nil
+}
+
+// dial connects to the server, over TLS when it is requested
+func dial() (*sdk.Client, error) {
+	cfg, err := config()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return sdk.Dial(ctx, *addrFlag, cfg)
+}
+
+// link holds the connection to the server, which is replaced after a reconnect
+type link struct {
+	mu sync.Mutex
+	// nil while the client is reconnecting
+	c *sdk.Client
+	// lines typed while the client was reconnecting
+	pending []string
+}
+
+// current returns the connection, nil while the client is reconnecting
+func (l *link) current() *sdk.Client {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.c
+}
+
+// setClient swaps the connection, lines typed meanwhile are sent on the new one
+func (l *link) setClient(c *sdk.Client) {
+	l.mu.Lock()
+	l.c = c
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if c == nil {
+		return
+	}
+	for _, line := range pending {
+		l.Send(line)
+	}
+}
+
+// Send sends a line typed by the user and prints the answer of the server,
+// it is kept and sent later when the connection is down
+func (l *link) Send(line string) {
+	l.mu.Lock()
+	c := l.c
+	if c == nil {
+		l.pending = append(l.pending, line)
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	events, err := c.Exec(ctx, line)
+	for _, e := range events {
+		show(l, e)
+	}
+	if err != nil && c.Err() == nil {
+		fmt.Fprintln(console, err)
+	}
+}
+
+// Exec sends a line and returns the answer of the server without printing it,
+// used by file transfers which need the id the server gives an offer
+func (l *link) Exec(line string) ([]sdk.Event, error) {
+	c := l.current()
+	if c == nil {
+		return nil, errOffline
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return c.Exec(ctx, line)
+}
+
+// WriteLine writes a line without waiting for its answer, used by file transfers
+func (l *link) WriteLine(line string) error {
+	c := l.current()
+	if c == nil {
+		return errOffline
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return c.Post(ctx, line)
+}
+
+// show prints an event of the server, lines of file transfers and sessions are handled silently
+func show(l *link, e sdk.Event) {
+	switch e.Type {
+	case client.EventSession:
+		sess.set(e.Name, e.Token)
+		return
+	case client.EventUsers:
+		if ui != nil {
+			ui.setUsers(e.Users)
+		}
+	case client.EventText:
+		if strings.HasPrefix(e.Text, "/file-") && handleFileControl(l, e.Text) {
+			return
+		}
+		if isFarewell(e.Text) {
+			// the server ended the session, do not come back
+			sess.close()
+		}
+	}
+	fmt.Fprint(console, "> "+e.String()+"\n")
+}
+
+// Reads events of the server and outputs them to the console.
+func Read(l *link) {
+	for {
+		c := l.current()
+		for e := range c.Events() {
+			show(l, e)
+		}
+		if sess.isClosing() {
+			fmt.Fprintf(console, MSG_DISCONNECT)
 			wg.Done()
 			return
 		}
-		if str == "> There is a user which is used for this name. Please choose another name\n" {
-			fmt.Println("There is a user in server which is using same name with you.\nPlease choose another name.")
-			os.Exit(1)
+		// the connection dropped, resume the session on a new one
+		if ui != nil {
+			ui.setConnected(false)
+		}
+		l.setClient(nil)
+		c.Close()
+		fmt.Fprintf(console, MSG_RECONNECTING)
+		reconnect(l)
+		if ui != nil {
+			ui.setConnected(true)
 		}
-		fmt.Print(str)
 	}
 }
 
 // Reads from Stdin, and outputs to the socket.
-func Write(conn net.Conn) {
+func Write(l *link) {
 	reader := bufio.NewReader(os.Stdin)
-	writer := bufio.NewWriter(conn)
 
 	for {
 		str, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fatal(err.Error())
 		}
+		handleInput(l, strings.TrimRight(str, "\r\n"))
+	}
+}
 
-		_, err = writer.WriteString(str)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+// handleInput sends a line typed by the user
+func handleInput(l *link, str string) {
+	args := strings.Split(str, " ")
+
+	// file commands are prepared by the client before reaching the server
+	switch args[0] {
+	case "/send-file":
+		if len(args) < 2 {
+			fmt.Fprintln(console, "usage: /send-file <path>")
+			return
 		}
-		err = writer.Flush()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		if err := offerFile(l, strings.Join(args[1:], " ")); err != nil {
+			fmt.Fprintln(console, "unable to send file:", err)
+		}
+		return
+	case "/file-accept":
+		if err := acceptFile(l, args); err != nil {
+			fmt.Fprintln(console, "unable to accept file:", err)
+		}
+		return
+	case "/join":
+		if len(args) == 2 && join(l, args[1]) {
+			return
+		}
+	case "/quit":
+		// leaving on purpose, the session is not resumed
+		sess.close()
+	case "/file-decline":
+		if len(args) == 2 {
+			if id, err := strconv.ParseInt(args[1], 10, 64); err == nil {
+				transferMu.Lock()
+				delete(incomingFiles, id)
+				transferMu.Unlock()
+			}
 		}
 	}
-}
-func sendName(conn net.Conn, name string) error {
-	writer := bufio.NewWriter(conn)
 
-	str := "/name " + name + "\n"
+	l.Send(str)
+}
 
-	_, err := writer.WriteString(str)
-	if err != nil {
-		return err
+// join chooses the contact, it returns false when the line should be sent as it is
+func join(l *link, name string) bool {
+	c := l.current()
+	if c == nil {
+		return false
 	}
-	err = writer.Flush()
-	if err != nil {
-		return err
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	err := c.Join(ctx, name)
+	var refused *sdk.Error
+	switch {
+	case err == nil:
+		fmt.Fprint(console, "> You are now talking to :"+name+"\n")
+		if ui != nil {
+			ui.setContact(name)
+		}
+	case errors.As(err, &refused):
+		fmt.Fprint(console, "> "+refused.Text+"\n")
+	case c.Err() == nil:
+		fmt.Fprintln(console, err)
 	}
-	return nil
+	return true
 }
 
-func quit(conn net.Conn) error {
-	writer := bufio.NewWriter(conn)
-
-	str := "/quit\n"
-
-	_, err := writer.WriteString(str)
-	if err != nil {
-		return err
+func quit(l *link) error {
+	sess.close()
+	c := l.current()
+	if c == nil {
+		return errOffline
 	}
-	err = writer.Flush()
-	if err != nil {
-		return err
-	}
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return c.Quit(ctx)
 }
 
 // Starts up a read and write thread which connect to the server through the
 // a socket connection.
 func main() {
 	flag.Parse()
-	wg.Add(2)
+	rand.Seed(time.Now().UnixNano())
+	wg.Add(1)
 	if *NameFlag == "" {
 		fmt.Println("Pls write a name\nExample:\n\t-name Selahattin")
 		os.Exit(1)
@@ -110,22 +326,38 @@ func main() {
 		fmt.Println("Pls write a server adress\nExample:\n\t-addr localhost:8080\n\t-addr :8080\n\t-addr 127.0.0.1:8080")
 		os.Exit(1)
 	}
-	conn, err := net.Dial("tcp", *addrFlag)
+	c, err := dial()
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if err := setName(c, *NameFlag); err != nil {
+		log.Fatalln(err)
+	}
+	l := &link{}
+	l.setClient(c)
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		go func() {
 			<-c
-			quit(conn)
-			os.Exit(1)
+			quit(l)
+			exit(1)
 		}()
 	}()
-	go Read(conn)
-	go Write(conn)
-	sendName(conn, *NameFlag)
+	if *tuiFlag {
+		ui = newTUI(l)
+		console = ui
+	}
+	go Read(l)
+	if ui != nil {
+		ui.setConnected(true)
+		if err := ui.run(); err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf(MSG_DISCONNECT)
+		return
+	}
+	go Write(l)
 	wg.Wait()
 
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk"
+)
+
+const (
+	MSG_RECONNECTING = "Connection lost, reconnecting...\n"
+	MSG_RECONNECTED  = "Reconnected.\n"
+
+	// backoff between reconnect attempts
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+)
+
+var errOffline = errors.New("not connected to the server")
+
+// sessionState keeps the name and token the server issued,
+// so a dropped connection can resume the same session
+type sessionState struct {
+	mu    sync.Mutex
+	name  string
+	token string
+	// set when the client leaves on purpose, no reconnect is tried then
+	closing bool
+}
+
+var sess = &sessionState{}
+
+func (s *sessionState) set(name string, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+	s.token = token
+}
+
+func (s *sessionState) get() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name, s.token
+}
+
+func (s *sessionState) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+}
+
+func (s *sessionState) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// isFarewell reports whether a line of the server ends the session
+func isFarewell(line string) bool {
+	return line == "We will miss you..." ||
+		strings.HasPrefix(line, "You are kicked from the server") ||
+		strings.HasPrefix(line, "You are banned from this server")
+}
+
+// setName chooses the name on a new connection and keeps the session the server issued
+func setName(c *sdk.Client, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	err := c.SetName(ctx, name)
+	if err == sdk.ErrNameTaken {
+		fatal("There is a user in server which is using same name with you.\nPlease choose another name.")
+	}
+	if err != nil {
+		return err
+	}
+	sess.set(c.Session())
+	return nil
+}
+
+// resume resumes the session on a new connection,
+// the name is chosen again when the session expired
+func resume(c *sdk.Client) error {
+	name, token := sess.get()
+	if token == "" {
+		return setName(c, name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	err := c.Resume(ctx, name, token)
+	if _, refused := err.(*sdk.Error); refused {
+		fmt.Fprintln(console, "Session expired, joining again as "+name+".")
+		return setName(c, name)
+	}
+	if err != nil {
+		return err
+	}
+	sess.set(c.Session())
+	return nil
+}
+
+// reconnect dials the server until it succeeds, waiting longer after each failure,
+// then resumes the session on the new connection
+func reconnect(l *link) {
+	delay := reconnectMinDelay
+	for {
+		// jitter keeps clients from reconnecting all at once after a restart
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+		c, err := dial()
+		if err == nil {
+			if err = resume(c); err == nil {
+				fmt.Fprint(console, MSG_RECONNECTED)
+				l.setClient(c)
+				return
+			}
+			c.Close()
+		}
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
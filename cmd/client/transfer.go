@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk"
+)
+
+// chunkSize must not exceed the chunk size accepted by the server
+const chunkSize = 2048
+
+var downloadDirFlag = flag.String("download.dir", ".", "Directory which received files are saved to.")
+
+// structure of a file which is being sent
+type outgoing struct {
+	path     string
+	name     string
+	size     int64
+	hash     string
+	canceled bool
+	// increased when the transfer is paused or accepted again,
+	// so the chunks of an earlier accept stop
+	gen int
+}
+
+// structure of a file which is being received
+type incoming struct {
+	from     string
+	name     string
+	size     int64
+	hash     string
+	file     *os.File
+	received int64
+}
+
+var (
+	transferMu sync.Mutex
+
+	// held while an offer waits for its transfer id,
+	// so the answer of the recipient is handled once the offer is known
+	offersMu sync.Mutex
+
+	// transfer id (key) & file (value)
+	outgoingFiles = make(map[int64]*outgoing)
+	incomingFiles = make(map[int64]*incoming)
+)
+
+// hashFile returns the hex encoded SHA-256 sum of a file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// partPath returns where a partially received file is kept :
+// it is named after the file hash, so a transfer can be resumed after reconnect
+func partPath(hash string) string {
+	return filepath.Join(*downloadDirFlag, hash+".part")
+}
+
+// offerFile sends an offer for a local file to the current contact
+func offerFile(out *link, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return fmt.Errorf("%s is not a regular non-empty file", path)
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	o := &outgoing{
+		path: path,
+		name: filepath.Base(path),
+		size: info.Size(),
+		hash: hash,
+	}
+
+	offersMu.Lock()
+	events, err := out.Exec(fmt.Sprintf("/file-offer %d %s %s", o.size, o.hash, o.name))
+	var others []sdk.Event
+	for _, e := range events {
+		id, ok := offeredID(e)
+		if !ok {
+			others = append(others, e)
+			continue
+		}
+		transferMu.Lock()
+		outgoingFiles[id] = o
+		transferMu.Unlock()
+		fmt.Fprintf(console, "offered %s, waiting for the recipient\n", o.name)
+	}
+	offersMu.Unlock()
+	for _, e := range others {
+		show(out, e)
+	}
+	return err
+}
+
+// offeredID returns the transfer id of a /file-offered answer
+func offeredID(e sdk.Event) (int64, bool) {
+	args := strings.Split(e.Text, " ")
+	if e.Type != client.EventText || len(args) != 3 || args[0] != "/file-offered" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	return id, err == nil
+}
+
+// acceptFile accepts an offered file, resuming from a partial file if there is one
+func acceptFile(out *link, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: /file-accept <id>")
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid file id: %s", args[1])
+	}
+	transferMu.Lock()
+	in, ok := incomingFiles[id]
+	transferMu.Unlock()
+	if !ok {
+		return fmt.Errorf("no such file offer: %d", id)
+	}
+
+	f, err := os.OpenFile(partPath(in.hash), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	offset := info.Size()
+	if offset > in.size {
+		offset = 0
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	transferMu.Lock()
+	in.file = f
+	in.received = offset
+	transferMu.Unlock()
+	if offset > 0 {
+		fmt.Fprintf(console, "resuming %s at %d of %d bytes\n", in.name, offset, in.size)
+	}
+	return out.WriteLine(fmt.Sprintf("/file-accept %d %d", id, offset))
+}
+
+// sendFile streams an accepted file to the server in chunks,
+// until it is sent or the accept of gen is paused or canceled
+func sendFile(out *link, id int64, o *outgoing, offset int64, gen int) {
+	f, err := os.Open(o.path)
+	if err != nil {
+		fmt.Fprintln(console, "unable to send file:", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		fmt.Fprintln(console, "unable to send file:", err)
+		return
+	}
+
+	buf := make([]byte, chunkSize)
+	progress := -1
+	for offset < o.size {
+		transferMu.Lock()
+		canceled, paused := o.canceled, o.gen != gen
+		transferMu.Unlock()
+		if canceled {
+			fmt.Fprintf(console, "\nsending %s canceled\n", o.name)
+			return
+		}
+		if paused {
+			return
+		}
+
+		n, err := f.Read(buf)
+		if n > 0 {
+			line := fmt.Sprintf("/file-chunk %d %d %s", id, offset, base64.StdEncoding.EncodeToString(buf[:n]))
+			if err := out.WriteLine(line); err != nil {
+				fmt.Fprintln(console, "unable to send file:", err)
+				return
+			}
+			offset += int64(n)
+			progress = printProgress("sending", o.name, offset, o.size, progress)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(console, "unable to send file:", err)
+			return
+		}
+	}
+	// the server confirms the file with /file-sent
+	if err := out.WriteLine(fmt.Sprintf("/file-done %d", id)); err != nil {
+		fmt.Fprintln(console, "unable to send file:", err)
+	}
+}
+
+// printProgress prints transfer percentage when it changes
+func printProgress(action string, name string, done int64, size int64, last int) int {
+	percent := int(done * 100 / size)
+	if percent != last {
+		// the terminal UI shows progress in its status bar
+		if ui != nil {
+			ui.setProgress(fmt.Sprintf("%s %s: %d%%", action, name, percent))
+		} else {
+			fmt.Fprintf(console, "\r%s %s: %d%%", action, name, percent)
+		}
+	}
+	return percent
+}
+
+// receiveChunk writes a received chunk to the partial file
+func receiveChunk(id int64, offset int64, data string) {
+	transferMu.Lock()
+	defer transferMu.Unlock()
+	in, ok := incomingFiles[id]
+	if !ok || in.file == nil {
+		return
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || offset != in.received || in.received+int64(len(b)) > in.size {
+		fmt.Fprintf(console, "\ninvalid chunk received for %s\n", in.name)
+		return
+	}
+	if _, err := in.file.Write(b); err != nil {
+		fmt.Fprintf(console, "\nunable to write %s: %v\n", in.name, err)
+		return
+	}
+	last := int(in.received * 100 / in.size)
+	in.received += int64(len(b))
+	printProgress("receiving", in.name, in.received, in.size, last)
+}
+
+// completeFile verifies the integrity of a received file and moves it in place
+func completeFile(id int64) {
+	transferMu.Lock()
+	in, ok := incomingFiles[id]
+	delete(incomingFiles, id)
+	transferMu.Unlock()
+	if !ok || in.file == nil {
+		return
+	}
+	in.file.Close()
+
+	part := partPath(in.hash)
+	hash, err := hashFile(part)
+	if err != nil || hash != in.hash {
+		os.Remove(part)
+		fmt.Fprintf(console, "\n%s from %s failed integrity check and was discarded\n", in.name, in.from)
+		return
+	}
+	target := filepath.Join(*downloadDirFlag, filepath.Base(in.name))
+	if _, err := os.Stat(target); err == nil {
+		target = filepath.Join(*downloadDirFlag, in.hash[:8]+"-"+filepath.Base(in.name))
+	}
+	if err := os.Rename(part, target); err != nil {
+		fmt.Fprintf(console, "\nunable to save %s: %v\n", in.name, err)
+		return
+	}
+	fmt.Fprintf(console, "\nreceived %s from %s, saved to %s\n", in.name, in.from, target)
+}
+
+// handleFileControl processes file transfer lines sent by the server,
+// it reports whether the line was handled
+func handleFileControl(out *link, line string) bool {
+	args := strings.Split(line, " ")
+	if len(args) < 2 || !strings.HasPrefix(args[0], "/file-") {
+		return false
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	// answers to an offer wait until offerFile knows its id
+	if args[0] == "/file-accepted" || args[0] == "/file-declined" || args[0] == "/file-error" {
+		offersMu.Lock()
+		offersMu.Unlock()
+	}
+
+	switch {
+	case args[0] == "/file-accepted" && len(args) == 3:
+		offset, err := strconv.ParseInt(args[2], 10, 64)
+		transferMu.Lock()
+		o, ok := outgoingFiles[id]
+		if ok {
+			o.gen++
+		}
+		transferMu.Unlock()
+		if ok && err == nil {
+			go sendFile(out, id, o, offset, o.gen)
+		}
+	case args[0] == "/file-sent":
+		transferMu.Lock()
+		o, ok := outgoingFiles[id]
+		delete(outgoingFiles, id)
+		transferMu.Unlock()
+		if ok {
+			fmt.Fprintf(console, "\n%s sent\n", o.name)
+		}
+	case args[0] == "/file-paused":
+		name := ""
+		transferMu.Lock()
+		if o, ok := outgoingFiles[id]; ok {
+			o.gen++
+			name = o.name
+		}
+		if in, ok := incomingFiles[id]; ok {
+			name = in.name
+		}
+		transferMu.Unlock()
+		fmt.Fprintf(console, "\n%s paused: %s\n", name, strings.Join(args[2:], " "))
+	case args[0] == "/file-declined":
+		transferMu.Lock()
+		o, ok := outgoingFiles[id]
+		if ok {
+			o.canceled = true
+			delete(outgoingFiles, id)
+		}
+		transferMu.Unlock()
+		if ok {
+			fmt.Fprintf(console, "%s was declined\n", o.name)
+		}
+	case args[0] == "/file-error":
+		transferMu.Lock()
+		if o, ok := outgoingFiles[id]; ok {
+			o.canceled = true
+			delete(outgoingFiles, id)
+		}
+		// the partial file is kept, sending the file again resumes it
+		if in, ok := incomingFiles[id]; ok {
+			if in.file != nil {
+				in.file.Close()
+			}
+			delete(incomingFiles, id)
+		}
+		transferMu.Unlock()
+		fmt.Fprintln(console, "file transfer error:", strings.Join(args[2:], " "))
+	case args[0] == "/file-offer" && len(args) >= 6:
+		size, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return false
+		}
+		transferMu.Lock()
+		previous, resumed := incomingFiles[id]
+		resumed = resumed && previous.file != nil
+		if resumed {
+			previous.file.Close()
+		}
+		incomingFiles[id] = &incoming{
+			from: args[2],
+			size: size,
+			hash: args[4],
+			name: strings.Join(args[5:], " "),
+		}
+		transferMu.Unlock()
+		// a paused file which was accepted continues from its partial file
+		if resumed {
+			if err := acceptFile(out, []string{"/file-accept", args[1]}); err != nil {
+				fmt.Fprintln(console, "unable to resume file:", err)
+			}
+		}
+	case args[0] == "/file-chunk" && len(args) == 4:
+		offset, err := strconv.ParseInt(args[2], 10, 64)
+		if err == nil {
+			receiveChunk(id, offset, args[3])
+		}
+	case args[0] == "/file-done":
+		completeFile(id)
+	default:
+		return false
+	}
+	return true
+}
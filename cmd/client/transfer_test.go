@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk"
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk/sdktest"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer collects the console output of go routines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// setupTransfer saves received files to a temporary directory and collects the console output
+func setupTransfer(t *testing.T) (string, *syncBuffer) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &syncBuffer{}
+	previousDir, previousConsole := *downloadDirFlag, console
+	*downloadDirFlag, console = dir, out
+	t.Cleanup(func() {
+		*downloadDirFlag, console = previousDir, previousConsole
+		os.RemoveAll(dir)
+	})
+	return dir, out
+}
+
+// newTestLink connects a named user to the server, its events are shown like the client does
+func newTestLink(t *testing.T, s *sdktest.Server, name string) *link {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := sdk.Dial(ctx, s.Addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the events are shown until the client is closed, before the test restores the console
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		c.Close()
+		<-done
+	})
+	l := &link{c: c}
+	go func() {
+		defer close(done)
+		for e := range c.Events() {
+			show(l, e)
+		}
+	}()
+	if err := c.SetName(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// offerTestFile lets the client know about an offered file
+func offerTestFile(t *testing.T, l *link, data string) {
+	line := fmt.Sprintf("/file-offer 1 Test %d %s hello.txt", len(data), hashString(data))
+	if !assert.True(t, handleFileControl(l, line)) {
+		t.FailNow()
+	}
+}
+
+// hashString returns the hash a file with data is offered with
+func hashString(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSendFile(t *testing.T) {
+	dir, out := setupTransfer(t)
+	s, err := sdktest.NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := newTestLink(t, s, "Test")
+	recipient := newTestLink(t, s, "Test2")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sender.current().Join(ctx, "Test2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is larger than a chunk
+	data := bytes.Repeat([]byte("0123456789abcdef"), chunkSize/8+3)
+	path := filepath.Join(dir, "sent.bin")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !assert.NoError(t, offerFile(sender, path)) {
+		return
+	}
+	assert.Contains(t, out.String(), "offered sent.bin, waiting for the recipient\n")
+	assert.Eventually(t, func() bool {
+		transferMu.Lock()
+		defer transferMu.Unlock()
+		_, ok := incomingFiles[1]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	// the recipient saves the file next to the sent one
+	if !assert.NoError(t, acceptFile(recipient, []string{"/file-accept", "1"})) {
+		return
+	}
+	assert.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "sent.bin sent\n")
+	}, 2*time.Second, 10*time.Millisecond, out.String())
+	assert.Eventually(t, func() bool {
+		received, err := ioutil.ReadFile(filepath.Join(dir, hashString(string(data))[:8]+"-sent.bin"))
+		return err == nil && bytes.Equal(data, received)
+	}, 2*time.Second, 10*time.Millisecond, out.String())
+}
+
+func TestReceiveFileHashMismatch(t *testing.T) {
+	dir, out := setupTransfer(t)
+	s, err := sdktest.NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newTestLink(t, s, "Test2")
+
+	offerTestFile(t, l, "hello")
+	assert.NoError(t, acceptFile(l, []string{"/file-accept", "1"}))
+	handleFileControl(l, "/file-chunk 1 0 "+base64.StdEncoding.EncodeToString([]byte("jello")))
+	handleFileControl(l, "/file-done 1")
+
+	assert.Contains(t, out.String(), "hello.txt from Test failed integrity check and was discarded\n")
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestReceiveFileResume(t *testing.T) {
+	dir, out := setupTransfer(t)
+	s, err := sdktest.NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newTestLink(t, s, "Test2")
+
+	// a previous connection received the first bytes
+	if err := ioutil.WriteFile(partPath(hashString("hello")), []byte("hel"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offerTestFile(t, l, "hello")
+	assert.NoError(t, acceptFile(l, []string{"/file-accept", "1"}))
+	assert.Contains(t, out.String(), "resuming hello.txt at 3 of 5 bytes\n")
+
+	// chunks before the offset are refused
+	handleFileControl(l, "/file-chunk 1 0 "+base64.StdEncoding.EncodeToString([]byte("hel")))
+	assert.Contains(t, out.String(), "invalid chunk received for hello.txt\n")
+	handleFileControl(l, "/file-chunk 1 3 "+base64.StdEncoding.EncodeToString([]byte("lo")))
+	handleFileControl(l, "/file-done 1")
+
+	received, err := ioutil.ReadFile(filepath.Join(dir, "hello.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(received))
+}
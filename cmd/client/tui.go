@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// commands are completed with tab in the terminal UI
+var commands = []string{
+	"/admin", "/ban", "/block", "/bot", "/broadcast", "/export", "/file-accept", "/file-decline", "/get-contains", "/get-last",
+	"/get-m-from-me", "/get-m-to-me", "/help", "/join", "/kick", "/list", "/msg", "/msg-ttl", "/name", "/quit",
+	"/reply", "/resume", "/schedule", "/scheduled", "/send-file", "/thread", "/ttl", "/unban", "/unblock",
+	"/unschedule", "/who",
+}
+
+// maxMessageLines is the number of lines kept in the message pane
+const maxMessageLines = 10000
+
+// tui is the full-screen terminal UI of the client :
+// a scrolling message pane, a sidebar of online users, a status bar
+// and an input line with history and tab completion
+type tui struct {
+	app      *tview.Application
+	messages *tview.TextView
+	users    *tview.TextView
+	status   *tview.TextView
+	input    *tview.InputField
+
+	out *link
+
+	// lines typed by the user, sent in order by a single go routine
+	lines chan string
+
+	// history of typed lines, only used by the UI go routine
+	history []string
+	current int
+
+	// guards the fields below, they are updated by the reader go routine
+	mu        sync.Mutex
+	online    []string
+	contact   string
+	connected bool
+	progress  string
+	hint      string
+}
+
+func newTUI(out *link) *tui {
+	t := &tui{
+		app:      tview.NewApplication(),
+		messages: tview.NewTextView(),
+		users:    tview.NewTextView(),
+		status:   tview.NewTextView(),
+		input:    tview.NewInputField(),
+		out:      out,
+		lines:    make(chan string, 64),
+	}
+	draw := func() { t.app.Draw() }
+
+	t.messages.SetScrollable(true).SetWordWrap(true).SetMaxLines(maxMessageLines).SetChangedFunc(draw)
+	t.users.SetChangedFunc(draw).SetBorder(true).SetTitle(" online ")
+	t.status.SetChangedFunc(draw)
+	t.status.SetTextColor(tcell.ColorBlack).SetBackgroundColor(tcell.ColorSilver)
+	t.input.SetLabel("> ").SetFieldBackgroundColor(tcell.ColorDefault).SetDoneFunc(t.submit)
+	t.input.SetInputCapture(t.key)
+
+	panes := tview.NewFlex().
+		AddItem(t.messages, 0, 1, false).
+		AddItem(t.users, 24, 0, false)
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(panes, 0, 1, false).
+		AddItem(t.status, 1, 0, false).
+		AddItem(t.input, 1, 0, true)
+	t.app.SetRoot(root, true)
+	t.render()
+	return t
+}
+
+// Write prints output of the client to the message pane
+func (t *tui) Write(p []byte) (int, error) {
+	// lines of the console start with a new line to end progress output,
+	// progress is shown in the status bar here
+	if text := strings.TrimLeft(string(p), "\n"); text != "" {
+		t.messages.Write([]byte(text))
+	}
+	return len(p), nil
+}
+
+// run shows the UI until the user leaves or the client is disconnected
+func (t *tui) run() error {
+	go func() {
+		for str := range t.lines {
+			handleInput(t.out, str)
+			// the user list is refreshed when the user joins a conversation, not after every
+			// command or on a timer, so commands are not doubled against the rate limits and
+			// an idle user stays idle for the server. /list refreshes it too with its answer
+			if refreshesUsers(str) {
+				t.requestList()
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		t.app.Stop()
+	}()
+	err := t.app.Run()
+	console = os.Stdout
+	if !sess.isClosing() {
+		quit(t.out)
+	}
+	return err
+}
+
+// submit sends the typed line when enter is pressed
+func (t *tui) submit(key tcell.Key) {
+	if key != tcell.KeyEnter {
+		return
+	}
+	// completion leaves a space after the last word, the server splits arguments on spaces
+	str := strings.TrimRight(t.input.GetText(), " ")
+	if str == "" {
+		return
+	}
+	if len(t.history) == 0 || t.history[len(t.history)-1] != str {
+		t.history = append(t.history, str)
+	}
+	t.current = len(t.history)
+	t.input.SetText("")
+	t.setHint("")
+
+	// typed lines are shown like a terminal would echo them
+	fmt.Fprintln(t.messages, str)
+	t.messages.ScrollToEnd()
+	t.lines <- str
+}
+
+// key handles history, completion and scrolling keys of the input line
+func (t *tui) key(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyUp:
+		if t.current > 0 {
+			t.current--
+			t.input.SetText(t.history[t.current])
+		}
+		return nil
+	case tcell.KeyDown:
+		if t.current < len(t.history)-1 {
+			t.current++
+			t.input.SetText(t.history[t.current])
+		} else {
+			t.current = len(t.history)
+			t.input.SetText("")
+		}
+		return nil
+	case tcell.KeyTab:
+		t.mu.Lock()
+		users := t.online
+		t.mu.Unlock()
+		text, matches := complete(t.input.GetText(), users)
+		t.input.SetText(text)
+		if len(matches) > 1 {
+			t.setHint(strings.Join(matches, " "))
+		} else {
+			t.setHint("")
+		}
+		return nil
+	case tcell.KeyPgUp, tcell.KeyPgDn:
+		t.messages.InputHandler()(event, func(tview.Primitive) {})
+		return nil
+	}
+	return event
+}
+
+// setUsers shows the online users in the sidebar
+func (t *tui) setUsers(users []string) {
+	users = append([]string(nil), users...)
+	sort.Strings(users)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.online = users
+	t.renderUsers()
+}
+
+// setContact shows the user messages are sent to
+func (t *tui) setContact(contact string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.contact = contact
+	t.render()
+}
+
+// setConnected shows the state of the connection,
+// the user list is refreshed once the session is ready
+func (t *tui) setConnected(connected bool) {
+	t.mu.Lock()
+	t.connected = connected
+	t.render()
+	t.mu.Unlock()
+	if connected {
+		t.requestList()
+	}
+}
+
+// setProgress shows the progress of a file transfer
+func (t *tui) setProgress(progress string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress = progress
+	t.render()
+}
+
+// setHint shows the matches of a completion
+func (t *tui) setHint(hint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hint = hint
+	t.render()
+}
+
+// refreshesUsers reports whether the user list is refreshed after a typed line
+func refreshesUsers(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) > 0 && fields[0] == "/join"
+}
+
+// requestList asks the server for the online users without printing the answer
+func (t *tui) requestList() {
+	c := t.out.current()
+	if c == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if users, err := c.List(ctx); err == nil {
+		t.setUsers(users)
+	}
+}
+
+// render updates the status bar, t.mu must be held
+func (t *tui) render() {
+	name, _ := sess.get()
+	parts := []string{name}
+	if t.contact != "" {
+		parts = append(parts, "talking to "+t.contact)
+	} else {
+		parts = append(parts, "use /join <user> to talk")
+	}
+	if t.connected {
+		parts = append(parts, "connected")
+	} else {
+		parts = append(parts, "reconnecting...")
+	}
+	if t.progress != "" {
+		parts = append(parts, t.progress)
+	}
+	if t.hint != "" {
+		parts = append(parts, t.hint)
+	}
+	t.status.SetText(" " + strings.Join(parts, " | "))
+}
+
+// renderUsers updates the sidebar, t.mu must be held
+func (t *tui) renderUsers() {
+	t.users.SetText(strings.Join(t.online, "\n"))
+}
+
+// complete completes the last word of a line :
+// the first word is completed from commands, other words from user names.
+// the line is extended to the longest common prefix of the matches, which are returned
+func complete(line string, users []string) (string, []string) {
+	words := strings.Split(line, " ")
+	last := words[len(words)-1]
+	if last == "" {
+		return line, nil
+	}
+
+	var candidates []string
+	if len(words) == 1 && strings.HasPrefix(last, "/") {
+		candidates = commands
+	} else {
+		for _, user := range users {
+			user = strings.TrimSuffix(user, " (reconnecting)")
+			candidates = append(candidates, strings.TrimSuffix(user, " (bot)"))
+		}
+	}
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, last) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return line, nil
+	}
+
+	prefix := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(matches) == 1 {
+		prefix += " "
+	}
+	words[len(words)-1] = prefix
+	return strings.Join(words, " "), matches
+}
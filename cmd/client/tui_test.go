@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	users := []string{"alice", "albert (reconnecting)", "bob", "helper (bot)"}
+	tests := []struct {
+		name    string
+		line    string
+		text    string
+		matches []string
+	}{
+		{name: "empty line", line: "", text: ""},
+		{name: "unique command", line: "/unbl", text: "/unblock ", matches: []string{"/unblock"}},
+		{name: "common prefix of commands", line: "/get-m", text: "/get-m-", matches: []string{"/get-m-from-me", "/get-m-to-me"}},
+		{name: "command and its longer variants", line: "/msg", text: "/msg", matches: []string{"/msg", "/msg-ttl"}},
+		{name: "unknown command", line: "/nope", text: "/nope"},
+		{name: "user name", line: "/join b", text: "/join bob ", matches: []string{"bob"}},
+		{name: "common prefix of user names", line: "/join a", text: "/join al", matches: []string{"alice", "albert"}},
+		{name: "reconnecting user", line: "/block alb", text: "/block albert ", matches: []string{"albert"}},
+		{name: "bot", line: "/join he", text: "/join helper ", matches: []string{"helper"}},
+		{name: "words are not commands", line: "hello /he", text: "hello /he"},
+		{name: "trailing space", line: "/join ", text: "/join "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, matches := complete(tt.line, users)
+			assert.Equal(t, tt.text, text)
+			assert.Equal(t, tt.matches, matches)
+		})
+	}
+}
+
+func TestRefreshesUsers(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{line: "/join bob", want: true},
+		{line: "  /join bob", want: true},
+		{line: "/list", want: false},
+		{line: "/msg hello", want: false},
+		{line: "/joined", want: false},
+		{line: "", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, refreshesUsers(tt.line), tt.line)
+	}
+}
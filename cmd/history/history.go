@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/history"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+	"github.com/Selahattinn/picus-tcp-message/pkg/server"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service"
+	"github.com/Selahattinn/picus-tcp-message/pkg/version"
+)
+
+var (
+	configFileFlag = flag.String("config.file", "config.yml", "Path to the configuration file of the server.")
+	formatFlag     = flag.String("format", history.FormatJSONL, "Format of the history file, jsonl or csv.")
+	fileFlag       = flag.String("file", "", "Path to the history file, stdout for export and stdin for import when empty.")
+	userFlag       = flag.String("user", "", "Export only the messages sent or received by the user.")
+	sinceFlag      = flag.String("since", "", "Export only the messages created at or after this RFC 3339 time.")
+	untilFlag      = flag.String("until", "", "Export only the messages created before this RFC 3339 time.")
+	versionFlag    = flag.Bool("version", false, "Show version information.")
+)
+
+// parseTime returns the time of a flag, the zero time when it is empty
+func parseTime(name string, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-%s: %s is not an RFC 3339 time", name, value)
+	}
+	return t, nil
+}
+
+// filter returns the history filter of the flags
+func filter() (model.HistoryFilter, error) {
+	since, err := parseTime("since", *sinceFlag)
+	if err != nil {
+		return model.HistoryFilter{}, err
+	}
+	until, err := parseTime("until", *untilFlag)
+	if err != nil {
+		return model.HistoryFilter{}, err
+	}
+	return model.HistoryFilter{User: *userFlag, Since: since, Until: until}, nil
+}
+
+// export writes the messages of the filter to the history file
+func export(src history.Source, f model.HistoryFilter) (int, error) {
+	var out io.Writer = os.Stdout
+	if *fileFlag != "" {
+		file, err := os.Create(*fileFlag)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		out = file
+	}
+	w, err := history.NewWriter(out, *formatFlag)
+	if err != nil {
+		return 0, err
+	}
+	return history.Export(context.Background(), src, f, w)
+}
+
+// importFile stores the messages of the history file
+func importFile(dst history.Sink) (int, error) {
+	var in io.Reader = os.Stdin
+	if *fileFlag != "" {
+		file, err := os.Open(*fileFlag)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		in = file
+	}
+	r, err := history.NewReader(in, *formatFlag)
+	if err != nil {
+		return 0, err
+	}
+	return history.Import(context.Background(), dst, r)
+}
+
+// Exports the messages in the database of the server to a history file or imports them,
+// the exit code is 1 when the database or the file can not be used :
+// history [flags] export|import
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] export|import\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Fprintln(os.Stdout, version.Print("tcp-message-history"))
+		os.Exit(0)
+	}
+	action := flag.Arg(0)
+	if flag.NArg() != 1 || (action != "export" && action != "import") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !history.KnownFormat(*formatFlag) {
+		fmt.Fprintf(os.Stderr, "-format: unknown format %q, use %s or %s\n", *formatFlag, history.FormatJSONL, history.FormatCSV)
+		os.Exit(2)
+	}
+	f, err := filter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	cfg, err := server.LoadConfig(*configFileFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not load configuration:", err)
+		os.Exit(1)
+	}
+	repo, err := repository.NewMySQLRepository(cfg.DB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not create mysql repository:", err)
+		os.Exit(1)
+	}
+	provider, err := service.NewProvider(cfg.Service, repo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not create service provider:", err)
+		os.Exit(1)
+	}
+	messages := provider.GetMessageService()
+
+	if action == "export" {
+		count, err := export(messages, f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export failed after %d messages: %v\n", count, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "%d messages exported\n", count)
+		return
+	}
+	count, err := importFile(messages)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed after %d messages: %v\n", count, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d messages imported\n", count)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+	"github.com/Selahattinn/picus-tcp-message/pkg/server"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service"
+	"github.com/Selahattinn/picus-tcp-message/pkg/version"
+)
+
+var (
+	configFileFlag = flag.String("config.file", "config.yml", "Path to the configuration file of the server.")
+	batchSizeFlag  = flag.Int("batch.size", 500, "Number of messages encrypted at once.")
+	batchPauseFlag = flag.Duration("batch.pause", 100*time.Millisecond, "Pause between batches, so the server is not blocked.")
+	versionFlag    = flag.Bool("version", false, "Show version information.")
+)
+
+// Encrypts all stored messages with the active key of database.encryption, after a key is
+// rotated or encryption is enabled, or decrypts them when there is no active key. The server
+// keeps running meanwhile, the exit code is 1 when a message can not be encrypted
+func main() {
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Fprintln(os.Stdout, version.Print("tcp-message-reencrypt"))
+		os.Exit(0)
+	}
+	if *batchSizeFlag <= 0 {
+		fmt.Fprintln(os.Stderr, "-batch.size: must be positive")
+		os.Exit(2)
+	}
+
+	cfg, err := server.LoadConfig(*configFileFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not load configuration:", err)
+		os.Exit(1)
+	}
+	repo, err := repository.NewMySQLRepository(cfg.DB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not create mysql repository:", err)
+		os.Exit(1)
+	}
+	provider, err := service.NewProvider(cfg.Service, repo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not create service provider:", err)
+		os.Exit(1)
+	}
+	messages := provider.GetMessageService()
+
+	var afterID, total int64
+	for {
+		last, count, err := messages.ResealMessages(context.Background(), afterID, *batchSizeFlag)
+		total += count
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reencrypt failed after %d messages: %v\n", total, err)
+			os.Exit(1)
+		}
+		if last == 0 {
+			break
+		}
+		afterID = last
+		time.Sleep(*batchPauseFlag)
+	}
+	fmt.Fprintf(os.Stdout, "%d messages reencrypted\n", total)
+}
@@ -1,25 +1,61 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/logging"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
 	"github.com/Selahattinn/picus-tcp-message/pkg/server"
 	"github.com/Selahattinn/picus-tcp-message/pkg/version"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 )
 
 var (
 	configFileFlag = flag.String("config.file", "config.yml", "Path to the configuration file.")
 	versionFlag    = flag.Bool("version", false, "Show version information.")
 	debugFlag      = flag.Bool("debug", false, "Show debug information.")
-	logFileFlag    = flag.String("log.file", "tcp-message-server.log", "Path to the log file.")
+	logFileFlag    = flag.String("log.file", "", "Path to the log file, overrides log.file and log.output of the configuration.")
+	checkFlag      = flag.Bool("config.check", false, "Validate the configuration file and exit.")
+	watchFlag      = flag.Duration("config.watch", 0, "Interval to check the configuration file for changes, disabled when 0.")
 )
 
+// setLogging applies the log settings of a config, the log file flag wins over the config
+func setLogging(cfg *server.Config) error {
+	if *logFileFlag != "" {
+		cfg.Log.Output = logging.OutputFile
+		cfg.Log.File = *logFileFlag
+	}
+	return logging.Apply(cfg.Log, *debugFlag)
+}
+
+// watchConfig requests a reload whenever the configuration file is modified
+func watchConfig(path string, interval time.Duration, reloads chan<- struct{}) {
+	var modified time.Time
+	if info, err := os.Stat(path); err == nil {
+		modified = info.ModTime()
+	}
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modified) {
+			continue
+		}
+		modified = info.ModTime()
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	}
+}
+
 func main() {
 	// getting flag values
 	flag.Parse()
@@ -29,41 +65,53 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Log settings
-	if *debugFlag {
-		logrus.SetReportCaller(true)
-		logrus.SetLevel(logrus.TraceLevel)
-	} else {
-		logrus.SetReportCaller(false)
-		logrus.SetLevel(logrus.InfoLevel)
-	}
-	logrus.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
-
-	logFile, err := os.OpenFile(*logFileFlag, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		logrus.WithError(err).Fatal("Could not open log file")
+	if *checkFlag {
+		if _, err := server.LoadConfig(*configFileFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stdout, "configuration is valid")
+		os.Exit(0)
 	}
 
-	// logrus log file setted
-	logrus.SetOutput(logFile)
-
 	// Load configuration file
-	data, err := ioutil.ReadFile(*configFileFlag)
+	cfg, err := server.LoadConfig(*configFileFlag)
 	if err != nil {
 		logrus.WithError(err).Fatal("Could not load configuration")
 	}
-	var cfg server.Config
-	err = yaml.Unmarshal(data, &cfg)
-	if err != nil {
-		logrus.WithError(err).Fatal("Could not load configuration")
+
+	// Log settings
+	if err := setLogging(cfg); err != nil {
+		logrus.WithError(err).Fatal("Could not open log output")
 	}
 
 	// instantiate a server
-	s := server.NewServer(&cfg)
+	s := server.NewServer(cfg)
 	logrus.Info("created new server")
 
+	// record security events to the audit log
+	if cfg.Audit != nil {
+		s.Audit, err = audit.Open(cfg.Audit.File)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open audit log")
+		}
+		defer s.Audit.Close()
+	}
+
+	// serve metrics and health checks over HTTP
+	if cfg.HTTP != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/healthz", s.LivenessHandler())
+		mux.Handle("/readyz", s.ReadinessHandler())
+		go func() {
+			logrus.Info("serving metrics and health checks on ", cfg.HTTP.ListenAddress)
+			if err := http.ListenAndServe(cfg.HTTP.ListenAddress, mux); err != nil {
+				logrus.WithError(err).Fatal("unable to start http server")
+			}
+		}()
+	}
+
 	// run server in separate go routine
 	go s.Run()
 
@@ -75,21 +123,86 @@ func main() {
 	} else {
 		logrus.Info("listening to port ", s.Config.ListenAddress)
 	}
+	if tlsConfig := s.TLSConfig(); tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		logrus.Info("serving clients over TLS")
+	}
 
 	defer listener.Close()
-	// sometime later.. close listener
+
+	// stop gracefully on termination signals :
+	// the server reports not ready, stops accepting and disconnects clients
+	stopping := make(chan struct{})
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stopping)
+		listener.Close()
+		s.Shutdown()
+		close(stopped)
+	}()
+
+	// reload the configuration on SIGHUP or when the file changes :
+	// a config which can not be loaded is rejected and the old one stays active
+	reloads := make(chan struct{}, 1)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			select {
+			case reloads <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	if *watchFlag > 0 {
+		go watchConfig(*configFileFlag, *watchFlag, reloads)
+	}
+	go func() {
+		for range reloads {
+			cfg, err := server.LoadConfig(*configFileFlag)
+			if err != nil {
+				logrus.WithError(err).Error("rejected new configuration, the old one stays active")
+				continue
+			}
+			restart, err := s.Reload(cfg)
+			if err != nil {
+				logrus.WithError(err).Error("unable to reload configuration")
+				continue
+			}
+			if err := setLogging(cfg); err != nil {
+				logrus.WithError(err).Error("unable to apply log settings")
+			}
+			for _, name := range restart {
+				logrus.Warn("configuration change of ", name, " needs a restart")
+			}
+			logrus.Info("configuration reloaded")
+		}
+	}()
 
 	// continuously accept new connections
 	for {
 
 		conn, err := listener.Accept()
 		if err != nil {
+			select {
+			case <-stopping:
+				<-stopped
+				logrus.Info("server stopped")
+				return
+			default:
+			}
 			logrus.WithError(err).Info("failed to accept connection")
 			continue
 		}
 
-		go s.NewClient(conn)
-		logrus.Info("added new client ", conn.RemoteAddr().String())
+		go func() {
+			if err := s.NewClient(conn); err != nil {
+				logrus.WithError(err).WithField("remote_addr", conn.RemoteAddr().String()).Info("failed to add client")
+			}
+		}()
 	}
 
 }
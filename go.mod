@@ -4,9 +4,12 @@ go 1.13
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/gdamore/tcell/v2 v2.4.0
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/prometheus/client_golang v1.11.0
+	github.com/rivo/tview v0.0.0-20210624165335-29d673af0ce2
 	github.com/sirupsen/logrus v1.8.1
-	github.com/stretchr/testify v1.2.2
+	github.com/stretchr/testify v1.4.0
 	golang.org/x/sys v0.0.0-20220114195835-da31bd327af9 // indirect
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// events recorded in the audit log
+const (
+	EventConnect         = "connect"
+	EventDisconnect      = "disconnect"
+	EventEvicted         = "evicted"
+	EventRefused         = "refused"
+	EventName            = "name"
+	EventResume          = "resume"
+	EventResumeFailed    = "resume_failed"
+	EventNameRefused     = "name_refused"
+	EventRateLimited     = "rate_limited"
+	EventDenied          = "permission_denied"
+	EventAdminAuth       = "admin_auth"
+	EventAdminAuthFailed = "admin_auth_failed"
+	EventKick            = "kick"
+	EventBan             = "ban"
+	EventUnban           = "unban"
+	EventBroadcast       = "broadcast"
+	EventWho             = "who"
+	EventUnknownCommand  = "unknown_command"
+	EventTimedOut        = "timed_out"
+)
+
+// Entry is a record of the audit log :
+// Hash covers all other fields, including the hash of the previous entry,
+// so an entry can not be edited, removed or inserted without breaking the chain
+type Entry struct {
+	Seq        uint64            `json:"seq"`
+	Time       time.Time         `json:"time"`
+	Event      string            `json:"event"`
+	ConnID     uint64            `json:"conn_id,omitempty"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+	PrevHash   string            `json:"prev_hash"`
+	Hash       string            `json:"hash"`
+}
+
+// sum returns the hash of an entry
+func (e Entry) sum() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log is an append only audit log file, safe for concurrent use
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+
+	// sequence number and hash of the last entry
+	seq  uint64
+	last string
+
+	// clock, replaced in tests
+	now func() time.Time
+}
+
+// Open opens an audit log for appending, the chain is continued from its last entry
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{file: file, now: time.Now}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("audit log %s is corrupted after entry %d: %v", path, l.seq, err)
+		}
+		l.seq = e.Seq
+		l.last = e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record appends an entry to the log, its sequence number, time and hashes are set
+func (l *Log) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	e.Time = l.now().UTC()
+	e.PrevHash = l.last
+	hash, err := e.sum()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.seq = e.Seq
+	l.last = e.Hash
+	return nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Verify reads an audit log and checks its chain :
+// the number of valid entries is returned with an error describing the first
+// entry which is edited, missing or out of order
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	count := 0
+	prev := ""
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return count, fmt.Errorf("line %d: invalid entry: %v", line, err)
+		}
+		if e.Seq != uint64(count+1) {
+			return count, fmt.Errorf("line %d: expected entry %d, found %d: entries are missing or reordered", line, count+1, e.Seq)
+		}
+		if e.PrevHash != prev {
+			return count, fmt.Errorf("line %d: entry %d does not follow the previous entry", line, e.Seq)
+		}
+		hash, err := e.sum()
+		if err != nil {
+			return count, err
+		}
+		if hash != e.Hash {
+			return count, fmt.Errorf("line %d: entry %d is edited", line, e.Seq)
+		}
+		prev = e.Hash
+		count++
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestLog records entries to a new log and returns its lines
+func writeTestLog(t *testing.T, events ...string) (string, []string) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		assert.NoError(t, l.Record(Entry{Event: event, User: "Test", Details: map[string]string{"target": "Test2"}}))
+	}
+	l.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestLog_Record(t *testing.T) {
+	path, lines := writeTestLog(t, EventConnect, EventKick)
+	assert.Len(t, lines, 2)
+
+	count, err := Verify(strings.NewReader(strings.Join(lines, "\n")))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// a reopened log continues the chain
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, l.Record(Entry{Event: EventDisconnect}))
+	l.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	count, err = Verify(f)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestVerify(t *testing.T) {
+	_, lines := writeTestLog(t, EventConnect, EventName, EventBan, EventDisconnect)
+
+	tests := []struct {
+		name  string
+		lines []string
+		count int
+		err   string
+	}{
+		{"edited", []string{lines[0], strings.Replace(lines[1], `"target":"Test2"`, `"target":"Test3"`, 1), lines[2]}, 1, "line 2: entry 2 is edited"},
+		{"removed", []string{lines[0], lines[2], lines[3]}, 1, "line 2: expected entry 2, found 3: entries are missing or reordered"},
+		{"reordered", []string{lines[0], lines[2], lines[1]}, 1, "line 2: expected entry 2, found 3: entries are missing or reordered"},
+		{"first removed", lines[1:], 0, "line 1: expected entry 1, found 2: entries are missing or reordered"},
+		{"invalid", []string{lines[0], "{"}, 1, "line 2: invalid entry: unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := Verify(strings.NewReader(strings.Join(tt.lines, "\n")))
+			assert.Equal(t, tt.count, count)
+			if assert.Error(t, err) {
+				assert.Equal(t, tt.err, err.Error())
+			}
+		})
+	}
+}
+
+func TestVerifyRehashed(t *testing.T) {
+	// an edited entry with a recomputed hash breaks the link of the next entry
+	_, lines := writeTestLog(t, EventConnect, EventName, EventDisconnect)
+
+	l, err := Open(filepath.Join(mustTempDir(t), "forged.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Entry{Event: EventConnect, User: "Test", Details: map[string]string{"target": "Test2"}})
+	l.Record(Entry{Event: EventName, User: "Forged"})
+	l.Close()
+	forged, _ := ioutil.ReadFile(l.file.Name())
+	forgedLines := strings.Split(strings.TrimSpace(string(forged)), "\n")
+
+	count, err := Verify(strings.NewReader(strings.Join([]string{lines[0], forgedLines[1], lines[2]}, "\n")))
+	assert.Equal(t, 1, count)
+	assert.Error(t, err)
+}
+
+func mustTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
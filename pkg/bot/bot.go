@@ -0,0 +1,340 @@
+// Package bot runs chat bots: programs which log in as users, like a deploy notifier or an echo bot.
+//
+// A bot connects with pkg/sdk, marks itself as a bot so users see it in /list, and reconnects on its
+// own. Messages starting with the prefix, "!deploy prod" by default, are passed to the handler of
+// their command, other messages to the handler set with HandleMessage.
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk"
+	"github.com/sirupsen/logrus"
+)
+
+// defaults of a bot
+const (
+	DefaultPrefix = "!"
+
+	// limit of a command the bot sends to the server
+	defaultTimeout = 30 * time.Second
+
+	// backoff between reconnect attempts
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// ErrOffline is returned by Send while the bot is reconnecting
+var ErrOffline = errors.New("bot: not connected to the server")
+
+// Config of a bot
+type Config struct {
+	// address of the server
+	Addr string
+
+	// name the bot is known as
+	Name string
+
+	// TLS and heartbeat of the connection, may be nil
+	SDK *sdk.Config
+
+	// commands are messages starting with the prefix, DefaultPrefix when empty
+	Prefix string
+
+	// limit of a command sent to the server, 30s when zero
+	Timeout time.Duration
+}
+
+// Message is a message sent to the bot
+type Message struct {
+	From string
+	Text string
+
+	// id of the message, set for replies
+	ID int64
+
+	// command and its arguments when the text starts with the prefix, the command is without the prefix
+	Command string
+	Args    []string
+
+	bot *Bot
+}
+
+// Reply sends a message back to the sender
+func (m *Message) Reply(ctx context.Context, text string) error {
+	return m.bot.Send(ctx, m.From, text)
+}
+
+// Replyf formats and sends a message back to the sender
+func (m *Message) Replyf(ctx context.Context, format string, args ...interface{}) error {
+	return m.Reply(ctx, fmt.Sprintf(format, args...))
+}
+
+// Handler handles a message, an error is replied to the sender
+type Handler func(ctx context.Context, m *Message) error
+
+// command is a registered command of a bot
+type command struct {
+	help    string
+	handler Handler
+}
+
+// Bot is a chat bot, create it with New and start it with Run
+type Bot struct {
+	cfg Config
+
+	// guards the fields below
+	mu       sync.Mutex
+	c        *sdk.Client
+	commands map[string]command
+	fallback Handler
+
+	// serializes Send, which joins the recipient before sending
+	sendMu sync.Mutex
+	// user the bot is talking to, guarded by sendMu
+	contact string
+}
+
+// New returns a bot which answers "help" with its commands
+func New(cfg Config) *Bot {
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	b := &Bot{
+		cfg:      cfg,
+		commands: make(map[string]command),
+	}
+	b.Handle("help", "list the commands", b.help)
+	return b
+}
+
+// Handle registers the handler of a command, like "deploy" for "!deploy prod"
+func (b *Bot) Handle(name string, help string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commands[name] = command{help: help, handler: h}
+}
+
+// HandleMessage registers the handler of messages which are not commands,
+// they are ignored when there is no handler
+func (b *Bot) HandleMessage(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fallback = h
+}
+
+// help answers with the commands of the bot, a message for each command
+// since messages are limited to the size RSA can encrypt
+func (b *Bot) help(ctx context.Context, m *Message) error {
+	b.mu.Lock()
+	names := make([]string, 0, len(b.commands))
+	for name := range b.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, b.cfg.Prefix+name+" : "+b.commands[name].help)
+	}
+	b.mu.Unlock()
+	for _, line := range lines {
+		if err := m.Reply(ctx, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Client returns the connection of the bot, nil while it is reconnecting
+func (b *Bot) Client() *sdk.Client {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.c
+}
+
+func (b *Bot) setClient(c *sdk.Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.c = c
+}
+
+// Send sends a message to a user, joining the user first when needed
+func (b *Bot) Send(ctx context.Context, to string, text string) error {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	c := b.Client()
+	if c == nil {
+		return ErrOffline
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.Timeout)
+	defer cancel()
+	if b.contact != to {
+		if err := c.Join(ctx, to); err != nil {
+			return err
+		}
+		b.contact = to
+	}
+	return c.Send(ctx, text)
+}
+
+// Run connects the bot and handles messages until ctx is done, then the bot leaves.
+// a lost connection is resumed, Run returns an error when the name is taken
+// or the bot is kicked or banned.
+func (b *Bot) Run(ctx context.Context) error {
+	delay := reconnectMinDelay
+	var name, token string
+	for {
+		c, err := b.connect(ctx, name, token)
+		switch {
+		case err == sdk.ErrNameTaken:
+			return err
+		case err != nil && ctx.Err() != nil:
+			return nil
+		case err != nil:
+			logrus.WithError(err).WithField("addr", b.cfg.Addr).Info("unable to connect, retrying")
+			// jitter keeps bots from reconnecting all at once after a restart
+			wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil
+			}
+			delay *= 2
+			if delay > reconnectMaxDelay {
+				delay = reconnectMaxDelay
+			}
+			continue
+		}
+		delay = reconnectMinDelay
+
+		b.setClient(c)
+		err = b.serve(ctx, c)
+		b.setClient(nil)
+		name, token = c.Session()
+		if ctx.Err() != nil {
+			quitCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			c.Quit(quitCtx)
+			cancel()
+			return nil
+		}
+		c.Close()
+		if err != nil {
+			return err
+		}
+		logrus.WithField("name", name).Info("connection lost, reconnecting")
+	}
+}
+
+// connect dials the server and resumes the session of the bot, or chooses its name
+func (b *Bot) connect(ctx context.Context, name string, token string) (*sdk.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.Timeout)
+	defer cancel()
+	c, err := sdk.Dial(ctx, b.cfg.Addr, b.cfg.SDK)
+	if err != nil {
+		return nil, err
+	}
+
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	if token != "" {
+		err = c.Resume(ctx, name, token)
+		if err == nil {
+			// the contact is restored with the session
+			return c, nil
+		}
+	}
+	if _, refused := err.(*sdk.Error); err == nil || refused {
+		b.contact = ""
+		err = c.SetName(ctx, b.cfg.Name)
+	}
+	if err == nil {
+		err = c.SetBot(ctx)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// serve handles the events of a connection until it is lost or ctx is done,
+// an error is returned when the server removed the bot
+func (b *Bot) serve(ctx context.Context, c *sdk.Client) error {
+	for {
+		select {
+		case e, ok := <-c.Events():
+			if !ok {
+				return nil
+			}
+			switch {
+			case e.Type == client.EventMessage:
+				b.dispatch(ctx, b.parse(e))
+			case e.Type == client.EventText && (strings.HasPrefix(e.Text, "You are kicked from the server") ||
+				strings.HasPrefix(e.Text, "You are banned from this server")):
+				return errors.New("bot: " + e.Text)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// parse returns the message of an event, with its command when it is one
+func (b *Bot) parse(e sdk.Event) *Message {
+	m := &Message{
+		From: e.From,
+		Text: e.Text,
+		ID:   e.MessageID,
+		bot:  b,
+	}
+	if strings.HasPrefix(m.Text, b.cfg.Prefix) {
+		fields := strings.Fields(strings.TrimPrefix(m.Text, b.cfg.Prefix))
+		if len(fields) > 0 {
+			m.Command = fields[0]
+			m.Args = fields[1:]
+		}
+	}
+	return m
+}
+
+// dispatch calls the handler of a message, handlers are called one at a time
+// in the order messages arrive
+func (b *Bot) dispatch(ctx context.Context, m *Message) {
+	b.mu.Lock()
+	h := b.fallback
+	if m.Command != "" {
+		cmd, ok := b.commands[m.Command]
+		h = cmd.handler
+		if !ok {
+			h = b.unknown
+		}
+	}
+	b.mu.Unlock()
+	if h == nil {
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{"from": m.From, "command": m.Command})
+	if err := h(ctx, m); err != nil {
+		log.WithError(err).Info("handler failed")
+		if err := m.Reply(ctx, "error: "+err.Error()); err != nil {
+			log.WithError(err).Info("unable to reply")
+		}
+	}
+}
+
+// unknown answers commands which are not registered
+func (b *Bot) unknown(ctx context.Context, m *Message) error {
+	return m.Replyf(ctx, "unknown command %s%s, try %shelp", b.cfg.Prefix, m.Command, b.cfg.Prefix)
+}
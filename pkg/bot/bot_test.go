@@ -0,0 +1,215 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk"
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk/sdktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) *sdktest.Server {
+	s, err := sdktest.NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// newEchoBot returns a bot which echoes its arguments
+func newEchoBot(s *sdktest.Server) *Bot {
+	b := New(Config{Addr: s.Addr, Name: "echo"})
+	b.Handle("echo", "repeat the arguments", func(ctx context.Context, m *Message) error {
+		return m.Reply(ctx, strings.Join(m.Args, " "))
+	})
+	return b
+}
+
+// start runs a bot until the test ends and waits until it is connected,
+// the returned channel gets the result of Run
+func start(t *testing.T, b *Bot) (context.CancelFunc, chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- b.Run(ctx) }()
+	t.Cleanup(cancel)
+	waitConnected(t, b)
+	return cancel, result
+}
+
+func waitConnected(t *testing.T, b *Bot) {
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Client() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("bot is not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// connectUser connects a user which is talking to the bot, unless bot is empty
+func connectUser(t *testing.T, s *sdktest.Server, name string, bot string) *sdk.Client {
+	ctx := testContext(t)
+	c, err := sdk.NewClient(ctx, s.Pipe(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.SetName(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	if bot == "" {
+		return c
+	}
+	if err := c.Join(ctx, bot); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// expectMessage waits for a message of another user
+func expectMessage(t *testing.T, c *sdk.Client, from string, text string) {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e, ok := <-c.Events():
+			if !ok {
+				t.Fatalf("connection closed while waiting for %q", text)
+			}
+			if e.Type == client.EventMessage {
+				assert.Equal(t, from, e.From)
+				assert.Equal(t, text, e.Text)
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no message %q", text)
+		}
+	}
+}
+
+func TestBot_commands(t *testing.T) {
+	s := newTestServer(t)
+	b := newEchoBot(s)
+	b.Handle("fail", "always fails", func(ctx context.Context, m *Message) error {
+		return errors.New("it failed")
+	})
+	start(t, b)
+	user := connectUser(t, s, "alice", "echo")
+	ctx := testContext(t)
+
+	users, err := user.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"echo (bot)"}, users)
+
+	assert.NoError(t, user.Send(ctx, "!echo hello  world"))
+	expectMessage(t, user, "echo", "hello world")
+
+	assert.NoError(t, user.Send(ctx, "!fail"))
+	expectMessage(t, user, "echo", "error: it failed")
+
+	assert.NoError(t, user.Send(ctx, "!nope"))
+	expectMessage(t, user, "echo", "unknown command !nope, try !help")
+
+	assert.NoError(t, user.Send(ctx, "!help"))
+	expectMessage(t, user, "echo", "!echo : repeat the arguments")
+	expectMessage(t, user, "echo", "!fail : always fails")
+	expectMessage(t, user, "echo", "!help : list the commands")
+}
+
+func TestBot_messages(t *testing.T) {
+	s := newTestServer(t)
+	b := New(Config{Addr: s.Addr, Name: "notifier", Prefix: "/"})
+	received := make(chan *Message, 1)
+	b.HandleMessage(func(ctx context.Context, m *Message) error {
+		received <- m
+		return nil
+	})
+	start(t, b)
+	alice := connectUser(t, s, "alice", "notifier")
+	bob := connectUser(t, s, "bob", "notifier")
+	ctx := testContext(t)
+
+	assert.NoError(t, alice.Send(ctx, "deploy finished"))
+	select {
+	case m := <-received:
+		assert.Equal(t, "alice", m.From)
+		assert.Equal(t, "deploy finished", m.Text)
+		assert.Equal(t, "", m.Command)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message is not handled")
+	}
+
+	// the bot joins each recipient before sending
+	assert.NoError(t, b.Send(ctx, "bob", "page for bob"))
+	assert.NoError(t, b.Send(ctx, "alice", "page for alice"))
+	expectMessage(t, bob, "notifier", "page for bob")
+	expectMessage(t, alice, "notifier", "page for alice")
+	assert.IsType(t, &sdk.Error{}, b.Send(ctx, "carol", "nobody"))
+}
+
+func TestBot_reconnect(t *testing.T) {
+	s := newTestServer(t)
+	b := newEchoBot(s)
+	start(t, b)
+	user := connectUser(t, s, "alice", "echo")
+	ctx := testContext(t)
+	assert.NoError(t, user.Send(ctx, "!echo before"))
+	expectMessage(t, user, "echo", "before")
+
+	// every connection drops, the bot resumes its session
+	old := b.Client()
+	s.Drop()
+	deadline := time.Now().Add(5 * time.Second)
+	for c := b.Client(); c == nil || c == old; c = b.Client() {
+		if time.Now().After(deadline) {
+			t.Fatal("bot is not reconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	user = connectUser(t, s, "bob", "echo")
+	users, err := user.List(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, users, "echo (bot)")
+	assert.NoError(t, user.Send(ctx, "!echo after"))
+	expectMessage(t, user, "echo", "after")
+}
+
+func TestBot_stop(t *testing.T) {
+	s := newTestServer(t)
+	b := newEchoBot(s)
+	cancel, result := start(t, b)
+	user := connectUser(t, s, "alice", "echo")
+
+	cancel()
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("bot does not stop")
+	}
+
+	// the bot left, its name is free
+	users, err := user.List(testContext(t))
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestBot_nameTaken(t *testing.T) {
+	s := newTestServer(t)
+	connectUser(t, s, "echo", "")
+
+	b := newEchoBot(s)
+	assert.Equal(t, sdk.ErrNameTaken, b.Run(testContext(t)))
+}
@@ -1,13 +1,18 @@
 package client
 
 import (
-	"bufio"
 	"crypto/rsa"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -26,25 +31,122 @@ const (
 	CmdGetLast
 	CmdGetContains
 	CmdGetMessageToMe
+	CmdReply
+	CmdThread
+	CmdFileOffer
+	CmdFileAccept
+	CmdFileDecline
+	CmdFileChunk
+	CmdFileDone
+	CmdAdmin
+	CmdKick
+	CmdBan
+	CmdUnban
+	CmdBroadcast
+	CmdWho
+	CmdBlock
+	CmdUnblock
+	CmdResume
+	CmdProto
+	CmdBot
+	CmdSchedule
+	CmdScheduled
+	CmdUnschedule
+	CmdMsgTTL
+	CmdTTL
+	CmdExport
+
+	// sent by the client itself when its connection is closed
+	CmdDisconnect
+
+	// sent for any command the client does not know, so the server can audit it
+	CmdUnknown
 )
 
+// names of commands, used in logs and metrics
+var commandNames = map[commandID]string{
+	CmdName:             "name",
+	CmdJoin:             "join",
+	CmdList:             "list",
+	CmdMsg:              "msg",
+	CmdQuit:             "quit",
+	CmdHelp:             "help",
+	CmdGetMessageFromMe: "get-m-from-me",
+	CmdGetLast:          "get-last",
+	CmdGetContains:      "get-contains",
+	CmdGetMessageToMe:   "get-m-to-me",
+	CmdReply:            "reply",
+	CmdThread:           "thread",
+	CmdFileOffer:        "file-offer",
+	CmdFileAccept:       "file-accept",
+	CmdFileDecline:      "file-decline",
+	CmdFileChunk:        "file-chunk",
+	CmdFileDone:         "file-done",
+	CmdDisconnect:       "disconnect",
+	CmdAdmin:            "admin",
+	CmdKick:             "kick",
+	CmdBan:              "ban",
+	CmdUnban:            "unban",
+	CmdBroadcast:        "broadcast",
+	CmdWho:              "who",
+	CmdBlock:            "block",
+	CmdUnblock:          "unblock",
+	CmdResume:           "resume",
+	CmdProto:            "proto",
+	CmdBot:              "bot",
+	CmdSchedule:         "schedule",
+	CmdScheduled:        "scheduled",
+	CmdUnschedule:       "unschedule",
+	CmdMsgTTL:           "msg-ttl",
+	CmdTTL:              "ttl",
+	CmdExport:           "export",
+	CmdUnknown:          "unknown",
+}
+
+// String returns the name of the command
+func (id commandID) String() string {
+	if name, ok := commandNames[id]; ok {
+		return name
+	}
+	return "unknown"
+}
+
 // structure for a command
 type Command struct {
 	ID     commandID
 	Client *Client
 	Args   []string
+
+	// id of a request of the JSON protocol, zero for commands of the text protocol
+	RequestID uint64
 }
 
 // structure of a client i.e a user ( a new connection, will have this structure )
 type Client struct {
+	// time of the last line read from the client in unix nanoseconds, accessed atomically :
+	// kept first so it is 64-bit aligned on 32-bit platforms
+	lastSeen int64
+
+	// id of the request which is handled, its answers carry it, accessed atomically
+	request uint64
+
+	// 1 when the client speaks the JSON protocol, accessed atomically
+	jsonProto int32
 
 	// client connection details
 	Conn net.Conn
 
+	// identifier of the connection, used in logs
+	ID uint64
+
 	// identifier for the client :
-	// client will be known on the server by this name
+	// client will be known on the server by this name,
+	// changed with SetName since logs read it from the reader go routine
 	Name string
 
+	// guards Name
+	nameMu sync.Mutex
+
 	// identifier for the (other) client :
 	// the other client (person), this client is talking to currently
 	Contact string
@@ -57,137 +159,505 @@ type Client struct {
 
 	// public
 	Public rsa.PublicKey
+
+	// set when the client authenticated as an admin
+	Admin bool
+
+	// set when the client is a bot, shown in /list
+	Bot bool
+
+	// time the client connected
+	ConnectedAt time.Time
+
+	// time of the last command of the client, updated by the server
+	LastActive time.Time
+
+	// maximum size of a line read from the client, DefaultMaxLineSize when zero
+	MaxLineSize int
+
+	// time the client can stay idle or take to send a line, no limit when zero
+	ReadTimeout time.Duration
+
+	// time a write to the client can take, no limit when zero
+	WriteTimeout time.Duration
+}
+
+// SetName changes the name of the client
+func (c *Client) SetName(name string) {
+	c.nameMu.Lock()
+	defer c.nameMu.Unlock()
+	c.Name = name
+}
+
+// GetName returns the name of the client, it can be called outside of the server dispatcher
+func (c *Client) GetName() string {
+	c.nameMu.Lock()
+	defer c.nameMu.Unlock()
+	return c.Name
+}
+
+// SetProto changes the protocol of the client, ProtoText or ProtoJSON
+func (c *Client) SetProto(proto string) {
+	var v int32
+	if proto == ProtoJSON {
+		v = 1
+	}
+	atomic.StoreInt32(&c.jsonProto, v)
+}
+
+// Proto returns the protocol of the client
+func (c *Client) Proto() string {
+	if atomic.LoadInt32(&c.jsonProto) == 1 {
+		return ProtoJSON
+	}
+	return ProtoText
+}
+
+// SetRequest sets the request whose answers are sent, zero when no request is handled
+func (c *Client) SetRequest(id uint64) {
+	atomic.StoreUint64(&c.request, id)
+}
+
+// LastSeen returns the time of the last line read from the client,
+// heartbeat answers included, or the connect time before the first line
+func (c *Client) LastSeen() time.Time {
+	if seen := atomic.LoadInt64(&c.lastSeen); seen != 0 {
+		return time.Unix(0, seen)
+	}
+	return c.ConnectedAt
+}
+
+// Log returns a log entry carrying the connection id, remote address and
+// user name of the client. Message bodies must not be logged with it.
+func (c *Client) Log() *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"conn_id":     c.ID,
+		"remote_addr": c.Conn.RemoteAddr().String(),
+		"user":        c.GetName(),
+	})
 }
 
 // function to read input
 func (c *Client) ReadInput() {
 
+	// a single reader is used, so lines which arrive together are not lost
+	reader := NewLineReader(c.Conn, c.MaxLineSize)
+
+	// time of the last line which is not a heartbeat,
+	// heartbeats keep the connection alive but do not make the client active
+	active := time.Now()
+
 	// continuously...
 	for {
 
+		// the deadline covers the whole line, so a slowly sent line times out too
+		if c.ReadTimeout > 0 {
+			c.Conn.SetReadDeadline(active.Add(c.ReadTimeout))
+		}
+
 		// read user input
-		msg, err := bufio.NewReader(c.Conn).ReadString('\n')
+		msg, err := reader.ReadLine()
 		if err != nil {
-			logrus.WithError(err).Info("Error accured when reading msg")
-			// abort if an error occurs
+			c.Log().WithError(err).Info("Error accured when reading msg")
+
+			// tell the client why it is disconnected, the connection is dropped anyway
+			if err == ErrLineTooLong {
+				c.Err(err)
+			} else if isTimeout(err) {
+				c.Err(errIdleTimeout)
+			}
+
+			// abort if an error occurs and let the server remove the client
+			c.Commands <- Command{
+				ID:     CmdDisconnect,
+				Client: c,
+			}
 			return
 		}
+		atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
 
 		// process input, to parse commands
 		msg = strings.Trim(msg, "\r\n")
+
+		// clients speaking the JSON protocol can send requests, their answers carry the request id
+		var id uint64
+		if strings.HasPrefix(msg, "{") && c.Proto() == ProtoJSON {
+			var req Request
+			if err := json.Unmarshal([]byte(msg), &req); err != nil {
+				c.Err(fmt.Errorf("invalid request: %v", err))
+				continue
+			}
+			id, msg = req.ID, req.Command
+		}
 		args := strings.Split(msg, " ")
 		cmd := strings.TrimSpace(args[0])
+		if cmd != "/ping" && cmd != "/pong" {
+			active = time.Now()
+		}
 
 		// update client command for desired command
 		switch cmd {
 
+		case "/pong":
+			// answer to a heartbeat ping of the server, reading it is enough
+		case "/ping":
+			// heartbeat of the client, answered without waiting for the dispatcher
+			c.Send(c, Event{Type: EventPong, Text: strings.Join(args[1:], " ")})
+
 		case "/name":
 			// specify your name
 			c.Commands <- Command{
-				ID:     CmdName,
-				Client: c,
-				Args:   args,
+				ID:        CmdName,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
 			}
 		case "/join":
 			// connect to another user :
 			// to be able to chat with him/her
 			c.Commands <- Command{
-				ID:     CmdJoin,
-				Client: c,
-				Args:   args,
+				ID:        CmdJoin,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
 			}
 		case "/list":
 			// display all the available users on the server :
 			// these are ones you ( a client ) can join and chat to
 			c.Commands <- Command{
-				ID:     CmdList,
-				Client: c,
+				ID:        CmdList,
+				Client:    c,
+				RequestID: id,
 			}
 		case "/msg":
 			// send a message to the user ( another client ) you have joined
 			c.Commands <- Command{
-				ID:     CmdMsg,
-				Client: c,
-				Args:   args,
+				ID:        CmdMsg,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
 			}
 		case "/quit":
 			// exit the chat system
 			c.Commands <- Command{
-				ID:     CmdQuit,
-				Client: c,
+				ID:        CmdQuit,
+				Client:    c,
+				RequestID: id,
 			}
 		case "/help":
 			// return command list
 			c.Commands <- Command{
-				ID:     CmdHelp,
-				Client: c,
+				ID:        CmdHelp,
+				Client:    c,
+				RequestID: id,
 			}
 		case "/get-m-from-me":
 			// return all messages which is sended from user
 			c.Commands <- Command{
-				ID:     CmdGetMessageFromMe,
-				Client: c,
-				Args:   args,
+				ID:        CmdGetMessageFromMe,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
 			}
 		case "/get-m-to-me":
 			// return all messages which is sended to user
 			c.Commands <- Command{
-				ID:     CmdGetMessageToMe,
-				Client: c,
-				Args:   args,
+				ID:        CmdGetMessageToMe,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
 			}
 		case "/get-last":
 			// return last X messages which is sended from user
 			c.Commands <- Command{
-				ID:     CmdGetLast,
-				Client: c,
-				Args:   args,
+				ID:        CmdGetLast,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
 			}
 		case "/get-contains":
 			// return all messages which is contains a word and also sended from user
 			c.Commands <- Command{
-				ID:     CmdGetContains,
-				Client: c,
-				Args:   args,
+				ID:        CmdGetContains,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/reply":
+			// reply to a message with its id
+			c.Commands <- Command{
+				ID:        CmdReply,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/thread":
+			// return the reply chain of a message
+			c.Commands <- Command{
+				ID:        CmdThread,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/file-offer":
+			// offer a file to the user you have joined
+			c.Commands <- Command{
+				ID:        CmdFileOffer,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/file-accept":
+			// accept a file offered to you
+			c.Commands <- Command{
+				ID:        CmdFileAccept,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/file-decline":
+			// decline a file offered to you
+			c.Commands <- Command{
+				ID:        CmdFileDecline,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/file-chunk":
+			// send a part of an accepted file
+			c.Commands <- Command{
+				ID:        CmdFileChunk,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/file-done":
+			// complete sending of a file
+			c.Commands <- Command{
+				ID:        CmdFileDone,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/resume":
+			// resume a session from a new connection
+			c.Commands <- Command{
+				ID:        CmdResume,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/proto":
+			// choose the protocol, text or json
+			c.Commands <- Command{
+				ID:        CmdProto,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/bot":
+			// mark the client as a bot
+			c.Commands <- Command{
+				ID:        CmdBot,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/schedule":
+			// send a message to the contact later
+			c.Commands <- Command{
+				ID:        CmdSchedule,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/scheduled":
+			// list the scheduled messages of the client
+			c.Commands <- Command{
+				ID:        CmdScheduled,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/unschedule":
+			// cancel a scheduled message
+			c.Commands <- Command{
+				ID:        CmdUnschedule,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/msg-ttl":
+			// send a message which is deleted after a duration
+			c.Commands <- Command{
+				ID:        CmdMsgTTL,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/ttl":
+			// show or set the default time to live of the messages with the contact
+			c.Commands <- Command{
+				ID:        CmdTTL,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/export":
+			// download your own message history as a file
+			c.Commands <- Command{
+				ID:        CmdExport,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/block":
+			// stop receiving messages from a user
+			c.Commands <- Command{
+				ID:        CmdBlock,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/unblock":
+			// receive messages from a blocked user again
+			c.Commands <- Command{
+				ID:        CmdUnblock,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/admin":
+			// authenticate as an admin
+			c.Commands <- Command{
+				ID:        CmdAdmin,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/kick":
+			// disconnect a user (admin only)
+			c.Commands <- Command{
+				ID:        CmdKick,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/ban":
+			// ban a user or an ip address (admin only)
+			c.Commands <- Command{
+				ID:        CmdBan,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/unban":
+			// lift a ban of a user or an ip address (admin only)
+			c.Commands <- Command{
+				ID:        CmdUnban,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/broadcast":
+			// send a message to all users (admin only)
+			c.Commands <- Command{
+				ID:        CmdBroadcast,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
+		case "/who":
+			// list connection details of all users (admin only)
+			c.Commands <- Command{
+				ID:        CmdWho,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
 			}
 			// for any other command
 		default:
-			c.Err(fmt.Errorf("unknown command: %s", cmd))
-			c.Msg(c, "* use '/help' to   command to send message to selected user")
+			// the server answers unknown commands, requests included
+			args[0] = cmd
+			c.Commands <- Command{
+				ID:        CmdUnknown,
+				Client:    c,
+				Args:      args,
+				RequestID: id,
+			}
 		}
 	}
 }
 
 // writes an error message current client
-func (c *Client) Err(err error) {
-
-	_, e := c.Conn.Write([]byte("err: " + err.Error() + "\n"))
-	if e != nil {
-		logrus.WithError(e).Fatalln("unable to write to connection", e)
+func (c *Client) Err(err error) error {
+	if c.Proto() == ProtoJSON {
+		return c.writeEvent(Event{Type: EventError, Text: err.Error()})
 	}
+	return c.write("err: " + err.Error() + "\n")
 }
 
-// writes a message to specified client
-func (c *Client) Msg(x *Client, msg string) {
+// writes a message to specified client :
+// messages to other clients are encrypted with their public key
+func (c *Client) Msg(x *Client, msg string) error {
+	return c.Send(x, Event{Type: EventText, Text: msg})
+}
+
+// Send writes an event to specified client :
+// the text of events to other clients is encrypted with their public key
+func (c *Client) Send(x *Client, e Event) error {
 
 	// if contacting other client
 	if c.Private != x.Private {
+		start := time.Now()
+		text, err := crypto.Decrypt(e.Text, *x.Private)
+		metrics.ObserveCrypto("decrypt", start)
+		if err != nil {
+			return err
+		}
+		e.Text = text
+	}
 
-		dMsg := crypto.Decrypt(msg, *x.Private)
-
-		// write message to client
-		_, e := x.Conn.Write([]byte("> " + dMsg + "\n"))
-		if e != nil {
-			logrus.WithError(e).Fatalln("unable to write to connection", e)
+	// write event to client
+	return x.writeEvent(e)
+}
 
-		}
+// writeEvent writes an event in the protocol of the client
+func (c *Client) writeEvent(e Event) error {
+	if c.Proto() != ProtoJSON {
+		return c.write("> " + e.String() + "\n")
+	}
+	if e.ID == 0 && e.answersRequest() {
+		e.ID = atomic.LoadUint64(&c.request)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.write(string(line) + "\n")
+}
 
-	} else {
-		// write message to client
-		_, e := x.Conn.Write([]byte("> " + msg + "\n"))
-		if e != nil {
-			logrus.WithError(e).Fatalln("unable to write to connection", e)
-		}
+// write writes to the client connection within the write timeout :
+// a connection which can not be written to is closed, so its reader stops
+// and the server removes the client
+func (c *Client) write(str string) error {
+	if c.WriteTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+	}
+	_, err := c.Conn.Write([]byte(str))
+	if err != nil {
+		c.Log().WithError(err).Info("unable to write to connection, closing it")
+		c.Conn.Close()
+		return fmt.Errorf("unable to write to connection: %v", err)
 	}
+	return nil
+}
+
+// errIdleTimeout is sent to a client which does not send a line in time
+var errIdleTimeout = errors.New("idle timeout")
 
+// isTimeout reports whether err is caused by a deadline
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
 }
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPipeClient creates a client on one side of a pipe
+func newPipeClient(t *testing.T, name string) (*Client, net.Conn) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close(); server.Close() })
+	return &Client{
+		Conn:         server,
+		Name:         name,
+		Private:      key,
+		Public:       key.PublicKey,
+		WriteTimeout: time.Second,
+	}, conn
+}
+
+func TestClient_Msg(t *testing.T) {
+	sender, _ := newPipeClient(t, "Test")
+	recipient, conn := newPipeClient(t, "Test2")
+
+	eMsg, err := crypto.Encrypt("Test : Test Text", recipient.Public)
+	assert.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- sender.Msg(recipient, eMsg) }()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "> Test : Test Text\n", line)
+	assert.NoError(t, <-done)
+}
+
+func TestClient_MsgClosedConnection(t *testing.T) {
+	sender, _ := newPipeClient(t, "Test")
+	recipient, conn := newPipeClient(t, "Test2")
+
+	// the other side of the connection is gone
+	conn.Close()
+
+	eMsg, err := crypto.Encrypt("Test : Test Text", recipient.Public)
+	assert.NoError(t, err)
+	assert.Error(t, sender.Msg(recipient, eMsg))
+	assert.Error(t, recipient.Msg(recipient, "Test Text"))
+	assert.Error(t, recipient.Err(errors.New("Test Error")))
+
+	// the connection is closed, so the reader of the client stops
+	_, err = recipient.Conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestClient_MsgWriteTimeout(t *testing.T) {
+	c, _ := newPipeClient(t, "Test")
+	c.WriteTimeout = 50 * time.Millisecond
+
+	// nobody reads from the other side of the pipe
+	err := c.Msg(c, "Test Text")
+	assert.Error(t, err)
+}
+
+func TestClient_MsgInvalidCipherText(t *testing.T) {
+	sender, _ := newPipeClient(t, "Test")
+	recipient, _ := newPipeClient(t, "Test2")
+
+	assert.Error(t, sender.Msg(recipient, "not encrypted"))
+}
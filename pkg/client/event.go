@@ -0,0 +1,161 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+// protocols a client can speak, chosen with /proto
+const (
+	// lines of text prefixed with "> ", the default
+	ProtoText = "text"
+
+	// one JSON encoded Event per line, commands can carry a request id
+	ProtoJSON = "json"
+)
+
+// types of events
+const (
+	// a reply of the server or a notice, shown as it is
+	EventText = "text"
+
+	// a problem with the connection, like a too long line
+	EventError = "error"
+
+	// a message or a reply of another user
+	EventMessage = "message"
+
+	// a message which expired and is deleted, clients remove it
+	EventExpired = "expired"
+
+	// a message of an admin to all users
+	EventBroadcast = "broadcast"
+
+	// the answer of /list
+	EventUsers = "users"
+
+	// the answer of the history commands, a notice in its text when it is empty
+	EventMessages = "messages"
+
+	// the token which resumes the session of a name
+	EventSession = "session"
+
+	// heartbeats
+	EventPing = "ping"
+	EventPong = "pong"
+
+	// the answer of /proto
+	EventProto = "proto"
+
+	// the end of the answer of a request
+	EventDone = "done"
+)
+
+// Event is a line sent to a client
+type Event struct {
+	Type string `json:"type"`
+
+	// request the event answers, zero for events which do not answer a request
+	ID uint64 `json:"id,omitempty"`
+
+	Text string `json:"text,omitempty"`
+
+	// sender of messages and broadcasts
+	From string `json:"from,omitempty"`
+
+	// id of a reply, the message it replies to and a quote of it
+	MessageID int64  `json:"message_id,omitempty"`
+	ParentID  int64  `json:"parent_id,omitempty"`
+	Quote     string `json:"quote,omitempty"`
+
+	// time a message is deleted, nil when it is kept
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	Users    []string        `json:"users,omitempty"`
+	Messages []model.Message `json:"messages,omitempty"`
+
+	// name and token of a session
+	Name  string `json:"name,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// String returns the event as it is shown to clients speaking the text protocol
+func (e Event) String() string {
+	switch e.Type {
+	case EventMessage:
+		text := e.Text
+		if e.Quote != "" {
+			// the quote of a reply starts with its id
+			text = e.Quote + " " + text
+		}
+		switch {
+		case e.ExpiresAt != nil && e.Quote != "":
+			text = fmt.Sprintf("[expires %s] %s", e.ExpiresAt.Format(time.RFC3339), text)
+		case e.ExpiresAt != nil:
+			text = fmt.Sprintf("[#%d expires %s] %s", e.MessageID, e.ExpiresAt.Format(time.RFC3339), text)
+		case e.Quote == "" && e.MessageID != 0:
+			// the id is shown so the message can be replied to
+			text = fmt.Sprintf("[#%d] %s", e.MessageID, text)
+		}
+		return e.From + " : " + text
+	case EventExpired:
+		return fmt.Sprintf("message #%d expired", e.MessageID)
+	case EventBroadcast:
+		return "[broadcast] " + e.From + " : " + e.Text
+	case EventUsers:
+		return "available users: " + strings.Join(e.Users, ", ")
+	case EventMessages:
+		if len(e.Messages) == 0 {
+			return e.Text
+		}
+		var b strings.Builder
+		for _, message := range e.Messages {
+			b.WriteString(message.ToString())
+		}
+		return b.String()
+	case EventSession:
+		return "/session " + e.Name + " " + e.Token
+	case EventPing:
+		return strings.TrimSpace("/ping " + e.Text)
+	case EventPong:
+		return strings.TrimSpace("/pong " + e.Text)
+	case EventProto:
+		return "protocol " + e.Text
+	}
+	return e.Text
+}
+
+// Expired reports whether the message of an event is deleted at now
+func (e Event) Expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !e.ExpiresAt.After(now)
+}
+
+// answersRequest reports whether an event is part of the answer of a request,
+// messages of other users and heartbeats are not even when they arrive meanwhile
+func (e Event) answersRequest() bool {
+	switch e.Type {
+	case EventMessage, EventExpired, EventBroadcast, EventPing, EventPong, EventDone:
+		return false
+	}
+	return true
+}
+
+// Request is a command sent by a client speaking the JSON protocol
+type Request struct {
+	// echoed in the events which answer the command, followed by a done event
+	ID uint64 `json:"id"`
+
+	// the command line, like "/get-last 10"
+	Command string `json:"command"`
+}
+
+// ParseEvent decodes a line of the JSON protocol
+func ParseEvent(line []byte) (Event, error) {
+	var e Event
+	err := json.Unmarshal(line, &e)
+	return e, err
+}
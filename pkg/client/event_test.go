@@ -0,0 +1,30 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvent_String(t *testing.T) {
+	expiresAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{name: "message", event: Event{Type: EventMessage, From: "Test", Text: "Hello"}, want: "Test : Hello"},
+		{name: "message with id", event: Event{Type: EventMessage, From: "Test", Text: "Hello", MessageID: 3}, want: "Test : [#3] Hello"},
+		{name: "message with expiry", event: Event{Type: EventMessage, From: "Test", Text: "Hello", MessageID: 3, ExpiresAt: &expiresAt}, want: "Test : [#3 expires 2021-03-01T12:00:00Z] Hello"},
+		{name: "reply", event: Event{Type: EventMessage, From: "Test", Text: "Fine", MessageID: 4, Quote: `[#4 re #3 Test2: "Hello"]`}, want: `Test : [#4 re #3 Test2: "Hello"] Fine`},
+		{name: "reply with expiry", event: Event{Type: EventMessage, From: "Test", Text: "Fine", MessageID: 4, Quote: `[#4 re #3 Test2: "Hello"]`, ExpiresAt: &expiresAt}, want: `Test : [expires 2021-03-01T12:00:00Z] [#4 re #3 Test2: "Hello"] Fine`},
+		{name: "expired", event: Event{Type: EventExpired, MessageID: 3}, want: "message #3 expired"},
+		{name: "broadcast", event: Event{Type: EventBroadcast, From: "admin", Text: "Restart"}, want: "[broadcast] admin : Restart"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.event.String(); got != tt.want {
+				t.Errorf("Event.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// DefaultMaxLineSize is used when no maximum line size is configured
+const DefaultMaxLineSize = 4096
+
+// ErrLineTooLong is returned when a line exceeds the maximum line size
+var ErrLineTooLong = errors.New("line too long")
+
+// LineReader reads newline terminated lines with an upper bound on their size,
+// so a client can not exhaust server memory with an endless line
+type LineReader struct {
+	r   *bufio.Reader
+	max int
+}
+
+// NewLineReader creates a reader which returns lines of at most max bytes
+func NewLineReader(r io.Reader, max int) *LineReader {
+	if max <= 0 {
+		max = DefaultMaxLineSize
+	}
+	size := max + 1
+	if size < 16 {
+		size = 16
+	}
+	return &LineReader{
+		r:   bufio.NewReaderSize(r, size),
+		max: max,
+	}
+}
+
+// ReadLine returns the next line including its newline :
+// when the line is longer than the limit ErrLineTooLong is returned,
+// the rest of the line is not consumed and the reader should not be used anymore
+func (l *LineReader) ReadLine() (string, error) {
+	line, err := l.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", ErrLineTooLong
+	}
+	size := len(line)
+	if size > 0 && line[size-1] == '\n' {
+		size--
+	}
+	if size > l.max {
+		return "", ErrLineTooLong
+	}
+	return string(line), err
+}
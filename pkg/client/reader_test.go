@@ -0,0 +1,194 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineReader_ReadLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		max   int
+		want  []string
+		err   error
+	}{
+		{name: "single line", input: "/list\n", max: 10, want: []string{"/list\n"}, err: io.EOF},
+		{name: "multiple lines", input: "/list\n/help\n", max: 10, want: []string{"/list\n", "/help\n"}, err: io.EOF},
+		{name: "line at limit", input: "0123456789\n", max: 10, want: []string{"0123456789\n"}, err: io.EOF},
+		{name: "line over limit", input: "0123456789a\n", max: 10, err: ErrLineTooLong},
+		{name: "endless line", input: strings.Repeat("a", 100000), max: 10, err: ErrLineTooLong},
+		{name: "line over limit after valid line", input: "/list\n" + strings.Repeat("a", 100), max: 10, want: []string{"/list\n"}, err: ErrLineTooLong},
+		{name: "unterminated line", input: "/list", max: 10, want: []string{"/list"}, err: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewLineReader(strings.NewReader(tt.input), tt.max)
+			var got []string
+			var err error
+			for {
+				var line string
+				line, err = r.ReadLine()
+				if line != "" {
+					got = append(got, line)
+				}
+				if err != nil {
+					break
+				}
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func FuzzLineReader(f *testing.F) {
+	f.Add([]byte("/name Test\n/list\n"), 8)
+	f.Add([]byte(strings.Repeat("a", 64)), 16)
+	f.Add([]byte("\n\n\n"), 1)
+	f.Fuzz(func(t *testing.T, input []byte, max int) {
+		if max <= 0 || max > 1<<16 {
+			return
+		}
+		r := NewLineReader(strings.NewReader(string(input)), max)
+		read := ""
+		for {
+			line, err := r.ReadLine()
+			if len(strings.TrimSuffix(line, "\n")) > max {
+				t.Fatalf("line of %d bytes exceeds limit %d", len(line), max)
+			}
+			read += line
+			if err == ErrLineTooLong {
+				return
+			}
+			if err != nil {
+				break
+			}
+		}
+		// without an error every byte is returned exactly once
+		if read != string(input) {
+			t.Fatalf("read %q, want %q", read, input)
+		}
+	})
+}
+
+// newTestClient starts reading input of a client on one side of a pipe
+func newTestClient(t *testing.T, maxLineSize int, readTimeout time.Duration) (net.Conn, chan Command) {
+	server, conn := net.Pipe()
+	commands := make(chan Command, 10)
+	c := &Client{
+		Conn:         server,
+		Name:         "anonymous",
+		Commands:     commands,
+		MaxLineSize:  maxLineSize,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: time.Second,
+	}
+	go c.ReadInput()
+	t.Cleanup(func() { conn.Close(); server.Close() })
+	return conn, commands
+}
+
+// expectDisconnect waits for the client to report its disconnection
+func expectDisconnect(t *testing.T, commands chan Command) {
+	select {
+	case cmd := <-commands:
+		assert.Equal(t, CmdDisconnect, cmd.ID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("client is not disconnected")
+	}
+}
+
+func TestClient_ReadInputLineTooLong(t *testing.T) {
+	conn, commands := newTestClient(t, 16, 0)
+
+	go conn.Write([]byte(strings.Repeat("a", 1024)))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "err: line too long\n", line)
+	expectDisconnect(t, commands)
+}
+
+func TestClient_ReadInputSlowLoris(t *testing.T) {
+	conn, commands := newTestClient(t, 1024, 200*time.Millisecond)
+
+	// send a line one byte at a time, each byte comes before the timeout
+	// but the whole line takes longer than it
+	go func() {
+		for i := 0; i < 10; i++ {
+			if _, err := conn.Write([]byte("a")); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "err: idle timeout\n", line)
+	expectDisconnect(t, commands)
+}
+
+func TestClient_ReadInputIdle(t *testing.T) {
+	conn, commands := newTestClient(t, 1024, 100*time.Millisecond)
+
+	// a client sending complete lines in time stays connected
+	for i := 0; i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, err := conn.Write([]byte("/list\n"))
+		assert.NoError(t, err)
+		cmd := <-commands
+		assert.Equal(t, CmdList, cmd.ID)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "err: idle timeout\n", line)
+	expectDisconnect(t, commands)
+}
+
+func TestClient_ReadInputHeartbeat(t *testing.T) {
+	conn, commands := newTestClient(t, 1024, 0)
+	reader := bufio.NewReader(conn)
+
+	// pings of the client are answered by the reader
+	_, err := conn.Write([]byte("/ping 7\n"))
+	assert.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "> /pong 7\n", line)
+
+	// pongs only count as activity, they do not reach the dispatcher
+	_, err = conn.Write([]byte("/pong 1\n/list\n"))
+	assert.NoError(t, err)
+	cmd := <-commands
+	assert.Equal(t, CmdList, cmd.ID)
+	assert.WithinDuration(t, time.Now(), cmd.Client.LastSeen(), time.Second)
+}
+
+func TestClient_ReadInputHeartbeatIsNotActivity(t *testing.T) {
+	conn, commands := newTestClient(t, 1024, 150*time.Millisecond)
+	reader := bufio.NewReader(conn)
+
+	// heartbeats alone do not keep an idle client connected
+	go func() {
+		for i := 0; i < 10; i++ {
+			if _, err := conn.Write([]byte("/pong 1\n")); err != nil {
+				return
+			}
+			time.Sleep(30 * time.Millisecond)
+		}
+	}()
+
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "err: idle timeout\n", line)
+	expectDisconnect(t, commands)
+}
@@ -0,0 +1,35 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// NewChunkKey returns a random AES-256 key which encrypts the chunks of a file transfer :
+// it is passed to the recipient once with RSA, so chunks are not limited to an RSA block
+func NewChunkKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("unable to create key: %v", err)
+	}
+	return key, nil
+}
+
+// SealChunk encrypts a chunk of a file with the key of its transfer
+func SealChunk(key []byte, chunk []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, nil, chunk, nil)
+}
+
+// OpenChunk decrypts a chunk encrypted by SealChunk
+func OpenChunk(key []byte, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, sealed, nil)
+}
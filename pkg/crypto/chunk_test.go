@@ -0,0 +1,36 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealOpenChunk(t *testing.T) {
+	key, err := NewChunkKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := bytes.Repeat([]byte{0, 1, 2, 3}, 512)
+
+	sealed, err := SealChunk(key, chunk)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(sealed), string(chunk))
+
+	opened, err := OpenChunk(key, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, chunk, opened)
+
+	// a chunk changed on the way or opened with another key is refused
+	sealed[len(sealed)-1] ^= 1
+	_, err = OpenChunk(key, sealed)
+	assert.Error(t, err)
+	other, err := NewChunkKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	_, err = OpenChunk(other, sealed)
+	assert.Error(t, err)
+}
@@ -5,12 +5,11 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
-
-	"github.com/sirupsen/logrus"
+	"fmt"
 )
 
 // function to encrypt message to be sent
-func Encrypt(msg string, key rsa.PublicKey) string {
+func Encrypt(msg string, key rsa.PublicKey) (string, error) {
 
 	label := []byte("OAEP Encrypted")
 	rng := rand.Reader
@@ -20,17 +19,19 @@ func Encrypt(msg string, key rsa.PublicKey) string {
 	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rng, &key, []byte(msg), label)
 	// check for errors
 	if err != nil {
-		logrus.WithError(err).Fatal("unable to encrypt")
-
+		return "", fmt.Errorf("unable to encrypt: %v", err)
 	}
 
-	return base64.StdEncoding.EncodeToString(ciphertext)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
 // function to decrypt message to be received
-func Decrypt(cipherText string, key rsa.PrivateKey) string {
+func Decrypt(cipherText string, key rsa.PrivateKey) (string, error) {
 
-	ct, _ := base64.StdEncoding.DecodeString(cipherText)
+	ct, err := base64.StdEncoding.DecodeString(cipherText)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode: %v", err)
+	}
 	label := []byte("OAEP Encrypted")
 	rng := rand.Reader
 
@@ -38,7 +39,7 @@ func Decrypt(cipherText string, key rsa.PrivateKey) string {
 	plaintext, err := rsa.DecryptOAEP(sha256.New(), rng, &key, ct, label)
 	// check for errors
 	if err != nil {
-		logrus.WithError(err).Fatal("Decrypt proccess fail")
+		return "", fmt.Errorf("unable to decrypt: %v", err)
 	}
-	return string(plaintext)
+	return string(plaintext), nil
 }
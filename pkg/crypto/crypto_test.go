@@ -0,0 +1,53 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eMsg, err := Encrypt("Test : Test Text", key.PublicKey)
+	assert.NoError(t, err)
+
+	msg, err := Decrypt(eMsg, *key)
+	assert.NoError(t, err)
+	assert.Equal(t, "Test : Test Text", msg)
+}
+
+func TestEncryptTooLong(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Encrypt(strings.Repeat("a", 1024), key.PublicKey)
+	assert.Error(t, err)
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Decrypt("not base64!", *key)
+	assert.Error(t, err)
+
+	eMsg, err := Encrypt("Test Text", other.PublicKey)
+	assert.NoError(t, err)
+	_, err = Decrypt(eMsg, *key)
+	assert.Error(t, err)
+}
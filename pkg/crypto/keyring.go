@@ -0,0 +1,177 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// KeySize is the size of keys which encrypt stored messages, they are AES-256 keys
+const KeySize = 32
+
+// KeyConfig is a key which encrypts stored messages, given in base64 or in a file which holds it in base64
+type KeyConfig struct {
+	ID      string `yaml:"id"`
+	Key     string `yaml:"key"`
+	KeyFile string `yaml:"key_file"`
+}
+
+// AtRestConfig defines the keys of stored messages
+type AtRestConfig struct {
+	// id of the key new messages are encrypted with, they are stored in plain text when empty
+	ActiveKey string `yaml:"active_key"`
+
+	// keys messages may be encrypted with : a rotated key is kept until
+	// the messages it encrypted are encrypted with the active key
+	Keys []KeyConfig `yaml:"keys"`
+}
+
+// BoundPrefix starts the texts Seal encrypts, which are bound to their additional data :
+// texts without it were encrypted by older versions without additional data
+const BoundPrefix = "v2:"
+
+// ErrUnknownKey is returned when a stored message is encrypted with a key which is not configured
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Keyring encrypts stored messages with envelope encryption : each message is encrypted
+// with a random data key, which is encrypted with a configured key and stored along
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewKeyring reads the keys of cfg
+func NewKeyring(cfg AtRestConfig) (*Keyring, error) {
+	k := &Keyring{active: cfg.ActiveKey, keys: make(map[string]cipher.AEAD)}
+	for _, key := range cfg.Keys {
+		encoded := key.Key
+		if key.KeyFile != "" {
+			data, err := ioutil.ReadFile(key.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %v", key.ID, err)
+			}
+			encoded = strings.TrimSpace(string(data))
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(secret) != KeySize {
+			return nil, fmt.Errorf("key %s: must be %d bytes in base64", key.ID, KeySize)
+		}
+		k.keys[key.ID], err = newGCM(secret)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", key.ID, err)
+		}
+	}
+	if _, ok := k.keys[k.active]; k.active != "" && !ok {
+		return nil, fmt.Errorf("active key %s: %v", k.active, ErrUnknownKey)
+	}
+	return k, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ActiveKey returns the id of the key new messages are encrypted with, empty when they are not encrypted
+func (k *Keyring) ActiveKey() string {
+	return k.active
+}
+
+// Seal encrypts a text with the active key and returns the id of the key with the
+// encrypted text in base64, the text is returned as it is when there is no active key.
+// The text is bound to data, like the row it is stored in : it is only opened with the same data
+func (k *Keyring) Seal(text string, data []byte) (string, string, error) {
+	if k.active == "" {
+		return "", text, nil
+	}
+	kek := k.keys[k.active]
+
+	dataKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", "", fmt.Errorf("unable to create data key: %v", err)
+	}
+	dek, err := newGCM(dataKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	// the data key is bound to the id of the key which encrypts it
+	sealed, err := seal(kek, nil, dataKey, []byte(k.active))
+	if err != nil {
+		return "", "", err
+	}
+	sealed, err = seal(dek, sealed, []byte(text), data)
+	if err != nil {
+		return "", "", err
+	}
+	return k.active, BoundPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a text which Seal encrypted with the key of keyID and data,
+// a text without a key id is returned as it is
+func (k *Keyring) Open(keyID string, sealed string, data []byte) (string, error) {
+	if keyID == "" {
+		return sealed, nil
+	}
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("key %s: %v", keyID, ErrUnknownKey)
+	}
+	// texts of older versions are not bound to any data
+	if !strings.HasPrefix(sealed, BoundPrefix) {
+		data = nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, BoundPrefix))
+	if err != nil {
+		return "", fmt.Errorf("unable to decode: %v", err)
+	}
+
+	wrapped := kek.NonceSize() + KeySize + kek.Overhead()
+	if len(raw) < wrapped {
+		return "", errors.New("unable to decrypt: text is too short")
+	}
+	dataKey, err := open(kek, raw[:wrapped], []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	dek, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	text, err := open(dek, raw[wrapped:], data)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// seal appends a random nonce and the encrypted plaintext to dst
+func seal(aead cipher.AEAD, dst []byte, plaintext []byte, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("unable to create nonce: %v", err)
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, data), nil
+}
+
+// open decrypts a nonce followed by a ciphertext of seal
+func open(aead cipher.AEAD, sealed []byte, data []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("unable to decrypt: text is too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt: %v", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	// additional data the texts of the tests are bound to
+	row = []byte("messages:1")
+
+	oldKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", KeySize)))
+	newKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", KeySize)))
+)
+
+func TestKeyring_SealOpen(t *testing.T) {
+	k, err := NewKeyring(AtRestConfig{ActiveKey: "2021-01", Keys: []KeyConfig{{ID: "2021-01", Key: oldKey}}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	keyID, sealed, err := k.Seal("Test Text", row)
+	assert.NoError(t, err)
+	assert.Equal(t, "2021-01", keyID)
+	assert.NotContains(t, sealed, "Test Text")
+
+	// every text has its own data key
+	_, again, _ := k.Seal("Test Text", row)
+	assert.NotEqual(t, sealed, again)
+
+	text, err := k.Open(keyID, sealed, row)
+	assert.NoError(t, err)
+	assert.Equal(t, "Test Text", text)
+
+	// a text moved to another row is not opened
+	_, err = k.Open(keyID, sealed, []byte("messages:2"))
+	assert.EqualError(t, err, "unable to decrypt: cipher: message authentication failed")
+	_, err = k.Open(keyID, strings.TrimPrefix(sealed, BoundPrefix), row)
+	assert.Error(t, err)
+
+	// texts stored before encryption have no key
+	text, err = k.Open("", "Plain Text", row)
+	assert.NoError(t, err)
+	assert.Equal(t, "Plain Text", text)
+}
+
+func TestKeyring_rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "new.key")
+	if err := ioutil.WriteFile(file, []byte(newKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	old, _ := NewKeyring(AtRestConfig{ActiveKey: "old", Keys: []KeyConfig{{ID: "old", Key: oldKey}}})
+	_, sealed, _ := old.Seal("Test Text", row)
+
+	// old messages are read after a new key is active
+	k, err := NewKeyring(AtRestConfig{ActiveKey: "new", Keys: []KeyConfig{{ID: "old", Key: oldKey}, {ID: "new", KeyFile: file}}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	text, err := k.Open("old", sealed, row)
+	assert.NoError(t, err)
+	assert.Equal(t, "Test Text", text)
+	keyID, _, _ := k.Seal("Test Text", row)
+	assert.Equal(t, "new", keyID)
+
+	// a data key is bound to the id of its key
+	_, err = k.Open("new", sealed, row)
+	assert.Error(t, err)
+
+	// without an active key messages are stored in plain text
+	plain, _ := NewKeyring(AtRestConfig{Keys: []KeyConfig{{ID: "old", Key: oldKey}}})
+	keyID, text, err = plain.Seal("Test Text", row)
+	assert.NoError(t, err)
+	assert.Equal(t, "", keyID)
+	assert.Equal(t, "Test Text", text)
+}
+
+func TestKeyring_errors(t *testing.T) {
+	_, err := NewKeyring(AtRestConfig{ActiveKey: "new", Keys: []KeyConfig{{ID: "old", Key: oldKey}}})
+	assert.EqualError(t, err, "active key new: unknown encryption key")
+	_, err = NewKeyring(AtRestConfig{Keys: []KeyConfig{{ID: "short", Key: "c2hvcnQ="}}})
+	assert.EqualError(t, err, "key short: must be 32 bytes in base64")
+	_, err = NewKeyring(AtRestConfig{Keys: []KeyConfig{{ID: "file", KeyFile: "/does/not/exist"}}})
+	assert.Error(t, err)
+
+	k, _ := NewKeyring(AtRestConfig{ActiveKey: "old", Keys: []KeyConfig{{ID: "old", Key: oldKey}}})
+	_, err = k.Open("gone", "c2hvcnQ=", row)
+	assert.EqualError(t, err, "key gone: unknown encryption key")
+	_, err = k.Open("old", "c2hvcnQ=", row)
+	assert.EqualError(t, err, "unable to decrypt: text is too short")
+
+	_, sealed, _ := k.Seal("Test Text", row)
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, BoundPrefix))
+	data[len(data)-1] ^= 1
+	_, err = k.Open("old", BoundPrefix+base64.StdEncoding.EncodeToString(data), row)
+	assert.Error(t, err)
+}
+
+func TestKeyring_openUnbound(t *testing.T) {
+	k, _ := NewKeyring(AtRestConfig{ActiveKey: "old", Keys: []KeyConfig{{ID: "old", Key: oldKey}}})
+
+	// texts of older versions were encrypted without additional data
+	dataKey := []byte(strings.Repeat("d", KeySize))
+	dek, _ := newGCM(dataKey)
+	sealed, err := seal(k.keys["old"], nil, dataKey, []byte("old"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	sealed, err = seal(dek, sealed, []byte("Test Text"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	text, err := k.Open("old", base64.StdEncoding.EncodeToString(sealed), row)
+	assert.NoError(t, err)
+	assert.Equal(t, "Test Text", text)
+}
@@ -0,0 +1,238 @@
+// Package history exports messages to JSON Lines or CSV files and imports them back,
+// to back up the history or move it between repositories.
+//
+// A JSON Lines file has a message per line, with the fields of model.Message. A CSV
+// file starts with a header line of the columns id, from, to, text, parent_id,
+// created_at and expires_at; times are in RFC 3339 format and expires_at is empty
+// for messages which are kept.
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+// formats of history files
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// number of messages read from the repository at once
+const batchSize = 500
+
+// columns of CSV files
+var csvHeader = []string{"id", "from", "to", "text", "parent_id", "created_at", "expires_at"}
+
+// KnownFormat reports whether a format can be exported and imported
+func KnownFormat(format string) bool {
+	return format == FormatJSONL || format == FormatCSV
+}
+
+// Source reads messages in pages ordered by id, like the message service
+type Source interface {
+	GetHistory(ctx context.Context, f model.HistoryFilter, afterID int64, limit int) ([]model.Message, error)
+}
+
+// Sink stores imported messages, like the message service
+type Sink interface {
+	StoreMessage(ctx context.Context, m model.Message) (int64, error)
+}
+
+// Writer writes messages to a history file, call Flush after the last message
+type Writer interface {
+	Write(m model.Message) error
+	Flush() error
+}
+
+// Reader reads messages of a history file, it returns io.EOF after the last message
+type Reader interface {
+	Read() (model.Message, error)
+}
+
+// NewWriter returns a writer of a history file in format
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatJSONL:
+		b := bufio.NewWriter(w)
+		return &jsonWriter{w: b, enc: json.NewEncoder(b)}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, use %s or %s", format, FormatJSONL, FormatCSV)
+}
+
+// NewReader returns a reader of a history file in format
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonReader{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		return &csvReader{r: csv.NewReader(r)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, use %s or %s", format, FormatJSONL, FormatCSV)
+}
+
+// Export writes the messages of a filter to w, ordered by id, and returns how many were written
+func Export(ctx context.Context, src Source, f model.HistoryFilter, w Writer) (int, error) {
+	var count int
+	var afterID int64
+	for {
+		messages, err := src.GetHistory(ctx, f, afterID, batchSize)
+		if err != nil {
+			return count, err
+		}
+		for _, m := range messages {
+			if err := w.Write(m); err != nil {
+				return count, err
+			}
+			count++
+			afterID = m.ID
+		}
+		if len(messages) < batchSize {
+			return count, w.Flush()
+		}
+	}
+}
+
+// Import stores the messages of r and returns how many were stored. Messages get new ids,
+// replies are linked to the new id of their parent; a reply whose parent is not imported
+// before it loses its parent. Creation and expiry times are kept.
+func Import(ctx context.Context, dst Sink, r Reader) (int, error) {
+	var count int
+	ids := make(map[int64]int64)
+	for {
+		m, err := r.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("message %d: %v", count+1, err)
+		}
+		old := m.ID
+		m.ID = 0
+		m.ParentID = ids[m.ParentID]
+		id, err := dst.StoreMessage(ctx, m)
+		if err != nil {
+			return count, fmt.Errorf("message %d: %v", count+1, err)
+		}
+		if old != 0 {
+			ids[old] = id
+		}
+		count++
+	}
+}
+
+type jsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonWriter) Write(m model.Message) error {
+	return w.enc.Encode(m)
+}
+
+func (w *jsonWriter) Flush() error {
+	return w.w.Flush()
+}
+
+type jsonReader struct {
+	dec *json.Decoder
+}
+
+func (r *jsonReader) Read() (model.Message, error) {
+	var m model.Message
+	err := r.dec.Decode(&m)
+	return m, err
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (w *csvWriter) Write(m model.Message) error {
+	if !w.header {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	var expiresAt string
+	if m.ExpiresAt != nil {
+		expiresAt = m.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return w.w.Write([]string{
+		strconv.FormatInt(m.ID, 10),
+		m.From,
+		m.To,
+		m.Text,
+		strconv.FormatInt(m.ParentID, 10),
+		m.CreatedAt.UTC().Format(time.RFC3339),
+		expiresAt,
+	})
+}
+
+// Flush writes the header too when there are no messages, so the file can be imported
+func (w *csvWriter) Flush() error {
+	if !w.header {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header bool
+}
+
+func (r *csvReader) Read() (model.Message, error) {
+	if !r.header {
+		record, err := r.r.Read()
+		if err != nil {
+			return model.Message{}, err
+		}
+		for i, column := range csvHeader {
+			if i >= len(record) || record[i] != column {
+				return model.Message{}, fmt.Errorf("header must be %v", csvHeader)
+			}
+		}
+		r.header = true
+	}
+	record, err := r.r.Read()
+	if err != nil {
+		return model.Message{}, err
+	}
+
+	var m model.Message
+	if m.ID, err = strconv.ParseInt(record[0], 10, 64); err != nil {
+		return m, fmt.Errorf("invalid id %q", record[0])
+	}
+	m.From, m.To, m.Text = record[1], record[2], record[3]
+	if m.ParentID, err = strconv.ParseInt(record[4], 10, 64); err != nil {
+		return m, fmt.Errorf("invalid parent_id %q", record[4])
+	}
+	if m.CreatedAt, err = time.Parse(time.RFC3339, record[5]); err != nil {
+		return m, fmt.Errorf("invalid created_at %q", record[5])
+	}
+	if record[6] != "" {
+		expiresAt, err := time.Parse(time.RFC3339, record[6])
+		if err != nil {
+			return m, fmt.Errorf("invalid expires_at %q", record[6])
+		}
+		m.ExpiresAt = &expiresAt
+	}
+	return m, nil
+}
@@ -0,0 +1,145 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore keeps messages in memory
+type memoryStore struct {
+	nextID   int64
+	messages []model.Message
+	pages    int
+}
+
+func (s *memoryStore) GetHistory(ctx context.Context, f model.HistoryFilter, afterID int64, limit int) ([]model.Message, error) {
+	s.pages++
+	var messages []model.Message
+	for _, m := range s.messages {
+		if m.ID > afterID && f.Match(m) && len(messages) < limit {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+func (s *memoryStore) StoreMessage(ctx context.Context, m model.Message) (int64, error) {
+	s.nextID++
+	m.ID = s.nextID
+	s.messages = append(s.messages, m)
+	return m.ID, nil
+}
+
+var (
+	createdAt = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt = time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+)
+
+// newStore returns a store with a conversation between alice and bob, and a message to carol
+func newStore() *memoryStore {
+	s := &memoryStore{nextID: 10}
+	s.StoreMessage(context.Background(), model.Message{From: "alice", To: "bob", Text: "Hello, \"bob\"\nhow are you?", CreatedAt: createdAt})
+	s.StoreMessage(context.Background(), model.Message{From: "bob", To: "alice", Text: "Fine", ParentID: 11, CreatedAt: createdAt.Add(time.Hour), ExpiresAt: &expiresAt})
+	s.StoreMessage(context.Background(), model.Message{From: "carol", To: "dave", Text: "Hi", CreatedAt: createdAt.Add(2 * time.Hour)})
+	return s
+}
+
+func TestExport_jsonl(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b, FormatJSONL)
+	assert.NoError(t, err)
+	count, err := Export(context.Background(), newStore(), model.HistoryFilter{User: "alice"}, w)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, `{"id":11,"from":"alice","to":"bob","text":"Hello, \"bob\"\nhow are you?","created_at":"2021-03-01T12:00:00Z"}
+{"id":12,"from":"bob","to":"alice","text":"Fine","parent_id":11,"created_at":"2021-03-01T13:00:00Z","expires_at":"2021-03-02T12:00:00Z"}
+`, b.String())
+}
+
+func TestExport_csv(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b, FormatCSV)
+	assert.NoError(t, err)
+	f := model.HistoryFilter{Since: createdAt.Add(time.Hour), Until: createdAt.Add(3 * time.Hour)}
+	count, err := Export(context.Background(), newStore(), f, w)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, `id,from,to,text,parent_id,created_at,expires_at
+12,bob,alice,Fine,11,2021-03-01T13:00:00Z,2021-03-02T12:00:00Z
+13,carol,dave,Hi,0,2021-03-01T14:00:00Z,
+`, b.String())
+
+	// an empty export has the header
+	b.Reset()
+	w, _ = NewWriter(&b, FormatCSV)
+	count, err = Export(context.Background(), newStore(), model.HistoryFilter{User: "erin"}, w)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, "id,from,to,text,parent_id,created_at,expires_at\n", b.String())
+}
+
+func TestExport_pages(t *testing.T) {
+	s := &memoryStore{}
+	for i := 0; i < batchSize+1; i++ {
+		s.StoreMessage(context.Background(), model.Message{From: "alice", To: "bob", Text: "Hi", CreatedAt: createdAt})
+	}
+	var b bytes.Buffer
+	w, _ := NewWriter(&b, FormatJSONL)
+	count, err := Export(context.Background(), s, model.HistoryFilter{}, w)
+	assert.NoError(t, err)
+	assert.Equal(t, batchSize+1, count)
+	assert.Equal(t, 2, s.pages)
+}
+
+func TestImport(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		var b bytes.Buffer
+		w, _ := NewWriter(&b, format)
+		_, err := Export(context.Background(), newStore(), model.HistoryFilter{}, w)
+		assert.NoError(t, err)
+
+		// messages get new ids, replies keep their parent
+		dst := &memoryStore{nextID: 100}
+		r, err := NewReader(&b, format)
+		assert.NoError(t, err)
+		count, err := Import(context.Background(), dst, r)
+		assert.NoError(t, err, format)
+		assert.Equal(t, 3, count, format)
+		if assert.Len(t, dst.messages, 3, format) {
+			want := newStore().messages
+			for i := range want {
+				want[i].ID += 90
+			}
+			want[1].ParentID = 101
+			assert.Equal(t, want, dst.messages, format)
+		}
+	}
+}
+
+func TestImport_errors(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		data   string
+		count  int
+		err    string
+	}{
+		{FormatJSONL, `{"id":1,"from":"alice","to":"bob","text":"Hi"}` + "\n{\"id\":", 1, "message 2: unexpected EOF"},
+		{FormatCSV, "id,from,to\n", 0, "message 1: header must be [id from to text parent_id created_at expires_at]"},
+		{FormatCSV, "id,from,to,text,parent_id,created_at,expires_at\n1,alice,bob,Hi,0,yesterday,\n", 0, `message 1: invalid created_at "yesterday"`},
+	} {
+		r, err := NewReader(strings.NewReader(test.data), test.format)
+		assert.NoError(t, err)
+		count, err := Import(context.Background(), &memoryStore{}, r)
+		assert.Equal(t, test.count, count)
+		assert.EqualError(t, err, test.err)
+	}
+
+	_, err := NewReader(strings.NewReader(""), "xml")
+	assert.EqualError(t, err, `unknown format "xml", use jsonl or csv`)
+}
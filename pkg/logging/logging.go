@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// log outputs
+const (
+	OutputStdout = "stdout"
+	OutputFile   = "file"
+)
+
+// Config defines logging settings
+type Config struct {
+	// one of panic, fatal, error, warning, info, debug, trace
+	Level string `yaml:"level"`
+
+	// text or json
+	Format string `yaml:"format"`
+
+	// stdout or file
+	Output string `yaml:"output"`
+
+	// Path of the log file when output is file
+	File string `yaml:"file"`
+
+	// Size in bytes a log file can grow to before it is rotated, no rotation when zero
+	MaxSize int64 `yaml:"max_size"`
+
+	// Number of rotated log files which are kept
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// ParseLevel returns the configured log level, info when it is not set
+func (cfg *Config) ParseLevel() logrus.Level {
+	if cfg == nil || cfg.Level == "" {
+		return logrus.InfoLevel
+	}
+	level, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		return logrus.InfoLevel
+	}
+	return level
+}
+
+// Formatter returns the formatter of the configured format
+func (cfg *Config) Formatter() logrus.Formatter {
+	if cfg != nil && cfg.Format == FormatJSON {
+		return &logrus.JSONFormatter{}
+	}
+	return &logrus.TextFormatter{
+		FullTimestamp: true,
+	}
+}
+
+// Open returns the configured output, stdout when it is not set
+func (cfg *Config) Open() (io.WriteCloser, error) {
+	if cfg == nil || cfg.Output != OutputFile {
+		return stdout{}, nil
+	}
+	return NewRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+}
+
+// stdout is an output which is never closed
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdout) Close() error {
+	return nil
+}
+
+var (
+	mu     sync.Mutex
+	output io.Closer
+)
+
+// Apply configures the standard logger with cfg, the previous output is
+// closed once it is replaced. debug enables trace logs whatever the level is.
+func Apply(cfg *Config, debug bool) error {
+	out, err := cfg.Open()
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if debug {
+		logrus.SetReportCaller(true)
+		logrus.SetLevel(logrus.TraceLevel)
+	} else {
+		logrus.SetReportCaller(false)
+		logrus.SetLevel(cfg.ParseLevel())
+	}
+	logrus.SetFormatter(cfg.Formatter())
+	logrus.SetOutput(out)
+	if output != nil {
+		output.Close()
+	}
+	output = out
+	return nil
+}
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tcp_message"
+
+var (
+	// ConnectedClients is the number of open client connections
+	ConnectedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_clients",
+		Help:      "Number of open client connections.",
+	})
+
+	// Commands counts processed commands by command name
+	Commands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Number of commands processed by the server.",
+	}, []string{"command"})
+
+	// ThrottledCommands counts commands rejected by rate limits by limit scope
+	ThrottledCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "throttled_commands_total",
+		Help:      "Number of commands rejected by rate limits.",
+	}, []string{"scope"})
+
+	// TimedOutCommands counts commands which ran out of time by command name
+	TimedOutCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timed_out_commands_total",
+		Help:      "Number of commands which ran out of time.",
+	}, []string{"command"})
+
+	// EvictedClients counts clients disconnected for not answering heartbeats
+	EvictedClients = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evicted_clients_total",
+		Help:      "Number of clients disconnected for not answering heartbeats.",
+	})
+
+	// Messages counts messages delivered to clients
+	Messages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_total",
+		Help:      "Number of messages delivered to clients.",
+	})
+
+	// MessageBytes counts bytes of messages delivered to clients
+	MessageBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "message_bytes_total",
+		Help:      "Number of plaintext bytes of messages delivered to clients.",
+	})
+
+	// CryptoDuration observes encryption and decryption latency by operation
+	CryptoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "crypto_duration_seconds",
+		Help:      "Latency of message encryption and decryption.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 12),
+	}, []string{"operation"})
+
+	// RepositoryDuration observes repository query latency by operation
+	RepositoryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_query_duration_seconds",
+		Help:      "Latency of repository queries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// RepositoryErrors counts failed repository queries by operation
+	RepositoryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "repository_errors_total",
+		Help:      "Number of failed repository queries.",
+	}, []string{"operation"})
+
+	// WebhookDeliveries counts webhook delivery attempts by result : sent, retried or dropped
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of webhook delivery attempts.",
+	}, []string{"result"})
+
+	// ExpiredMessages counts expired messages deleted from the repository
+	ExpiredMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expired_messages_total",
+		Help:      "Number of expired messages deleted.",
+	})
+
+	// RetentionPurged counts messages deleted by retention policies by scope : global, user or room
+	RetentionPurged = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retention_purged_messages_total",
+		Help:      "Number of messages deleted by retention policies.",
+	}, []string{"scope"})
+
+	// RetentionPurgeable is the number of messages retention policies would delete in a dry run, by scope
+	RetentionPurgeable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "retention_purgeable_messages",
+		Help:      "Number of messages retention policies would delete, reported in dry run mode.",
+	}, []string{"scope"})
+
+	// QueueDepth is the number of commands waiting for the dispatcher
+	QueueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dispatcher_queue_depth",
+		Help:      "Number of commands waiting to be processed by the dispatcher.",
+	}, queueDepth)
+)
+
+var (
+	queueMu        sync.Mutex
+	queueDepthFunc func() int
+)
+
+// SetQueueDepthFunc sets the function which reports the dispatcher queue depth
+func SetQueueDepthFunc(f func() int) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	queueDepthFunc = f
+}
+
+func queueDepth() float64 {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if queueDepthFunc == nil {
+		return 0
+	}
+	return float64(queueDepthFunc())
+}
+
+// Registry contains all metrics of the server
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		ConnectedClients,
+		Commands,
+		ThrottledCommands,
+		TimedOutCommands,
+		EvictedClients,
+		Messages,
+		MessageBytes,
+		CryptoDuration,
+		RepositoryDuration,
+		RepositoryErrors,
+		WebhookDeliveries,
+		ExpiredMessages,
+		RetentionPurged,
+		RetentionPurgeable,
+		QueueDepth,
+	)
+}
+
+// Handler returns the HTTP handler which exposes the metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveCrypto records the latency of a crypto operation started at start
+func ObserveCrypto(operation string, start time.Time) {
+	CryptoDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveQuery records the latency and the result of a repository query started at start
+func ObserveQuery(operation string, start time.Time, err error) {
+	RepositoryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		RepositoryErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scrape returns the metrics exposed by the handler
+func scrape(t *testing.T) string {
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	SetQueueDepthFunc(func() int { return 3 })
+	defer SetQueueDepthFunc(nil)
+
+	ConnectedClients.Set(2)
+	Commands.WithLabelValues("list").Inc()
+	ThrottledCommands.WithLabelValues("connection").Inc()
+	Messages.Inc()
+	MessageBytes.Add(9)
+	ObserveCrypto("encrypt", time.Now())
+	ObserveCrypto("decrypt", time.Now())
+	ObserveQuery("store", time.Now(), nil)
+	ObserveQuery("get_all", time.Now(), errors.New("Test Error"))
+
+	body := scrape(t)
+	for _, want := range []string{
+		"tcp_message_connected_clients 2",
+		`tcp_message_commands_total{command="list"} 1`,
+		`tcp_message_throttled_commands_total{scope="connection"} 1`,
+		"tcp_message_messages_total 1",
+		"tcp_message_message_bytes_total 9",
+		`tcp_message_crypto_duration_seconds_count{operation="encrypt"} 1`,
+		`tcp_message_crypto_duration_seconds_count{operation="decrypt"} 1`,
+		`tcp_message_repository_query_duration_seconds_count{operation="store"} 1`,
+		`tcp_message_repository_query_duration_seconds_count{operation="get_all"} 1`,
+		`tcp_message_repository_errors_total{operation="get_all"} 1`,
+		"tcp_message_dispatcher_queue_depth 3",
+		"go_goroutines",
+	} {
+		assert.True(t, strings.Contains(body, want+"\n") || strings.Contains(body, want+" "), "missing %s", want)
+	}
+	assert.NotContains(t, body, `tcp_message_repository_errors_total{operation="store"}`)
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// kinds of ban targets
+const (
+	BanUser = "user"
+	BanIP   = "ip"
+)
+
+type Ban struct {
+	ID     int64
+	Kind   string
+	Target string
+	// zero means the ban never expires
+	Until time.Time
+	By    string
+}
+
+// Permanent reports whether the ban never expires
+func (b Ban) Permanent() bool {
+	return b.Until.IsZero()
+}
+
+func (b Ban) ToString() string {
+	until := "forever"
+	if !b.Permanent() {
+		until = "until " + b.Until.Format(time.RFC3339)
+	}
+	return b.Kind + " " + b.Target + " is banned " + until + " by " + b.By
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Delivery is a webhook request which is waiting to be sent to its URL
+type Delivery struct {
+	ID    int64
+	URL   string
+	Event string
+	// JSON body of the request
+	Payload string
+	// number of failed attempts
+	Attempts    int
+	NextAttempt time.Time
+	CreatedAt   time.Time
+}
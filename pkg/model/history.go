@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// HistoryFilter selects the messages of a history export
+type HistoryFilter struct {
+	// messages sent or received by the user, of all users when empty
+	User string
+
+	// messages created in [Since, Until), no limit when zero
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether a message is selected by the filter
+func (f HistoryFilter) Match(m Message) bool {
+	if f.User != "" && m.From != f.User && m.To != f.User {
+		return false
+	}
+	if !f.Since.IsZero() && m.CreatedAt.Before(f.Since) {
+		return false
+	}
+	return f.Until.IsZero() || m.CreatedAt.Before(f.Until)
+}
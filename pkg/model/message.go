@@ -1,16 +1,47 @@
 package model
 
-import "strconv"
+import (
+	"strconv"
+	"time"
+)
 
 type Message struct {
-	ID   int64
-	From string
-	To   string
-	Text string
+	ID       int64  `json:"id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Text     string `json:"text"`
+	ParentID int64  `json:"parent_id,omitempty"`
+
+	// time the message is stored, set by the repository when it is zero
+	CreatedAt time.Time `json:"created_at"`
+
+	// time the message is deleted, nil when it is kept
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 func (m Message) ToString() string {
 	message := ""
-	message = "ID: " + strconv.FormatInt(m.ID, 10) + "\n\tFrom: " + m.From + "\n\tTo: " + m.To + "\n\tmessage: " + m.Text + "\n"
+	message = "ID: " + strconv.FormatInt(m.ID, 10) + "\n\tFrom: " + m.From + "\n\tTo: " + m.To
+	if m.ParentID != 0 {
+		message += "\n\tReply to: " + strconv.FormatInt(m.ParentID, 10)
+	}
+	if m.ExpiresAt != nil {
+		message += "\n\tExpires: " + m.ExpiresAt.Format(time.RFC3339)
+	}
+	message += "\n\tmessage: " + m.Text + "\n"
 	return message
 }
+
+// Expired reports whether the message is deleted at now
+func (m Message) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !m.ExpiresAt.After(now)
+}
+
+// Snippet returns the first n characters of the message text, used when quoting a message
+func (m Message) Snippet(n int) string {
+	text := []rune(m.Text)
+	if len(text) <= n {
+		return m.Text
+	}
+	return string(text[:n]) + "..."
+}
@@ -3,30 +3,38 @@ package model
 import (
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestMessage_ToString(t *testing.T) {
 	type fields struct {
-		ID   int64
-		From string
-		To   string
-		Text string
+		ID        int64
+		From      string
+		To        string
+		Text      string
+		ParentID  int64
+		ExpiresAt *time.Time
 	}
+	expiresAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
 	tests := []struct {
 		name   string
 		fields fields
 		want   string
 	}{
 		{name: " String format correct", fields: fields{ID: 1, From: "Test_From", To: "Test_To", Text: "Test Text"}, want: "ID: " + strconv.FormatInt(1, 10) + "\n\tFrom: " + "Test_From" + "\n\tTo: " + "Test_To" + "\n\tmessage: " + "Test Text" + "\n"},
+		{name: " String format with parent", fields: fields{ID: 2, From: "Test_From", To: "Test_To", Text: "Test Text", ParentID: 1}, want: "ID: " + strconv.FormatInt(2, 10) + "\n\tFrom: " + "Test_From" + "\n\tTo: " + "Test_To" + "\n\tReply to: " + strconv.FormatInt(1, 10) + "\n\tmessage: " + "Test Text" + "\n"},
+		{name: " String format with expiry", fields: fields{ID: 3, From: "Test_From", To: "Test_To", Text: "Test Text", ExpiresAt: &expiresAt}, want: "ID: " + strconv.FormatInt(3, 10) + "\n\tFrom: " + "Test_From" + "\n\tTo: " + "Test_To" + "\n\tExpires: " + "2021-03-01T12:00:00Z" + "\n\tmessage: " + "Test Text" + "\n"},
 		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := Message{
-				ID:   tt.fields.ID,
-				From: tt.fields.From,
-				To:   tt.fields.To,
-				Text: tt.fields.Text,
+				ID:        tt.fields.ID,
+				From:      tt.fields.From,
+				To:        tt.fields.To,
+				Text:      tt.fields.Text,
+				ParentID:  tt.fields.ParentID,
+				ExpiresAt: tt.fields.ExpiresAt,
 			}
 			if got := m.ToString(); got != tt.want {
 				t.Errorf("Message.ToString() = %v, want %v", got, tt.want)
@@ -34,3 +42,24 @@ func TestMessage_ToString(t *testing.T) {
 		})
 	}
 }
+
+func TestMessage_Snippet(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		n    int
+		want string
+	}{
+		{name: "short text", text: "Hello", n: 10, want: "Hello"},
+		{name: "long text", text: "Hello World", n: 5, want: "Hello..."},
+		{name: "multibyte text", text: "Selahattin Şahin", n: 12, want: "Selahattin Ş..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Message{Text: tt.text}
+			if got := m.Snippet(tt.n); got != tt.want {
+				t.Errorf("Message.Snippet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
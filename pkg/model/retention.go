@@ -0,0 +1,53 @@
+package model
+
+import "time"
+
+// RetentionFilter selects the messages a retention policy purges :
+// the messages of its scope which are older than Before or not among the newest KeepLast
+type RetentionFilter struct {
+	// messages sent by these users, of all users when empty
+	Users []string
+
+	// messages between these pairs of users, of all users when empty
+	Rooms [][2]string
+
+	// messages which have a more specific policy
+	ExceptUsers []string
+	ExceptRooms [][2]string
+
+	// messages created before are purged, no age limit when zero
+	Before time.Time
+
+	// number of newest messages which are kept, no count limit when zero
+	KeepLast int
+}
+
+// InScope reports whether a message is in the scope of the filter, regardless of its age
+func (f RetentionFilter) InScope(m Message) bool {
+	if len(f.Users) > 0 && !contains(f.Users, m.From) {
+		return false
+	}
+	if len(f.Rooms) > 0 && !inRooms(f.Rooms, m) {
+		return false
+	}
+	return !contains(f.ExceptUsers, m.From) && !inRooms(f.ExceptRooms, m)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inRooms reports whether a message is between the users of one of the rooms
+func inRooms(rooms [][2]string, m Message) bool {
+	for _, room := range rooms {
+		if (m.From == room[0] && m.To == room[1]) || (m.From == room[1] && m.To == room[0]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// ScheduledMessage is a message which is sent to its recipient at a later time
+type ScheduledMessage struct {
+	ID   int64
+	From string
+	To   string
+	Text string
+	At   time.Time
+}
+
+func (m ScheduledMessage) ToString() string {
+	return "#" + strconv.FormatInt(m.ID, 10) + " to " + m.To + " at " + m.At.Format(time.RFC3339) + ": " + m.Text
+}
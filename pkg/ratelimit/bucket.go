@@ -0,0 +1,72 @@
+package ratelimit
+
+import "time"
+
+// Limit defines the rate of a token bucket
+type Limit struct {
+	// tokens added to the bucket per second, 0 means unlimited
+	Rate float64 `yaml:"rate"`
+
+	// maximum number of tokens the bucket can hold
+	Burst int `yaml:"burst"`
+}
+
+// Unlimited reports whether the limit allows everything
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// Bucket is a token bucket, it is not safe for concurrent use
+type Bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a full bucket
+func NewBucket(limit Limit, now time.Time) *Bucket {
+	return &Bucket{
+		limit:  limit,
+		tokens: float64(limit.burst()),
+		last:   now,
+	}
+}
+
+// burst returns the bucket size, a bucket holds at least one token
+func (l Limit) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+// refill adds the tokens earned since the last call
+func (b *Bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+		if max := float64(b.limit.burst()); b.tokens > max {
+			b.tokens = max
+		}
+		b.last = now
+	}
+}
+
+// Allow takes a token from the bucket if there is one
+func (b *Bucket) Allow(now time.Time) bool {
+	if b.limit.Unlimited() {
+		return true
+	}
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Full reports whether the bucket is refilled completely,
+// a full bucket can be dropped without changing any decision
+func (b *Bucket) Full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.limit.burst())
+}
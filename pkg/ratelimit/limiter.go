@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config defines the rate limits of the server
+type Config struct {
+	// limit of all commands of a single connection
+	Connection Limit `yaml:"connection"`
+
+	// limit of all commands of a user, shared by all connections with the same name
+	User Limit `yaml:"user"`
+
+	// limits per command class of a single connection : class name (key) & limit (value)
+	Commands map[string]Limit `yaml:"commands"`
+
+	// number of rejected commands in ViolationWindow which disconnects a client
+	MaxViolations int `yaml:"max_violations"`
+
+	// window in which violations are counted
+	ViolationWindow time.Duration `yaml:"violation_window"`
+
+	// how long a disconnected abuser can not connect again
+	BanDuration time.Duration `yaml:"ban_duration"`
+}
+
+// Error is returned when a command exceeds a limit
+type Error struct {
+	// scope of the exceeded limit : connection, user or command class
+	Scope string
+
+	// set when the client exceeded MaxViolations and has to be disconnected
+	Abuse bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s, slow down", e.Scope)
+}
+
+// structure of violations of a connection
+type violations struct {
+	count int
+	start time.Time
+}
+
+// Limiter applies token bucket limits per connection, per user and per command class.
+// It is safe for concurrent use.
+type Limiter struct {
+	mu  sync.Mutex
+	cfg Config
+
+	connections map[string]*Bucket
+	users       map[string]*Bucket
+	commands    map[string]*Bucket
+	violations  map[string]*violations
+	bans        map[string]time.Time
+
+	// number of rejected commands : scope (key) & count (value)
+	throttled map[string]int64
+
+	// clock, replaced in tests
+	now func() time.Time
+}
+
+// NewLimiter creates a limiter with the given limits
+func NewLimiter(cfg Config) *Limiter {
+	return &Limiter{
+		cfg:         cfg,
+		connections: make(map[string]*Bucket),
+		users:       make(map[string]*Bucket),
+		commands:    make(map[string]*Bucket),
+		violations:  make(map[string]*violations),
+		bans:        make(map[string]time.Time),
+		throttled:   make(map[string]int64),
+		now:         time.Now,
+	}
+}
+
+// take returns the bucket of key, creating it when missing
+func take(buckets map[string]*Bucket, key string, limit Limit, now time.Time) bool {
+	b, ok := buckets[key]
+	if !ok {
+		b = NewBucket(limit, now)
+		buckets[key] = b
+	}
+	return b.Allow(now)
+}
+
+// Allow checks a command of class sent from connection conn by user.
+// user may be empty when the client has no name yet. When general is false
+// only the command class limit applies, this is used for file chunks which
+// would otherwise exhaust the connection limit.
+func (l *Limiter) Allow(conn string, user string, class string, general bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	scope := ""
+	if limit, ok := l.cfg.Commands[class]; ok && !take(l.commands, conn+"/"+class, limit, now) {
+		scope = class
+	}
+	if scope == "" && general && !take(l.connections, conn, l.cfg.Connection, now) {
+		scope = "connection"
+	}
+	if scope == "" && general && user != "" && !take(l.users, user, l.cfg.User, now) {
+		scope = "user"
+	}
+	if scope == "" {
+		return nil
+	}
+
+	l.throttled[scope]++
+	v, ok := l.violations[conn]
+	if !ok || now.Sub(v.start) > l.cfg.ViolationWindow {
+		v = &violations{start: now}
+		l.violations[conn] = v
+	}
+	v.count++
+	return &Error{
+		Scope: scope,
+		Abuse: l.cfg.MaxViolations > 0 && v.count >= l.cfg.MaxViolations,
+	}
+}
+
+// SetConfig replaces the limits :
+// buckets are dropped, so they are created again with the new limits,
+// violations and bans are kept
+func (l *Limiter) SetConfig(cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+	l.connections = make(map[string]*Bucket)
+	l.users = make(map[string]*Bucket)
+	l.commands = make(map[string]*Bucket)
+}
+
+// Ban refuses new connections from host for the configured ban duration
+func (l *Limiter) Ban(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.BanDuration > 0 {
+		l.bans[host] = l.now().Add(l.cfg.BanDuration)
+	}
+}
+
+// Banned reports whether connections from host are refused
+func (l *Limiter) Banned(host string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, ok := l.bans[host]
+	if ok && !l.now().Before(until) {
+		delete(l.bans, host)
+		return false
+	}
+	return ok
+}
+
+// Forget drops the state of a closed connection and the buckets which are full again
+func (l *Limiter) Forget(conn string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	delete(l.connections, conn)
+	delete(l.violations, conn)
+	for key, b := range l.commands {
+		if b.Full(now) || strings.HasPrefix(key, conn+"/") {
+			delete(l.commands, key)
+		}
+	}
+	for key, b := range l.users {
+		if b.Full(now) {
+			delete(l.users, key)
+		}
+	}
+}
+
+// Throttled returns the number of rejected commands per scope
+func (l *Limiter) Throttled() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	throttled := make(map[string]int64, len(l.throttled))
+	for scope, count := range l.throttled {
+		throttled[scope] = count
+	}
+	return throttled
+}
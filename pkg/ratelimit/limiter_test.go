@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket_Allow(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(Limit{Rate: 1, Burst: 2}, now)
+
+	assert.True(t, b.Allow(now))
+	assert.True(t, b.Allow(now))
+	assert.False(t, b.Allow(now))
+
+	// a token is added every second
+	assert.False(t, b.Allow(now.Add(500*time.Millisecond)))
+	assert.True(t, b.Allow(now.Add(time.Second)))
+	assert.False(t, b.Allow(now.Add(time.Second)))
+
+	// bucket never holds more than burst
+	assert.True(t, b.Full(now.Add(time.Hour)))
+	assert.True(t, b.Allow(now.Add(time.Hour)))
+	assert.True(t, b.Allow(now.Add(time.Hour)))
+	assert.False(t, b.Allow(now.Add(time.Hour)))
+}
+
+func TestBucket_Unlimited(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(Limit{}, now)
+	for i := 0; i < 100; i++ {
+		assert.True(t, b.Allow(now))
+	}
+}
+
+func newTestLimiter(cfg Config) (*Limiter, *time.Time) {
+	now := time.Now()
+	l := NewLimiter(cfg)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	l, _ := newTestLimiter(Config{
+		Connection: Limit{Rate: 1, Burst: 3},
+		User:       Limit{Rate: 1, Burst: 4},
+		Commands: map[string]Limit{
+			"query": {Rate: 1, Burst: 1},
+		},
+	})
+
+	// command class limit
+	assert.NoError(t, l.Allow("conn1", "Test", "query", true))
+	err := l.Allow("conn1", "Test", "query", true)
+	if assert.Error(t, err) {
+		assert.Equal(t, "query", err.(*Error).Scope)
+	}
+
+	// connection limit, one token was taken by the first query
+	assert.NoError(t, l.Allow("conn1", "Test", "message", true))
+	assert.NoError(t, l.Allow("conn1", "Test", "message", true))
+	err = l.Allow("conn1", "Test", "message", true)
+	if assert.Error(t, err) {
+		assert.Equal(t, "connection", err.(*Error).Scope)
+	}
+
+	// user limit is shared between connections
+	assert.NoError(t, l.Allow("conn2", "Test", "message", true))
+	err = l.Allow("conn2", "Test", "message", true)
+	if assert.Error(t, err) {
+		assert.Equal(t, "user", err.(*Error).Scope)
+	}
+
+	// anonymous clients are not limited per user
+	assert.NoError(t, l.Allow("conn3", "", "message", true))
+
+	assert.Equal(t, map[string]int64{"query": 1, "connection": 1, "user": 1}, l.Throttled())
+}
+
+func TestLimiter_AllowClassOnly(t *testing.T) {
+	l, _ := newTestLimiter(Config{
+		Connection: Limit{Rate: 1, Burst: 1},
+		Commands: map[string]Limit{
+			"file": {Rate: 1, Burst: 5},
+		},
+	})
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, l.Allow("conn1", "Test", "file", false))
+	}
+	assert.Error(t, l.Allow("conn1", "Test", "file", false))
+	assert.NoError(t, l.Allow("conn1", "Test", "message", true))
+}
+
+func TestLimiter_Abuse(t *testing.T) {
+	l, now := newTestLimiter(Config{
+		Connection:      Limit{Rate: 1, Burst: 1},
+		MaxViolations:   3,
+		ViolationWindow: time.Minute,
+		BanDuration:     time.Minute,
+	})
+	assert.NoError(t, l.Allow("conn1", "", "message", true))
+	for i := 1; i < 3; i++ {
+		err := l.Allow("conn1", "", "message", true)
+		assert.False(t, err.(*Error).Abuse)
+	}
+	err := l.Allow("conn1", "", "message", true)
+	assert.True(t, err.(*Error).Abuse)
+
+	l.Ban("127.0.0.1")
+	assert.True(t, l.Banned("127.0.0.1"))
+	assert.False(t, l.Banned("127.0.0.2"))
+
+	*now = now.Add(2 * time.Minute)
+	assert.False(t, l.Banned("127.0.0.1"))
+
+	// violations are counted in a window
+	l.Forget("conn1")
+	assert.NoError(t, l.Allow("conn1", "", "message", true))
+	err = l.Allow("conn1", "", "message", true)
+	assert.False(t, err.(*Error).Abuse)
+}
+
+func TestLimiter_SetConfig(t *testing.T) {
+	l, _ := newTestLimiter(Config{
+		Connection: Limit{Rate: 1, Burst: 1},
+	})
+	assert.NoError(t, l.Allow("conn1", "Test", "message", true))
+	assert.Error(t, l.Allow("conn1", "Test", "message", true))
+
+	// new limits apply to the next commands
+	l.SetConfig(Config{
+		Connection: Limit{Rate: 1, Burst: 2},
+	})
+	assert.NoError(t, l.Allow("conn1", "Test", "message", true))
+	assert.NoError(t, l.Allow("conn1", "Test", "message", true))
+	assert.Error(t, l.Allow("conn1", "Test", "message", true))
+}
@@ -0,0 +1,105 @@
+package ban
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+const (
+	tableName = "bans"
+)
+const (
+	initTableTemplate = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id bigint(20) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		kind VARCHAR(8) NOT NULL,
+		target VARCHAR(255) NOT NULL,
+		until DATETIME NULL,
+		created_by TEXT NOT NULL,
+		KEY target (kind, target)
+	  ) ENGINE=MyISAM  DEFAULT CHARSET=utf8 ROW_FORMAT=DYNAMIC;
+`
+)
+
+func NewMySQLRepository(db *sql.DB) (*MySQLRepository, error) {
+	tableInitCmd := fmt.Sprintf(initTableTemplate, tableName)
+	_, err := db.Exec(tableInitCmd)
+
+	if err != nil {
+		return nil, fmt.Errorf("error init bans repository: %v", err)
+	}
+
+	return &MySQLRepository{
+		db: db,
+	}, nil
+}
+
+// GetActive returns the latest ban of a target which is not expired at now
+func (r *MySQLRepository) GetActive(ctx context.Context, kind string, target string, now time.Time) (model.Ban, error) {
+	q := "SELECT id, kind, target, until, created_by FROM " + tableName + " where kind=? AND target=? AND (until IS NULL OR until > ?) ORDER BY id DESC LIMIT 1"
+
+	logrus.Debug("QUERY: ", q, kind, target)
+	var ban model.Ban
+	var until sql.NullTime
+	err := r.db.QueryRowContext(ctx, q, kind, target, now.UTC()).Scan(&ban.ID, &ban.Kind, &ban.Target, &until, &ban.By)
+	if err == sql.ErrNoRows {
+		return ban, ErrNotFound
+	}
+	if err != nil {
+		return ban, fmt.Errorf("error get ban: %v", err)
+	}
+	if until.Valid {
+		ban.Until = until.Time
+	}
+	return ban, nil
+}
+
+// Store returns an id which is ID of row
+func (r *MySQLRepository) Store(ctx context.Context, ban model.Ban) (int64, error) {
+	stmt, err := r.db.PrepareContext(ctx, `INSERT INTO `+tableName+`(
+		kind,target,until,created_by)
+		VALUES(
+			?,?,?,?)`)
+	if err != nil {
+		return -1, err
+	}
+
+	defer stmt.Close()
+	var until sql.NullTime
+	if !ban.Permanent() {
+		until = sql.NullTime{Time: ban.Until.UTC(), Valid: true}
+	}
+	logrus.Debug("QUERY: ", stmt)
+	res, err := stmt.ExecContext(ctx,
+		ban.Kind, ban.Target, until, ban.By)
+	if err != nil {
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// Delete removes all bans of a target and returns how many were removed
+func (r *MySQLRepository) Delete(ctx context.Context, kind string, target string) (int64, error) {
+	q := "DELETE FROM " + tableName + " where kind=? AND target=?"
+
+	logrus.Debug("QUERY: ", q, kind, target)
+	res, err := r.db.ExecContext(ctx, q, kind, target)
+	if err != nil {
+		return -1, fmt.Errorf("error delete ban: %v", err)
+	}
+	return res.RowsAffected()
+}
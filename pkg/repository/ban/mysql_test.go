@@ -0,0 +1,79 @@
+package ban
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMySQLRepository_GetActive(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	query := "SELECT id, kind, target, until, created_by FROM bans where kind=? AND target=? AND (until IS NULL OR until > ?) ORDER BY id DESC LIMIT 1"
+	now := time.Date(2022, 1, 20, 10, 0, 0, 0, time.UTC)
+	until := now.Add(time.Hour)
+
+	rows := sqlmock.NewRows([]string{"id", "kind", "target", "until", "created_by"}).
+		AddRow(int64(1), model.BanUser, "Test", until, "root")
+	mock.ExpectQuery(query).WithArgs(model.BanUser, "Test", now).WillReturnRows(rows)
+
+	ban, err := repo.GetActive(context.Background(), model.BanUser, "Test", now)
+	assert.NoError(t, err)
+	assert.Equal(t, model.Ban{ID: 1, Kind: model.BanUser, Target: "Test", Until: until, By: "root"}, ban)
+
+	rows = sqlmock.NewRows([]string{"id", "kind", "target", "until", "created_by"}).
+		AddRow(int64(2), model.BanIP, "127.0.0.1", nil, "root")
+	mock.ExpectQuery(query).WithArgs(model.BanIP, "127.0.0.1", now).WillReturnRows(rows)
+
+	ban, err = repo.GetActive(context.Background(), model.BanIP, "127.0.0.1", now)
+	assert.NoError(t, err)
+	assert.True(t, ban.Permanent())
+
+	mock.ExpectQuery(query).WithArgs(model.BanUser, "Test2", now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "target", "until", "created_by"}))
+
+	_, err = repo.GetActive(context.Background(), model.BanUser, "Test2", now)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMySQLRepository_Store(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	until := time.Date(2022, 1, 20, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectPrepare("INSERT INTO bans").
+		ExpectExec().
+		WithArgs(model.BanUser, "Test", until, "root").
+		WillReturnResult(sqlmock.NewResult(3, 1))
+
+	id, err := repo.Store(context.Background(), model.Ban{Kind: model.BanUser, Target: "Test", Until: until, By: "root"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), id)
+}
+
+func TestMySQLRepository_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectExec("DELETE FROM bans where kind=? AND target=?").
+		WithArgs(model.BanUser, "Test").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	count, err := repo.Delete(context.Background(), model.BanUser, "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
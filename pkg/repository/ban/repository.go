@@ -0,0 +1,27 @@
+package ban
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+// ErrNotFound is returned when there is no active ban
+var ErrNotFound = errors.New("ban not found")
+
+type Reader interface {
+	GetActive(ctx context.Context, kind string, target string, now time.Time) (model.Ban, error)
+}
+
+type Writer interface {
+	Store(ctx context.Context, ban model.Ban) (int64, error)
+	Delete(ctx context.Context, kind string, target string) (int64, error)
+}
+
+// Repository repository interface
+type Repository interface {
+	Reader
+	Writer
+}
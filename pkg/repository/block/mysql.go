@@ -0,0 +1,100 @@
+package block
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+const (
+	tableName = "blocks"
+)
+const (
+	initTableTemplate = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id bigint(20) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		user VARCHAR(255) NOT NULL,
+		blocked VARCHAR(255) NOT NULL,
+		UNIQUE KEY user_blocked (user, blocked)
+	  ) ENGINE=MyISAM  DEFAULT CHARSET=utf8 ROW_FORMAT=DYNAMIC;
+`
+)
+
+func NewMySQLRepository(db *sql.DB) (*MySQLRepository, error) {
+	tableInitCmd := fmt.Sprintf(initTableTemplate, tableName)
+	_, err := db.Exec(tableInitCmd)
+
+	if err != nil {
+		return nil, fmt.Errorf("error init blocks repository: %v", err)
+	}
+
+	return &MySQLRepository{
+		db: db,
+	}, nil
+}
+
+// GetBlocked returns the names which are blocked by a user
+func (r *MySQLRepository) GetBlocked(ctx context.Context, user string) ([]string, error) {
+	q := "SELECT blocked FROM " + tableName + " where user=? ORDER BY blocked"
+
+	logrus.Debug("QUERY: ", q, user)
+	rows, err := r.db.QueryContext(ctx, q, user)
+	if err != nil {
+		return nil, fmt.Errorf("error get blocked users: %v", err)
+	}
+	defer rows.Close()
+
+	var blocked []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error get blocked users: %v", err)
+		}
+		blocked = append(blocked, name)
+	}
+	return blocked, rows.Err()
+}
+
+// IsBlocked reports whether a user blocked another one
+func (r *MySQLRepository) IsBlocked(ctx context.Context, user string, blocked string) (bool, error) {
+	q := "SELECT COUNT(*) FROM " + tableName + " where user=? AND blocked=?"
+
+	logrus.Debug("QUERY: ", q, user, blocked)
+	var count int
+	err := r.db.QueryRowContext(ctx, q, user, blocked).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error check block: %v", err)
+	}
+	return count > 0, nil
+}
+
+// Store blocks a user, blocking the same user again has no effect
+func (r *MySQLRepository) Store(ctx context.Context, user string, blocked string) error {
+	q := "INSERT IGNORE INTO " + tableName + " (user, blocked) VALUES (?, ?)"
+
+	logrus.Debug("QUERY: ", q, user, blocked)
+	_, err := r.db.ExecContext(ctx, q, user, blocked)
+	if err != nil {
+		return fmt.Errorf("error store block: %v", err)
+	}
+	return nil
+}
+
+// Delete unblocks a user and returns how many blocks were removed
+func (r *MySQLRepository) Delete(ctx context.Context, user string, blocked string) (int64, error) {
+	q := "DELETE FROM " + tableName + " where user=? AND blocked=?"
+
+	logrus.Debug("QUERY: ", q, user, blocked)
+	res, err := r.db.ExecContext(ctx, q, user, blocked)
+	if err != nil {
+		return -1, fmt.Errorf("error delete block: %v", err)
+	}
+	return res.RowsAffected()
+}
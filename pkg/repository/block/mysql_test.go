@@ -0,0 +1,75 @@
+package block
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMySQLRepository_GetBlocked(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	rows := sqlmock.NewRows([]string{"blocked"}).AddRow("Test2").AddRow("Test3")
+	mock.ExpectQuery("SELECT blocked FROM blocks where user=? ORDER BY blocked").WithArgs("Test").WillReturnRows(rows)
+
+	blocked, err := repo.GetBlocked(context.Background(), "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Test2", "Test3"}, blocked)
+}
+
+func TestMySQLRepository_IsBlocked(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	query := "SELECT COUNT(*) FROM blocks where user=? AND blocked=?"
+
+	mock.ExpectQuery(query).WithArgs("Test", "Test2").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(query).WithArgs("Test", "Test3").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	blocked, err := repo.IsBlocked(context.Background(), "Test", "Test2")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	blocked, err = repo.IsBlocked(context.Background(), "Test", "Test3")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+func TestMySQLRepository_Store(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectExec("INSERT IGNORE INTO blocks (user, blocked) VALUES (?, ?)").
+		WithArgs("Test", "Test2").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(t, repo.Store(context.Background(), "Test", "Test2"))
+}
+
+func TestMySQLRepository_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectExec("DELETE FROM blocks where user=? AND blocked=?").
+		WithArgs("Test", "Test2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	count, err := repo.Delete(context.Background(), "Test", "Test2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,19 @@
+package block
+
+import "context"
+
+type Reader interface {
+	GetBlocked(ctx context.Context, user string) ([]string, error)
+	IsBlocked(ctx context.Context, user string, blocked string) (bool, error)
+}
+
+type Writer interface {
+	Store(ctx context.Context, user string, blocked string) error
+	Delete(ctx context.Context, user string, blocked string) (int64, error)
+}
+
+// Repository repository interface
+type Repository interface {
+	Reader
+	Writer
+}
@@ -0,0 +1,97 @@
+package conversation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+const (
+	tableName = "conversations"
+)
+const (
+	initTableTemplate = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id bigint(20) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		user_a VARCHAR(255) NOT NULL,
+		user_b VARCHAR(255) NOT NULL,
+		ttl_seconds bigint(20) NOT NULL DEFAULT 0,
+		UNIQUE KEY users (user_a, user_b)
+	  ) ENGINE=MyISAM  DEFAULT CHARSET=utf8 ROW_FORMAT=DYNAMIC;
+`
+)
+
+func NewMySQLRepository(db *sql.DB) (*MySQLRepository, error) {
+	tableInitCmd := fmt.Sprintf(initTableTemplate, tableName)
+	_, err := db.Exec(tableInitCmd)
+
+	if err != nil {
+		return nil, fmt.Errorf("error init conversations repository: %v", err)
+	}
+
+	return &MySQLRepository{
+		db: db,
+	}, nil
+}
+
+// users returns the users of a conversation in order, so both of them find the same row
+func users(user string, other string) (string, string) {
+	if other < user {
+		return other, user
+	}
+	return user, other
+}
+
+// GetTTL returns the default time to live of the messages between two users,
+// zero when they have none
+func (r *MySQLRepository) GetTTL(ctx context.Context, user string, other string) (time.Duration, error) {
+	q := "SELECT ttl_seconds FROM " + tableName + " where user_a=? AND user_b=?"
+	a, b := users(user, other)
+
+	logrus.Debug("QUERY: ", q, a, b)
+	var seconds int64
+	err := r.db.QueryRowContext(ctx, q, a, b).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error get conversation ttl: %v", err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// SetTTL sets the default time to live of the messages between two users
+func (r *MySQLRepository) SetTTL(ctx context.Context, user string, other string, ttl time.Duration) error {
+	q := "INSERT INTO " + tableName + " (user_a, user_b, ttl_seconds) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE ttl_seconds=VALUES(ttl_seconds)"
+	a, b := users(user, other)
+	seconds := int64(ttl / time.Second)
+
+	logrus.Debug("QUERY: ", q, a, b, seconds)
+	_, err := r.db.ExecContext(ctx, q, a, b, seconds)
+	if err != nil {
+		return fmt.Errorf("error set conversation ttl: %v", err)
+	}
+	return nil
+}
+
+// DeleteTTL removes the default time to live of the messages between two users
+// and returns how many were removed
+func (r *MySQLRepository) DeleteTTL(ctx context.Context, user string, other string) (int64, error) {
+	q := "DELETE FROM " + tableName + " where user_a=? AND user_b=?"
+	a, b := users(user, other)
+
+	logrus.Debug("QUERY: ", q, a, b)
+	res, err := r.db.ExecContext(ctx, q, a, b)
+	if err != nil {
+		return -1, fmt.Errorf("error delete conversation ttl: %v", err)
+	}
+	return res.RowsAffected()
+}
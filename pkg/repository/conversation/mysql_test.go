@@ -0,0 +1,63 @@
+package conversation
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMySQLRepository_GetTTL(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	query := "SELECT ttl_seconds FROM conversations where user_a=? AND user_b=?"
+
+	// both users of a conversation find the same row
+	mock.ExpectQuery(query).WithArgs("Test", "Test2").WillReturnRows(sqlmock.NewRows([]string{"ttl_seconds"}).AddRow(90))
+	mock.ExpectQuery(query).WithArgs("Test", "Test2").WillReturnError(sql.ErrNoRows)
+
+	ttl, err := repo.GetTTL(context.Background(), "Test2", "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, ttl)
+
+	ttl, err = repo.GetTTL(context.Background(), "Test", "Test2")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+}
+
+func TestMySQLRepository_SetTTL(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectExec("INSERT INTO conversations (user_a, user_b, ttl_seconds) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE ttl_seconds=VALUES(ttl_seconds)").
+		WithArgs("Test", "Test2", int64(3600)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(t, repo.SetTTL(context.Background(), "Test2", "Test", time.Hour))
+}
+
+func TestMySQLRepository_DeleteTTL(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectExec("DELETE FROM conversations where user_a=? AND user_b=?").
+		WithArgs("Test", "Test2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	count, err := repo.DeleteTTL(context.Background(), "Test", "Test2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
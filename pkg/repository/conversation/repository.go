@@ -0,0 +1,21 @@
+package conversation
+
+import (
+	"context"
+	"time"
+)
+
+type Reader interface {
+	GetTTL(ctx context.Context, user string, other string) (time.Duration, error)
+}
+
+type Writer interface {
+	SetTTL(ctx context.Context, user string, other string, ttl time.Duration) error
+	DeleteTTL(ctx context.Context, user string, other string) (int64, error)
+}
+
+// Repository repository interface
+type Repository interface {
+	Reader
+	Writer
+}
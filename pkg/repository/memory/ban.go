@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/ban"
+)
+
+// BanRepository keeps bans in memory
+type BanRepository struct {
+	mu   sync.Mutex
+	bans []model.Ban
+}
+
+// All returns the stored bans in the order they were stored, expired ones too
+func (r *BanRepository) All() []model.Ban {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.Ban(nil), r.bans...)
+}
+
+func (r *BanRepository) GetActive(ctx context.Context, kind string, target string, now time.Time) (model.Ban, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.bans) - 1; i >= 0; i-- {
+		b := r.bans[i]
+		if b.Kind == kind && b.Target == target && (b.Permanent() || b.Until.After(now)) {
+			return b, nil
+		}
+	}
+	return model.Ban{}, ban.ErrNotFound
+}
+
+func (r *BanRepository) Store(ctx context.Context, b model.Ban) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b.ID = int64(len(r.bans) + 1)
+	r.bans = append(r.bans, b)
+	return b.ID, nil
+}
+
+func (r *BanRepository) Delete(ctx context.Context, kind string, target string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept []model.Ban
+	for _, b := range r.bans {
+		if b.Kind != kind || b.Target != target {
+			kept = append(kept, b)
+		}
+	}
+	deleted := int64(len(r.bans) - len(kept))
+	r.bans = kept
+	return deleted, nil
+}
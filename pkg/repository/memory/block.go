@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"context"
+	"sync"
+)
+
+// BlockRepository keeps blocks in memory
+type BlockRepository struct {
+	mu     sync.Mutex
+	blocks map[string]map[string]bool
+}
+
+func (r *BlockRepository) GetBlocked(ctx context.Context, user string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var blocked []string
+	for name := range r.blocks[user] {
+		blocked = append(blocked, name)
+	}
+	return blocked, nil
+}
+
+func (r *BlockRepository) IsBlocked(ctx context.Context, user string, blocked string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.blocks[user][blocked], nil
+}
+
+func (r *BlockRepository) Store(ctx context.Context, user string, blocked string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.blocks == nil {
+		r.blocks = make(map[string]map[string]bool)
+	}
+	if r.blocks[user] == nil {
+		r.blocks[user] = make(map[string]bool)
+	}
+	r.blocks[user][blocked] = true
+	return nil
+}
+
+func (r *BlockRepository) Delete(ctx context.Context, user string, blocked string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.blocks[user][blocked] {
+		return 0, nil
+	}
+	delete(r.blocks[user], blocked)
+	return 1, nil
+}
@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ConversationRepository keeps the settings of conversations in memory
+type ConversationRepository struct {
+	mu   sync.Mutex
+	ttls map[[2]string]time.Duration
+}
+
+// conversationKey returns the key of a conversation, the same for both users
+func conversationKey(user string, other string) [2]string {
+	if other < user {
+		return [2]string{other, user}
+	}
+	return [2]string{user, other}
+}
+
+func (r *ConversationRepository) GetTTL(ctx context.Context, user string, other string) (time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ttls[conversationKey(user, other)], nil
+}
+
+func (r *ConversationRepository) SetTTL(ctx context.Context, user string, other string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ttls == nil {
+		r.ttls = make(map[[2]string]time.Duration)
+	}
+	r.ttls[conversationKey(user, other)] = ttl
+	return nil
+}
+
+func (r *ConversationRepository) DeleteTTL(ctx context.Context, user string, other string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ttls[conversationKey(user, other)]; !ok {
+		return 0, nil
+	}
+	delete(r.ttls, conversationKey(user, other))
+	return 1, nil
+}
@@ -0,0 +1,69 @@
+// Package memory keeps the data of the chat server in memory, for tests of the server
+// and of clients which run it in process.
+package memory
+
+import (
+	"context"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/ban"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/block"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/conversation"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/message"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/schedule"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/webhook"
+)
+
+// Repository is an in memory repository.Repository
+type Repository struct {
+	Messages      *MessageRepository
+	Bans          *BanRepository
+	Blocks        *BlockRepository
+	Webhooks      *WebhookRepository
+	Schedule      *ScheduleRepository
+	Conversations *ConversationRepository
+
+	// returned by Ping
+	PingErr error
+}
+
+// NewRepository returns an empty repository
+func NewRepository() *Repository {
+	return &Repository{
+		Messages:      &MessageRepository{},
+		Bans:          &BanRepository{},
+		Blocks:        &BlockRepository{},
+		Webhooks:      &WebhookRepository{},
+		Schedule:      &ScheduleRepository{},
+		Conversations: &ConversationRepository{},
+	}
+}
+
+func (r *Repository) Shutdown() {}
+
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.PingErr
+}
+
+func (r *Repository) GetMessageRepository() message.Repository {
+	return r.Messages
+}
+
+func (r *Repository) GetBanRepository() ban.Repository {
+	return r.Bans
+}
+
+func (r *Repository) GetBlockRepository() block.Repository {
+	return r.Blocks
+}
+
+func (r *Repository) GetWebhookRepository() webhook.Repository {
+	return r.Webhooks
+}
+
+func (r *Repository) GetScheduleRepository() schedule.Repository {
+	return r.Schedule
+}
+
+func (r *Repository) GetConversationRepository() conversation.Repository {
+	return r.Conversations
+}
@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/message"
+)
+
+// MessageRepository keeps messages in memory
+type MessageRepository struct {
+	mu       sync.Mutex
+	nextID   int64
+	messages []model.Message
+}
+
+// All returns the stored messages in the order they were stored, expired ones too
+func (r *MessageRepository) All() []model.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.Message(nil), r.messages...)
+}
+
+func (r *MessageRepository) filter(keep func(model.Message) bool) []model.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var messages []model.Message
+	now := time.Now()
+	for _, m := range r.messages {
+		// expired messages are never returned, like in MySQL
+		if keep(m) && !m.Expired(now) {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+func (r *MessageRepository) GetAll(ctx context.Context, from string) ([]model.Message, error) {
+	return r.filter(func(m model.Message) bool { return m.From == from }), nil
+}
+
+func (r *MessageRepository) GetAllToMe(ctx context.Context, to string) ([]model.Message, error) {
+	return r.filter(func(m model.Message) bool { return m.To == to }), nil
+}
+
+func (r *MessageRepository) GetLast(ctx context.Context, from string, limit string) ([]model.Message, error) {
+	return r.GetAll(ctx, from)
+}
+
+func (r *MessageRepository) GetContains(ctx context.Context, from string, word string) ([]model.Message, error) {
+	return r.filter(func(m model.Message) bool { return m.From == from && strings.Contains(m.Text, word) }), nil
+}
+
+func (r *MessageRepository) Get(ctx context.Context, id int64) (model.Message, error) {
+	messages := r.filter(func(m model.Message) bool { return m.ID == id })
+	if len(messages) == 0 {
+		return model.Message{}, message.ErrNotFound
+	}
+	return messages[0], nil
+}
+
+func (r *MessageRepository) GetReplies(ctx context.Context, parentID int64) ([]model.Message, error) {
+	return r.filter(func(m model.Message) bool { return m.ParentID == parentID }), nil
+}
+
+func (r *MessageRepository) GetExpired(ctx context.Context, now time.Time, limit int) ([]model.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expired []model.Message
+	for _, m := range r.messages {
+		if m.Expired(now) && len(expired) < limit {
+			expired = append(expired, m)
+		}
+	}
+	return expired, nil
+}
+
+// purgeable returns the ids of the messages a retention filter purges, oldest first
+func (r *MessageRepository) purgeable(f model.RetentionFilter) []int64 {
+	var scope []model.Message
+	for _, m := range r.messages {
+		if f.InScope(m) {
+			scope = append(scope, m)
+		}
+	}
+	var ids []int64
+	for i, m := range scope {
+		tooOld := !f.Before.IsZero() && m.CreatedAt.Before(f.Before)
+		tooMany := f.KeepLast > 0 && i < len(scope)-f.KeepLast
+		if tooOld || tooMany {
+			ids = append(ids, m.ID)
+		}
+	}
+	return ids
+}
+
+func (r *MessageRepository) GetPurgeable(ctx context.Context, f model.RetentionFilter, limit int) ([]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := r.purgeable(f)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (r *MessageRepository) CountPurgeable(ctx context.Context, f model.RetentionFilter) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(len(r.purgeable(f))), nil
+}
+
+func (r *MessageRepository) GetHistory(ctx context.Context, f model.HistoryFilter, afterID int64, limit int) ([]model.Message, error) {
+	messages := r.filter(func(m model.Message) bool { return m.ID > afterID && f.Match(m) })
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// Reseal has nothing to do, messages are not encrypted in memory
+func (r *MessageRepository) Reseal(ctx context.Context, afterID int64, limit int) (int64, int64, error) {
+	return 0, 0, nil
+}
+
+func (r *MessageRepository) Store(ctx context.Context, m model.Message) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	m.ID = r.nextID
+	r.messages = append(r.messages, m)
+	return m.ID, nil
+}
+
+func (r *MessageRepository) Delete(ctx context.Context, ids []int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deleted := make(map[int64]bool)
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	var kept []model.Message
+	for _, m := range r.messages {
+		if !deleted[m.ID] {
+			kept = append(kept, m)
+		}
+	}
+	count := int64(len(r.messages) - len(kept))
+	r.messages = kept
+	return count, nil
+}
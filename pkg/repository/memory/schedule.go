@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+// ScheduleRepository keeps scheduled messages in memory
+type ScheduleRepository struct {
+	mu        sync.Mutex
+	nextID    int64
+	scheduled []model.ScheduledMessage
+}
+
+// All returns the scheduled messages in the order they were stored
+func (r *ScheduleRepository) All() []model.ScheduledMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.ScheduledMessage(nil), r.scheduled...)
+}
+
+func (r *ScheduleRepository) GetAll(ctx context.Context, from string) ([]model.ScheduledMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var messages []model.ScheduledMessage
+	for _, m := range r.scheduled {
+		if m.From == from {
+			messages = append(messages, m)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].At.Before(messages[j].At) })
+	return messages, nil
+}
+
+func (r *ScheduleRepository) GetDue(ctx context.Context, now time.Time, limit int) ([]model.ScheduledMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []model.ScheduledMessage
+	for _, m := range r.scheduled {
+		if !m.At.After(now) && len(due) < limit {
+			due = append(due, m)
+		}
+	}
+	return due, nil
+}
+
+func (r *ScheduleRepository) Store(ctx context.Context, m model.ScheduledMessage) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	m.ID = r.nextID
+	r.scheduled = append(r.scheduled, m)
+	return m.ID, nil
+}
+
+func (r *ScheduleRepository) Delete(ctx context.Context, id int64, from string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, m := range r.scheduled {
+		if m.ID == id && m.From == from {
+			r.scheduled = append(r.scheduled[:i], r.scheduled[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
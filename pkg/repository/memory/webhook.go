@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+// WebhookRepository keeps webhook deliveries in memory
+type WebhookRepository struct {
+	mu         sync.Mutex
+	nextID     int64
+	deliveries []model.Delivery
+}
+
+func (r *WebhookRepository) GetDue(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []model.Delivery
+	for _, d := range r.deliveries {
+		if !d.NextAttempt.After(now) && len(due) < limit {
+			due = append(due, d)
+		}
+	}
+	return due, nil
+}
+
+func (r *WebhookRepository) Store(ctx context.Context, d model.Delivery) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	d.ID = r.nextID
+	r.deliveries = append(r.deliveries, d)
+	return d.ID, nil
+}
+
+func (r *WebhookRepository) Retry(ctx context.Context, id int64, attempts int, next time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.deliveries {
+		if r.deliveries[i].ID == id {
+			r.deliveries[i].Attempts = attempts
+			r.deliveries[i].NextAttempt = next
+		}
+	}
+	return nil
+}
+
+func (r *WebhookRepository) Delete(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept []model.Delivery
+	for _, d := range r.deliveries {
+		if d.ID != id {
+			kept = append(kept, d)
+		}
+	}
+	r.deliveries = kept
+	return nil
+}
@@ -0,0 +1,130 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+// InstrumentedRepository records latency and errors of every query of a message repository
+type InstrumentedRepository struct {
+	repository Repository
+}
+
+// NewInstrumentedRepository wraps a message repository with metrics
+func NewInstrumentedRepository(repo Repository) *InstrumentedRepository {
+	return &InstrumentedRepository{
+		repository: repo,
+	}
+}
+
+// GetAll returns all messages which is sended from a user
+func (r *InstrumentedRepository) GetAll(ctx context.Context, from string) ([]model.Message, error) {
+	start := time.Now()
+	messages, err := r.repository.GetAll(ctx, from)
+	metrics.ObserveQuery("get_all", start, err)
+	return messages, err
+}
+
+// GetAllToMe returns all messages which is sended to a user
+func (r *InstrumentedRepository) GetAllToMe(ctx context.Context, from string) ([]model.Message, error) {
+	start := time.Now()
+	messages, err := r.repository.GetAllToMe(ctx, from)
+	metrics.ObserveQuery("get_all_to_me", start, err)
+	return messages, err
+}
+
+// GetLast returns last X messages which is sended from a user
+func (r *InstrumentedRepository) GetLast(ctx context.Context, from string, limit string) ([]model.Message, error) {
+	start := time.Now()
+	messages, err := r.repository.GetLast(ctx, from, limit)
+	metrics.ObserveQuery("get_last", start, err)
+	return messages, err
+}
+
+// GetContains returns all messages which is contains a word
+func (r *InstrumentedRepository) GetContains(ctx context.Context, from string, word string) ([]model.Message, error) {
+	start := time.Now()
+	messages, err := r.repository.GetContains(ctx, from, word)
+	metrics.ObserveQuery("get_contains", start, err)
+	return messages, err
+}
+
+// Get returns the message with the given id
+func (r *InstrumentedRepository) Get(ctx context.Context, id int64) (model.Message, error) {
+	start := time.Now()
+	message, err := r.repository.Get(ctx, id)
+	// a missing message is an answer, not a failed query
+	if err == ErrNotFound {
+		metrics.ObserveQuery("get", start, nil)
+	} else {
+		metrics.ObserveQuery("get", start, err)
+	}
+	return message, err
+}
+
+// GetReplies returns direct replies of a message
+func (r *InstrumentedRepository) GetReplies(ctx context.Context, parentID int64) ([]model.Message, error) {
+	start := time.Now()
+	messages, err := r.repository.GetReplies(ctx, parentID)
+	metrics.ObserveQuery("get_replies", start, err)
+	return messages, err
+}
+
+// Store stores a message and returns its id
+func (r *InstrumentedRepository) Store(ctx context.Context, message model.Message) (int64, error) {
+	start := time.Now()
+	id, err := r.repository.Store(ctx, message)
+	metrics.ObserveQuery("store", start, err)
+	return id, err
+}
+
+// GetExpired returns the messages which are expired at now
+func (r *InstrumentedRepository) GetExpired(ctx context.Context, now time.Time, limit int) ([]model.Message, error) {
+	start := time.Now()
+	messages, err := r.repository.GetExpired(ctx, now, limit)
+	metrics.ObserveQuery("get_expired", start, err)
+	return messages, err
+}
+
+// GetHistory returns a page of the messages of a history filter
+func (r *InstrumentedRepository) GetHistory(ctx context.Context, f model.HistoryFilter, afterID int64, limit int) ([]model.Message, error) {
+	start := time.Now()
+	messages, err := r.repository.GetHistory(ctx, f, afterID, limit)
+	metrics.ObserveQuery("get_history", start, err)
+	return messages, err
+}
+
+// Reseal encrypts a page of messages with the active key
+func (r *InstrumentedRepository) Reseal(ctx context.Context, afterID int64, limit int) (int64, int64, error) {
+	start := time.Now()
+	last, count, err := r.repository.Reseal(ctx, afterID, limit)
+	metrics.ObserveQuery("reseal", start, err)
+	return last, count, err
+}
+
+// Delete removes messages by their ids
+func (r *InstrumentedRepository) Delete(ctx context.Context, ids []int64) (int64, error) {
+	start := time.Now()
+	count, err := r.repository.Delete(ctx, ids)
+	metrics.ObserveQuery("delete", start, err)
+	return count, err
+}
+
+// GetPurgeable returns the ids of messages a retention filter purges
+func (r *InstrumentedRepository) GetPurgeable(ctx context.Context, f model.RetentionFilter, limit int) ([]int64, error) {
+	start := time.Now()
+	ids, err := r.repository.GetPurgeable(ctx, f, limit)
+	metrics.ObserveQuery("get_purgeable", start, err)
+	return ids, err
+}
+
+// CountPurgeable returns the number of messages a retention filter purges
+func (r *InstrumentedRepository) CountPurgeable(ctx context.Context, f model.RetentionFilter) (int64, error) {
+	start := time.Now()
+	count, err := r.repository.CountPurgeable(ctx, f)
+	metrics.ObserveQuery("count_purgeable", start, err)
+	return count, err
+}
@@ -1,17 +1,24 @@
 package message
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
 	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/mysqlutil"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 )
 
 type MySQLRepository struct {
 	db *sql.DB
+
+	// encrypts bodies of stored messages, nil when they are stored in plain text
+	keys *crypto.Keyring
 }
 
 const (
@@ -24,12 +31,25 @@ const (
 		from_client TEXT NOT NULL,
 		to_client TEXT NOT NULL,
 		body TEXT NOT NULL,
-		UNIQUE KEY id (id)
+		parent_id bigint(20) NOT NULL DEFAULT 0,
+		expires_at DATETIME NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		key_id VARCHAR(64) NOT NULL DEFAULT '',
+		UNIQUE KEY id (id),
+		KEY expires_at (expires_at),
+		KEY created_at (created_at)
 	  ) ENGINE=MyISAM  DEFAULT CHARSET=utf8 ROW_FORMAT=DYNAMIC;	
 `
+	selectColumns = "SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM " + tableName
+
+	// condition of messages which are not expired, expired messages are never returned
+	// even before they are deleted
+	notExpired = " AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP())"
 )
 
-func NewMySQLRepository(db *sql.DB) (*MySQLRepository, error) {
+// NewMySQLRepository returns a repository of the messages table, bodies are encrypted
+// with keys when it is not nil
+func NewMySQLRepository(db *sql.DB, keys *crypto.Keyring) (*MySQLRepository, error) {
 	tableInitCmd := fmt.Sprintf(initTableTemplate, tableName)
 	_, err := db.Exec(tableInitCmd)
 
@@ -37,115 +57,180 @@ func NewMySQLRepository(db *sql.DB) (*MySQLRepository, error) {
 		return nil, fmt.Errorf("error init messages repository: %v", err)
 	}
 
+	// tables created by older versions don't have the reply column yet
+	err = mysqlutil.EnsureColumn(db, tableName, "parent_id", "bigint(20) NOT NULL DEFAULT 0")
+	if err != nil {
+		return nil, fmt.Errorf("error init messages repository: %v", err)
+	}
+	err = mysqlutil.EnsureColumn(db, tableName, "expires_at", "DATETIME NULL")
+	if err != nil {
+		return nil, fmt.Errorf("error init messages repository: %v", err)
+	}
+	// messages stored before get the time of the upgrade, in UTC since sessions use UTC
+	err = mysqlutil.EnsureColumn(db, tableName, "created_at", "DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP")
+	if err != nil {
+		return nil, fmt.Errorf("error init messages repository: %v", err)
+	}
+
+	// messages stored before are in plain text
+	err = mysqlutil.EnsureColumn(db, tableName, "key_id", mysqlutil.KeyIDColumn)
+	if err != nil {
+		return nil, fmt.Errorf("error init messages repository: %v", err)
+	}
+
 	return &MySQLRepository{
-		db: db,
+		db:   db,
+		keys: keys,
 	}, nil
 }
 
-// GetAll returns all messages which is sended from a user
-func (r *MySQLRepository) GetAll(from string) ([]model.Message, error) {
-	q := "SELECT id, from_client, to_client, body FROM " + tableName + " where from_client=?"
+// scanner is a row or rows of a message query
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	logrus.Debug("QUERY: ", q, from)
-	res, err := r.db.Query(q, from)
+// scanMessage reads a message of a query of selectColumns
+func (r *MySQLRepository) scanMessage(row scanner) (model.Message, error) {
+	var message model.Message
+	var expiresAt sql.NullTime
+	var body, keyID string
+	if err := row.Scan(&message.ID, &message.From, &message.To, &body, &message.ParentID, &expiresAt, &message.CreatedAt, &keyID); err != nil {
+		return message, err
+	}
+	if expiresAt.Valid {
+		message.ExpiresAt = &expiresAt.Time
+	}
+	text, err := mysqlutil.Open(r.keys, keyID, body, mysqlutil.RowData(tableName, message.ID))
 	if err != nil {
-		return nil, fmt.Errorf("error init message repository: %v", err)
+		return message, fmt.Errorf("message %d: %v", message.ID, err)
 	}
+	message.Text = text
+	return message, nil
+}
+
+// scanMessages reads all rows of a message query
+func (r *MySQLRepository) scanMessages(res *sql.Rows) ([]model.Message, error) {
+	defer res.Close()
 	var messages []model.Message
 	for res.Next() {
-		var message model.Message
-		if err := res.Scan(&message.ID, &message.From, &message.To, &message.Text); err != nil {
-			fmt.Println(err)
+		message, err := r.scanMessage(res)
+		if err != nil {
 			return nil, err
 		}
 		messages = append(messages, message)
-
 	}
-	return messages, nil
+	return messages, res.Err()
 }
 
 // GetAll returns all messages which is sended from a user
-func (r *MySQLRepository) GetAllToMe(from string) ([]model.Message, error) {
-	q := "SELECT id, from_client, to_client, body FROM " + tableName + " where to_client=?"
+func (r *MySQLRepository) GetAll(ctx context.Context, from string) ([]model.Message, error) {
+	q := selectColumns + " where from_client=?" + notExpired
 
 	logrus.Debug("QUERY: ", q, from)
-	res, err := r.db.Query(q, from)
+	res, err := r.db.QueryContext(ctx, q, from)
 	if err != nil {
 		return nil, fmt.Errorf("error init message repository: %v", err)
 	}
-	var messages []model.Message
-	for res.Next() {
-		var message model.Message
-		if err := res.Scan(&message.ID, &message.From, &message.To, &message.Text); err != nil {
-			fmt.Println(err)
-			return nil, err
-		}
-		messages = append(messages, message)
+	return r.scanMessages(res)
+}
+
+// GetAll returns all messages which is sended from a user
+func (r *MySQLRepository) GetAllToMe(ctx context.Context, from string) ([]model.Message, error) {
+	q := selectColumns + " where to_client=?" + notExpired
 
+	logrus.Debug("QUERY: ", q, from)
+	res, err := r.db.QueryContext(ctx, q, from)
+	if err != nil {
+		return nil, fmt.Errorf("error init message repository: %v", err)
 	}
-	return messages, nil
+	return r.scanMessages(res)
 }
 
 // GetLast returns last X messages which is sended from a user
-func (r *MySQLRepository) GetLast(from string, limit string) ([]model.Message, error) {
-	q := "SELECT id, from_client, to_client, body FROM " + tableName + " where from_client=? ORDER BY id DESC LIMIT ?"
+func (r *MySQLRepository) GetLast(ctx context.Context, from string, limit string) ([]model.Message, error) {
+	q := selectColumns + " where from_client=?" + notExpired + " ORDER BY id DESC LIMIT ?"
 
 	logrus.Debug("QUERY: ", q, from)
-	res, err := r.db.Query(q, from, limit)
+	res, err := r.db.QueryContext(ctx, q, from, limit)
 	if err != nil {
 		return nil, fmt.Errorf("error init message repository: %v", err)
 	}
-	var messages []model.Message
-	for res.Next() {
-		var message model.Message
-		if err := res.Scan(&message.ID, &message.From, &message.To, &message.Text); err != nil {
-			fmt.Println(err)
-			return nil, err
-		}
-		messages = append(messages, message)
-
-	}
-	return messages, nil
+	return r.scanMessages(res)
 }
 
 // GetContains returns all messages which is contains a word
-func (r *MySQLRepository) GetContains(from string, word string) ([]model.Message, error) {
-	q := "SELECT id, from_client, to_client, body FROM " + tableName + " where from_client=?"
+func (r *MySQLRepository) GetContains(ctx context.Context, from string, word string) ([]model.Message, error) {
+	q := selectColumns + " where from_client=?" + notExpired
 
 	logrus.Debug("QUERY: ", q)
-	res, err := r.db.Query(q, from)
+	res, err := r.db.QueryContext(ctx, q, from)
 	if err != nil {
 		return nil, fmt.Errorf("error init message repository: %v", err)
 	}
+	all, err := r.scanMessages(res)
+	if err != nil {
+		return nil, err
+	}
 	var messages []model.Message
-	for res.Next() {
-		var message model.Message
-		if err := res.Scan(&message.ID, &message.From, &message.To, &message.Text); err != nil {
-			fmt.Println(err)
-			return nil, err
-		}
+	for _, message := range all {
 		if strings.Contains(message.Text, word) {
 			messages = append(messages, message)
 		}
-
 	}
 	return messages, nil
 }
 
+// Get returns the message with the given id
+func (r *MySQLRepository) Get(ctx context.Context, id int64) (model.Message, error) {
+	q := selectColumns + " where id=?" + notExpired
+
+	logrus.Debug("QUERY: ", q, id)
+	message, err := r.scanMessage(r.db.QueryRowContext(ctx, q, id))
+	if err == sql.ErrNoRows {
+		return message, ErrNotFound
+	}
+	if err != nil {
+		return message, fmt.Errorf("error get message: %v", err)
+	}
+	return message, nil
+}
+
+// GetReplies returns direct replies of a message ordered by id
+func (r *MySQLRepository) GetReplies(ctx context.Context, parentID int64) ([]model.Message, error) {
+	q := selectColumns + " where parent_id=?" + notExpired + " ORDER BY id"
+
+	logrus.Debug("QUERY: ", q, parentID)
+	res, err := r.db.QueryContext(ctx, q, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("error get replies: %v", err)
+	}
+	return r.scanMessages(res)
+}
+
 // Store returns an id which is ID of row
-func (r *MySQLRepository) Store(message model.Message) (int64, error) {
-	stmt, err := r.db.Prepare(`INSERT INTO ` + tableName + `(
-		from_client,to_client,body)
-		VALUES(
-			?,?,?)`)
+func (r *MySQLRepository) Store(ctx context.Context, message model.Message) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return -1, err
 	}
+	defer tx.Rollback()
 
-	defer stmt.Close()
-	logrus.Debug("QUERY: ", stmt)
-	res, err := stmt.Exec(
-		message.From, message.To, message.Text)
+	// the body is encrypted once the id of the row is known
+	q := `INSERT INTO ` + tableName + `(
+		from_client,to_client,body,parent_id,expires_at,created_at,key_id)
+		VALUES(
+			?,?,'',?,?,?,'')`
+	logrus.Debug("QUERY: ", q)
+	var expiresAt sql.NullTime
+	if message.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: message.ExpiresAt.UTC(), Valid: true}
+	}
+	createdAt := message.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	res, err := tx.ExecContext(ctx, q,
+		message.From, message.To, message.ParentID, expiresAt, createdAt.UTC())
 	if err != nil {
 		return -1, err
 	}
@@ -153,5 +238,266 @@ func (r *MySQLRepository) Store(message model.Message) (int64, error) {
 	if err != nil {
 		return -1, err
 	}
+	if err := mysqlutil.SealColumn(ctx, tx, r.keys, tableName, "body", id, message.Text); err != nil {
+		return -1, err
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
 	return id, nil
 }
+
+// GetExpired returns the messages which are expired at now, the first expired first
+func (r *MySQLRepository) GetExpired(ctx context.Context, now time.Time, limit int) ([]model.Message, error) {
+	q := selectColumns + " where expires_at <= ? ORDER BY expires_at, id LIMIT ?"
+
+	logrus.Debug("QUERY: ", q, now, limit)
+	res, err := r.db.QueryContext(ctx, q, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error get expired messages: %v", err)
+	}
+	return r.scanMessages(res)
+}
+
+// GetHistory returns the messages of a history filter with an id after afterID, at most limit of them
+// ordered by id, so all messages are read in pages by passing the last id of the previous page
+func (r *MySQLRepository) GetHistory(ctx context.Context, f model.HistoryFilter, afterID int64, limit int) ([]model.Message, error) {
+	q := selectColumns + " where id > ?"
+	args := []interface{}{afterID}
+	if f.User != "" {
+		q += " AND (from_client=? OR to_client=?)"
+		args = append(args, f.User, f.User)
+	}
+	if !f.Since.IsZero() {
+		q += " AND created_at >= ?"
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		q += " AND created_at < ?"
+		args = append(args, f.Until.UTC())
+	}
+	q += notExpired + " ORDER BY id LIMIT ?"
+	args = append(args, limit)
+
+	logrus.Debug("QUERY: ", q, args)
+	res, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error get message history: %v", err)
+	}
+	return r.scanMessages(res)
+}
+
+// Delete removes messages by their ids and returns how many were removed
+func (r *MySQLRepository) Delete(ctx context.Context, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	q := "DELETE FROM " + tableName + " where id IN (" + placeholders(len(ids)) + ")"
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	logrus.Debug("QUERY: ", q, ids)
+	res, err := r.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return -1, fmt.Errorf("error delete messages: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// Reseal encrypts the bodies of messages which are not encrypted with the active key with it,
+// or stores them in plain text when there is no active key. Bodies which older versions encrypted
+// without binding them to their row are encrypted again too. It reads at most limit messages with
+// an id after afterID and returns the last id it read, 0 when none is left, and how many it updated
+func (r *MySQLRepository) Reseal(ctx context.Context, afterID int64, limit int) (int64, int64, error) {
+	var active string
+	if r.keys != nil {
+		active = r.keys.ActiveKey()
+	}
+	q := "SELECT id, body, key_id FROM " + tableName + " where id > ? AND (key_id <> ? OR (key_id <> '' AND body NOT LIKE ?)) ORDER BY id LIMIT ?"
+
+	logrus.Debug("QUERY: ", q, afterID, active, limit)
+	res, err := r.db.QueryContext(ctx, q, afterID, active, crypto.BoundPrefix+"%", limit)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error get messages to reseal: %v", err)
+	}
+	type row struct {
+		id          int64
+		body, keyID string
+	}
+	var rows []row
+	for res.Next() {
+		var x row
+		if err := res.Scan(&x.id, &x.body, &x.keyID); err != nil {
+			res.Close()
+			return 0, 0, fmt.Errorf("error get messages to reseal: %v", err)
+		}
+		rows = append(rows, x)
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error get messages to reseal: %v", err)
+	}
+
+	var last, count int64
+	for _, x := range rows {
+		data := mysqlutil.RowData(tableName, x.id)
+		text, err := mysqlutil.Open(r.keys, x.keyID, x.body, data)
+		if err != nil {
+			return last, count, fmt.Errorf("message %d: %v", x.id, err)
+		}
+		keyID, body, err := mysqlutil.Seal(r.keys, text, data)
+		if err != nil {
+			return last, count, fmt.Errorf("message %d: %v", x.id, err)
+		}
+		// a message changed meanwhile is left for the next run
+		u := "UPDATE " + tableName + " SET body=?, key_id=? where id=? AND key_id=?"
+		updated, err := r.db.ExecContext(ctx, u, body, keyID, x.id, x.keyID)
+		if err != nil {
+			return last, count, fmt.Errorf("error reseal message %d: %v", x.id, err)
+		}
+		n, err := updated.RowsAffected()
+		if err != nil {
+			return last, count, fmt.Errorf("error reseal message %d: %v", x.id, err)
+		}
+		count += n
+		last = x.id
+	}
+	return last, count, nil
+}
+
+// placeholders returns n comma separated placeholders
+func placeholders(n int) string {
+	return "?" + strings.Repeat(",?", n-1)
+}
+
+// roomsCondition returns the condition of the messages between the users of rooms
+func roomsCondition(rooms [][2]string) (string, []interface{}) {
+	conds := make([]string, 0, len(rooms))
+	var args []interface{}
+	for _, room := range rooms {
+		conds = append(conds, "(from_client=? AND to_client=?) OR (from_client=? AND to_client=?)")
+		args = append(args, room[0], room[1], room[1], room[0])
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// retentionScope returns the condition of the messages in the scope of a retention filter,
+// empty for all messages
+func retentionScope(f model.RetentionFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if len(f.Users) > 0 {
+		conds = append(conds, "from_client IN ("+placeholders(len(f.Users))+")")
+		for _, user := range f.Users {
+			args = append(args, user)
+		}
+	}
+	if len(f.Rooms) > 0 {
+		cond, roomArgs := roomsCondition(f.Rooms)
+		conds = append(conds, cond)
+		args = append(args, roomArgs...)
+	}
+	if len(f.ExceptUsers) > 0 {
+		conds = append(conds, "from_client NOT IN ("+placeholders(len(f.ExceptUsers))+")")
+		for _, user := range f.ExceptUsers {
+			args = append(args, user)
+		}
+	}
+	if len(f.ExceptRooms) > 0 {
+		cond, roomArgs := roomsCondition(f.ExceptRooms)
+		conds = append(conds, "NOT "+cond)
+		args = append(args, roomArgs...)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// purgeable returns the condition of the messages a retention filter purges,
+// false when it purges none
+func (r *MySQLRepository) purgeable(ctx context.Context, f model.RetentionFilter) (string, []interface{}, bool, error) {
+	scope, args := retentionScope(f)
+	where := ""
+	if scope != "" {
+		where = " where " + scope
+	}
+
+	var limits []string
+	var limitArgs []interface{}
+	if !f.Before.IsZero() {
+		limits = append(limits, "created_at < ?")
+		limitArgs = append(limitArgs, f.Before.UTC())
+	}
+	if f.KeepLast > 0 {
+		// messages older than the last one kept are purged
+		q := "SELECT id FROM " + tableName + where + " ORDER BY id DESC LIMIT 1 OFFSET ?"
+
+		logrus.Debug("QUERY: ", q, args, f.KeepLast-1)
+		var oldest int64
+		err := r.db.QueryRowContext(ctx, q, append(args, f.KeepLast-1)...).Scan(&oldest)
+		if err != nil && err != sql.ErrNoRows {
+			return "", nil, false, err
+		}
+		if err == nil {
+			limits = append(limits, "id < ?")
+			limitArgs = append(limitArgs, oldest)
+		}
+	}
+	if len(limits) == 0 {
+		return "", nil, false, nil
+	}
+
+	cond := "(" + strings.Join(limits, " OR ") + ")"
+	if scope != "" {
+		cond = scope + " AND " + cond
+	}
+	return cond, append(args, limitArgs...), true, nil
+}
+
+// GetPurgeable returns the ids of the oldest messages a retention filter purges, at most limit of them
+func (r *MySQLRepository) GetPurgeable(ctx context.Context, f model.RetentionFilter, limit int) ([]int64, error) {
+	cond, args, ok, err := r.purgeable(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("error get purgeable messages: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	q := "SELECT id FROM " + tableName + " where " + cond + " ORDER BY id LIMIT ?"
+
+	logrus.Debug("QUERY: ", q, args, limit)
+	rows, err := r.db.QueryContext(ctx, q, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("error get purgeable messages: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error get purgeable messages: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CountPurgeable returns the number of messages a retention filter purges
+func (r *MySQLRepository) CountPurgeable(ctx context.Context, f model.RetentionFilter) (int64, error) {
+	cond, args, ok, err := r.purgeable(ctx, f)
+	if err != nil {
+		return 0, fmt.Errorf("error count purgeable messages: %v", err)
+	}
+	if !ok {
+		return 0, nil
+	}
+	q := "SELECT COUNT(*) FROM " + tableName + " where " + cond
+
+	logrus.Debug("QUERY: ", q, args)
+	var count int64
+	if err := r.db.QueryRowContext(ctx, q, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error count purgeable messages: %v", err)
+	}
+	return count, nil
+}
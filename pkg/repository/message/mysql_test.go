@@ -1,13 +1,20 @@
 package message
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
 	"log"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
 	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/mysqlutil"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,6 +26,9 @@ var m = &model.Message{
 	Text: "Test Text",
 }
 
+// time messages of the tests are created
+var createdAt = time.Date(2021, 2, 1, 9, 0, 0, 0, time.UTC)
+
 var wrongM = &model.Message{
 	ID:   int64(1),
 	From: "Test3",
@@ -34,39 +44,82 @@ func NewMock() (*sql.DB, sqlmock.Sqlmock) {
 
 	return db, mock
 }
+func TestNewMySQLRepository_addsParentID(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	columnQuery := "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?"
+
+	// the table of an older version has no reply column, the others exist
+	mock.ExpectExec(fmt.Sprintf(initTableTemplate, tableName)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(columnQuery).WithArgs(tableName, "parent_id").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN parent_id bigint(20) NOT NULL DEFAULT 0").WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, column := range []string{"expires_at", "created_at", "key_id"} {
+		mock.ExpectQuery(columnQuery).WithArgs(tableName, column).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	}
+
+	_, err = NewMySQLRepository(db, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestGetAll(t *testing.T) {
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if err != nil {
 		log.Fatalln(err)
 	}
 	repo := &MySQLRepository{db: db}
-	query := "SELECT id, from_client, to_client, body FROM messages where from_client=?"
+	query := "SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where from_client=? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP())"
 
-	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body"}).
-		AddRow(m.ID, m.From, m.To, m.Text)
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, m.Text, m.ParentID, nil, createdAt, "")
 
 	mock.ExpectQuery(query).WithArgs(m.From).WillReturnRows(rows)
 
-	messages, err := repo.GetAll(m.From)
+	messages, err := repo.GetAll(context.Background(), m.From)
 	assert.NotNil(t, messages)
 	assert.NoError(t, err)
 
 }
 
+func TestMySQLRepository_GetAllCanceled(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	query := "SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where from_client=? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP())"
+
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, m.Text, m.ParentID, nil, createdAt, "")
+
+	// the query does not answer before the deadline
+	mock.ExpectQuery(query).WithArgs(m.From).WillDelayFor(time.Second).WillReturnRows(rows)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	messages, err := repo.GetAll(ctx, m.From)
+	assert.Nil(t, messages)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
 func TestMySQLRepository_GetAllToMe(t *testing.T) {
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if err != nil {
 		log.Fatalln(err)
 	}
 	repo := &MySQLRepository{db: db}
-	query := "SELECT id, from_client, to_client, body FROM messages where to_client=?"
+	query := "SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where to_client=? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP())"
 
-	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body"}).
-		AddRow(m.ID, m.From, m.To, m.Text)
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, m.Text, m.ParentID, nil, createdAt, "")
 
 	mock.ExpectQuery(query).WithArgs(m.From).WillReturnRows(rows)
 
-	messages, err := repo.GetAllToMe(m.From)
+	messages, err := repo.GetAllToMe(context.Background(), m.From)
 	assert.NotNil(t, messages)
 	assert.NoError(t, err)
 }
@@ -77,14 +130,14 @@ func TestMySQLRepository_GetLast(t *testing.T) {
 		log.Fatalln(err)
 	}
 	repo := &MySQLRepository{db: db}
-	query := "SELECT id, from_client, to_client, body FROM messages where from_client=? ORDER BY id DESC LIMIT ?"
+	query := "SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where from_client=? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP()) ORDER BY id DESC LIMIT ?"
 
-	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body"}).
-		AddRow(m.ID, m.From, m.To, m.Text)
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, m.Text, m.ParentID, nil, createdAt, "")
 
 	mock.ExpectQuery(query).WithArgs(m.From, "2").WillReturnRows(rows)
 
-	message, err := repo.GetLast(m.From, "2")
+	message, err := repo.GetLast(context.Background(), m.From, "2")
 	assert.NotNil(t, message)
 	assert.NoError(t, err)
 }
@@ -95,14 +148,14 @@ func TestMySQLRepository_GetContains(t *testing.T) {
 		log.Fatalln(err)
 	}
 	repo := &MySQLRepository{db: db}
-	query := "SELECT id, from_client, to_client, body FROM messages where from_client=?"
+	query := "SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where from_client=? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP())"
 
-	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body"}).
-		AddRow(m.ID, m.From, m.To, m.Text)
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, m.Text, m.ParentID, nil, createdAt, "")
 
 	mock.ExpectQuery(query).WithArgs(m.From).WillReturnRows(rows)
 
-	messages, err := repo.GetContains(m.From, "Test")
+	messages, err := repo.GetContains(context.Background(), m.From, "Test")
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -116,3 +169,385 @@ func TestMySQLRepository_GetContains(t *testing.T) {
 	assert.NotNil(t, messages)
 	assert.NoError(t, err)
 }
+
+func TestMySQLRepository_Get(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	query := "SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where id=? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP())"
+
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, m.Text, m.ParentID, nil, createdAt, "")
+
+	mock.ExpectQuery(query).WithArgs(m.ID).WillReturnRows(rows)
+
+	message, err := repo.Get(context.Background(), m.ID)
+	assert.NoError(t, err)
+	want := *m
+	want.CreatedAt = createdAt
+	assert.Equal(t, want, message)
+
+	mock.ExpectQuery(query).WithArgs(int64(42)).WillReturnRows(sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}))
+
+	_, err = repo.Get(context.Background(), 42)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMySQLRepository_GetReplies(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	query := "SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where parent_id=? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP()) ORDER BY id"
+
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(int64(2), m.To, m.From, "Reply Text", m.ID, nil, createdAt, "")
+
+	mock.ExpectQuery(query).WithArgs(m.ID).WillReturnRows(rows)
+
+	messages, err := repo.GetReplies(context.Background(), m.ID)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, m.ID, messages[0].ParentID)
+}
+
+func TestMySQLRepository_Store(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO messages").
+		WithArgs(m.From, m.To, int64(7), nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec("UPDATE messages SET body").
+		WithArgs(m.Text, "", int64(8)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	reply := *m
+	reply.ParentID = 7
+	id, err := repo.Store(context.Background(), reply)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), id)
+}
+
+func TestMySQLRepository_StoreExpiring(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	expiresAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO messages").
+		WithArgs(m.From, m.To, int64(0), expiresAt, createdAt).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec("UPDATE messages SET body").
+		WithArgs(m.Text, "", int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	expiring := *m
+	expiring.ExpiresAt = &expiresAt
+	expiring.CreatedAt = createdAt
+	id, err := repo.Store(context.Background(), expiring)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), id)
+}
+
+// newKeyring returns a keyring with the keys old and new, the active one encrypts messages
+func newKeyring(t *testing.T, active string) *crypto.Keyring {
+	keys, err := crypto.NewKeyring(crypto.AtRestConfig{
+		ActiveKey: active,
+		Keys: []crypto.KeyConfig{
+			{ID: "old", Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", crypto.KeySize)))},
+			{ID: "new", Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", crypto.KeySize)))},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// bodyArg matches any body and keeps it
+type bodyArg struct {
+	body string
+}
+
+func (a *bodyArg) Match(v driver.Value) bool {
+	a.body, _ = v.(string)
+	return true
+}
+
+func TestMySQLRepository_StoreEncrypted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db, keys: newKeyring(t, "new")}
+	body := &bodyArg{}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO messages").
+		WithArgs(m.From, m.To, int64(0), nil, createdAt).
+		WillReturnResult(sqlmock.NewResult(10, 1))
+	mock.ExpectExec("UPDATE messages SET body").
+		WithArgs(body, "new", int64(10)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	stored := *m
+	stored.CreatedAt = createdAt
+	id, err := repo.Store(context.Background(), stored)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), id)
+	assert.NotContains(t, body.body, m.Text)
+
+	// bodies are decrypted with the key of their row, old ones are in plain text
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(int64(1), m.From, m.To, "Plain Text", int64(0), nil, createdAt, "").
+		AddRow(int64(10), m.From, m.To, body.body, int64(0), nil, createdAt, "new")
+	mock.ExpectQuery("SELECT (.+) FROM messages where from_client=?").
+		WithArgs(m.From).
+		WillReturnRows(rows)
+
+	messages, err := repo.GetContains(context.Background(), m.From, "Text")
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, "Plain Text", messages[0].Text)
+		assert.Equal(t, m.Text, messages[1].Text)
+	}
+
+	// a body moved to another row is not decrypted
+	rows = sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(int64(11), m.From, m.To, body.body, int64(0), nil, createdAt, "new")
+	mock.ExpectQuery("SELECT (.+) FROM messages where id=?").
+		WithArgs(int64(11)).
+		WillReturnRows(rows)
+	_, err = repo.Get(context.Background(), 11)
+	assert.EqualError(t, err, "error get message: message 11: unable to decrypt: cipher: message authentication failed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQLRepository_GetUnknownKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, "c2VhbGVk", m.ParentID, nil, createdAt, "old")
+	mock.ExpectQuery("SELECT (.+) FROM messages where id=?").
+		WithArgs(m.ID).
+		WillReturnRows(rows)
+
+	_, err = repo.Get(context.Background(), m.ID)
+	assert.EqualError(t, err, "error get message: message 1: key old: unknown encryption key")
+}
+
+func TestMySQLRepository_Reseal(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	old := &MySQLRepository{db: db, keys: newKeyring(t, "old")}
+	_, sealed, err := mysqlutil.Seal(old.keys, "Old Text", mysqlutil.RowData(tableName, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &MySQLRepository{db: db, keys: newKeyring(t, "new")}
+	plainBody, oldBody := &bodyArg{}, &bodyArg{}
+
+	mock.ExpectQuery("SELECT id, body, key_id FROM messages where id > ? AND (key_id <> ? OR (key_id <> '' AND body NOT LIKE ?)) ORDER BY id LIMIT ?").
+		WithArgs(int64(0), "new", crypto.BoundPrefix+"%", 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "body", "key_id"}).
+			AddRow(int64(1), "Plain Text", "").
+			AddRow(int64(2), sealed, "old"))
+	mock.ExpectExec("UPDATE messages SET body=?, key_id=? where id=? AND key_id=?").
+		WithArgs(plainBody, "new", int64(1), "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE messages SET body=?, key_id=? where id=? AND key_id=?").
+		WithArgs(oldBody, "new", int64(2), "old").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	last, count, err := repo.Reseal(context.Background(), 0, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), last)
+	assert.Equal(t, int64(2), count)
+	resealed := []struct {
+		id   int64
+		body *bodyArg
+		want string
+	}{{1, plainBody, "Plain Text"}, {2, oldBody, "Old Text"}}
+	for _, x := range resealed {
+		text, err := mysqlutil.Open(repo.keys, "new", x.body.body, mysqlutil.RowData(tableName, x.id))
+		assert.NoError(t, err)
+		assert.Equal(t, x.want, text)
+	}
+
+	// nothing is left
+	mock.ExpectQuery("SELECT id, body, key_id FROM messages where id > ? AND (key_id <> ? OR (key_id <> '' AND body NOT LIKE ?)) ORDER BY id LIMIT ?").
+		WithArgs(int64(2), "new", crypto.BoundPrefix+"%", 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "body", "key_id"}))
+	last, count, err = repo.Reseal(context.Background(), 2, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), last)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQLRepository_GetExpired(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(-time.Minute)
+
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, m.Text, m.ParentID, expiresAt, createdAt, "")
+	mock.ExpectQuery("SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where expires_at <= ? ORDER BY expires_at, id LIMIT ?").
+		WithArgs(now, 100).
+		WillReturnRows(rows)
+
+	messages, err := repo.GetExpired(context.Background(), now, 100)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, m.ID, messages[0].ID)
+		assert.Equal(t, expiresAt, *messages[0].ExpiresAt)
+	}
+}
+
+func TestMySQLRepository_GetHistory(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	since := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}).
+		AddRow(m.ID, m.From, m.To, m.Text, m.ParentID, nil, createdAt, "")
+	mock.ExpectQuery("SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where id > ? AND (from_client=? OR to_client=?) AND created_at >= ? AND created_at < ? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP()) ORDER BY id LIMIT ?").
+		WithArgs(int64(0), m.From, m.From, since, until, 500).
+		WillReturnRows(rows)
+
+	messages, err := repo.GetHistory(context.Background(), model.HistoryFilter{User: m.From, Since: since, Until: until}, 0, 500)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, m.ID, messages[0].ID)
+		assert.Equal(t, createdAt, messages[0].CreatedAt)
+	}
+
+	// without a filter all messages are read
+	mock.ExpectQuery("SELECT id, from_client, to_client, body, parent_id, expires_at, created_at, key_id FROM messages where id > ? AND (expires_at IS NULL OR expires_at > UTC_TIMESTAMP()) ORDER BY id LIMIT ?").
+		WithArgs(m.ID, 500).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_client", "to_client", "body", "parent_id", "expires_at", "created_at", "key_id"}))
+
+	messages, err = repo.GetHistory(context.Background(), model.HistoryFilter{}, m.ID, 500)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQLRepository_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectExec("DELETE FROM messages where id IN (?,?,?)").
+		WithArgs(1, 2, 5).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	count, err := repo.Delete(context.Background(), []int64{1, 2, 5})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// nothing is deleted without ids
+	count, err = repo.Delete(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestMySQLRepository_GetPurgeable(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	before := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// messages of the user, except the ones of a room with its own policy,
+	// which are too old or not among the newest 100
+	f := model.RetentionFilter{
+		Users:       []string{"Test"},
+		ExceptRooms: [][2]string{{"Test", "Test2"}},
+		Before:      before,
+		KeepLast:    100,
+	}
+	scope := "from_client IN (?) AND NOT ((from_client=? AND to_client=?) OR (from_client=? AND to_client=?))"
+	mock.ExpectQuery("SELECT id FROM messages where "+scope+" ORDER BY id DESC LIMIT 1 OFFSET ?").
+		WithArgs("Test", "Test", "Test2", "Test2", "Test", 99).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(40)))
+	mock.ExpectQuery("SELECT id FROM messages where "+scope+" AND (created_at < ? OR id < ?) ORDER BY id LIMIT ?").
+		WithArgs("Test", "Test", "Test2", "Test2", "Test", before, int64(40), 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(3)).AddRow(int64(5)))
+
+	ids, err := repo.GetPurgeable(context.Background(), f, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3, 5}, ids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQLRepository_GetPurgeableFewMessages(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	// nothing is purged when there are fewer messages than kept
+	mock.ExpectQuery("SELECT id FROM messages ORDER BY id DESC LIMIT 1 OFFSET ?").
+		WithArgs(999).
+		WillReturnError(sql.ErrNoRows)
+
+	ids, err := repo.GetPurgeable(context.Background(), model.RetentionFilter{KeepLast: 1000}, 100)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// nor without limits
+	ids, err = repo.GetPurgeable(context.Background(), model.RetentionFilter{}, 100)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestMySQLRepository_CountPurgeable(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	before := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT COUNT(*) FROM messages where from_client NOT IN (?,?) AND (created_at < ?)").
+		WithArgs("Test", "Test2", before).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+
+	count, err := repo.CountPurgeable(context.Background(), model.RetentionFilter{ExceptUsers: []string{"Test", "Test2"}, Before: before})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), count)
+}
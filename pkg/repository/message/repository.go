@@ -1,19 +1,36 @@
 package message
 
-import "github.com/Selahattinn/picus-tcp-message/pkg/model"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+// ErrNotFound is returned when a requested message does not exist
+var ErrNotFound = errors.New("message not found")
 
 type Reader interface {
-	GetAll(from string) ([]model.Message, error)
-	GetAllToMe(from string) ([]model.Message, error)
-	GetLast(from string, limit string) ([]model.Message, error)
-	GetContains(from string, word string) ([]model.Message, error)
+	GetAll(ctx context.Context, from string) ([]model.Message, error)
+	GetAllToMe(ctx context.Context, from string) ([]model.Message, error)
+	GetLast(ctx context.Context, from string, limit string) ([]model.Message, error)
+	GetContains(ctx context.Context, from string, word string) ([]model.Message, error)
+	Get(ctx context.Context, id int64) (model.Message, error)
+	GetReplies(ctx context.Context, parentID int64) ([]model.Message, error)
+	GetExpired(ctx context.Context, now time.Time, limit int) ([]model.Message, error)
+	GetPurgeable(ctx context.Context, f model.RetentionFilter, limit int) ([]int64, error)
+	CountPurgeable(ctx context.Context, f model.RetentionFilter) (int64, error)
+	GetHistory(ctx context.Context, f model.HistoryFilter, afterID int64, limit int) ([]model.Message, error)
 }
 
 type Writer interface {
-	Store(message model.Message) (int64, error)
+	Store(ctx context.Context, message model.Message) (int64, error)
+	Delete(ctx context.Context, ids []int64) (int64, error)
+	Reseal(ctx context.Context, afterID int64, limit int) (int64, int64, error)
 }
 
-//Repository repository interface
+// Repository repository interface
 type Repository interface {
 	Reader
 	Writer
@@ -1,17 +1,30 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/ban"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/block"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/conversation"
 	"github.com/Selahattinn/picus-tcp-message/pkg/repository/message"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/schedule"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/webhook"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 // MySQL Repository defines the MySQL implementation of Repository interface
 type MySQLRepository struct {
-	cfg               *MySQLConfig
-	db                *sql.DB
-	messageRepository message.Repository
+	cfg                    *MySQLConfig
+	db                     *sql.DB
+	messageRepository      message.Repository
+	banRepository          ban.Repository
+	blockRepository        block.Repository
+	webhookRepository      webhook.Repository
+	scheduleRepository     schedule.Repository
+	conversationRepository conversation.Repository
 }
 
 // MySQLConfig defines the MySQL Repository configuration
@@ -20,6 +33,9 @@ type MySQLConfig struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 	DBName   string `yaml:"db_name"`
+
+	// keys which encrypt stored message bodies, they are stored in plain text when nil
+	Encryption *crypto.AtRestConfig `yaml:"encryption"`
 }
 
 // dbConn opens connection with MySQL driver
@@ -43,7 +59,9 @@ func dbConn(cfg *MySQLConfig) (*sql.DB, error) {
 
 	db.Close()
 
-	db, err = sql.Open(dbDriver, dbUser+":"+dbPass+"@/"+dbName+"?parseTime=true")
+	// sessions use UTC like the times the server writes, so defaults
+	// like CURRENT_TIMESTAMP match them whatever the server time zone is
+	db, err = sql.Open(dbDriver, dbUser+":"+dbPass+"@tcp("+addr+")/"+dbName+"?parseTime=true&time_zone=%27%2B00%3A00%27")
 	if err != nil {
 		return nil, err
 	}
@@ -54,19 +72,52 @@ func dbConn(cfg *MySQLConfig) (*sql.DB, error) {
 
 // NewMySQLRepository creates a new MySQL Repository
 func NewMySQLRepository(cfg *MySQLConfig) (*MySQLRepository, error) {
+	var keys *crypto.Keyring
+	if cfg.Encryption != nil {
+		var err error
+		keys, err = crypto.NewKeyring(*cfg.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: %v", err)
+		}
+	}
+
 	db, err := dbConn(cfg)
 	if err != nil {
 		return nil, err
 	}
-
-	messageRepository, err := message.NewMySQLRepository(db)
+	messageRepository, err := message.NewMySQLRepository(db, keys)
+	if err != nil {
+		return nil, err
+	}
+	banRepository, err := ban.NewMySQLRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	blockRepository, err := block.NewMySQLRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	webhookRepository, err := webhook.NewMySQLRepository(db, keys)
+	if err != nil {
+		return nil, err
+	}
+	scheduleRepository, err := schedule.NewMySQLRepository(db, keys)
+	if err != nil {
+		return nil, err
+	}
+	conversationRepository, err := conversation.NewMySQLRepository(db)
 	if err != nil {
 		return nil, err
 	}
 	return &MySQLRepository{
-		cfg:               cfg,
-		db:                db,
-		messageRepository: messageRepository,
+		cfg:                    cfg,
+		db:                     db,
+		messageRepository:      message.NewInstrumentedRepository(messageRepository),
+		banRepository:          banRepository,
+		blockRepository:        blockRepository,
+		webhookRepository:      webhookRepository,
+		scheduleRepository:     scheduleRepository,
+		conversationRepository: conversationRepository,
 	}, nil
 }
 
@@ -75,6 +126,36 @@ func (r *MySQLRepository) GetMessageRepository() message.Repository {
 	return r.messageRepository
 }
 
+// GetBanRepository returns the ban repository
+func (r *MySQLRepository) GetBanRepository() ban.Repository {
+	return r.banRepository
+}
+
+// GetBlockRepository returns the block repository
+func (r *MySQLRepository) GetBlockRepository() block.Repository {
+	return r.blockRepository
+}
+
+// GetWebhookRepository returns the webhook delivery repository
+func (r *MySQLRepository) GetWebhookRepository() webhook.Repository {
+	return r.webhookRepository
+}
+
+// GetScheduleRepository returns the scheduled message repository
+func (r *MySQLRepository) GetScheduleRepository() schedule.Repository {
+	return r.scheduleRepository
+}
+
+// GetConversationRepository returns the conversation settings repository
+func (r *MySQLRepository) GetConversationRepository() conversation.Repository {
+	return r.conversationRepository
+}
+
+// Ping checks whether the database is reachable
+func (r *MySQLRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
 // Shutdown closes the database connection
 func (r *MySQLRepository) Shutdown() {
 	r.db.Close()
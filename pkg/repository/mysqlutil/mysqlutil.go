@@ -0,0 +1,76 @@
+// Package mysqlutil holds helpers shared by the MySQL repositories : migrations of
+// tables created by older versions and encryption of stored texts.
+package mysqlutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+)
+
+// KeyIDColumn is the definition of the column which holds the id of the key
+// a text is encrypted with, empty for texts in plain text
+const KeyIDColumn = "VARCHAR(64) NOT NULL DEFAULT ''"
+
+// EnsureColumn adds a column to an existing table if it is missing
+func EnsureColumn(db *sql.DB, table string, column string, definition string) error {
+	q := "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?"
+
+	var count int
+	if err := db.QueryRow(q, table, column).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// RowData returns the additional data a text stored in a row is bound to,
+// so an encrypted text moved to another row or table is not opened
+func RowData(table string, id int64) []byte {
+	return []byte(table + ":" + strconv.FormatInt(id, 10))
+}
+
+// Seal encrypts a text with the active key of keys and returns it with the id of its key,
+// the text is returned as it is when keys is nil
+func Seal(keys *crypto.Keyring, text string, data []byte) (string, string, error) {
+	if keys == nil {
+		return "", text, nil
+	}
+	start := time.Now()
+	keyID, sealed, err := keys.Seal(text, data)
+	metrics.ObserveCrypto("seal", start)
+	return keyID, sealed, err
+}
+
+// Open decrypts a text encrypted with the key of keyID and data, a text without a key is in plain text
+func Open(keys *crypto.Keyring, keyID string, sealed string, data []byte) (string, error) {
+	if keyID == "" {
+		return sealed, nil
+	}
+	if keys == nil {
+		return "", fmt.Errorf("key %s: %v", keyID, crypto.ErrUnknownKey)
+	}
+	start := time.Now()
+	text, err := keys.Open(keyID, sealed, data)
+	metrics.ObserveCrypto("open", start)
+	return text, err
+}
+
+// SealColumn encrypts a text bound to the row of id and stores it in column with the id of its key :
+// rows are inserted without their text first, as the id is known once they are inserted
+func SealColumn(ctx context.Context, tx *sql.Tx, keys *crypto.Keyring, table string, column string, id int64, text string) error {
+	keyID, sealed, err := Seal(keys, text, RowData(table, id))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE "+table+" SET "+column+"=?, key_id=? where id=?", sealed, keyID, id)
+	return err
+}
@@ -1,10 +1,25 @@
 package repository
 
-import "github.com/Selahattinn/picus-tcp-message/pkg/repository/message"
+import (
+	"context"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/ban"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/block"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/conversation"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/message"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/schedule"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/webhook"
+)
 
 // Repository defines the method for all operations related with repository
 // Repository interface is composition of  Repository interfaces of imported packages.
 type Repository interface {
 	Shutdown()
+	Ping(ctx context.Context) error
 	GetMessageRepository() message.Repository
+	GetBanRepository() ban.Repository
+	GetBlockRepository() block.Repository
+	GetWebhookRepository() webhook.Repository
+	GetScheduleRepository() schedule.Repository
+	GetConversationRepository() conversation.Repository
 }
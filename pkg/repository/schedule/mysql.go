@@ -0,0 +1,154 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/mysqlutil"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+type MySQLRepository struct {
+	db *sql.DB
+
+	// keys encrypt the texts, nil keeps them in plain text
+	keys *crypto.Keyring
+}
+
+const (
+	tableName = "scheduled_messages"
+)
+const (
+	initTableTemplate = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id bigint(20) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		from_user VARCHAR(255) NOT NULL,
+		to_user VARCHAR(255) NOT NULL,
+		text TEXT NOT NULL,
+		send_at DATETIME NOT NULL,
+		key_id VARCHAR(64) NOT NULL DEFAULT '',
+		KEY from_user (from_user),
+		KEY send_at (send_at)
+	  ) ENGINE=MyISAM  DEFAULT CHARSET=utf8 ROW_FORMAT=DYNAMIC;
+`
+	selectColumns = "SELECT id, from_user, to_user, text, send_at, key_id FROM " + tableName
+)
+
+// NewMySQLRepository returns a repository of the scheduled messages table, texts are encrypted
+// with keys when it is not nil
+func NewMySQLRepository(db *sql.DB, keys *crypto.Keyring) (*MySQLRepository, error) {
+	tableInitCmd := fmt.Sprintf(initTableTemplate, tableName)
+	_, err := db.Exec(tableInitCmd)
+
+	if err != nil {
+		return nil, fmt.Errorf("error init scheduled messages repository: %v", err)
+	}
+
+	// scheduled messages stored before are in plain text
+	err = mysqlutil.EnsureColumn(db, tableName, "key_id", mysqlutil.KeyIDColumn)
+	if err != nil {
+		return nil, fmt.Errorf("error init scheduled messages repository: %v", err)
+	}
+
+	return &MySQLRepository{
+		db:   db,
+		keys: keys,
+	}, nil
+}
+
+// scan reads the scheduled messages of rows and decrypts their texts
+func (r *MySQLRepository) scan(rows *sql.Rows) ([]model.ScheduledMessage, error) {
+	defer rows.Close()
+	var messages []model.ScheduledMessage
+	for rows.Next() {
+		var m model.ScheduledMessage
+		var keyID string
+		if err := rows.Scan(&m.ID, &m.From, &m.To, &m.Text, &m.At, &keyID); err != nil {
+			return nil, err
+		}
+		text, err := mysqlutil.Open(r.keys, keyID, m.Text, mysqlutil.RowData(tableName, m.ID))
+		if err != nil {
+			return nil, fmt.Errorf("scheduled message %d: %v", m.ID, err)
+		}
+		m.Text = text
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// GetAll returns the scheduled messages of a user, the next one first
+func (r *MySQLRepository) GetAll(ctx context.Context, from string) ([]model.ScheduledMessage, error) {
+	q := selectColumns + " where from_user=? ORDER BY send_at, id"
+
+	logrus.Debug("QUERY: ", q, from)
+	rows, err := r.db.QueryContext(ctx, q, from)
+	if err != nil {
+		return nil, fmt.Errorf("error get scheduled messages: %v", err)
+	}
+	messages, err := r.scan(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error get scheduled messages: %v", err)
+	}
+	return messages, nil
+}
+
+// GetDue returns the scheduled messages whose time is not after now, the oldest first
+func (r *MySQLRepository) GetDue(ctx context.Context, now time.Time, limit int) ([]model.ScheduledMessage, error) {
+	q := selectColumns + " where send_at <= ? ORDER BY send_at, id LIMIT ?"
+
+	logrus.Debug("QUERY: ", q, now, limit)
+	rows, err := r.db.QueryContext(ctx, q, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error get due scheduled messages: %v", err)
+	}
+	messages, err := r.scan(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error get due scheduled messages: %v", err)
+	}
+	return messages, nil
+}
+
+// Store returns an id which is ID of row
+func (r *MySQLRepository) Store(ctx context.Context, m model.ScheduledMessage) (int64, error) {
+	// the text is encrypted once the id of the row is known
+	q := "INSERT INTO " + tableName + " (from_user, to_user, text, send_at, key_id) VALUES (?, ?, '', ?, '')"
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return -1, fmt.Errorf("error store scheduled message: %v", err)
+	}
+	defer tx.Rollback()
+	logrus.Debug("QUERY: ", q, m.From, m.To, m.At)
+	res, err := tx.ExecContext(ctx, q, m.From, m.To, m.At.UTC())
+	if err != nil {
+		return -1, fmt.Errorf("error store scheduled message: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err == nil {
+		err = mysqlutil.SealColumn(ctx, tx, r.keys, tableName, "text", id, m.Text)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return -1, fmt.Errorf("error store scheduled message: %v", err)
+	}
+	return id, nil
+}
+
+// Delete removes a scheduled message of a user and returns how many were removed
+func (r *MySQLRepository) Delete(ctx context.Context, id int64, from string) (int64, error) {
+	q := "DELETE FROM " + tableName + " where id=? AND from_user=?"
+
+	logrus.Debug("QUERY: ", q, id, from)
+	res, err := r.db.ExecContext(ctx, q, id, from)
+	if err != nil {
+		return -1, fmt.Errorf("error delete scheduled message: %v", err)
+	}
+	return res.RowsAffected()
+}
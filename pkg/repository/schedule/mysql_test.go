@@ -0,0 +1,169 @@
+package schedule
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMySQLRepository_GetAll(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"id", "from_user", "to_user", "text", "send_at", "key_id"}).
+		AddRow(1, "Test", "Test2", "Hello", at, "").
+		AddRow(2, "Test", "Test3", "Hi", at.Add(time.Hour), "")
+	mock.ExpectQuery("SELECT id, from_user, to_user, text, send_at, key_id FROM scheduled_messages where from_user=? ORDER BY send_at, id").
+		WithArgs("Test").
+		WillReturnRows(rows)
+
+	messages, err := repo.GetAll(context.Background(), "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, []model.ScheduledMessage{
+		{ID: 1, From: "Test", To: "Test2", Text: "Hello", At: at},
+		{ID: 2, From: "Test", To: "Test3", Text: "Hi", At: at.Add(time.Hour)},
+	}, messages)
+}
+
+func TestMySQLRepository_GetDue(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"id", "from_user", "to_user", "text", "send_at", "key_id"}).
+		AddRow(1, "Test", "Test2", "Hello", now, "")
+	mock.ExpectQuery("SELECT id, from_user, to_user, text, send_at, key_id FROM scheduled_messages where send_at <= ? ORDER BY send_at, id LIMIT ?").
+		WithArgs(now, 100).
+		WillReturnRows(rows)
+
+	messages, err := repo.GetDue(context.Background(), now, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.ScheduledMessage{{ID: 1, From: "Test", To: "Test2", Text: "Hello", At: now}}, messages)
+}
+
+func TestMySQLRepository_Store(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO scheduled_messages (from_user, to_user, text, send_at, key_id) VALUES (?, ?, '', ?, '')").
+		WithArgs("Test", "Test2", at).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec("UPDATE scheduled_messages SET text=?, key_id=? where id=?").
+		WithArgs("Hello", "", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	id, err := repo.Store(context.Background(), model.ScheduledMessage{From: "Test", To: "Test2", Text: "Hello", At: at})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), id)
+}
+
+// textArg matches any text and keeps it
+type textArg struct {
+	text string
+}
+
+func (a *textArg) Match(v driver.Value) bool {
+	a.text, _ = v.(string)
+	return true
+}
+
+func TestMySQLRepository_StoreEncrypted(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	keys, err := crypto.NewKeyring(crypto.AtRestConfig{
+		ActiveKey: "k1",
+		Keys:      []crypto.KeyConfig{{ID: "k1", Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", crypto.KeySize)))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &MySQLRepository{db: db, keys: keys}
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	text := &textArg{}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO scheduled_messages (from_user, to_user, text, send_at, key_id) VALUES (?, ?, '', ?, '')").
+		WithArgs("Test", "Test2", at).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec("UPDATE scheduled_messages SET text=?, key_id=? where id=?").
+		WithArgs(text, "k1", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.Store(context.Background(), model.ScheduledMessage{From: "Test", To: "Test2", Text: "Hello", At: at})
+	assert.NoError(t, err)
+	assert.NotContains(t, text.text, "Hello")
+
+	// texts are decrypted with the key of their row, old ones are in plain text
+	mock.ExpectQuery("SELECT id, from_user, to_user, text, send_at, key_id FROM scheduled_messages where send_at <= ? ORDER BY send_at, id LIMIT ?").
+		WithArgs(at, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_user", "to_user", "text", "send_at", "key_id"}).
+			AddRow(1, "Test", "Test2", "Old", at, "").
+			AddRow(3, "Test", "Test2", text.text, at, "k1"))
+
+	messages, err := repo.GetDue(context.Background(), at, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.ScheduledMessage{
+		{ID: 1, From: "Test", To: "Test2", Text: "Old", At: at},
+		{ID: 3, From: "Test", To: "Test2", Text: "Hello", At: at},
+	}, messages)
+
+	// a text sealed with an unknown key is an error
+	mock.ExpectQuery("SELECT id, from_user, to_user, text, send_at, key_id FROM scheduled_messages where from_user=? ORDER BY send_at, id").
+		WithArgs("Test").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_user", "to_user", "text", "send_at", "key_id"}).
+			AddRow(3, "Test", "Test2", text.text, at, "k0"))
+
+	_, err = repo.GetAll(context.Background(), "Test")
+	assert.EqualError(t, err, "error get scheduled messages: scheduled message 3: key k0: unknown encryption key")
+
+	// a text moved to another row is an error
+	mock.ExpectQuery("SELECT id, from_user, to_user, text, send_at, key_id FROM scheduled_messages where from_user=? ORDER BY send_at, id").
+		WithArgs("Test").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "from_user", "to_user", "text", "send_at", "key_id"}).
+			AddRow(4, "Test", "Test2", text.text, at, "k1"))
+
+	_, err = repo.GetAll(context.Background(), "Test")
+	assert.EqualError(t, err, "error get scheduled messages: scheduled message 4: unable to decrypt: cipher: message authentication failed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQLRepository_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectExec("DELETE FROM scheduled_messages where id=? AND from_user=?").
+		WithArgs(3, "Test").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	count, err := repo.Delete(context.Background(), 3, "Test")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,24 @@
+package schedule
+
+import (
+	"context"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+type Reader interface {
+	GetAll(ctx context.Context, from string) ([]model.ScheduledMessage, error)
+	GetDue(ctx context.Context, now time.Time, limit int) ([]model.ScheduledMessage, error)
+}
+
+type Writer interface {
+	Store(ctx context.Context, m model.ScheduledMessage) (int64, error)
+	Delete(ctx context.Context, id int64, from string) (int64, error)
+}
+
+// Repository repository interface
+type Repository interface {
+	Reader
+	Writer
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/mysqlutil"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+type MySQLRepository struct {
+	db *sql.DB
+
+	// keys encrypt the payloads, nil keeps them in plain text
+	keys *crypto.Keyring
+}
+
+const (
+	tableName = "webhook_deliveries"
+)
+const (
+	initTableTemplate = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id bigint(20) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		url TEXT NOT NULL,
+		event VARCHAR(32) NOT NULL,
+		payload TEXT NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		key_id VARCHAR(64) NOT NULL DEFAULT '',
+		KEY next_attempt_at (next_attempt_at)
+	  ) ENGINE=MyISAM  DEFAULT CHARSET=utf8 ROW_FORMAT=DYNAMIC;
+`
+)
+
+// NewMySQLRepository returns a repository of the webhook deliveries table, payloads are encrypted
+// with keys when it is not nil
+func NewMySQLRepository(db *sql.DB, keys *crypto.Keyring) (*MySQLRepository, error) {
+	tableInitCmd := fmt.Sprintf(initTableTemplate, tableName)
+	_, err := db.Exec(tableInitCmd)
+
+	if err != nil {
+		return nil, fmt.Errorf("error init webhook deliveries repository: %v", err)
+	}
+
+	// deliveries stored before are in plain text
+	err = mysqlutil.EnsureColumn(db, tableName, "key_id", mysqlutil.KeyIDColumn)
+	if err != nil {
+		return nil, fmt.Errorf("error init webhook deliveries repository: %v", err)
+	}
+
+	return &MySQLRepository{
+		db:   db,
+		keys: keys,
+	}, nil
+}
+
+// GetDue returns the oldest deliveries whose next attempt is not after now
+func (r *MySQLRepository) GetDue(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error) {
+	q := "SELECT id, url, event, payload, attempts, next_attempt_at, created_at, key_id FROM " + tableName + " where next_attempt_at <= ? ORDER BY id LIMIT ?"
+
+	logrus.Debug("QUERY: ", q, now, limit)
+	rows, err := r.db.QueryContext(ctx, q, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error get due deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	var deliveries []model.Delivery
+	for rows.Next() {
+		var d model.Delivery
+		var keyID string
+		if err := rows.Scan(&d.ID, &d.URL, &d.Event, &d.Payload, &d.Attempts, &d.NextAttempt, &d.CreatedAt, &keyID); err != nil {
+			return nil, fmt.Errorf("error get due deliveries: %v", err)
+		}
+		payload, err := mysqlutil.Open(r.keys, keyID, d.Payload, mysqlutil.RowData(tableName, d.ID))
+		if err != nil {
+			return nil, fmt.Errorf("error get due deliveries: delivery %d: %v", d.ID, err)
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Store returns an id which is ID of row
+func (r *MySQLRepository) Store(ctx context.Context, d model.Delivery) (int64, error) {
+	// the payload is encrypted once the id of the row is known
+	q := "INSERT INTO " + tableName + " (url, event, payload, attempts, next_attempt_at, created_at, key_id) VALUES (?, ?, '', ?, ?, ?, '')"
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return -1, fmt.Errorf("error store delivery: %v", err)
+	}
+	defer tx.Rollback()
+	logrus.Debug("QUERY: ", q, d.URL, d.Event)
+	res, err := tx.ExecContext(ctx, q, d.URL, d.Event, d.Attempts, d.NextAttempt.UTC(), d.CreatedAt.UTC())
+	if err != nil {
+		return -1, fmt.Errorf("error store delivery: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err == nil {
+		err = mysqlutil.SealColumn(ctx, tx, r.keys, tableName, "payload", id, d.Payload)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return -1, fmt.Errorf("error store delivery: %v", err)
+	}
+	return id, nil
+}
+
+// Retry records a failed attempt and the time of the next one
+func (r *MySQLRepository) Retry(ctx context.Context, id int64, attempts int, next time.Time) error {
+	q := "UPDATE " + tableName + " SET attempts=?, next_attempt_at=? where id=?"
+
+	logrus.Debug("QUERY: ", q, attempts, next, id)
+	_, err := r.db.ExecContext(ctx, q, attempts, next.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("error retry delivery: %v", err)
+	}
+	return nil
+}
+
+// Delete removes a delivery which is sent or given up
+func (r *MySQLRepository) Delete(ctx context.Context, id int64) error {
+	q := "DELETE FROM " + tableName + " where id=?"
+
+	logrus.Debug("QUERY: ", q, id)
+	_, err := r.db.ExecContext(ctx, q, id)
+	if err != nil {
+		return fmt.Errorf("error delete delivery: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package webhook
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMySQLRepository_GetDue(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"id", "url", "event", "payload", "attempts", "next_attempt_at", "created_at", "key_id"}).
+		AddRow(1, "http://hooks.local/chat", "message", `{"event":"message"}`, 2, now, now.Add(-time.Minute), "")
+	mock.ExpectQuery("SELECT id, url, event, payload, attempts, next_attempt_at, created_at, key_id FROM webhook_deliveries where next_attempt_at <= ? ORDER BY id LIMIT ?").
+		WithArgs(now, 10).
+		WillReturnRows(rows)
+
+	deliveries, err := repo.GetDue(context.Background(), now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Delivery{{
+		ID:          1,
+		URL:         "http://hooks.local/chat",
+		Event:       "message",
+		Payload:     `{"event":"message"}`,
+		Attempts:    2,
+		NextAttempt: now,
+		CreatedAt:   now.Add(-time.Minute),
+	}}, deliveries)
+}
+
+func TestMySQLRepository_Store(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO webhook_deliveries (url, event, payload, attempts, next_attempt_at, created_at, key_id) VALUES (?, ?, '', ?, ?, ?, '')").
+		WithArgs("http://hooks.local/chat", "connect", 0, now, now).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectExec("UPDATE webhook_deliveries SET payload=?, key_id=? where id=?").
+		WithArgs(`{"event":"connect"}`, "", int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	id, err := repo.Store(context.Background(), model.Delivery{
+		URL:         "http://hooks.local/chat",
+		Event:       "connect",
+		Payload:     `{"event":"connect"}`,
+		NextAttempt: now,
+		CreatedAt:   now,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), id)
+}
+
+// payloadArg matches any payload and keeps it
+type payloadArg struct {
+	payload string
+}
+
+func (a *payloadArg) Match(v driver.Value) bool {
+	a.payload, _ = v.(string)
+	return true
+}
+
+func TestMySQLRepository_StoreEncrypted(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	keys, err := crypto.NewKeyring(crypto.AtRestConfig{
+		ActiveKey: "k1",
+		Keys:      []crypto.KeyConfig{{ID: "k1", Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", crypto.KeySize)))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &MySQLRepository{db: db, keys: keys}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	payload := &payloadArg{}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO webhook_deliveries (url, event, payload, attempts, next_attempt_at, created_at, key_id) VALUES (?, ?, '', ?, ?, ?, '')").
+		WithArgs("http://hooks.local/chat", "message", 0, now, now).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectExec("UPDATE webhook_deliveries SET payload=?, key_id=? where id=?").
+		WithArgs(payload, "k1", int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.Store(context.Background(), model.Delivery{
+		URL:         "http://hooks.local/chat",
+		Event:       "message",
+		Payload:     `{"event":"message","text":"Hello"}`,
+		NextAttempt: now,
+		CreatedAt:   now,
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, payload.payload, "Hello")
+
+	// payloads are decrypted with the key of their row, old ones are in plain text
+	mock.ExpectQuery("SELECT id, url, event, payload, attempts, next_attempt_at, created_at, key_id FROM webhook_deliveries where next_attempt_at <= ? ORDER BY id LIMIT ?").
+		WithArgs(now, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "event", "payload", "attempts", "next_attempt_at", "created_at", "key_id"}).
+			AddRow(1, "http://hooks.local/chat", "connect", `{"event":"connect"}`, 0, now, now, "").
+			AddRow(4, "http://hooks.local/chat", "message", payload.payload, 0, now, now, "k1"))
+
+	deliveries, err := repo.GetDue(context.Background(), now, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 2) {
+		assert.Equal(t, `{"event":"connect"}`, deliveries[0].Payload)
+		assert.Equal(t, `{"event":"message","text":"Hello"}`, deliveries[1].Payload)
+	}
+
+	// a payload moved to another row is an error
+	mock.ExpectQuery("SELECT id, url, event, payload, attempts, next_attempt_at, created_at, key_id FROM webhook_deliveries where next_attempt_at <= ? ORDER BY id LIMIT ?").
+		WithArgs(now, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "event", "payload", "attempts", "next_attempt_at", "created_at", "key_id"}).
+			AddRow(5, "http://hooks.local/chat", "message", payload.payload, 0, now, now, "k1"))
+
+	_, err = repo.GetDue(context.Background(), now, 10)
+	assert.EqualError(t, err, "error get due deliveries: delivery 5: unable to decrypt: cipher: message authentication failed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMySQLRepository_Retry(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+	next := time.Date(2021, 3, 1, 12, 0, 4, 0, time.UTC)
+
+	mock.ExpectExec("UPDATE webhook_deliveries SET attempts=?, next_attempt_at=? where id=?").
+		WithArgs(3, next, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, repo.Retry(context.Background(), 4, 3, next))
+}
+
+func TestMySQLRepository_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	repo := &MySQLRepository{db: db}
+
+	mock.ExpectExec("DELETE FROM webhook_deliveries where id=?").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, repo.Delete(context.Background(), 4))
+}
@@ -0,0 +1,24 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+type Reader interface {
+	GetDue(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error)
+}
+
+type Writer interface {
+	Store(ctx context.Context, d model.Delivery) (int64, error)
+	Retry(ctx context.Context, id int64, attempts int, next time.Time) error
+	Delete(ctx context.Context, id int64) error
+}
+
+// Repository repository interface
+type Repository interface {
+	Reader
+	Writer
+}
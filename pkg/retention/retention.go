@@ -0,0 +1,273 @@
+// Package retention purges old messages by configured retention policies.
+//
+// A policy limits messages by age, by count or both. The global policy applies to all
+// messages, a user policy to the messages a user sent and a room policy to the messages
+// between two users : the most specific policy of a message applies, so a user or a room
+// can keep messages longer than the global policy. Messages are deleted in small batches
+// with a pause in between, so the table is never locked for long. In dry run mode
+// nothing is deleted, the number of messages each policy would delete is reported.
+package retention
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/sirupsen/logrus"
+)
+
+// scopes of policies
+const (
+	ScopeGlobal = "global"
+	ScopeUser   = "user"
+	ScopeRoom   = "room"
+)
+
+// defaults of the purger
+const (
+	// interval between purges
+	DefaultInterval = time.Hour
+
+	// number of messages deleted at once
+	DefaultBatchSize = 1000
+
+	// pause between batches, so other queries are not blocked
+	DefaultBatchPause = 100 * time.Millisecond
+)
+
+// Policy limits the messages which are kept, a zero limit is not applied
+type Policy struct {
+	// messages older than this are purged
+	MaxAge time.Duration `yaml:"max_age"`
+
+	// only the newest messages are kept
+	MaxCount int `yaml:"max_count"`
+}
+
+// Empty reports whether the policy keeps all messages
+func (p Policy) Empty() bool {
+	return p.MaxAge <= 0 && p.MaxCount <= 0
+}
+
+// UserPolicy is the policy of the messages a user sent
+type UserPolicy struct {
+	User   string `yaml:"user"`
+	Policy `yaml:",inline"`
+}
+
+// RoomPolicy is the policy of the messages between two users
+type RoomPolicy struct {
+	Users  []string `yaml:"users"`
+	Policy `yaml:",inline"`
+}
+
+// Config defines the retention policies and how they are enforced
+type Config struct {
+	// time between purges, 1h when zero
+	Interval time.Duration `yaml:"interval"`
+
+	// number of messages deleted at once, 1000 when zero
+	BatchSize int `yaml:"batch_size"`
+
+	// pause between batches, 100ms when zero
+	BatchPause time.Duration `yaml:"batch_pause"`
+
+	// report what would be purged without deleting anything
+	DryRun bool `yaml:"dry_run"`
+
+	// global policy, applied to messages without a more specific policy
+	Policy `yaml:",inline"`
+
+	Users []UserPolicy `yaml:"users"`
+	Rooms []RoomPolicy `yaml:"rooms"`
+}
+
+// SetDefaults sets the settings which are not configured
+func (cfg *Config) SetDefaults() {
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.BatchPause == 0 {
+		cfg.BatchPause = DefaultBatchPause
+	}
+}
+
+// Room returns the users of a room in order, so both orders name the same room
+func Room(user string, other string) [2]string {
+	if other < user {
+		return [2]string{other, user}
+	}
+	return [2]string{user, other}
+}
+
+// Store finds and deletes the messages of retention filters
+type Store interface {
+	GetPurgeable(ctx context.Context, f model.RetentionFilter, limit int) ([]int64, error)
+	CountPurgeable(ctx context.Context, f model.RetentionFilter) (int64, error)
+	DeleteMessages(ctx context.Context, ids []int64) (int64, error)
+}
+
+// Result is the number of messages a policy purged, or would purge in a dry run
+type Result struct {
+	Scope string
+
+	// user or room of the policy, empty for the global policy
+	Target string
+
+	Count  int64
+	DryRun bool
+}
+
+// target is a policy with the messages it applies to
+type target struct {
+	scope  string
+	name   string
+	policy Policy
+	filter model.RetentionFilter
+}
+
+// targets returns the policies of a config with their filters at now :
+// users and rooms with their own policy are left out of less specific ones
+func targets(cfg Config, now time.Time) []target {
+	var rooms [][2]string
+	var roomPolicies []Policy
+	var users []string
+	for _, r := range cfg.Rooms {
+		if len(r.Users) != 2 {
+			continue
+		}
+		rooms = append(rooms, Room(r.Users[0], r.Users[1]))
+		roomPolicies = append(roomPolicies, r.Policy)
+	}
+	for _, u := range cfg.Users {
+		users = append(users, u.User)
+	}
+
+	var list []target
+	add := func(scope string, name string, p Policy, f model.RetentionFilter) {
+		if p.Empty() {
+			return
+		}
+		if p.MaxAge > 0 {
+			f.Before = now.Add(-p.MaxAge)
+		}
+		f.KeepLast = p.MaxCount
+		list = append(list, target{scope: scope, name: name, policy: p, filter: f})
+	}
+
+	for i, room := range rooms {
+		add(ScopeRoom, strings.Join(room[:], ","), roomPolicies[i], model.RetentionFilter{Rooms: [][2]string{room}})
+	}
+	for _, u := range cfg.Users {
+		var except [][2]string
+		for _, room := range rooms {
+			if room[0] == u.User || room[1] == u.User {
+				except = append(except, room)
+			}
+		}
+		add(ScopeUser, u.User, u.Policy, model.RetentionFilter{Users: []string{u.User}, ExceptRooms: except})
+	}
+	add(ScopeGlobal, "", cfg.Policy, model.RetentionFilter{ExceptUsers: users, ExceptRooms: rooms})
+	return list
+}
+
+// Purger enforces retention policies, create it with NewPurger and call Purge periodically
+type Purger struct {
+	store Store
+
+	// guards cfg
+	mu  sync.RWMutex
+	cfg Config
+
+	// replaced in tests
+	sleep func(time.Duration)
+}
+
+// NewPurger returns a purger of the policies of cfg, which deletes messages of store
+func NewPurger(store Store, cfg Config) *Purger {
+	cfg.SetDefaults()
+	return &Purger{
+		store: store,
+		cfg:   cfg,
+		sleep: time.Sleep,
+	}
+}
+
+// SetConfig replaces the policies, it is used by the next purge
+func (p *Purger) SetConfig(cfg Config) {
+	cfg.SetDefaults()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cfg = cfg
+}
+
+func (p *Purger) getConfig() Config {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cfg
+}
+
+// Purge deletes the messages which the policies do not keep at now,
+// or only counts them in dry run mode, and reports the result of each policy
+func (p *Purger) Purge(ctx context.Context, now time.Time) []Result {
+	cfg := p.getConfig()
+	var results []Result
+	purgeable := make(map[string]int64)
+	for _, t := range targets(cfg, now) {
+		log := logrus.WithFields(logrus.Fields{"scope": t.scope, "target": t.name, "max_age": t.policy.MaxAge, "max_count": t.policy.MaxCount})
+		var count int64
+		var err error
+		if cfg.DryRun {
+			count, err = p.store.CountPurgeable(ctx, t.filter)
+			purgeable[t.scope] += count
+		} else {
+			count, err = p.delete(ctx, cfg, t)
+		}
+		if err != nil {
+			log.WithError(err).Error("unable to purge messages")
+		}
+		if cfg.DryRun {
+			log.WithField("count", count).Info("retention dry run, messages would be purged")
+		} else if count > 0 {
+			log.WithField("count", count).Info("messages purged")
+		}
+		results = append(results, Result{Scope: t.scope, Target: t.name, Count: count, DryRun: cfg.DryRun})
+	}
+	if cfg.DryRun {
+		for _, scope := range []string{ScopeGlobal, ScopeUser, ScopeRoom} {
+			metrics.RetentionPurgeable.WithLabelValues(scope).Set(float64(purgeable[scope]))
+		}
+	}
+	return results
+}
+
+// delete removes the messages of a policy in batches, until none is left
+func (p *Purger) delete(ctx context.Context, cfg Config, t target) (int64, error) {
+	var total int64
+	for {
+		ids, err := p.store.GetPurgeable(ctx, t.filter, cfg.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		count, err := p.store.DeleteMessages(ctx, ids)
+		if err != nil {
+			return total, err
+		}
+		total += count
+		metrics.RetentionPurged.WithLabelValues(t.scope).Add(float64(count))
+		// stop when the last batch was not full or nothing could be deleted
+		if len(ids) < cfg.BatchSize || count == 0 {
+			return total, nil
+		}
+		p.sleep(cfg.BatchPause)
+	}
+}
@@ -0,0 +1,184 @@
+package retention
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore keeps messages in memory
+type memoryStore struct {
+	mu       sync.Mutex
+	nextID   int64
+	messages []model.Message
+	batches  int
+}
+
+func (s *memoryStore) add(from string, to string, createdAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.messages = append(s.messages, model.Message{ID: s.nextID, From: from, To: to, CreatedAt: createdAt})
+}
+
+// purgeable returns the ids of the messages a filter purges, oldest first
+func (s *memoryStore) purgeable(f model.RetentionFilter) []int64 {
+	var scope []model.Message
+	for _, m := range s.messages {
+		if f.InScope(m) {
+			scope = append(scope, m)
+		}
+	}
+	sort.Slice(scope, func(i, j int) bool { return scope[i].ID < scope[j].ID })
+	var ids []int64
+	for i, m := range scope {
+		tooOld := !f.Before.IsZero() && m.CreatedAt.Before(f.Before)
+		tooMany := f.KeepLast > 0 && i < len(scope)-f.KeepLast
+		if tooOld || tooMany {
+			ids = append(ids, m.ID)
+		}
+	}
+	return ids
+}
+
+func (s *memoryStore) GetPurgeable(ctx context.Context, f model.RetentionFilter, limit int) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := s.purgeable(f)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (s *memoryStore) CountPurgeable(ctx context.Context, f model.RetentionFilter) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.purgeable(f))), nil
+}
+
+func (s *memoryStore) DeleteMessages(ctx context.Context, ids []int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	deleted := make(map[int64]bool)
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	var kept []model.Message
+	for _, m := range s.messages {
+		if !deleted[m.ID] {
+			kept = append(kept, m)
+		}
+	}
+	count := int64(len(s.messages) - len(kept))
+	s.messages = kept
+	return count, nil
+}
+
+// kept returns the ids of the kept messages
+func (s *memoryStore) kept() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []int64
+	for _, m := range s.messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func newPurger(store Store, cfg Config) *Purger {
+	p := NewPurger(store, cfg)
+	p.sleep = func(time.Duration) {}
+	return p
+}
+
+func TestPurger_maxAge(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{}
+	store.add("alice", "bob", now.Add(-72*time.Hour))
+	store.add("bob", "alice", now.Add(-50*time.Hour))
+	store.add("alice", "bob", now.Add(-time.Hour))
+
+	results := newPurger(store, Config{Policy: Policy{MaxAge: 48 * time.Hour}}).Purge(context.Background(), now)
+	assert.Equal(t, []Result{{Scope: ScopeGlobal, Count: 2}}, results)
+	assert.Equal(t, []int64{3}, store.kept())
+}
+
+func TestPurger_maxCount(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{}
+	for i := 0; i < 7; i++ {
+		store.add("alice", "bob", now)
+	}
+
+	// messages are deleted in batches, with a pause in between
+	p := newPurger(store, Config{BatchSize: 2, Policy: Policy{MaxCount: 2}})
+	var pauses int
+	p.sleep = func(time.Duration) { pauses++ }
+	results := p.Purge(context.Background(), now)
+	assert.Equal(t, []Result{{Scope: ScopeGlobal, Count: 5}}, results)
+	assert.Equal(t, []int64{6, 7}, store.kept())
+	assert.Equal(t, 3, store.batches)
+	assert.Equal(t, 2, pauses)
+}
+
+func TestPurger_specificPolicies(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-30 * 24 * time.Hour)
+	store := &memoryStore{}
+	store.add("alice", "bob", old)   // 1 : room policy keeps it
+	store.add("bob", "alice", old)   // 2 : room policy keeps it
+	store.add("alice", "carol", old) // 3 : user policy keeps it
+	store.add("carol", "alice", old) // 4 : global policy purges it
+	store.add("dave", "carol", old)  // 5 : global policy purges it
+	store.add("alice", "carol", now) // 6 : user policy keeps only this one
+	store.add("alice", "bob", now)   // 7
+
+	cfg := Config{
+		Policy: Policy{MaxAge: 7 * 24 * time.Hour},
+		Users:  []UserPolicy{{User: "alice", Policy: Policy{MaxCount: 2}}},
+		Rooms:  []RoomPolicy{{Users: []string{"bob", "alice"}, Policy: Policy{MaxAge: 90 * 24 * time.Hour}}},
+	}
+	results := newPurger(store, cfg).Purge(context.Background(), now)
+	assert.Equal(t, []Result{
+		{Scope: ScopeRoom, Target: "alice,bob", Count: 0},
+		{Scope: ScopeUser, Target: "alice", Count: 0},
+		{Scope: ScopeGlobal, Count: 2},
+	}, results)
+	assert.Equal(t, []int64{1, 2, 3, 6, 7}, store.kept())
+
+	// the user policy keeps the last 2 messages of alice out of rooms
+	store.add("alice", "dave", now)
+	newPurger(store, cfg).Purge(context.Background(), now)
+	assert.Equal(t, []int64{1, 2, 6, 7, 8}, store.kept())
+}
+
+func TestPurger_dryRun(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{}
+	store.add("alice", "bob", now.Add(-72*time.Hour))
+	store.add("alice", "bob", now)
+
+	p := newPurger(store, Config{DryRun: true, Policy: Policy{MaxAge: time.Hour}})
+	assert.Equal(t, []Result{{Scope: ScopeGlobal, Count: 1, DryRun: true}}, p.Purge(context.Background(), now))
+	assert.Equal(t, []int64{1, 2}, store.kept())
+
+	// the policies are replaced live
+	p.SetConfig(Config{Policy: Policy{MaxAge: time.Hour}})
+	assert.Equal(t, []Result{{Scope: ScopeGlobal, Count: 1}}, p.Purge(context.Background(), now))
+	assert.Equal(t, []int64{2}, store.kept())
+}
+
+func TestPurger_noPolicy(t *testing.T) {
+	store := &memoryStore{}
+	store.add("alice", "bob", time.Time{})
+
+	assert.Empty(t, newPurger(store, Config{}).Purge(context.Background(), time.Now()))
+	assert.Equal(t, []int64{1}, store.kept())
+}
@@ -0,0 +1,649 @@
+// Package sdk is a client library for the chat server.
+//
+// It speaks the JSON protocol of the server: every command is sent as a request
+// with an id, and the answers of the server carry this id, so they are returned
+// by the method which sent the command. Messages of other users and notices of the
+// server are delivered on the channel returned by Events.
+package sdk
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+)
+
+// Event is a line sent by the server, see the Event* types of pkg/client
+type Event = client.Event
+
+var (
+	// ErrClosed is returned when the connection is closed
+	ErrClosed = errors.New("sdk: connection is closed")
+
+	// ErrNameTaken is returned by SetName when another user has the name
+	ErrNameTaken = errors.New("sdk: name is used by another user")
+)
+
+// Error is a command refused by the server, like a join to an unknown user
+type Error struct {
+	Text string
+}
+
+func (e *Error) Error() string {
+	return "sdk: " + e.Text
+}
+
+// Config of a client, the zero value is usable
+type Config struct {
+	// connects over TLS when set
+	TLS *tls.Config
+
+	// interval of pings sent to the server, disabled when zero
+	Heartbeat time.Duration
+
+	// time without any line from the server before the connection is considered dead,
+	// three heartbeats when zero
+	HeartbeatTimeout time.Duration
+}
+
+// Client is a connection to the server, its methods can be called from multiple go routines
+type Client struct {
+	// id of the last request, accessed atomically :
+	// kept first so it is 64-bit aligned on 32-bit platforms
+	lastID uint64
+
+	conn net.Conn
+	cfg  Config
+
+	// guards writes to conn
+	writeMu sync.Mutex
+
+	// guards the fields below
+	mu       sync.Mutex
+	requests map[uint64]*request
+	name     string
+	token    string
+	err      error
+
+	// events which are not answers of requests
+	queue  chan Event
+	events chan Event
+
+	// closed by Close
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// closed when the connection is lost
+	done chan struct{}
+}
+
+// request collects the answers of a command until the done event
+type request struct {
+	events []Event
+	done   chan struct{}
+}
+
+// Dial connects to the server at addr and switches to the JSON protocol
+func Dial(ctx context.Context, addr string, cfg *Config) (*Client, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLS != nil {
+		tlsCfg := cfg.TLS
+		if tlsCfg.ServerName == "" {
+			// verified against the host of addr, like tls.Dial does
+			tlsCfg = tlsCfg.Clone()
+			tlsCfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, tlsCfg)
+		if deadline, ok := ctx.Deadline(); ok {
+			tlsConn.SetDeadline(deadline)
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	c, err := NewClient(ctx, conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient starts a client on an open connection, like one side of a net.Pipe
+func NewClient(ctx context.Context, conn net.Conn, cfg *Config) (*Client, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	c := &Client{
+		conn:     conn,
+		cfg:      *cfg,
+		requests: make(map[uint64]*request),
+		queue:    make(chan Event),
+		events:   make(chan Event),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	reader := bufio.NewReader(conn)
+	greeting, err := c.negotiate(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	go c.pump()
+	go c.read(reader, greeting)
+	if c.cfg.Heartbeat > 0 {
+		go c.heartbeat()
+	}
+	return c, nil
+}
+
+// negotiate switches the connection to the JSON protocol,
+// lines sent before, like the message of the day, are returned as events
+func (c *Client) negotiate(ctx context.Context, reader *bufio.Reader) ([]Event, error) {
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+	defer c.conn.SetDeadline(time.Time{})
+
+	stop := c.watch(ctx)
+	defer stop()
+
+	// the greeting is read while the request is written,
+	// unbuffered connections would block otherwise
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.conn.Write([]byte("/proto " + client.ProtoJSON + "\n"))
+		written <- err
+	}()
+	var greeting []Event
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if werr := <-written; werr != nil {
+				err = werr
+			}
+			return nil, contextError(ctx, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "{"):
+			e, err := client.ParseEvent([]byte(line))
+			if err != nil {
+				return nil, err
+			}
+			if e.Type == client.EventProto {
+				return greeting, nil
+			}
+			greeting = append(greeting, e)
+		case strings.HasPrefix(line, "err: "):
+			// refused connections, like banned addresses
+			return nil, &Error{Text: strings.TrimPrefix(line, "err: ")}
+		default:
+			greeting = append(greeting, Event{Type: client.EventText, Text: strings.TrimPrefix(line, "> ")})
+		}
+	}
+}
+
+// read dispatches the lines of the server until the connection is lost
+func (c *Client) read(reader *bufio.Reader, greeting []Event) {
+	defer close(c.queue)
+	for _, e := range greeting {
+		c.emit(e)
+	}
+	for {
+		if c.cfg.Heartbeat > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.heartbeatTimeout()))
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		e, err := client.ParseEvent(line)
+		if err != nil {
+			e = Event{Type: client.EventText, Text: strings.TrimRight(string(line), "\r\n")}
+		}
+
+		switch e.Type {
+		case client.EventPing:
+			c.writeLine("/pong " + e.Text)
+			continue
+		case client.EventPong:
+			continue
+		case client.EventSession:
+			c.mu.Lock()
+			c.name, c.token = e.Name, e.Token
+			c.mu.Unlock()
+		}
+
+		if e.ID != 0 {
+			c.mu.Lock()
+			req, ok := c.requests[e.ID]
+			if ok && e.Type == client.EventDone {
+				delete(c.requests, e.ID)
+				close(req.done)
+			} else if ok {
+				req.events = append(req.events, e)
+			}
+			c.mu.Unlock()
+			continue
+		}
+		c.emit(e)
+	}
+}
+
+// emit passes an event to the pump, unless the client is closed
+func (c *Client) emit(e Event) {
+	select {
+	case c.queue <- e:
+	case <-c.closing:
+	}
+}
+
+// pump passes events to Events without blocking the reader :
+// events wait in memory until they are read
+func (c *Client) pump() {
+	defer close(c.events)
+	var pending []Event
+	queue := c.queue
+	for queue != nil || len(pending) > 0 {
+		var out chan Event
+		var next Event
+		if len(pending) > 0 {
+			out = c.events
+			next = pending[0]
+		}
+		select {
+		case e, ok := <-queue:
+			if !ok {
+				queue = nil
+				continue
+			}
+			pending = append(pending, e)
+		case out <- next:
+			pending = pending[1:]
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// heartbeat pings the server, so a dead connection is noticed by the read deadline
+func (c *Client) heartbeat() {
+	ticker := time.NewTicker(c.cfg.Heartbeat)
+	defer ticker.Stop()
+	var seq uint64
+	for {
+		select {
+		case <-ticker.C:
+			seq++
+			c.writeLine("/ping " + strconv.FormatUint(seq, 10))
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) heartbeatTimeout() time.Duration {
+	if c.cfg.HeartbeatTimeout > 0 {
+		return c.cfg.HeartbeatTimeout
+	}
+	return 3 * c.cfg.Heartbeat
+}
+
+// fail closes the connection after an error, waiting requests return it
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		select {
+		case <-c.closing:
+			c.err = ErrClosed
+		default:
+			c.err = err
+		}
+	}
+	c.mu.Unlock()
+	c.conn.Close()
+	close(c.done)
+}
+
+// Err returns the error which closed the connection, nil while it is open
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Events returns the events which are not answers of requests : messages of other users,
+// broadcasts, file transfer lines and notices. it is closed when the connection is lost
+// and must be read, events wait in memory until then.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Close closes the connection without leaving, use Quit to leave
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closing) })
+	return c.conn.Close()
+}
+
+// Done is closed when the connection is lost
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// writeLine writes a line, used for lines which are not requests
+func (c *Client) writeLine(line string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+// watch expires the deadline of the connection when ctx is canceled, so a blocked read or write returns.
+// the returned function stops watching, the deadline is not touched once it returns.
+func (c *Client) watch(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// contextError prefers the error of ctx, which explains an expired deadline better
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Exec sends a command and returns the events which answer it.
+// it returns when the server is done with the command or ctx is done.
+func (c *Client) Exec(ctx context.Context, command string) ([]Event, error) {
+	id := atomic.AddUint64(&c.lastID, 1)
+	req := &request{done: make(chan struct{})}
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.requests[id] = req
+	c.mu.Unlock()
+	forget := func() {
+		c.mu.Lock()
+		delete(c.requests, id)
+		c.mu.Unlock()
+	}
+
+	line, err := json.Marshal(client.Request{ID: id, Command: command})
+	if err != nil {
+		forget()
+		return nil, err
+	}
+	c.writeMu.Lock()
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	_, err = c.conn.Write(append(line, '\n'))
+	c.conn.SetWriteDeadline(time.Time{})
+	c.writeMu.Unlock()
+	if err != nil {
+		forget()
+		return nil, contextError(ctx, err)
+	}
+
+	select {
+	case <-req.done:
+		return req.events, nil
+	case <-ctx.Done():
+		forget()
+		return nil, ctx.Err()
+	case <-c.done:
+		forget()
+		return nil, c.Err()
+	}
+}
+
+// Post sends a command without waiting for its answer, which is delivered on Events.
+// it suits streams of commands, like the chunks of a file.
+func (c *Client) Post(ctx context.Context, command string) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	defer c.conn.SetWriteDeadline(time.Time{})
+	if _, err := c.conn.Write([]byte(command + "\n")); err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// refusal returns the first text or error event of an answer as an error
+func refusal(events []Event) error {
+	for _, e := range events {
+		if e.Type == client.EventText || e.Type == client.EventError {
+			return &Error{Text: e.Text}
+		}
+	}
+	return nil
+}
+
+// SetName chooses the name of the user, ErrNameTaken is returned when it is used
+func (c *Client) SetName(ctx context.Context, name string) error {
+	events, err := c.Exec(ctx, "/name "+name)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if e.Type == client.EventSession {
+			return nil
+		}
+		if e.Type == client.EventText && strings.HasPrefix(e.Text, "There is a user which is used for this name") {
+			return ErrNameTaken
+		}
+	}
+	if err := refusal(events); err != nil {
+		return err
+	}
+	return &Error{Text: "name is not accepted"}
+}
+
+// SetBot marks the user as a bot, which other users see in /list
+func (c *Client) SetBot(ctx context.Context) error {
+	events, err := c.Exec(ctx, "/bot")
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if e.Type == client.EventText && e.Text == "You are marked as a bot" {
+			return nil
+		}
+	}
+	if err := refusal(events); err != nil {
+		return err
+	}
+	return &Error{Text: "bot is not accepted"}
+}
+
+// Session returns the name and the token of the session, empty before SetName
+func (c *Client) Session() (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name, c.token
+}
+
+// Resume resumes a session of a lost connection with the token of Session,
+// messages which arrived meanwhile are delivered on Events
+func (c *Client) Resume(ctx context.Context, name string, token string) error {
+	events, err := c.Exec(ctx, "/resume "+name+" "+token)
+	if err != nil {
+		return err
+	}
+	resumed := false
+	for _, e := range events {
+		switch {
+		case e.Type == client.EventSession:
+			resumed = true
+		case e.Type == client.EventText && strings.HasPrefix(e.Text, "session resumed"):
+		case e.Type == client.EventText || e.Type == client.EventError:
+			return &Error{Text: e.Text}
+		}
+	}
+	if !resumed {
+		return &Error{Text: "session is not resumed"}
+	}
+	return nil
+}
+
+// Join chooses the user which messages are sent to
+func (c *Client) Join(ctx context.Context, name string) error {
+	events, err := c.Exec(ctx, "/join "+name)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if e.Type == client.EventText && strings.HasPrefix(e.Text, "You are now talking to") {
+			return nil
+		}
+	}
+	if err := refusal(events); err != nil {
+		return err
+	}
+	return &Error{Text: "join is not accepted"}
+}
+
+// Send sends a message to the joined user
+func (c *Client) Send(ctx context.Context, text string) error {
+	events, err := c.Exec(ctx, "/msg "+text)
+	if err != nil {
+		return err
+	}
+	return refusal(events)
+}
+
+// Reply replies to a message with its id and returns the id of the reply
+func (c *Client) Reply(ctx context.Context, id int64, text string) (int64, error) {
+	events, err := c.Exec(ctx, fmt.Sprintf("/reply %d %s", id, text))
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range events {
+		// the reply is confirmed with its id
+		if e.Type == client.EventText && e.MessageID != 0 {
+			return e.MessageID, nil
+		}
+	}
+	return 0, refusal(events)
+}
+
+// List returns the online users, users which are reconnecting are marked with " (reconnecting)"
+// and bots with " (bot)"
+func (c *Client) List(ctx context.Context) ([]string, error) {
+	events, err := c.Exec(ctx, "/list")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		if e.Type == client.EventUsers {
+			return e.Users, nil
+		}
+	}
+	return nil, refusal(events)
+}
+
+// messages runs a history command
+func (c *Client) messages(ctx context.Context, command string) ([]model.Message, error) {
+	events, err := c.Exec(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		if e.Type == client.EventMessages {
+			return e.Messages, nil
+		}
+	}
+	if err := refusal(events); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// FromMe returns the messages sent by the user
+func (c *Client) FromMe(ctx context.Context) ([]model.Message, error) {
+	return c.messages(ctx, "/get-m-from-me")
+}
+
+// ToMe returns the messages sent to the user
+func (c *Client) ToMe(ctx context.Context) ([]model.Message, error) {
+	return c.messages(ctx, "/get-m-to-me")
+}
+
+// Last returns the last n messages sent by the user
+func (c *Client) Last(ctx context.Context, n int) ([]model.Message, error) {
+	if n <= 0 {
+		return nil, &Error{Text: "the number of messages must be positive"}
+	}
+	return c.messages(ctx, "/get-last "+strconv.Itoa(n))
+}
+
+// Contains returns the messages of the user which contain a word
+func (c *Client) Contains(ctx context.Context, word string) ([]model.Message, error) {
+	if word == "" || strings.Contains(word, " ") {
+		return nil, &Error{Text: "the word must not be empty or contain spaces"}
+	}
+	return c.messages(ctx, "/get-contains "+word)
+}
+
+// Thread returns the reply chain of a message
+func (c *Client) Thread(ctx context.Context, id int64) ([]model.Message, error) {
+	return c.messages(ctx, "/thread "+strconv.FormatInt(id, 10))
+}
+
+// Quit leaves the server and closes the connection
+func (c *Client) Quit(ctx context.Context) error {
+	_, err := c.Exec(ctx, "/quit")
+
+	// the server closes the connection, maybe before its answer is complete
+	select {
+	case <-c.done:
+		err = nil
+	default:
+	}
+	c.Close()
+	return err
+}
@@ -0,0 +1,267 @@
+package sdk
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/sdk/sdktest"
+	"github.com/Selahattinn/picus-tcp-message/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) *sdktest.Server {
+	s, err := sdktest.NewServer(&server.Config{
+		SessionGracePeriod: time.Minute,
+		MOTD:               "Welcome",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// connect connects a client to the server over a pipe
+func connect(t *testing.T, s *sdktest.Server) *Client {
+	conn := s.Pipe()
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := NewClient(ctx, conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// connectAs connects a client and chooses its name
+func connectAs(t *testing.T, s *sdktest.Server, name string) *Client {
+	c := connect(t, s)
+	if err := c.SetName(testContext(t), name); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// expectEvent waits for an event of a type
+func expectEvent(t *testing.T, c *Client, typ string) Event {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e, ok := <-c.Events():
+			if !ok {
+				t.Fatalf("connection closed while waiting for %s event: %v", typ, c.Err())
+			}
+			if e.Type == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", typ)
+		}
+	}
+}
+
+func TestClient_chat(t *testing.T) {
+	s := newTestServer(t)
+	alice := connectAs(t, s, "alice")
+	bob := connectAs(t, s, "bob")
+	ctx := testContext(t)
+
+	// the message of the day comes before the protocol is switched
+	motd := expectEvent(t, alice, client.EventText)
+	assert.Equal(t, "Welcome", motd.Text)
+
+	users, err := alice.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, users)
+
+	assert.NoError(t, alice.Join(ctx, "bob"))
+	assert.NoError(t, alice.Send(ctx, "hello bob"))
+
+	e := expectEvent(t, bob, client.EventMessage)
+	assert.Equal(t, "alice", e.From)
+	assert.Equal(t, "hello bob", e.Text)
+	assert.Equal(t, "alice : [#1] hello bob", e.String())
+}
+
+func TestClient_history(t *testing.T) {
+	s := newTestServer(t)
+	alice := connectAs(t, s, "alice")
+	bob := connectAs(t, s, "bob")
+	ctx := testContext(t)
+
+	messages, err := alice.FromMe(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+
+	assert.NoError(t, alice.Join(ctx, "bob"))
+	assert.NoError(t, alice.Send(ctx, "first message"))
+	assert.NoError(t, alice.Send(ctx, "second message"))
+
+	messages, err = alice.FromMe(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Message{
+		{ID: 1, From: "alice", To: "bob", Text: "first message"},
+		{ID: 2, From: "alice", To: "bob", Text: "second message"},
+	}, messages)
+
+	messages, err = alice.Contains(ctx, "second")
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+
+	messages, err = bob.ToMe(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+
+	// a reply carries the ids of both messages
+	id, err := bob.Reply(ctx, 1, "got it")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), id)
+	e := expectEvent(t, alice, client.EventMessage)
+	assert.Equal(t, int64(3), e.MessageID)
+	assert.Equal(t, int64(1), e.ParentID)
+	assert.Equal(t, "got it", e.Text)
+
+	messages, err = alice.Thread(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, int64(1), messages[0].ID)
+		assert.Equal(t, int64(3), messages[1].ID)
+	}
+
+	_, err = alice.Last(ctx, 0)
+	assert.Error(t, err)
+}
+
+func TestClient_errors(t *testing.T) {
+	s := newTestServer(t)
+	connectAs(t, s, "alice")
+	bob := connect(t, s)
+	ctx := testContext(t)
+
+	assert.Equal(t, ErrNameTaken, bob.SetName(ctx, "alice"))
+	assert.NoError(t, bob.SetName(ctx, "bob"))
+
+	err := bob.Send(ctx, "nobody hears this")
+	if assert.IsType(t, &Error{}, err) {
+		assert.True(t, strings.HasPrefix(err.(*Error).Text, "no one hears you"))
+	}
+	assert.IsType(t, &Error{}, bob.Join(ctx, "carol"))
+	_, err = bob.Reply(ctx, 42, "hello")
+	assert.IsType(t, &Error{}, err)
+
+	events, err := bob.Exec(ctx, "/unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{{Type: client.EventError, ID: events[0].ID, Text: "unknown command: /unknown"}}, events)
+}
+
+func TestClient_resume(t *testing.T) {
+	s := newTestServer(t)
+	alice := connectAs(t, s, "alice")
+	bob := connectAs(t, s, "bob")
+	ctx := testContext(t)
+
+	name, token := alice.Session()
+	assert.Equal(t, "alice", name)
+	assert.NotEmpty(t, token)
+
+	// the connection of alice drops, bob's message waits for her
+	alice.Close()
+	assert.NoError(t, bob.Join(ctx, "alice"))
+	assert.NoError(t, bob.Send(ctx, "are you there?"))
+
+	again := connect(t, s)
+	assert.IsType(t, &Error{}, again.Resume(ctx, "alice", "wrong"))
+	assert.NoError(t, again.Resume(ctx, "alice", token))
+	e := expectEvent(t, again, client.EventMessage)
+	assert.Equal(t, "are you there?", e.Text)
+
+	_, newToken := again.Session()
+	assert.NotEqual(t, token, newToken)
+}
+
+func TestClient_quit(t *testing.T) {
+	s := newTestServer(t)
+	alice := connectAs(t, s, "alice")
+
+	assert.NoError(t, alice.Quit(testContext(t)))
+	select {
+	case <-alice.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection is not closed")
+	}
+	_, err := alice.List(testContext(t))
+	assert.Error(t, err)
+}
+
+func TestClient_deadline(t *testing.T) {
+	// a server which switches the protocol and answers nothing else
+	serverConn, conn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		reader := bufio.NewReader(serverConn)
+		reader.ReadString('\n')
+		serverConn.Write([]byte(`{"type":"proto","text":"json"}` + "\n"))
+		for {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+
+	c, err := NewClient(testContext(t), conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.List(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// a canceled request does not break the client
+	c.mu.Lock()
+	assert.Empty(t, c.requests)
+	c.mu.Unlock()
+}
+
+func TestClient_heartbeat(t *testing.T) {
+	// a server which switches the protocol and then stops answering
+	serverConn, conn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		reader := bufio.NewReader(serverConn)
+		reader.ReadString('\n')
+		serverConn.Write([]byte(`{"type":"proto","text":"json"}` + "\n"))
+		for {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+
+	c, err := NewClient(testContext(t), conn, &Config{Heartbeat: 20 * time.Millisecond, HeartbeatTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+		assert.Error(t, c.Err())
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead connection is not noticed")
+	}
+}
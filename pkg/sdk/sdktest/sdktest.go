@@ -0,0 +1,102 @@
+// Package sdktest runs a chat server in process, for tests of clients built on pkg/sdk.
+// Its data is kept in memory, see pkg/repository/memory.
+package sdktest
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/memory"
+	"github.com/Selahattinn/picus-tcp-message/pkg/server"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service"
+)
+
+// Server is a chat server listening on a local address
+type Server struct {
+	// address clients dial, like 127.0.0.1:41234
+	Addr string
+
+	srv interface {
+		NewClient(conn net.Conn) error
+	}
+	listener net.Listener
+
+	// guards conns
+	mu sync.Mutex
+	// server side of the connections of clients
+	conns map[net.Conn]bool
+}
+
+// NewServer starts a server, cfg may be nil. it runs until the test binary exits,
+// Close stops accepting clients.
+func NewServer(cfg *server.Config) (*Server, error) {
+	if cfg == nil {
+		cfg = &server.Config{}
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = time.Second
+	}
+	if cfg.Service == nil {
+		cfg.Service = &service.Config{}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := server.NewServer(cfg)
+	go srv.RunWith(memory.NewRepository())
+
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		srv:      srv,
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve runs a client until its connection is closed
+func (s *Server) serve(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+	s.srv.NewClient(conn)
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// Pipe returns a connection to the server which does not use the network
+func (s *Server) Pipe() net.Conn {
+	serverConn, conn := net.Pipe()
+	go s.serve(serverConn)
+	return conn
+}
+
+// Drop closes the connections of all clients, like a network failure :
+// their sessions are kept, so they can be resumed
+func (s *Server) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Close stops accepting clients and closes their connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.Drop()
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/sirupsen/logrus"
+)
+
+// activeBan returns the ban of a user name or an ip address if there is one :
+// empty values are not checked, and failures of the repository do not lock users out
+func (s *server) activeBan(ctx context.Context, name string, ip string) (model.Ban, bool) {
+	checks := [][2]string{{model.BanUser, name}, {model.BanIP, ip}}
+	for _, check := range checks {
+		if check[1] == "" {
+			continue
+		}
+		ban, ok, err := s.Service.GetBanService().GetActiveBan(ctx, check[0], check[1])
+		if err != nil {
+			logrus.WithError(err).Info("unable to check ban of ", check[1])
+			continue
+		}
+		if ok {
+			return ban, true
+		}
+	}
+	return model.Ban{}, false
+}
+
+// requireAdmin tells the client when it is not an admin, the denied command is audited
+func (s *server) requireAdmin(c *client.Client, command string) bool {
+	if !c.Admin {
+		s.record(c, audit.EventDenied, map[string]string{"command": command})
+		c.Msg(c, "Permission denied: admin role required. use '/admin <password>' to authenticate.")
+		return false
+	}
+	return true
+}
+
+// function to authenticate a named client as an admin
+func (s *server) admin(c *client.Client, args []string) {
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/admin <password>")
+		return
+	}
+	for _, admin := range s.Config.Admins {
+		if admin.Name == c.Name && admin.Password != "" &&
+			subtle.ConstantTimeCompare([]byte(admin.Password), []byte(args[1])) == 1 {
+			c.Admin = true
+			c.Log().Info("admin authenticated")
+			s.record(c, audit.EventAdminAuth, nil)
+			c.Msg(c, "you are authenticated as admin")
+			return
+		}
+	}
+	c.Log().Info("failed admin authentication")
+	s.record(c, audit.EventAdminAuthFailed, nil)
+	c.Msg(c, "Permission denied: wrong admin name or password")
+}
+
+// function to disconnect a user
+func (s *server) kick(c *client.Client, args []string) {
+	if !s.requireAdmin(c, "kick") {
+		return
+	}
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/kick Selahattin")
+		return
+	}
+	target, ok := s.contacts[args[1]]
+	if !ok {
+		c.Msg(c, "No such user exists. check available users again.")
+		return
+	}
+	c.Log().WithField("target", target.Name).Info("admin kicked a user")
+	s.record(c, audit.EventKick, map[string]string{"target": target.Name})
+	target.Msg(target, fmt.Sprintf("You are kicked from the server by %s", c.Name))
+	s.disconnect(target)
+	c.Msg(c, fmt.Sprintf("%s is kicked", args[1]))
+}
+
+// banTarget returns the kind of a ban target, addresses are banned as ip
+func banTarget(target string) string {
+	if net.ParseIP(target) != nil {
+		return model.BanIP
+	}
+	return model.BanUser
+}
+
+// function to ban a user or an ip address :
+// /ban <user|ip> [duration]
+// connected clients matching the ban are disconnected
+func (s *server) ban(ctx context.Context, c *client.Client, args []string) {
+	if !s.requireAdmin(c, "ban") {
+		return
+	}
+	if len(args) < 2 || len(args) > 3 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/ban Selahattin 1h")
+		return
+	}
+	var duration time.Duration
+	if len(args) == 3 {
+		var err error
+		duration, err = time.ParseDuration(args[2])
+		if err != nil || duration <= 0 {
+			c.Msg(c, "Comand Error: duration must be positive like 30m or 24h")
+			return
+		}
+	}
+
+	kind := banTarget(args[1])
+	ban, err := s.Service.GetBanService().Ban(ctx, kind, args[1], duration, c.Name)
+	if err != nil {
+		c.Log().WithError(err).Info("Ban not saved to db")
+		c.Msg(c, "Ban could not be saved. Please try again.")
+		return
+	}
+	c.Log().WithFields(logrus.Fields{"kind": kind, "target": args[1]}).Info("admin banned a target")
+
+	disconnected := 0
+	for _, target := range s.contacts {
+		if (kind == model.BanUser && target.Name == args[1]) || (kind == model.BanIP && host(target.Conn) == args[1]) {
+			target.Msg(target, "You are banned from this server: "+ban.ToString())
+			s.disconnect(target)
+			disconnected++
+		}
+	}
+	until := "forever"
+	if !ban.Permanent() {
+		until = ban.Until.UTC().Format(time.RFC3339)
+	}
+	s.record(c, audit.EventBan, map[string]string{"kind": kind, "target": args[1], "until": until, "disconnected": strconv.Itoa(disconnected)})
+	c.Msg(c, ban.ToString())
+}
+
+// function to lift bans of a user or an ip address
+func (s *server) unban(ctx context.Context, c *client.Client, args []string) {
+	if !s.requireAdmin(c, "unban") {
+		return
+	}
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/unban Selahattin")
+		return
+	}
+	kind := banTarget(args[1])
+	ok, err := s.Service.GetBanService().Unban(ctx, kind, args[1])
+	if err != nil {
+		c.Log().WithError(err).Info("Ban not removed from db")
+		c.Msg(c, "Ban could not be removed. Please try again.")
+		return
+	}
+	if !ok {
+		c.Msg(c, fmt.Sprintf("%s %s is not banned", kind, args[1]))
+		return
+	}
+	c.Log().WithFields(logrus.Fields{"kind": kind, "target": args[1]}).Info("admin unbanned a target")
+	s.record(c, audit.EventUnban, map[string]string{"kind": kind, "target": args[1]})
+	c.Msg(c, fmt.Sprintf("%s %s is not banned anymore", kind, args[1]))
+}
+
+// function to send a message to all connected users
+func (s *server) broadcast(c *client.Client, args []string) {
+	if !s.requireAdmin(c, "broadcast") {
+		return
+	}
+	if len(args) < 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/broadcast Server restarts in 5 minutes")
+		return
+	}
+	msg := client.Event{Type: client.EventBroadcast, From: c.Name, Text: strings.Join(args[1:], " ")}
+	sent := 0
+	for _, recipient := range s.contacts {
+		if recipient == c {
+			continue
+		}
+		if s.deliver(c, recipient, msg) == nil {
+			sent++
+		}
+	}
+	c.Log().Info("admin broadcasted a message")
+	s.record(c, audit.EventBroadcast, map[string]string{"recipients": strconv.Itoa(sent)})
+	c.Msg(c, fmt.Sprintf("broadcast sent to %d users", sent))
+}
+
+// function to list connection details of connected users
+func (s *server) who(c *client.Client) {
+	if !s.requireAdmin(c, "who") {
+		return
+	}
+	s.record(c, audit.EventWho, nil)
+	names := make([]string, 0, len(s.contacts))
+	for name := range s.contacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d users connected\n", len(names))
+	for _, name := range names {
+		user := s.contacts[name]
+		role := ""
+		if user.Admin {
+			role = " (admin)"
+		}
+		if user.Bot {
+			role += " (bot)"
+		}
+		fmt.Fprintf(&b, "%s%s\n\taddress: %s\n\tconnected: %s\n\tidle: %s\n", name, role, user.Conn.RemoteAddr().String(),
+			user.ConnectedAt.Format(time.RFC3339), now.Sub(user.LastActive).Truncate(time.Second))
+	}
+	c.Msg(c, b.String())
+}
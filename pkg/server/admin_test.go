@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_adminPermissionDenied(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	newTestClient(t, s, "Test2")
+
+	s.kick(c, []string{"/kick", "Test2"})
+
+	expectLine(t, lines, "> Permission denied: admin role required. use '/admin <password>' to authenticate.\n")
+	_, ok := s.contacts["Test2"]
+	assert.True(t, ok)
+}
+
+func TestServer_admin(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Config.Admins = []AdminConfig{{Name: "Test", Password: "secret"}}
+	c, _, lines := newTestClient(t, s, "Test")
+	other, _, otherLines := newTestClient(t, s, "Test2")
+
+	s.admin(c, []string{"/admin", "wrong"})
+	expectLine(t, lines, "> Permission denied: wrong admin name or password\n")
+	assert.False(t, c.Admin)
+
+	// the password only belongs to the configured name
+	s.admin(other, []string{"/admin", "secret"})
+	expectLine(t, otherLines, "> Permission denied: wrong admin name or password\n")
+	assert.False(t, other.Admin)
+
+	s.admin(c, []string{"/admin", "secret"})
+	expectLine(t, lines, "> you are authenticated as admin\n")
+	assert.True(t, c.Admin)
+}
+
+func TestServer_kick(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	c.Admin = true
+	_, _, targetLines := newTestClient(t, s, "Test2")
+
+	s.kick(c, []string{"/kick", "Test2"})
+
+	expectLine(t, targetLines, "> You are kicked from the server by Test\n")
+	expectLine(t, lines, "> Test2 is kicked\n")
+	_, ok := s.contacts["Test2"]
+	assert.False(t, ok)
+}
+
+func TestServer_ban(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	c.Admin = true
+	_, _, targetLines := newTestClient(t, s, "Test2")
+
+	s.ban(context.Background(), c, []string{"/ban", "Test2", "1h"})
+
+	line := <-targetLines
+	assert.True(t, strings.HasPrefix(line, "> You are banned from this server: user Test2 is banned until "), line)
+	line = <-lines
+	assert.True(t, strings.HasPrefix(line, "> user Test2 is banned until "), line)
+	_, ok := s.contacts["Test2"]
+	assert.False(t, ok)
+	assert.Len(t, repo.Bans.All(), 1)
+	assert.Equal(t, model.BanUser, repo.Bans.All()[0].Kind)
+
+	// the banned name can not be taken again
+	other, _, otherLines := newTestClient(t, s, "Guest")
+	s.name(context.Background(), other, []string{"/name", "Test2"})
+	line = <-otherLines
+	assert.True(t, strings.HasPrefix(line, "> You are banned from this server: user Test2"), line)
+	_, ok = s.contacts["Test2"]
+	assert.False(t, ok)
+
+	s.unban(context.Background(), c, []string{"/unban", "Test2"})
+	expectLine(t, lines, "> user Test2 is not banned anymore\n")
+	s.unban(context.Background(), c, []string{"/unban", "Test2"})
+	expectLine(t, lines, "> user Test2 is not banned\n")
+}
+
+func TestServer_banInvalidDuration(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	c.Admin = true
+
+	s.ban(context.Background(), c, []string{"/ban", "Test2", "soon"})
+
+	expectLine(t, lines, "> Comand Error: duration must be positive like 30m or 24h\n")
+	assert.Empty(t, repo.Bans.All())
+}
+
+func TestServer_broadcast(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	c.Admin = true
+	_, _, lines2 := newTestClient(t, s, "Test2")
+	_, _, lines3 := newTestClient(t, s, "Test3")
+
+	s.broadcast(c, []string{"/broadcast", "server", "restarts"})
+
+	expectLine(t, lines2, "> [broadcast] Test : server restarts\n")
+	expectLine(t, lines3, "> [broadcast] Test : server restarts\n")
+	expectLine(t, lines, "> broadcast sent to 2 users\n")
+}
+
+func TestServer_who(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	c.Admin = true
+	newTestClient(t, s, "Test2")
+
+	s.who(c)
+
+	expectLine(t, lines, "> 2 users connected\n")
+	expectLine(t, lines, "Test (admin)\n")
+	expectLine(t, lines, "\taddress: pipe\n")
+}
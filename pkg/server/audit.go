@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditConfig defines the audit log of security events
+type AuditConfig struct {
+	// Path of the audit log file
+	File string `yaml:"file"`
+}
+
+// record writes an event of a client to the audit log, if there is one :
+// failures are logged, they do not stop the command
+func (s *server) record(c *client.Client, event string, details map[string]string) {
+	if s.Audit == nil {
+		return
+	}
+	err := s.Audit.Record(audit.Entry{
+		Event:      event,
+		ConnID:     c.ID,
+		RemoteAddr: c.Conn.RemoteAddr().String(),
+		User:       c.GetName(),
+		Details:    details,
+	})
+	if err != nil {
+		c.Log().WithError(err).WithField("event", event).Error("unable to write audit log")
+	}
+}
+
+// recordConn writes an event of a connection which has no client yet
+func (s *server) recordConn(id uint64, conn net.Conn, event string, details map[string]string) {
+	if s.Audit == nil {
+		return
+	}
+	err := s.Audit.Record(audit.Entry{
+		Event:      event,
+		ConnID:     id,
+		RemoteAddr: conn.RemoteAddr().String(),
+		Details:    details,
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("event", event).Error("unable to write audit log")
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_audit(t *testing.T) {
+	s, _ := newTestServer(t)
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	s.Audit, err = audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Audit.Close()
+
+	s.Config.Admins = []AdminConfig{{Name: "Admin", Password: "secret"}}
+	c, _, lines := newTestClient(t, s, "Guest")
+	newTestClient(t, s, "Test2")
+
+	s.kick(c, []string{"/kick", "Test2"})
+	<-lines
+	s.name(context.Background(), c, []string{"/name", "Admin"})
+	<-lines
+	<-lines
+	s.admin(c, []string{"/admin", "wrong"})
+	<-lines
+	s.admin(c, []string{"/admin", "secret"})
+	<-lines
+	s.kick(c, []string{"/kick", "Test2"})
+	<-lines
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := audit.Verify(strings.NewReader(string(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, count)
+
+	var events []string
+	var entries []audit.Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e audit.Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e.Event)
+		entries = append(entries, e)
+	}
+	assert.Equal(t, []string{audit.EventDenied, audit.EventName, audit.EventAdminAuthFailed, audit.EventAdminAuth, audit.EventKick}, events)
+	assert.Equal(t, "Guest", entries[1].User)
+	assert.Equal(t, map[string]string{"name": "Admin"}, entries[1].Details)
+	assert.Equal(t, "Admin", entries[4].User)
+	assert.Equal(t, map[string]string{"target": "Test2"}, entries[4].Details)
+
+	// passwords are never recorded
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), "wrong")
+}
+
+func TestServer_auditCommands(t *testing.T) {
+	s, _ := newTestServer(t)
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	s.Audit, err = audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Audit.Close()
+	c, _, lines := newTestClient(t, s, "Test")
+
+	s.handle(client.Command{ID: client.CmdUnknown, Client: c, Args: []string{"/nope", "secret"}})
+	expectLine(t, lines, "err: unknown command: /nope\n")
+	<-lines
+	s.handle(client.Command{ID: client.CmdUnknown, Client: c, Args: []string{"hello", "world"}})
+	expectLine(t, lines, "err: unknown command: hello\n")
+	<-lines
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	<-ctx.Done()
+	s.timedOut(ctx, client.Command{ID: client.CmdGetLast, Client: c})
+	expectLine(t, lines, "err: /get-last timed out after 5s, please try again\n")
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []audit.Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e audit.Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, audit.EventUnknownCommand, entries[0].Event)
+		assert.Equal(t, map[string]string{"command": "/nope"}, entries[0].Details)
+		// text without a slash may be a message, it is not recorded
+		assert.Equal(t, audit.EventUnknownCommand, entries[1].Event)
+		assert.Empty(t, entries[1].Details)
+		assert.Equal(t, audit.EventTimedOut, entries[2].Event)
+		assert.Equal(t, "Test", entries[2].User)
+		assert.Equal(t, map[string]string{"command": "get-last", "timeout": "5s"}, entries[2].Details)
+	}
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), "hello")
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/sirupsen/logrus"
+)
+
+// blocked reports whether the recipient blocked the sender :
+// failures of the repository do not stop messages
+func (s *server) blocked(ctx context.Context, recipient string, sender string) bool {
+	ok, err := s.Service.GetBlockService().IsBlocked(ctx, recipient, sender)
+	if err != nil {
+		logrus.WithError(err).Info("unable to check block of ", recipient)
+		return false
+	}
+	return ok
+}
+
+// function to stop receiving messages from a user :
+// the blocked user is not told, its messages are silently dropped
+func (s *server) block(ctx context.Context, c *client.Client, args []string) {
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/block Selahattin")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	if args[1] == c.Name {
+		c.Msg(c, "You can not block yourself.")
+		return
+	}
+	err := s.Service.GetBlockService().Block(ctx, c.Name, args[1])
+	if err != nil {
+		c.Log().WithError(err).Info("Block not saved to db")
+		c.Msg(c, "Block could not be saved. Please try again.")
+		return
+	}
+	// a blocked user is not the contact anymore
+	if c.Contact == args[1] {
+		c.Contact = ""
+	}
+	c.Msg(c, fmt.Sprintf("%s is blocked, you will not receive messages from %s", args[1], args[1]))
+}
+
+// function to receive messages from a blocked user again
+func (s *server) unblock(ctx context.Context, c *client.Client, args []string) {
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/unblock Selahattin")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	ok, err := s.Service.GetBlockService().Unblock(ctx, c.Name, args[1])
+	if err != nil {
+		c.Log().WithError(err).Info("Block not removed from db")
+		c.Msg(c, "Block could not be removed. Please try again.")
+		return
+	}
+	if !ok {
+		c.Msg(c, fmt.Sprintf("%s is not blocked", args[1]))
+		return
+	}
+	c.Msg(c, fmt.Sprintf("%s is not blocked anymore", args[1]))
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// expectNoLine checks that nothing is written to a client for a while
+func expectNoLine(t *testing.T, lines chan string) {
+	select {
+	case line := <-lines:
+		t.Fatalf("unexpected line %q", line)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServer_blockMsg(t *testing.T) {
+	s, repo := newTestServer(t)
+	sender, _, senderLines := newTestClient(t, s, "Test")
+	recipient, _, lines := newTestClient(t, s, "Test2")
+	sender.Contact = "Test2"
+
+	s.block(context.Background(), recipient, []string{"/block", "Test"})
+	expectLine(t, lines, "> Test is blocked, you will not receive messages from Test\n")
+
+	// the sender is not told about the block
+	s.msg(context.Background(), sender, []string{"/msg", "Test", "Text"})
+	expectNoLine(t, lines)
+	expectNoLine(t, senderLines)
+	assert.Empty(t, repo.Messages.All())
+
+	s.unblock(context.Background(), recipient, []string{"/unblock", "Test"})
+	expectLine(t, lines, "> Test is not blocked anymore\n")
+
+	s.msg(context.Background(), sender, []string{"/msg", "Test", "Text"})
+	expectLine(t, lines, "> Test : [#1] Test Text\n")
+}
+
+func TestServer_blockReply(t *testing.T) {
+	s, repo := newTestServer(t)
+	sender, _, senderLines := newTestClient(t, s, "Test")
+	recipient, _, lines := newTestClient(t, s, "Test2")
+	recipient.Contact = "Test"
+
+	s.msg(context.Background(), recipient, []string{"/msg", "Hello"})
+	expectLine(t, senderLines, "> Test2 : [#1] Hello\n")
+	s.block(context.Background(), recipient, []string{"/block", "Test"})
+	expectLine(t, lines, "> Test is blocked, you will not receive messages from Test\n")
+
+	// the reply looks sent, but is neither delivered nor kept
+	s.reply(context.Background(), sender, []string{"/reply", "1", "Hi"})
+	expectLine(t, senderLines, "> reply #2 sent to Test2\n")
+	expectNoLine(t, lines)
+	assert.Len(t, repo.Messages.All(), 1)
+}
+
+func TestServer_blockList(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	newTestClient(t, s, "Test2")
+
+	s.block(context.Background(), c, []string{"/block", "Test2"})
+	expectLine(t, lines, "> Test2 is blocked, you will not receive messages from Test2\n")
+
+	s.list(context.Background(), c)
+	expectLine(t, lines, "> available users: \n")
+}
+
+func TestServer_blockYourself(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+
+	s.block(context.Background(), c, []string{"/block", "Test"})
+
+	expectLine(t, lines, "> You can not block yourself.\n")
+	blocked, err := repo.Blocks.GetBlocked(context.Background(), "Test")
+	assert.NoError(t, err)
+	assert.Empty(t, blocked)
+}
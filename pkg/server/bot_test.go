@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_bot(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), c, []string{"/name", "Deploy"})
+	token := nameTestClient(t, s, lines, "Deploy")
+	user, _, userLines := newTestClient(t, s, "Test")
+
+	s.bot(c, []string{"/bot", "yes"})
+	expectLine(t, lines, "> Comand Error: \n")
+	expectLine(t, lines, "Correct Comamnd Example\n")
+	expectLine(t, lines, "\n")
+	expectLine(t, lines, "/bot\n")
+	assert.False(t, c.Bot)
+
+	s.bot(c, []string{"/bot"})
+	expectLine(t, lines, "> You are marked as a bot\n")
+	s.list(context.Background(), user)
+	expectLine(t, userLines, "> available users: Deploy (bot)\n")
+
+	// the flag is kept with the session
+	s.detach(c)
+	resumed, _, resumedLines := newTestClient(t, s, "anonymous")
+	s.resume(context.Background(), resumed, []string{"/resume", "Deploy", token})
+	<-resumedLines
+	expectLine(t, resumedLines, "> session resumed, you will be known as Deploy\n")
+	assert.True(t, resumed.Bot)
+
+	user.Admin = true
+	s.who(user)
+	expectLine(t, userLines, "> 3 users connected\n")
+	expectLine(t, userLines, "Deploy (bot)\n")
+}
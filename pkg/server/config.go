@@ -0,0 +1,409 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/logging"
+	"github.com/Selahattinn/picus-tcp-message/pkg/ratelimit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/retention"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service"
+	"github.com/Selahattinn/picus-tcp-message/pkg/webhook"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// reloadTimeout is the time the dispatcher has to apply a new config
+const reloadTimeout = 5 * time.Second
+
+// defaultLogFile is used when logs are written to a file without a path
+const defaultLogFile = "tcp-message-server.log"
+
+// defaults of rate limiting when max_violations is set
+const (
+	defaultViolationWindow = time.Minute
+	defaultBanDuration     = 5 * time.Minute
+)
+
+// database names are used in statements, so they are restricted
+var dbNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// TLSConfig defines the certificate which clients are served with
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// loaded certificate of the files
+	certificate *tls.Certificate
+}
+
+// LoadConfig reads a configuration file :
+// unknown keys are rejected, environment variables override the file,
+// defaults are set and the config is checked and its certificate is loaded,
+// so a config which is returned without an error can be used to start or reload the server
+func LoadConfig(path string) (*Config, error) {
+	return loadConfig(path, os.LookupEnv)
+}
+
+func loadConfig(path string, lookup func(string) (string, bool)) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	if _, err := applyEnv(reflect.ValueOf(&cfg).Elem(), EnvPrefix, lookup); err != nil {
+		return nil, err
+	}
+	cfg.SetDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.TLS != nil {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %v", err)
+		}
+		cfg.TLS.certificate = &cert
+	}
+	return &cfg, nil
+}
+
+// SetDefaults sets the optional settings which are not configured
+func (cfg *Config) SetDefaults() {
+	if cfg.MaxFileSize == 0 {
+		cfg.MaxFileSize = defaultMaxFileSize
+	}
+	if cfg.MaxLineSize == 0 {
+		cfg.MaxLineSize = client.DefaultMaxLineSize
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.CommandTimeout == 0 {
+		cfg.CommandTimeout = defaultCommandTimeout
+	}
+	if cfg.SessionGracePeriod == 0 {
+		cfg.SessionGracePeriod = defaultSessionGracePeriod
+	}
+	if cfg.SessionMaxPending == 0 {
+		cfg.SessionMaxPending = defaultSessionMaxPending
+	}
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if cfg.HeartbeatTimeout == 0 {
+		cfg.HeartbeatTimeout = defaultHeartbeatTimeout
+	}
+	if cfg.Log == nil {
+		cfg.Log = &logging.Config{}
+	}
+	if cfg.Log.Level == "" {
+		cfg.Log.Level = logrus.InfoLevel.String()
+	}
+	if cfg.Log.Format == "" {
+		cfg.Log.Format = logging.FormatText
+	}
+	if cfg.Log.Output == "" {
+		cfg.Log.Output = logging.OutputFile
+	}
+	if cfg.Log.Output == logging.OutputFile && cfg.Log.File == "" {
+		cfg.Log.File = defaultLogFile
+	}
+	if cfg.Service == nil {
+		cfg.Service = &service.Config{}
+	}
+	if cfg.Retention != nil {
+		cfg.Retention.SetDefaults()
+	}
+	if cfg.RateLimit != nil && cfg.RateLimit.MaxViolations > 0 {
+		if cfg.RateLimit.ViolationWindow == 0 {
+			cfg.RateLimit.ViolationWindow = defaultViolationWindow
+		}
+		if cfg.RateLimit.BanDuration == 0 {
+			cfg.RateLimit.BanDuration = defaultBanDuration
+		}
+	}
+}
+
+// Validate checks the values of a config, all problems are reported at once
+func (cfg *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	address := func(name string, addr string) {
+		if addr == "" {
+			problems = append(problems, name+": address is required")
+		} else if _, _, err := net.SplitHostPort(addr); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a host:port address", name, addr))
+		}
+	}
+
+	address("host", cfg.ListenAddress)
+	check(cfg.MaxFileSize > 0, "max_file_size: must be positive")
+	check(cfg.MaxLineSize > 0, "max_line_size: must be positive")
+	check(cfg.ReadTimeout >= 0, "read_timeout: must not be negative")
+	check(cfg.WriteTimeout >= 0, "write_timeout: must not be negative")
+	check(cfg.QueueSize > 0, "queue_size: must be positive")
+	check(cfg.CommandTimeout > 0, "command_timeout: must be positive")
+	check(cfg.SessionGracePeriod > 0, "session_grace_period: must be positive")
+	check(cfg.SessionMaxPending > 0, "session_max_pending: must be positive")
+	check(cfg.HeartbeatInterval > 0, "heartbeat_interval: must be positive")
+	check(cfg.HeartbeatTimeout > cfg.HeartbeatInterval, "heartbeat_timeout: must be longer than heartbeat_interval")
+
+	names := make(map[string]bool)
+	for i, admin := range cfg.Admins {
+		check(admin.Name != "", "admins[%d].name: is required", i)
+		check(admin.Password != "", "admins[%d].password: is required", i)
+		check(!names[admin.Name], "admins[%d].name: %q is listed more than once", i, admin.Name)
+		names[admin.Name] = true
+	}
+
+	if cfg.Log != nil {
+		if cfg.Log.Level != "" {
+			_, err := logrus.ParseLevel(cfg.Log.Level)
+			check(err == nil, "log.level: %q is not a log level, use one of trace, debug, info, warning, error", cfg.Log.Level)
+		}
+		check(cfg.Log.Format == "" || cfg.Log.Format == logging.FormatText || cfg.Log.Format == logging.FormatJSON,
+			"log.format: %q is not a log format, use text or json", cfg.Log.Format)
+		check(cfg.Log.Output == "" || cfg.Log.Output == logging.OutputStdout || cfg.Log.Output == logging.OutputFile,
+			"log.output: %q is not a log output, use stdout or file", cfg.Log.Output)
+		check(cfg.Log.Output != logging.OutputFile || cfg.Log.File != "", "log.file: is required when log.output is file")
+		check(cfg.Log.MaxSize >= 0, "log.max_size: must not be negative")
+		check(cfg.Log.MaxBackups >= 0, "log.max_backups: must not be negative")
+	}
+	if cfg.TLS != nil {
+		check(cfg.TLS.CertFile != "", "tls.cert_file: is required")
+		check(cfg.TLS.KeyFile != "", "tls.key_file: is required")
+	}
+	if cfg.HTTP != nil {
+		address("http.host", cfg.HTTP.ListenAddress)
+	}
+	if cfg.Audit != nil {
+		check(cfg.Audit.File != "", "audit.file: is required")
+	}
+	if cfg.RateLimit != nil {
+		limit := func(name string, l ratelimit.Limit) {
+			check(l.Rate >= 0, "%s.rate: must not be negative", name)
+			check(l.Burst >= 0, "%s.burst: must not be negative", name)
+		}
+		limit("rate_limit.connection", cfg.RateLimit.Connection)
+		limit("rate_limit.user", cfg.RateLimit.User)
+		classes := make([]string, 0, len(cfg.RateLimit.Commands))
+		for class := range cfg.RateLimit.Commands {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			l := cfg.RateLimit.Commands[class]
+			switch class {
+			case classMessage, classQuery, classFile, classOther:
+				limit("rate_limit.commands."+class, l)
+			default:
+				problems = append(problems, fmt.Sprintf("rate_limit.commands: unknown command class %q, use one of message, query, file, other", class))
+			}
+		}
+		check(cfg.RateLimit.MaxViolations >= 0, "rate_limit.max_violations: must not be negative")
+		check(cfg.RateLimit.ViolationWindow >= 0, "rate_limit.violation_window: must not be negative")
+		check(cfg.RateLimit.BanDuration >= 0, "rate_limit.ban_duration: must not be negative")
+	}
+
+	urls := make(map[string]bool)
+	for i, hook := range cfg.Webhooks {
+		name := fmt.Sprintf("webhooks[%d]", i)
+		u, err := url.Parse(hook.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "%s.url: %q is not an http or https URL", name, hook.URL)
+		check(!urls[hook.URL], "%s.url: %q is listed more than once", name, hook.URL)
+		urls[hook.URL] = true
+		check(hook.Secret != "", "%s.secret: is required", name)
+		check(hook.Timeout >= 0, "%s.timeout: must not be negative", name)
+		for _, event := range hook.Events {
+			check(webhook.KnownEvent(event), "%s.events: unknown event %q, use one of message, connect, disconnect, keyword", name, event)
+			check(event != webhook.EventKeyword || len(hook.Keywords) > 0, "%s.keywords: are required for keyword events", name)
+		}
+	}
+
+	if cfg.Retention != nil {
+		policy := func(name string, p retention.Policy) {
+			check(p.MaxAge >= 0, "%s.max_age: must not be negative", name)
+			check(p.MaxCount >= 0, "%s.max_count: must not be negative", name)
+		}
+		check(cfg.Retention.Interval >= 0, "retention.interval: must not be negative")
+		check(cfg.Retention.BatchSize >= 0, "retention.batch_size: must not be negative")
+		check(cfg.Retention.BatchPause >= 0, "retention.batch_pause: must not be negative")
+		policy("retention", cfg.Retention.Policy)
+		users := make(map[string]bool)
+		for i, u := range cfg.Retention.Users {
+			name := fmt.Sprintf("retention.users[%d]", i)
+			check(u.User != "", "%s.user: is required", name)
+			check(!users[u.User], "%s.user: %q is listed more than once", name, u.User)
+			users[u.User] = true
+			policy(name, u.Policy)
+		}
+		rooms := make(map[[2]string]bool)
+		for i, r := range cfg.Retention.Rooms {
+			name := fmt.Sprintf("retention.rooms[%d]", i)
+			if len(r.Users) != 2 || r.Users[0] == "" || r.Users[1] == "" || r.Users[0] == r.Users[1] {
+				problems = append(problems, name+".users: must be two different users")
+			} else {
+				room := retention.Room(r.Users[0], r.Users[1])
+				check(!rooms[room], "%s.users: the room of %s and %s is listed more than once", name, room[0], room[1])
+				rooms[room] = true
+			}
+			policy(name, r.Policy)
+		}
+	}
+
+	if cfg.DB == nil {
+		problems = append(problems, "database: is required")
+	} else {
+		address("database.addr", cfg.DB.Addr)
+		check(cfg.DB.Username != "", "database.username: is required")
+		check(dbNamePattern.MatchString(cfg.DB.DBName), "database.db_name: must be letters, digits or underscores")
+		if enc := cfg.DB.Encryption; enc != nil {
+			ids := make(map[string]bool)
+			for i, key := range enc.Keys {
+				name := fmt.Sprintf("database.encryption.keys[%d]", i)
+				check(key.ID != "" && len(key.ID) <= 64, "%s.id: is required, at most 64 characters", name)
+				check(!ids[key.ID], "%s.id: %q is listed more than once", name, key.ID)
+				ids[key.ID] = true
+				check((key.Key == "") != (key.KeyFile == ""), "%s: one of key or key_file is required", name)
+			}
+			check(enc.ActiveKey == "" || ids[enc.ActiveKey], "database.encryption.active_key: %q is not one of the keys", enc.ActiveKey)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// TLSConfig returns the TLS settings of the listener, nil when TLS is disabled :
+// the certificate is looked up on each handshake, so reloaded certificates
+// are used by new connections
+func (s *server) TLSConfig() *tls.Config {
+	if s.Config.TLS == nil {
+		return nil
+	}
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			s.configMu.RLock()
+			defer s.configMu.RUnlock()
+			if s.Config.TLS.certificate == nil {
+				return nil, errors.New("no certificate is loaded")
+			}
+			return s.Config.TLS.certificate, nil
+		},
+	}
+}
+
+// motd returns the message of the day
+func (s *server) motd() string {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.Config.MOTD
+}
+
+// Reload applies a config loaded with LoadConfig to the running server :
+// admins, max file size, message of the day, command timeout, session and heartbeat settings, log settings,
+// rate limits, webhooks, retention policies and the TLS certificate are applied live, names of the other changed settings
+// are returned since they are only used after a restart
+func (s *server) Reload(cfg *Config) ([]string, error) {
+	restart := restartChanges(s.Config, cfg)
+
+	err := s.do(func() {
+		s.configMu.Lock()
+		defer s.configMu.Unlock()
+
+		s.Config.Admins = cfg.Admins
+		s.Config.MaxFileSize = cfg.MaxFileSize
+		s.Config.MOTD = cfg.MOTD
+		s.Config.CommandTimeout = cfg.CommandTimeout
+		s.Config.SessionGracePeriod = cfg.SessionGracePeriod
+		s.Config.SessionMaxPending = cfg.SessionMaxPending
+		s.Config.HeartbeatInterval = cfg.HeartbeatInterval
+		s.Config.HeartbeatTimeout = cfg.HeartbeatTimeout
+		s.Config.Log = cfg.Log
+		s.Config.Webhooks = cfg.Webhooks
+		if s.webhooks != nil {
+			s.webhooks.SetHooks(cfg.Webhooks)
+		}
+		if s.retention != nil && cfg.Retention != nil {
+			s.retention.SetConfig(*cfg.Retention)
+			s.Config.Retention = cfg.Retention
+		}
+		if s.limiter != nil && cfg.RateLimit != nil {
+			s.limiter.SetConfig(*cfg.RateLimit)
+			s.Config.RateLimit = cfg.RateLimit
+		}
+		if s.Config.TLS != nil && cfg.TLS != nil {
+			s.Config.TLS = cfg.TLS
+		}
+
+		// admins which are removed from the config lose their role
+		for _, c := range s.contacts {
+			if c.Admin && !s.isAdmin(c.Name) {
+				c.Admin = false
+				c.Msg(c, "your admin role is revoked")
+			}
+		}
+	}, reloadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return restart, nil
+}
+
+// isAdmin reports whether a name is configured as an admin
+func (s *server) isAdmin(name string) bool {
+	for _, admin := range s.Config.Admins {
+		if admin.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// restartChanges returns the settings which differ between two configs
+// and can not be applied to a running server
+func restartChanges(old *Config, cfg *Config) []string {
+	var changes []string
+	changed := func(name string, a interface{}, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, name)
+		}
+	}
+	changed("host", old.ListenAddress, cfg.ListenAddress)
+	changed("max_line_size", old.MaxLineSize, cfg.MaxLineSize)
+	changed("read_timeout", old.ReadTimeout, cfg.ReadTimeout)
+	changed("write_timeout", old.WriteTimeout, cfg.WriteTimeout)
+	changed("queue_size", old.QueueSize, cfg.QueueSize)
+	changed("http", old.HTTP, cfg.HTTP)
+	changed("service", old.Service, cfg.Service)
+	changed("database", old.DB, cfg.DB)
+	changed("rate_limit", old.RateLimit == nil, cfg.RateLimit == nil)
+	changed("tls", old.TLS == nil, cfg.TLS == nil)
+	changed("audit", old.Audit, cfg.Audit)
+	changed("retention", old.Retention == nil, cfg.Retention == nil)
+	if old.Retention != nil && cfg.Retention != nil {
+		changed("retention.interval", old.Retention.Interval, cfg.Retention.Interval)
+	}
+	return changes
+}
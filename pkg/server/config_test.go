@@ -0,0 +1,227 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/ratelimit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+	"github.com/Selahattinn/picus-tcp-message/pkg/retention"
+	"github.com/Selahattinn/picus-tcp-message/pkg/webhook"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeConfig writes a config file to a temporary directory
+func writeConfig(t *testing.T, data string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// database section of valid test configs
+const testDatabaseConfig = "database:\n  addr: localhost:3306\n  username: root\n  db_name: picus_tcp_chat\n"
+
+// noEnv is an environment without variables
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "host: localhost:8080\nmotd: Welcome\nlog:\n  level: debug\nadmins:\n  - name: admin\n    password: secret\n"+testDatabaseConfig)
+
+	cfg, err := loadConfig(path, noEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:8080", cfg.ListenAddress)
+	assert.Equal(t, "Welcome", cfg.MOTD)
+	assert.Equal(t, logrus.DebugLevel, cfg.Log.ParseLevel())
+	assert.Equal(t, []AdminConfig{{Name: "admin", Password: "secret"}}, cfg.Admins)
+
+	// defaults of optional settings
+	assert.Equal(t, int64(defaultMaxFileSize), cfg.MaxFileSize)
+	assert.Equal(t, client.DefaultMaxLineSize, cfg.MaxLineSize)
+	assert.Equal(t, defaultQueueSize, cfg.QueueSize)
+	assert.Equal(t, defaultCommandTimeout, cfg.CommandTimeout)
+	assert.Equal(t, defaultHeartbeatInterval, cfg.HeartbeatInterval)
+	assert.Equal(t, defaultHeartbeatTimeout, cfg.HeartbeatTimeout)
+	assert.NotNil(t, cfg.Service)
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	path := writeConfig(t, "host: localhost:8080\n"+testDatabaseConfig)
+	env := map[string]string{
+		"TCP_MESSAGE_DATABASE_PASSWORD":          "secret: #1",
+		"TCP_MESSAGE_READ_TIMEOUT":               "2m",
+		"TCP_MESSAGE_RATE_LIMIT_CONNECTION_RATE": "2.5",
+		"TCP_MESSAGE_RATE_LIMIT_MAX_VIOLATIONS":  "3",
+		"TCP_MESSAGE_ADMINS":                     "[{name: admin, password: secret}]",
+		"TCP_MESSAGE_RETENTION_MAX_AGE":          "720h",
+		"TCP_MESSAGE_RETENTION_MAX_COUNT":        "1000",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg, err := loadConfig(path, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret: #1", cfg.DB.Password)
+	assert.Equal(t, "root", cfg.DB.Username)
+	assert.Equal(t, 2*time.Minute, cfg.ReadTimeout)
+	assert.Equal(t, 2.5, cfg.RateLimit.Connection.Rate)
+	assert.Equal(t, defaultBanDuration, cfg.RateLimit.BanDuration)
+	assert.Equal(t, []AdminConfig{{Name: "admin", Password: "secret"}}, cfg.Admins)
+	assert.Nil(t, cfg.HTTP)
+	// the global retention policy is inlined in the retention section
+	if assert.NotNil(t, cfg.Retention) {
+		assert.Equal(t, 720*time.Hour, cfg.Retention.MaxAge)
+		assert.Equal(t, 1000, cfg.Retention.MaxCount)
+	}
+
+	env = map[string]string{"TCP_MESSAGE_QUEUE_SIZE": "many"}
+	_, err = loadConfig(path, lookup)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"syntax", "host: [localhost"},
+		{"unknown key", "host: localhost:8080\nmax_file_sise: 10\n" + testDatabaseConfig},
+		{"unknown nested key", "host: localhost:8080\ndatabase:\n  address: localhost:3306\n"},
+		{"no host", "motd: Welcome\n" + testDatabaseConfig},
+		{"no database", "host: localhost:8080\n"},
+		{"log level", "host: localhost:8080\nlog:\n  level: loud\n" + testDatabaseConfig},
+		{"log format", "host: localhost:8080\nlog:\n  format: xml\n" + testDatabaseConfig},
+		{"log file", "host: localhost:8080\nlog:\n  output: file\n  file: \"\"\n  max_size: -1\n" + testDatabaseConfig},
+		{"tls files", "host: localhost:8080\ntls:\n  cert_file: cert.pem\n" + testDatabaseConfig},
+		{"heartbeat timeout", "host: localhost:8080\nheartbeat_interval: 1m\nheartbeat_timeout: 30s\n" + testDatabaseConfig},
+		{"tls missing", "host: localhost:8080\ntls:\n  cert_file: missing.pem\n  key_file: missing.pem\n" + testDatabaseConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tt.data), noEnv)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := &Config{
+		ListenAddress:  "localhost",
+		ReadTimeout:    -time.Second,
+		CommandTimeout: -time.Second,
+		Admins:         []AdminConfig{{Name: "admin", Password: "secret"}, {Name: "admin"}},
+		RateLimit: &ratelimit.Config{
+			Connection: ratelimit.Limit{Rate: -1},
+			Commands:   map[string]ratelimit.Limit{"chat": {Rate: 1}},
+		},
+		Webhooks: []webhook.Config{
+			{URL: "hooks.local/chat", Events: []string{"sent", webhook.EventKeyword}},
+			{URL: "https://hooks.local/alerts", Secret: "secret", Keywords: []string{"outage"}},
+		},
+		Retention: &retention.Config{
+			BatchSize: -1,
+			Policy:    retention.Policy{MaxAge: -time.Hour},
+			Users:     []retention.UserPolicy{{User: "alice", Policy: retention.Policy{MaxCount: 10}}, {User: "alice"}},
+			Rooms: []retention.RoomPolicy{
+				{Users: []string{"alice", "bob"}, Policy: retention.Policy{MaxCount: -1}},
+				{Users: []string{"bob", "alice"}},
+				{Users: []string{"carol"}},
+			},
+		},
+		DB: &repository.MySQLConfig{
+			Addr:     "localhost:3306",
+			Username: "root",
+			DBName:   "chat; DROP",
+			Encryption: &crypto.AtRestConfig{
+				ActiveKey: "2021-03",
+				Keys:      []crypto.KeyConfig{{ID: "2021-01", Key: "a2V5"}, {ID: "2021-01", Key: "a2V5", KeyFile: "key"}},
+			},
+		},
+	}
+	cfg.SetDefaults()
+
+	err := cfg.Validate()
+	if assert.Error(t, err) {
+		assert.Equal(t, `invalid config:
+	host: "localhost" is not a host:port address
+	read_timeout: must not be negative
+	command_timeout: must be positive
+	admins[1].password: is required
+	admins[1].name: "admin" is listed more than once
+	rate_limit.connection.rate: must not be negative
+	rate_limit.commands: unknown command class "chat", use one of message, query, file, other
+	webhooks[0].url: "hooks.local/chat" is not an http or https URL
+	webhooks[0].secret: is required
+	webhooks[0].events: unknown event "sent", use one of message, connect, disconnect, keyword
+	webhooks[0].keywords: are required for keyword events
+	retention.batch_size: must not be negative
+	retention.max_age: must not be negative
+	retention.users[1].user: "alice" is listed more than once
+	retention.rooms[0].max_count: must not be negative
+	retention.rooms[1].users: the room of alice and bob is listed more than once
+	retention.rooms[2].users: must be two different users
+	database.db_name: must be letters, digits or underscores
+	database.encryption.keys[1].id: "2021-01" is listed more than once
+	database.encryption.keys[1]: one of key or key_file is required
+	database.encryption.active_key: "2021-03" is not one of the keys`, err.Error())
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Config.RateLimit = &ratelimit.Config{Connection: ratelimit.Limit{Rate: 1, Burst: 1}}
+	s.limiter = ratelimit.NewLimiter(*s.Config.RateLimit)
+	s.Config.Admins = []AdminConfig{{Name: "Test", Password: "secret"}}
+	c, _, lines := newTestClient(t, s, "Test")
+	c.Admin = true
+	go s.dispatch()
+
+	restart, err := s.Reload(&Config{
+		ListenAddress:  "localhost:9000",
+		MOTD:           "Welcome",
+		MaxFileSize:    1024,
+		CommandTimeout: time.Second,
+		WriteTimeout:   s.Config.WriteTimeout,
+		RateLimit:      &ratelimit.Config{Connection: ratelimit.Limit{Rate: 1, Burst: 5}},
+		DB:             &repository.MySQLConfig{Addr: "localhost:3306"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host", "database"}, restart)
+
+	// live settings are applied
+	assert.Equal(t, "Welcome", s.motd())
+	assert.Equal(t, int64(1024), s.maxFileSize())
+	assert.Equal(t, time.Second, s.commandTimeout())
+	assert.Equal(t, 5, s.Config.RateLimit.Connection.Burst)
+
+	// settings which need a restart are kept
+	assert.Equal(t, "", s.Config.ListenAddress)
+	assert.Nil(t, s.Config.DB)
+
+	// the removed admin loses the role
+	expectLine(t, lines, "> your admin role is revoked\n")
+	assert.False(t, c.Admin)
+}
+
+func TestRestartChanges(t *testing.T) {
+	old := &Config{ListenAddress: "localhost:8080", RateLimit: &ratelimit.Config{}}
+
+	assert.Empty(t, restartChanges(old, &Config{ListenAddress: "localhost:8080", RateLimit: &ratelimit.Config{MaxViolations: 3}, MOTD: "Welcome"}))
+	assert.Equal(t, []string{"rate_limit", "tls"}, restartChanges(old, &Config{ListenAddress: "localhost:8080", TLS: &TLSConfig{}}))
+}
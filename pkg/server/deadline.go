@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+)
+
+// defaultCommandTimeout is used when command_timeout is not configured
+const defaultCommandTimeout = 5 * time.Second
+
+// commandTimeout returns the time a command can take
+func (s *server) commandTimeout() time.Duration {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	if s.Config.CommandTimeout > 0 {
+		return s.Config.CommandTimeout
+	}
+	return defaultCommandTimeout
+}
+
+// deadline starts the context of a command, its queries to the repository
+// are canceled when the command runs out of time
+func (s *server) deadline() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.commandTimeout())
+}
+
+// timedOut tells the client when its command ran out of time
+func (s *server) timedOut(ctx context.Context, cmd client.Command) {
+	if ctx.Err() != context.DeadlineExceeded {
+		return
+	}
+	metrics.TimedOutCommands.WithLabelValues(cmd.ID.String()).Inc()
+	cmd.Client.Log().WithField("command", cmd.ID.String()).Info("command timed out")
+	s.record(cmd.Client, audit.EventTimedOut, map[string]string{"command": cmd.ID.String(), "timeout": s.commandTimeout().String()})
+	cmd.Client.Err(fmt.Errorf("/%s timed out after %s, please try again", cmd.ID, s.commandTimeout()))
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/memory"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/message"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowMessageRepository hangs on GetAll like a database which does not answer,
+// until the context of the query is canceled
+type slowMessageRepository struct {
+	*memory.MessageRepository
+
+	// errors of the canceled queries
+	canceled chan error
+}
+
+func (r *slowMessageRepository) GetAll(ctx context.Context, from string) ([]model.Message, error) {
+	<-ctx.Done()
+	r.canceled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func (r *slowMessageRepository) Store(ctx context.Context, m model.Message) (int64, error) {
+	<-ctx.Done()
+	r.canceled <- ctx.Err()
+	return 0, ctx.Err()
+}
+
+type slowRepository struct {
+	*memory.Repository
+	messages *slowMessageRepository
+}
+
+func (r *slowRepository) GetMessageRepository() message.Repository {
+	return r.messages
+}
+
+func TestServer_commandTimeout(t *testing.T) {
+	s, repo := newTestServer(t)
+	s.Config.CommandTimeout = 50 * time.Millisecond
+	slow := &slowMessageRepository{MessageRepository: repo.Messages, canceled: make(chan error, 1)}
+	var err error
+	s.Service, err = service.NewProvider(&service.Config{}, &slowRepository{Repository: repo, messages: slow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.dispatch()
+	c, _, lines := newTestClient(t, s, "Test")
+
+	// the hung query is canceled and the client is told
+	s.commands <- client.Command{ID: client.CmdGetMessageFromMe, Client: c}
+	select {
+	case err := <-slow.canceled:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the query to be canceled")
+	}
+	expectLine(t, lines, "err: /get-m-from-me timed out after 50ms, please try again\n")
+
+	// the dispatcher keeps processing commands
+	s.commands <- client.Command{ID: client.CmdGetMessageToMe, Client: c}
+	expectLine(t, lines, "> You haven't sent a message yet. Now it's time to talk to someone\n")
+	expectNoLine(t, lines)
+}
+
+func TestServer_commandTimeoutMessage(t *testing.T) {
+	s, repo := newTestServer(t)
+	s.Config.CommandTimeout = 50 * time.Millisecond
+	slow := &slowMessageRepository{MessageRepository: repo.Messages, canceled: make(chan error, 1)}
+	var err error
+	s.Service, err = service.NewProvider(&service.Config{}, &slowRepository{Repository: repo, messages: slow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, lines := newTestClient(t, s, "Test")
+	c.Contact = "Test2"
+	_, _, recipientLines := newTestClient(t, s, "Test2")
+
+	// a message which is not stored in time is not delivered, since the sender sends it again
+	s.handle(client.Command{ID: client.CmdMsg, Client: c, Args: []string{"/msg", "Hello"}})
+	assert.Equal(t, context.DeadlineExceeded, <-slow.canceled)
+	expectLine(t, lines, "err: /msg timed out after 50ms, please try again\n")
+	expectNoLine(t, recipientLines)
+}
+
+func TestServer_commandTimeoutRequest(t *testing.T) {
+	s, repo := newTestServer(t)
+	s.Config.CommandTimeout = 50 * time.Millisecond
+	slow := &slowMessageRepository{MessageRepository: repo.Messages, canceled: make(chan error, 1)}
+	var err error
+	s.Service, err = service.NewProvider(&service.Config{}, &slowRepository{Repository: repo, messages: slow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, lines := newTestClient(t, s, "Test")
+	s.proto(c, []string{"/proto", "json"})
+	expectLine(t, lines, `{"type":"proto","text":"json"}`+"\n")
+
+	// the error answers the request before it is done
+	s.handle(client.Command{ID: client.CmdGetMessageFromMe, Client: c, RequestID: 3})
+	expectLine(t, lines, `{"type":"error","id":3,"text":"/get-m-from-me timed out after 50ms, please try again"}`+"\n")
+	expectLine(t, lines, `{"type":"done","id":3}`+"\n")
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of environment variables overriding the config :
+// the name of a setting is its yaml path in upper case joined with underscores,
+// e.g. TCP_MESSAGE_DATABASE_PASSWORD overrides database.password
+const EnvPrefix = "TCP_MESSAGE"
+
+// applyEnv overrides the fields of a struct with the environment variables
+// found by lookup. Strings are used as they are, other values are parsed as
+// yaml, so lists like admins can be given as [{name: admin, password: secret}].
+// It reports whether any field is set.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) (bool, error) {
+	set := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		options := strings.Split(field.Tag.Get("yaml"), ",")
+		tag := options[0]
+		value := v.Field(i)
+
+		// fields of inlined structs are settings of the struct which holds them
+		if field.Anonymous && tag == "" && value.Kind() == reflect.Struct && hasOption(options[1:], "inline") {
+			ok, err := applyEnv(value, prefix, lookup)
+			if err != nil {
+				return false, err
+			}
+			set = set || ok
+			continue
+		}
+		if field.PkgPath != "" || tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+
+		switch {
+		case value.Kind() == reflect.Struct:
+			ok, err := applyEnv(value, name, lookup)
+			if err != nil {
+				return false, err
+			}
+			set = set || ok
+		case value.Kind() == reflect.Ptr && value.Type().Elem().Kind() == reflect.Struct:
+			// optional sections are only created when one of their fields is set
+			section := reflect.New(value.Type().Elem())
+			if !value.IsNil() {
+				section = value
+			}
+			ok, err := applyEnv(section.Elem(), name, lookup)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				value.Set(section)
+				set = true
+			}
+		default:
+			env, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if value.Kind() == reflect.String {
+				value.SetString(env)
+			} else if err := yaml.UnmarshalStrict([]byte(env), value.Addr().Interface()); err != nil {
+				return false, fmt.Errorf("%s: %v", name, err)
+			}
+			set = true
+		}
+	}
+	return set, nil
+}
+
+// hasOption reports whether the options of a yaml tag include option
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
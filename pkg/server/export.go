@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/history"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// name files of the server are offered from
+	serverSender = "server"
+
+	// time the dispatcher has to offer or complete a transfer of a server file
+	serverFileTimeout = 5 * time.Second
+)
+
+// errExportTooLarge is returned when a history export exceeds the file size limit
+var errExportTooLarge = errors.New("export is too large")
+
+// limitedWriter is a writer which refuses to write more than limit bytes
+type limitedWriter struct {
+	io.Writer
+	written int64
+	limit   int64
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.limit {
+		return 0, errExportTooLarge
+	}
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// function to send the message history of the client as a file :
+// /export [jsonl|csv]
+// the history is written to a temporary file off the dispatcher, which then offers it
+func (s *server) export(c *client.Client, args []string) {
+	format := history.FormatJSONL
+	if len(args) == 2 {
+		format = args[1]
+	}
+	if len(args) > 2 || !history.KnownFormat(format) {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/export\n/export csv")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	go s.exportHistory(c, c.Name, format, s.maxFileSize())
+}
+
+// exportHistory writes the history of a user to a temporary file and offers it to the client
+func (s *server) exportHistory(c *client.Client, name string, format string, limit int64) {
+	f, err := ioutil.TempFile("", "history-*."+format)
+	if err != nil {
+		c.Log().WithError(err).Info("History export error")
+		c.Msg(c, "History could not be exported. Please try again.")
+		return
+	}
+	hash := sha256.New()
+	w := &limitedWriter{Writer: io.MultiWriter(f, hash), limit: limit}
+	var count int
+	hw, err := history.NewWriter(w, format)
+	if err == nil {
+		ctx, cancel := s.deadline()
+		count, err = history.Export(ctx, s.Service.GetMessageService(), model.HistoryFilter{User: name}, hw)
+		cancel()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || count == 0 {
+		os.Remove(f.Name())
+	}
+	if err == errExportTooLarge {
+		c.Msg(c, fmt.Sprintf("Your history is larger than %d bytes and can not be exported.", limit))
+		return
+	}
+	if err != nil {
+		c.Log().WithError(err).Info("History export error")
+		c.Msg(c, "History could not be exported. Please try again.")
+		return
+	}
+	if count == 0 {
+		c.Msg(c, "You have no messages to export.")
+		return
+	}
+
+	t := &transfer{
+		From: serverSender,
+		To:   name,
+		Name: fmt.Sprintf("history-%s.%s", time.Now().Format("20060102"), format),
+		Size: w.written,
+		Hash: hex.EncodeToString(hash.Sum(nil)),
+		Path: f.Name(),
+	}
+	offered := false
+	err = s.do(func() {
+		// the client may have left while its history was exported
+		if contact, ok := s.contacts[name]; !ok || contact != c {
+			return
+		}
+		s.nextTransferID++
+		t.ID = s.nextTransferID
+		s.transfers[t.ID] = t
+		offered = true
+	}, serverFileTimeout)
+	if err != nil || !offered {
+		os.Remove(t.Path)
+		return
+	}
+	c.Log().WithFields(logrus.Fields{"transfer_id": t.ID, "messages": count, "size": t.Size}).Info("history exported")
+
+	c.Msg(c, fmt.Sprintf("/file-offer %d %s %d %s %s", t.ID, t.From, t.Size, t.Hash, t.Name))
+	c.Msg(c, fmt.Sprintf("your history of %d messages is ready as %s (%d bytes). use '/file-accept %d' or '/file-decline %d'", count, t.Name, t.Size, t.ID, t.ID))
+}
+
+// sendServerFile passes an accepted file of the server to the relay of its recipient from
+// its own go routine, the transfer is over once the file is complete or the relay stopped
+func (s *server) sendServerFile(id int64, path string, offset int64, r *relay) {
+	f, err := os.Open(path)
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+		defer f.Close()
+	}
+	for err == nil {
+		data := make([]byte, FileChunkSize)
+		var n int
+		n, err = io.ReadFull(f, data)
+		if n > 0 {
+			if r.queue(chunk{offset: offset, data: data[:n]}) != nil {
+				return
+			}
+			offset += int64(n)
+		}
+	}
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		logrus.WithError(err).WithField("transfer_id", id).Info("unable to read file")
+		s.do(func() {
+			if t, ok := s.transfers[id]; ok && t.relay == r {
+				s.dropTransfer(t, "file could not be sent, please try again")
+			}
+		}, serverFileTimeout)
+		return
+	}
+	if err := r.queue(chunk{offset: offset}); err != nil {
+		return
+	}
+	// the temporary file is removed once the relay wrote the file
+	<-r.done
+	err = s.do(func() {
+		if t, ok := s.transfers[id]; ok && t.relay == r {
+			s.deleteTransfer(t)
+			r.to.Log().WithField("transfer_id", id).Info("file transfer completed")
+		}
+	}, serverFileTimeout)
+	if err != nil {
+		logrus.WithError(err).WithField("transfer_id", id).Info("unable to complete file transfer")
+	}
+}
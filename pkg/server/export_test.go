@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_export(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	other, _, otherLines := newTestClient(t, s, "Test2")
+	go s.dispatch()
+
+	s.export(c, []string{"/export", "xml"})
+	expectLine(t, lines, "> Comand Error: \n")
+	expectLine(t, lines, "Correct Comamnd Example\n")
+	expectLine(t, lines, "\n")
+	expectLine(t, lines, "/export\n")
+	expectLine(t, lines, "/export csv\n")
+
+	s.export(c, []string{"/export"})
+	expectLine(t, lines, "> You have no messages to export.\n")
+
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := ""
+	for i := 1; i <= 5; i++ {
+		m := model.Message{From: "Test", To: "Test2", Text: fmt.Sprintf("Message number %d of the history", i), CreatedAt: createdAt}
+		repo.Messages.Store(context.Background(), m)
+		want += fmt.Sprintf(`{"id":%d,"from":"Test","to":"Test2","text":"Message number %d of the history","created_at":"2021-03-01T12:00:00Z"}`+"\n", i, i)
+	}
+	repo.Messages.Store(context.Background(), model.Message{From: "Test2", To: "Test3", Text: "Not yours", CreatedAt: createdAt})
+
+	s.export(c, []string{"/export", "jsonl"})
+	sum := sha256.Sum256([]byte(want))
+	hash := hex.EncodeToString(sum[:])
+	name := "history-" + time.Now().Format("20060102") + ".jsonl"
+	expectLine(t, lines, fmt.Sprintf("> /file-offer 1 server %d %s %s\n", len(want), hash, name))
+	expectLine(t, lines, fmt.Sprintf("> your history of 5 messages is ready as %s (%d bytes). use '/file-accept 1' or '/file-decline 1'\n", name, len(want)))
+
+	// nobody else takes part in the transfer
+	s.fileAccept(other, []string{"/file-accept", "1"})
+	expectLine(t, otherLines, "> /file-error 1 no such file transfer\n")
+	s.fileChunk(other, []string{"/file-chunk", "1", "0", "aGk="})
+	expectLine(t, otherLines, "> /file-error 1 no such file transfer\n")
+
+	// the history is kept in a temporary file until it is sent
+	var path string
+	s.do(func() { path = s.transfers[1].Path }, time.Second)
+	_, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	// the file is streamed in chunks, starting at the offset of a partial download
+	s.fileAccept(c, []string{"/file-accept", "1", "10"})
+	expectLine(t, lines, "> receiving "+name+" from server\n")
+	data := want[:10]
+	for line := range lines {
+		if line == "> /file-done 1\n" {
+			break
+		}
+		args := strings.Fields(strings.TrimPrefix(line, "> "))
+		if !assert.Len(t, args, 4) || !assert.Equal(t, "/file-chunk", args[0]) {
+			return
+		}
+		assert.Equal(t, fmt.Sprint(len(data)), args[2])
+		chunk, err := base64.StdEncoding.DecodeString(args[3])
+		assert.NoError(t, err)
+		assert.True(t, len(chunk) <= FileChunkSize)
+		data += string(chunk)
+	}
+	assert.Equal(t, want, data)
+	assert.Eventually(t, func() bool {
+		var done bool
+		s.do(func() { done = len(s.transfers) == 0 }, time.Second)
+		return done
+	}, time.Second, 10*time.Millisecond)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServer_exportTooLarge(t *testing.T) {
+	s, repo := newTestServer(t)
+	s.Config.MaxFileSize = 100
+	c, _, lines := newTestClient(t, s, "Test")
+	repo.Messages.Store(context.Background(), model.Message{From: "Test", To: "Test2", Text: strings.Repeat("a", 100)})
+
+	s.export(c, []string{"/export", "csv"})
+	expectLine(t, lines, "> Your history is larger than 100 bytes and can not be exported.\n")
+	assert.Empty(t, s.transfers)
+}
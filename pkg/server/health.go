@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lifecycle states of the server
+const (
+	stateStarting int32 = iota
+	stateRunning
+	stateStopping
+)
+
+var stateNames = map[int32]string{
+	stateStarting: "starting",
+	stateRunning:  "running",
+	stateStopping: "stopping",
+}
+
+// probeTimeout is the time a readiness check can take
+const probeTimeout = time.Second
+
+// status of a single component of the server
+type componentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// response of health endpoints
+type healthStatus struct {
+	Status     string                     `json:"status"`
+	State      string                     `json:"state"`
+	Components map[string]componentStatus `json:"components,omitempty"`
+}
+
+func (s *server) setState(state int32) {
+	atomic.StoreInt32(&s.state, state)
+}
+
+func (s *server) getState() int32 {
+	return atomic.LoadInt32(&s.state)
+}
+
+// errDispatcherStopped is returned by do once the server shut down
+var errDispatcherStopped = errors.New("dispatcher is stopped")
+
+// do runs a function on the dispatcher and waits until it is done :
+// it fails if the dispatcher does not pick it up within the timeout or is stopped
+func (s *server) do(task func(), timeout time.Duration) error {
+	done := make(chan struct{})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case s.tasks <- func() { task(); close(done) }:
+	case <-s.stop:
+		return errDispatcherStopped
+	case <-timer.C:
+		return errors.New("dispatcher is not processing commands")
+	}
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return errors.New("dispatcher did not complete in time")
+	}
+}
+
+// checkRepository pings the repository within the timeout
+func (s *server) checkRepository(timeout time.Duration) error {
+	if s.Service == nil {
+		return errors.New("repository is not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.Service.Ping(ctx); err != nil {
+		if ctx.Err() != nil {
+			return errors.New("repository did not respond in time")
+		}
+		return err
+	}
+	return nil
+}
+
+// writeHealth writes a health response with a matching status code
+func writeHealth(w http.ResponseWriter, status healthStatus) {
+	code := http.StatusOK
+	if status.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logrus.WithError(err).Info("unable to write health response")
+	}
+}
+
+// LivenessHandler reports whether the server process is alive
+func (s *server) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, healthStatus{
+			Status: "ok",
+			State:  stateNames[s.getState()],
+		})
+	})
+}
+
+// ReadinessHandler reports whether the server can serve clients :
+// the server has to be running, the repository reachable and the dispatcher processing commands
+func (s *server) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := s.getState()
+		status := healthStatus{
+			Status:     "ok",
+			State:      stateNames[state],
+			Components: make(map[string]componentStatus),
+		}
+		if state != stateRunning {
+			status.Status = "unavailable"
+			writeHealth(w, status)
+			return
+		}
+
+		checks := map[string]func() error{
+			"repository": func() error { return s.checkRepository(s.probeTimeout) },
+			"dispatcher": func() error { return s.do(func() {}, s.probeTimeout) },
+		}
+		for name, check := range checks {
+			if err := check(); err != nil {
+				status.Status = "unavailable"
+				status.Components[name] = componentStatus{Status: "unavailable", Error: err.Error()}
+				continue
+			}
+			status.Components[name] = componentStatus{Status: "ok"}
+		}
+		writeHealth(w, status)
+	})
+}
+
+// Shutdown stops the server : it reports not ready from now on,
+// tells connected clients and closes their connections, stops posting webhooks
+// and closes the repository
+func (s *server) Shutdown() {
+	s.setState(stateStopping)
+	logrus.Info("shutting down server...")
+
+	err := s.do(func() {
+		for _, c := range s.contacts {
+			c.Msg(c, "server is shutting down")
+			s.disconnect(c)
+		}
+	}, s.probeTimeout)
+	if err != nil {
+		logrus.WithError(err).Info("unable to disconnect clients")
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+	if s.webhooks != nil {
+		s.webhooks.Close()
+	}
+	if s.Service != nil {
+		s.Service.Shutdown()
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getHealth calls a health handler and decodes its response
+func getHealth(t *testing.T, h http.Handler) (int, healthStatus) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var status healthStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, status
+}
+
+func TestServer_Liveness(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	code, status := getHealth(t, s.LivenessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatus{Status: "ok", State: "starting"}, status)
+}
+
+func TestServer_ReadinessStarting(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	code, status := getHealth(t, s.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", status.Status)
+	assert.Equal(t, "starting", status.State)
+}
+
+func TestServer_Readiness(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.setState(stateRunning)
+	go s.dispatch()
+
+	code, status := getHealth(t, s.ReadinessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatus{
+		Status: "ok",
+		State:  "running",
+		Components: map[string]componentStatus{
+			"repository": {Status: "ok"},
+			"dispatcher": {Status: "ok"},
+		},
+	}, status)
+}
+
+func TestServer_ReadinessRepositoryDown(t *testing.T) {
+	s, repo := newTestServer(t)
+	s.setState(stateRunning)
+	repo.PingErr = errors.New("connection refused")
+	go s.dispatch()
+
+	code, status := getHealth(t, s.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, componentStatus{Status: "unavailable", Error: "connection refused"}, status.Components["repository"])
+	assert.Equal(t, componentStatus{Status: "ok"}, status.Components["dispatcher"])
+}
+
+func TestServer_ReadinessDispatcherStuck(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.setState(stateRunning)
+	s.probeTimeout = 50 * time.Millisecond
+
+	// the dispatcher is not running, so the probe is never picked up
+	code, status := getHealth(t, s.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", status.Components["dispatcher"].Status)
+	assert.Equal(t, componentStatus{Status: "ok"}, status.Components["repository"])
+}
+
+func TestServer_ReadinessShutdown(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.setState(stateRunning)
+	go s.dispatch()
+	c, _, lines := newTestClient(t, s, "Test")
+
+	s.Shutdown()
+
+	expectLine(t, lines, "> server is shutting down\n")
+	_, ok := s.contacts["Test"]
+	assert.False(t, ok)
+	_, err := c.Conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	// the dispatcher is stopped, tasks fail at once
+	start := time.Now()
+	assert.Equal(t, errDispatcherStopped, s.do(func() {}, time.Minute))
+	assert.True(t, time.Since(start) < time.Second)
+
+	code, status := getHealth(t, s.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "stopping", status.State)
+}
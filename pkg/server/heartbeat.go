@@ -0,0 +1,74 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+)
+
+// defaults of the heartbeat
+const (
+	// time between pings sent to a client
+	defaultHeartbeatInterval = 30 * time.Second
+
+	// time a client can stay silent before it is evicted
+	defaultHeartbeatTimeout = 90 * time.Second
+
+	// time the dispatcher has to remove an evicted client
+	evictTimeout = 5 * time.Second
+)
+
+// heartbeatSettings returns the heartbeat interval and timeout,
+// they are read on every ping since a reload can change them
+func (s *server) heartbeatSettings() (time.Duration, time.Duration) {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	interval, timeout := s.Config.HeartbeatInterval, s.Config.HeartbeatTimeout
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	if timeout <= interval {
+		timeout = 3 * interval
+	}
+	return interval, timeout
+}
+
+// heartbeat pings a client until done is closed :
+// /ping <seq>
+// any line read from the client counts as an answer, a client which stays silent
+// for the heartbeat timeout is evicted : its session ends and its connection is closed,
+// so a half-open connection does not keep its name
+func (s *server) heartbeat(c *client.Client, done <-chan struct{}) {
+	var seq uint64
+	for {
+		interval, timeout := s.heartbeatSettings()
+		timer := time.NewTimer(interval)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if silent := time.Since(c.LastSeen()); silent > timeout {
+			c.Log().WithField("silent", silent.Truncate(time.Second).String()).Info("client did not answer heartbeats, evicting it")
+			s.record(c, audit.EventEvicted, map[string]string{"silent": silent.Truncate(time.Second).String()})
+			metrics.EvictedClients.Inc()
+			// the name is free at once, an evicted client does not get the grace period of
+			// a dropped connection. the reader stops on the closed connection
+			if err := s.do(func() { s.disconnect(c) }, evictTimeout); err != nil {
+				c.Log().WithError(err).Info("unable to end session of evicted client")
+				c.Conn.Close()
+			}
+			return
+		}
+
+		// a write to a dead peer can block without a write timeout,
+		// so it must not hold up the check above
+		seq++
+		go c.Send(c, client.Event{Type: client.EventPing, Text: strconv.FormatUint(seq, 10)})
+	}
+}
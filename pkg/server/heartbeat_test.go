@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_heartbeatEvictsSilentClient(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Config.HeartbeatInterval = 20 * time.Millisecond
+	s.Config.HeartbeatTimeout = 100 * time.Millisecond
+	c, _, lines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), c, []string{"/name", "Test"})
+	nameTestClient(t, s, lines, "Test")
+	c.ConnectedAt = time.Now()
+	go s.dispatch()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.heartbeat(c, done)
+
+	expectLine(t, lines, "> /ping 1\n")
+
+	// the client never answers, its connection is closed
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-lines:
+			if !ok {
+				// the name can be claimed at once
+				other, _, otherLines := newTestClient(t, s, "anonymous")
+				assert.NoError(t, s.do(func() { s.name(context.Background(), other, []string{"/name", "Test"}) }, time.Second))
+				nameTestClient(t, s, otherLines, "Test")
+				return
+			}
+		case <-timeout:
+			t.Fatal("silent client is not evicted")
+		}
+	}
+}
+
+func TestServer_heartbeatKeepsAnsweringClient(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Config.HeartbeatInterval = 20 * time.Millisecond
+	s.Config.HeartbeatTimeout = 100 * time.Millisecond
+	c, conn, lines := newTestClient(t, s, "Test")
+	c.ConnectedAt = time.Now()
+	go c.ReadInput()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.heartbeat(c, done)
+
+	deadline := time.After(300 * time.Millisecond)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("answering client is evicted")
+			}
+			assert.True(t, strings.HasPrefix(line, "> /ping "), line)
+			_, err := conn.Write([]byte("/pong " + strings.TrimPrefix(line, "> /ping ")))
+			assert.NoError(t, err)
+		case <-deadline:
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+	"github.com/Selahattinn/picus-tcp-message/pkg/ratelimit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/webhook"
+)
+
+// command classes which can be limited separately in rate_limit.commands
+const (
+	classMessage = "message"
+	classQuery   = "query"
+	classFile    = "file"
+	classOther   = "other"
+)
+
+// commandClass returns the rate limit class of a command
+func commandClass(cmd client.Command) string {
+	switch cmd.ID {
+	case client.CmdMsg, client.CmdReply, client.CmdFileOffer, client.CmdSchedule, client.CmdMsgTTL:
+		return classMessage
+	case client.CmdList, client.CmdGetMessageFromMe, client.CmdGetMessageToMe, client.CmdGetLast, client.CmdGetContains, client.CmdThread, client.CmdScheduled, client.CmdExport:
+		return classQuery
+	case client.CmdFileChunk:
+		return classFile
+	}
+	return classOther
+}
+
+// host returns the ip address of a connection
+func host(conn net.Conn) string {
+	h, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return h
+}
+
+// allow applies the rate limits to a command :
+// rejected commands are reported to the client and abusive clients are disconnected
+func (s *server) allow(cmd client.Command) bool {
+	if s.limiter == nil || cmd.ID == client.CmdQuit || cmd.ID == client.CmdDisconnect {
+		return true
+	}
+	c := cmd.Client
+	name := c.Name
+	if name == "anonymous" {
+		name = ""
+	}
+
+	// file chunks are limited only by their class, a transfer sends many of them
+	err := s.limiter.Allow(c.Conn.RemoteAddr().String(), name, commandClass(cmd), cmd.ID != client.CmdFileChunk)
+	if err == nil {
+		return true
+	}
+	c.Log().WithError(err).Info("throttled command of client")
+	c.Err(err)
+
+	e, ok := err.(*ratelimit.Error)
+	if ok {
+		metrics.ThrottledCommands.WithLabelValues(e.Scope).Inc()
+		s.record(c, audit.EventRateLimited, map[string]string{"command": cmd.ID.String(), "scope": e.Scope, "disconnected": strconv.FormatBool(e.Abuse)})
+	}
+	if ok && e.Abuse {
+		c.Log().Info("disconnecting abusive client")
+		s.limiter.Ban(host(c.Conn))
+		s.disconnect(c)
+	}
+	return false
+}
+
+// disconnect removes a client from the server and closes its connection,
+// its session ends so it can not be resumed and its file transfers are dropped
+func (s *server) disconnect(c *client.Client) {
+	contact, ok := s.contacts[c.Name]
+	s.endSession(c)
+	s.remove(c)
+	if ok && contact == c {
+		s.dropTransfers(c.Name)
+	}
+}
+
+// remove removes a client from the server and closes its connection
+func (s *server) remove(c *client.Client) {
+	if contact, ok := s.contacts[c.Name]; ok && contact == c {
+		delete(s.contacts, c.Name)
+		s.notifyUser(c, webhook.EventDisconnect)
+	}
+	if s.limiter != nil {
+		s.limiter.Forget(c.Conn.RemoteAddr().String())
+	}
+	c.Conn.Close()
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLogs writes all logs as JSON to the returned buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	level := logrus.GetLevel()
+	logrus.SetOutput(&buf)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	logrus.SetLevel(logrus.TraceLevel)
+	t.Cleanup(func() {
+		logrus.SetOutput(os.Stderr)
+		logrus.SetFormatter(&logrus.TextFormatter{})
+		logrus.SetLevel(level)
+	})
+	return &buf
+}
+
+func TestServer_logContext(t *testing.T) {
+	s, _ := newTestServer(t)
+	sender, _, _ := newTestClient(t, s, "Test")
+	sender.ID = 7
+	_, _, lines := newTestClient(t, s, "Test2")
+	sender.Contact = "Test2"
+	buf := captureLogs(t)
+
+	s.msg(context.Background(), sender, []string{"/msg", "very", "secret", "text"})
+	expectLine(t, lines, "> Test : [#1] very secret text\n")
+
+	// bodies are never logged, whatever the level is
+	assert.NotContains(t, buf.String(), "secret")
+
+	var entry map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["msg"] == "sending message" {
+			break
+		}
+	}
+	assert.Equal(t, "sending message", entry["msg"])
+	assert.Equal(t, float64(7), entry["conn_id"])
+	assert.Equal(t, "pipe", entry["remote_addr"])
+	assert.Equal(t, "Test", entry["user"])
+	assert.Equal(t, "Test2", entry["to"])
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+	"github.com/Selahattinn/picus-tcp-message/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// scrapeMetric returns the value of a sample from the metrics endpoint, 0 when missing
+func scrapeMetric(t *testing.T, sample string) float64 {
+	srv := httptest.NewServer(metrics.Handler())
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, sample+" ") {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(line, sample+" "), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return value
+		}
+	}
+	return 0
+}
+
+func TestServer_metrics(t *testing.T) {
+	s, _ := newTestServer(t)
+	sender, _, senderLines := newTestClient(t, s, "Test")
+	_, _, lines := newTestClient(t, s, "Test2")
+	sender.Contact = "Test2"
+
+	commands := scrapeMetric(t, `tcp_message_commands_total{command="msg"}`)
+	lists := scrapeMetric(t, `tcp_message_commands_total{command="list"}`)
+	messages := scrapeMetric(t, "tcp_message_messages_total")
+	encrypts := scrapeMetric(t, `tcp_message_crypto_duration_seconds_count{operation="encrypt"}`)
+	decrypts := scrapeMetric(t, `tcp_message_crypto_duration_seconds_count{operation="decrypt"}`)
+
+	s.handle(client.Command{ID: client.CmdMsg, Client: sender, Args: []string{"/msg", "Test", "Text"}})
+	expectLine(t, lines, "> Test : [#1] Test Text\n")
+	s.handle(client.Command{ID: client.CmdList, Client: sender})
+	expectLine(t, senderLines, "> available users: Test2\n")
+
+	assert.Equal(t, commands+1, scrapeMetric(t, `tcp_message_commands_total{command="msg"}`))
+	assert.Equal(t, lists+1, scrapeMetric(t, `tcp_message_commands_total{command="list"}`))
+	assert.Equal(t, messages+1, scrapeMetric(t, "tcp_message_messages_total"))
+	assert.Equal(t, encrypts+1, scrapeMetric(t, `tcp_message_crypto_duration_seconds_count{operation="encrypt"}`))
+	assert.Equal(t, decrypts+1, scrapeMetric(t, `tcp_message_crypto_duration_seconds_count{operation="decrypt"}`))
+}
+
+func TestServer_metricsThrottled(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.limiter = ratelimit.NewLimiter(ratelimit.Config{
+		Commands: map[string]ratelimit.Limit{
+			classQuery: {Rate: 0.001, Burst: 1},
+		},
+	})
+	c, _, lines := newTestClient(t, s, "Test")
+
+	throttled := scrapeMetric(t, `tcp_message_throttled_commands_total{scope="query"}`)
+
+	s.handle(client.Command{ID: client.CmdList, Client: c})
+	expectLine(t, lines, "> available users: \n")
+	s.handle(client.Command{ID: client.CmdList, Client: c})
+	expectLine(t, lines, "err: rate limit exceeded for query, slow down\n")
+
+	assert.Equal(t, throttled+1, scrapeMetric(t, `tcp_message_throttled_commands_total{scope="query"}`))
+}
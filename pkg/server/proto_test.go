@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+)
+
+func TestServer_protoJSON(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	other, _, otherLines := newTestClient(t, s, "Test2")
+	other.Contact = "Test"
+
+	s.proto(c, []string{"/proto", "json"})
+	expectLine(t, lines, `{"type":"proto","text":"json"}`+"\n")
+
+	// answers of a request carry its id
+	s.handle(client.Command{ID: client.CmdList, Client: c, RequestID: 7})
+	expectLine(t, lines, `{"type":"users","id":7,"users":["Test2"]}`+"\n")
+	expectLine(t, lines, `{"type":"done","id":7}`+"\n")
+
+	s.handle(client.Command{ID: client.CmdGetMessageFromMe, Client: c, RequestID: 8})
+	expectLine(t, lines, `{"type":"messages","id":8,"text":"You haven't sent a message yet. Now it's time to talk to someone"}`+"\n")
+	expectLine(t, lines, `{"type":"done","id":8}`+"\n")
+
+	// messages of other users do not
+	s.msg(context.Background(), other, []string{"/msg", "Hello"})
+	expectLine(t, lines, `{"type":"message","text":"Hello","from":"Test2","message_id":1}`+"\n")
+
+	// the text protocol is unchanged
+	s.proto(c, []string{"/proto", "text"})
+	expectLine(t, lines, "> protocol text\n")
+	s.list(context.Background(), c)
+	expectLine(t, lines, "> available users: Test2\n")
+	s.proto(c, []string{"/proto", "xml"})
+	expectLine(t, lines, "> Comand Error: \n")
+	expectNoLine(t, otherLines)
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/retention"
+)
+
+// startRetention starts purging messages by the retention policies, if they are configured
+func (s *server) startRetention() {
+	if s.Config.Retention == nil {
+		return
+	}
+	s.retention = retention.NewPurger(s.Service.GetMessageService(), *s.Config.Retention)
+	interval := s.Config.Retention.Interval
+	if interval <= 0 {
+		interval = retention.DefaultInterval
+	}
+	go s.runEvery(interval, s.purge)
+}
+
+// purge deletes the messages which the retention policies do not keep at now :
+// it is not limited in time, since large purges run in batches with pauses
+func (s *server) purge(now time.Time) {
+	s.retention.Purge(context.Background(), now)
+}
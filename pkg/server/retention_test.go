@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/retention"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_purge(t *testing.T) {
+	s, repo := newTestServer(t)
+	s.Config.Retention = &retention.Config{
+		BatchPause: time.Millisecond,
+		Policy:     retention.Policy{MaxAge: 24 * time.Hour},
+		Rooms:      []retention.RoomPolicy{{Users: []string{"Test2", "Test"}, Policy: retention.Policy{MaxCount: 1}}},
+	}
+	s.retention = retention.NewPurger(s.Service.GetMessageService(), *s.Config.Retention)
+	go s.dispatch()
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	repo.Messages.Store(context.Background(), model.Message{From: "Test", To: "Test2", Text: "Old", CreatedAt: old})
+	repo.Messages.Store(context.Background(), model.Message{From: "Test2", To: "Test", Text: "Last", CreatedAt: old})
+	repo.Messages.Store(context.Background(), model.Message{From: "Test", To: "Test3", Text: "Gone", CreatedAt: old})
+	repo.Messages.Store(context.Background(), model.Message{From: "Test3", To: "Test", Text: "New", CreatedAt: now})
+
+	s.purge(now)
+	if assert.Len(t, repo.Messages.All(), 2) {
+		assert.Equal(t, "Last", repo.Messages.All()[0].Text)
+		assert.Equal(t, "New", repo.Messages.All()[1].Text)
+	}
+
+	// a reload replaces the policies of the running purger
+	cfg := *s.Config
+	cfg.Retention = &retention.Config{Policy: retention.Policy{MaxCount: 1}}
+	_, err := s.Reload(&cfg)
+	assert.NoError(t, err)
+	s.purge(now)
+	if assert.Len(t, repo.Messages.All(), 1) {
+		assert.Equal(t, "New", repo.Messages.All()[0].Text)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/sirupsen/logrus"
+)
+
+// defaults of scheduled messages
+const (
+	// interval between checks for scheduled messages which are due
+	scheduleInterval = time.Second
+
+	// number of scheduled messages sent per check
+	scheduleBatchSize = 100
+
+	// time the dispatcher has to send a scheduled message, and a check has to find them
+	scheduleTimeout = 5 * time.Second
+)
+
+// parseScheduleTime returns the time of a scheduled message : a duration like 10m or 1h30m,
+// a time like 2021-03-01T15:04:05Z, or a clock time like 15:04 which is the next one after now
+func parseScheduleTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("%s is not in the future", value)
+		}
+		return now.Add(d), nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		if !at.After(now) {
+			return time.Time{}, fmt.Errorf("%s is not in the future", value)
+		}
+		return at, nil
+	}
+	if clock, err := time.ParseInLocation("15:04", value, now.Location()); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	return time.Time{}, fmt.Errorf("%s is not a time or a duration", value)
+}
+
+// function to send a message to the contact later :
+// /schedule <time|duration> <text>
+func (s *server) schedule(ctx context.Context, c *client.Client, args []string) {
+	if len(args) < 3 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/schedule 10m Hello\n/schedule 15:04 Hello\n/schedule 2021-03-01T15:04:05Z Hello")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	if c.Contact == "" {
+		c.Msg(c, "no one hears you. use '/join' command to select who you want to send the message to.")
+		return
+	}
+	at, err := parseScheduleTime(args[1], time.Now())
+	if err != nil {
+		c.Msg(c, "Comand Error: "+err.Error()+"\nCorrect Comamnd Example\n\n/schedule 10m Hello\n/schedule 15:04 Hello\n/schedule 2021-03-01T15:04:05Z Hello")
+		return
+	}
+
+	m := model.ScheduledMessage{
+		From: c.Name,
+		To:   c.Contact,
+		Text: strings.Join(args[2:], " "),
+		At:   at,
+	}
+	m.ID, err = s.Service.GetScheduleService().Schedule(ctx, m)
+	if err != nil {
+		c.Log().WithError(err).Info("Scheduled message not saved to db")
+		c.Msg(c, "Message could not be scheduled. Please try again.")
+		return
+	}
+	c.Log().WithField("to", m.To).Info("message scheduled")
+	c.Msg(c, fmt.Sprintf("scheduled message #%d to %s will be sent at %s", m.ID, m.To, m.At.Format(time.RFC3339)))
+}
+
+// function to list the scheduled messages of the client
+func (s *server) scheduled(ctx context.Context, c *client.Client) {
+	messages, err := s.Service.GetScheduleService().GetScheduled(ctx, c.Name)
+	if err != nil {
+		c.Log().WithError(err).Info("Scheduled messages error")
+		c.Msg(c, "Scheduled messages could not be listed. Please try again.")
+		return
+	}
+	if len(messages) == 0 {
+		c.Msg(c, "You have no scheduled messages.")
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d scheduled messages\n", len(messages))
+	for _, m := range messages {
+		b.WriteString(m.ToString() + "\n")
+	}
+	c.Msg(c, b.String())
+}
+
+// function to cancel a scheduled message :
+// /unschedule <id>
+func (s *server) unschedule(ctx context.Context, c *client.Client, args []string) {
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/unschedule 10")
+		return
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/unschedule 10")
+		return
+	}
+	found, err := s.Service.GetScheduleService().Cancel(ctx, id, c.Name)
+	if err != nil {
+		c.Log().WithError(err).Info("Unschedule error")
+		c.Msg(c, "Scheduled message could not be canceled. Please try again.")
+		return
+	}
+	if !found {
+		c.Msg(c, "No such scheduled message exists.")
+		return
+	}
+	c.Msg(c, fmt.Sprintf("scheduled message #%d is canceled", id))
+}
+
+// sendDue sends the scheduled messages which are due at now,
+// they are sent by the dispatcher which owns the clients
+func (s *server) sendDue(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), scheduleTimeout)
+	defer cancel()
+	due, err := s.Service.GetScheduleService().Due(ctx, now, scheduleBatchSize)
+	if err != nil {
+		logrus.WithError(err).Error("unable to get scheduled messages")
+		return
+	}
+	for _, m := range due {
+		m := m
+		err := s.do(func() {
+			ctx, cancel := s.deadline()
+			defer cancel()
+			s.sendScheduled(ctx, m)
+		}, scheduleTimeout)
+		if err != nil {
+			logrus.WithError(err).Info("unable to send scheduled messages")
+			return
+		}
+	}
+}
+
+// sendScheduled sends a scheduled message like /msg, unless it was canceled meanwhile :
+// it is removed first, so it is sent at most once
+func (s *server) sendScheduled(ctx context.Context, m model.ScheduledMessage) {
+	found, err := s.Service.GetScheduleService().Cancel(ctx, m.ID, m.From)
+	if err != nil {
+		logrus.WithError(err).WithField("user", m.From).Error("unable to remove scheduled message")
+		return
+	}
+	if !found {
+		return
+	}
+	// the client of the sender is used when it is online, so it is told about failures
+	s.send(ctx, s.contacts[m.From], model.Message{From: m.From, To: m.To, Text: m.Text})
+}
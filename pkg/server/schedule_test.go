@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScheduleTime(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"10m", now.Add(10 * time.Minute)},
+		{"1h30m", now.Add(90 * time.Minute)},
+		{"2021-03-02T08:00:00Z", time.Date(2021, 3, 2, 8, 0, 0, 0, time.UTC)},
+		{"15:04", time.Date(2021, 3, 1, 15, 4, 0, 0, time.UTC)},
+		// clock times which passed today are tomorrow
+		{"08:00", time.Date(2021, 3, 2, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		at, err := parseScheduleTime(tt.value, now)
+		assert.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, at, tt.value)
+	}
+
+	for _, value := range []string{"-5m", "0s", "2021-03-01T12:00:00Z", "tomorrow", "25:00"} {
+		_, err := parseScheduleTime(value, now)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestServer_schedule(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	newTestClient(t, s, "Test2")
+
+	s.schedule(context.Background(), c, []string{"/schedule", "10m", "Hello"})
+	expectLine(t, lines, "> no one hears you. use '/join' command to select who you want to send the message to.\n")
+
+	c.Contact = "Test2"
+	s.schedule(context.Background(), c, []string{"/schedule", "later", "Hello"})
+	expectLine(t, lines, "> Comand Error: later is not a time or a duration\n")
+	expectLine(t, lines, "Correct Comamnd Example\n")
+	expectLine(t, lines, "\n")
+	expectLine(t, lines, "/schedule 10m Hello\n")
+	expectLine(t, lines, "/schedule 15:04 Hello\n")
+	expectLine(t, lines, "/schedule 2021-03-01T15:04:05Z Hello\n")
+
+	s.scheduled(context.Background(), c)
+	expectLine(t, lines, "> You have no scheduled messages.\n")
+
+	s.schedule(context.Background(), c, []string{"/schedule", "2h", "Good", "night"})
+	s.schedule(context.Background(), c, []string{"/schedule", "1h", "Hello"})
+	<-lines
+	<-lines
+	if assert.Len(t, repo.Schedule.All(), 2) {
+		m := repo.Schedule.All()[0]
+		assert.Equal(t, "Test", m.From)
+		assert.Equal(t, "Test2", m.To)
+		assert.Equal(t, "Good night", m.Text)
+		assert.WithinDuration(t, time.Now().Add(2*time.Hour), m.At, time.Minute)
+	}
+
+	s.scheduled(context.Background(), c)
+	expectLine(t, lines, "> 2 scheduled messages\n")
+	expectLine(t, lines, "#2 to Test2 at "+repo.Schedule.All()[1].At.Format(time.RFC3339)+": Hello\n")
+	expectLine(t, lines, "#1 to Test2 at "+repo.Schedule.All()[0].At.Format(time.RFC3339)+": Good night\n")
+	expectLine(t, lines, "\n")
+
+	s.unschedule(context.Background(), c, []string{"/unschedule", "1"})
+	expectLine(t, lines, "> scheduled message #1 is canceled\n")
+	s.unschedule(context.Background(), c, []string{"/unschedule", "1"})
+	expectLine(t, lines, "> No such scheduled message exists.\n")
+
+	// only the sender can cancel a message
+	other, _, otherLines := newTestClient(t, s, "Test3")
+	s.unschedule(context.Background(), other, []string{"/unschedule", "2"})
+	expectLine(t, otherLines, "> No such scheduled message exists.\n")
+	assert.Len(t, repo.Schedule.All(), 1)
+}
+
+func TestServer_sendScheduled(t *testing.T) {
+	s, repo := newTestServer(t)
+	newTestClient(t, s, "Test")
+	_, _, lines := newTestClient(t, s, "Test2")
+
+	repo.Schedule.Store(context.Background(), model.ScheduledMessage{From: "Test", To: "Test2", Text: "Hello", At: time.Now()})
+	repo.Schedule.Store(context.Background(), model.ScheduledMessage{From: "Test", To: "Test2", Text: "Later", At: time.Now().Add(time.Hour)})
+	due, err := s.Service.GetScheduleService().Due(context.Background(), time.Now(), scheduleBatchSize)
+	assert.NoError(t, err)
+	if !assert.Len(t, due, 1) {
+		return
+	}
+
+	s.sendScheduled(context.Background(), due[0])
+	expectLine(t, lines, "> Test : [#1] Hello\n")
+	assert.Equal(t, []model.Message{{ID: 1, From: "Test", To: "Test2", Text: "Hello"}}, repo.Messages.All())
+	assert.Len(t, repo.Schedule.All(), 1)
+
+	// a message is sent once
+	s.sendScheduled(context.Background(), due[0])
+	expectNoLine(t, lines)
+}
+
+func TestServer_sendScheduledOffline(t *testing.T) {
+	s, repo := newTestServer(t)
+	recipient, _, lines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), recipient, []string{"/name", "Test2"})
+	nameTestClient(t, s, lines, "Test2")
+
+	// the sender is offline, the recipient is reconnecting
+	s.detach(recipient)
+	id, _ := repo.Schedule.Store(context.Background(), model.ScheduledMessage{From: "Test", To: "Test2", Text: "Hello", At: time.Now()})
+	s.sendScheduled(context.Background(), model.ScheduledMessage{ID: id, From: "Test", To: "Test2", Text: "Hello"})
+
+	sess, ok := s.detachedSession("Test2")
+	if assert.True(t, ok) && assert.Len(t, sess.pending, 1) {
+		assert.Equal(t, "Test", sess.pending[0].From)
+		assert.Equal(t, "Hello", sess.pending[0].Text)
+	}
+	assert.Len(t, repo.Messages.All(), 1)
+
+	// canceled messages are not sent, the recipient writes messages of offline senders itself
+	_, _, onlineLines := newTestClient(t, s, "Test3")
+	s.sendScheduled(context.Background(), model.ScheduledMessage{ID: id, From: "Test", To: "Test3", Text: "Hi"})
+	expectNoLine(t, onlineLines)
+	id, _ = repo.Schedule.Store(context.Background(), model.ScheduledMessage{From: "Test", To: "Test3", Text: "Hi", At: time.Now()})
+	s.sendScheduled(context.Background(), model.ScheduledMessage{ID: id, From: "Test", To: "Test3", Text: "Hi"})
+	expectLine(t, onlineLines, "> Test : [#2] Hi\n")
+}
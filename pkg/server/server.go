@@ -1,34 +1,124 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
 	"github.com/Selahattinn/picus-tcp-message/pkg/client"
 	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/logging"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
 	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/ratelimit"
 	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/message"
+	"github.com/Selahattinn/picus-tcp-message/pkg/retention"
 	"github.com/Selahattinn/picus-tcp-message/pkg/service"
+	"github.com/Selahattinn/picus-tcp-message/pkg/webhook"
 	"github.com/sirupsen/logrus"
 )
 
+// quoteLength is the number of characters of a parent message quoted in replies
+const quoteLength = 30
+
+// defaultQueueSize is used when queue_size is not configured
+const defaultQueueSize = 64
+
 type Config struct {
 	// Host adress which server run
 	ListenAddress string `yaml:"host"`
 
+	// Maximum size of a file which can be sent between clients in bytes
+	MaxFileSize int64 `yaml:"max_file_size"`
+
+	// Maximum size of a line sent by a client in bytes
+	MaxLineSize int `yaml:"max_line_size"`
+
+	// Time a client can stay idle before it is disconnected
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+
+	// Time a write to a client can take before it is disconnected
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// Number of commands which can wait for the dispatcher
+	QueueSize int `yaml:"queue_size"`
+
+	// Time a command can take, its queries to the repository are canceled after it
+	CommandTimeout time.Duration `yaml:"command_timeout"`
+
+	// Message shown to clients when they connect
+	MOTD string `yaml:"motd"`
+
+	// Time a client whose connection dropped can resume its session
+	SessionGracePeriod time.Duration `yaml:"session_grace_period"`
+
+	// Number of messages kept for a client until it resumes its session
+	SessionMaxPending int `yaml:"session_max_pending"`
+
+	// Time between pings sent to a client
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+
+	// Time a client can stay silent, pongs included, before it is evicted
+	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout"`
+
+	// Users which can authenticate as admin
+	Admins []AdminConfig `yaml:"admins"`
+
+	// Logging configs
+	Log *logging.Config `yaml:"log"`
+
+	// TLS configs, clients are served without TLS when empty
+	TLS *TLSConfig `yaml:"tls"`
+
+	// Audit log configs, security events are not recorded when empty
+	Audit *AuditConfig `yaml:"audit"`
+
+	// HTTP endpoint configs, disabled when empty
+	HTTP *HTTPConfig `yaml:"http"`
+
+	// Rate limits of commands, no limit is applied when empty
+	RateLimit *ratelimit.Config `yaml:"rate_limit"`
+
+	// URLs which events like sent messages are posted to
+	Webhooks []webhook.Config `yaml:"webhooks"`
+
+	// Policies which purge old messages, messages are kept forever when empty
+	Retention *retention.Config `yaml:"retention"`
+
 	// Service configs
 	Service *service.Config `yaml:"service"`
 	// DB configs
 	DB *repository.MySQLConfig `yaml:"database"`
 }
 
+// AdminConfig defines a user which can use admin commands
+type AdminConfig struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+}
+
+// HTTPConfig defines the HTTP endpoint serving metrics and health checks
+type HTTPConfig struct {
+	// Host adress which HTTP endpoint run
+	ListenAddress string `yaml:"host"`
+}
+
 // structure of server
 type server struct {
 
+	// id of the last connection, accessed atomically :
+	// kept first so it is 64-bit aligned on 32-bit platforms
+	nextConnID uint64
+
 	// map of clients connected to the server :
 	// client name (key) & client (value)
 	contacts map[string]*client.Client
@@ -36,21 +126,73 @@ type server struct {
 	// channel on which server receives commands from clients
 	commands chan client.Command
 
+	// sessions of named clients : client name (key) & session (value)
+	sessions map[string]*session
+
+	// file transfers in progress : transfer id (key) & transfer (value)
+	transfers      map[int64]*transfer
+	nextTransferID int64
+
+	// rate limiter of commands, nil when rate limiting is disabled
+	limiter *ratelimit.Limiter
+
+	// functions executed by the dispatcher, used by parts of the server
+	// which need to access clients from another go routine
+	tasks chan func()
+
+	// closed by Shutdown : the dispatcher returns and tasks are refused
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// lifecycle state of the server, accessed atomically
+	state int32
+
+	// time readiness checks and shutdown can take
+	probeTimeout time.Duration
+
 	// Service Part
 	Service service.Service
 
+	// audit log of security events, nothing is recorded when nil
+	Audit *audit.Log
+
+	// posts events to webhooks, nil until the server runs
+	webhooks *webhook.Dispatcher
+
+	// purges messages by retention policies, nil when they are not configured
+	retention *retention.Purger
+
 	// Yaml Config
 	Config *Config
+
+	// guards settings of Config which are reloaded and read
+	// outside of the dispatcher
+	configMu sync.RWMutex
 }
 
 // function to instantiate new server
 func NewServer(cfg *Config) *server {
 
-	return &server{
-		contacts: make(map[string]*client.Client),
-		commands: make(chan client.Command),
-		Config:   cfg,
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
+	s := &server{
+		contacts:  make(map[string]*client.Client),
+		commands:  make(chan client.Command, queueSize),
+		sessions:  make(map[string]*session),
+		transfers: make(map[int64]*transfer),
+		tasks:     make(chan func()),
+		stop:      make(chan struct{}),
+		Config:    cfg,
+
+		probeTimeout: probeTimeout,
+	}
+	if cfg.RateLimit != nil {
+		s.limiter = ratelimit.NewLimiter(*cfg.RateLimit)
 	}
+	return s
 }
 
 // function to run server
@@ -62,99 +204,310 @@ func (s *server) Run() {
 		logrus.WithError(err).Fatal("Could not create mysql repository")
 	}
 
+	s.RunWith(repo)
+}
+
+// RunWith runs the server on a repository, like an in memory one in tests
+func (s *server) RunWith(repo repository.Repository) {
+	var err error
 	s.Service, err = service.NewProvider(s.Config.Service, repo)
 	if err != nil {
 		logrus.WithError(err).Fatal("Could not create service provider")
 	}
+	s.startWebhooks()
+	go s.runEvery(scheduleInterval, s.sendDue)
+	go s.runEvery(reapInterval, s.reapExpired)
+	s.startRetention()
 
 	logrus.Info("running server...")
+	s.setState(stateRunning)
+	s.dispatch()
+}
 
-	// loop through incoming commands..
-	for cmd := range s.commands {
-		// based on the command id, execute desired functions
-		switch cmd.ID {
-		case client.CmdName:
-			// update client name to input
-			s.name(cmd.Client, cmd.Args)
-		case client.CmdJoin:
-			// update client contact to input
-			s.join(cmd.Client, cmd.Args)
-		case client.CmdList:
-			// return list of users (clients) connected to the server
-			s.list(cmd.Client)
-		case client.CmdMsg:
-			// send input to client contact
-			s.msg(cmd.Client, cmd.Args)
-		case client.CmdQuit:
-			// quit chat system
-			s.quit(cmd.Client)
-		case client.CmdHelp:
-			// return command list
-			s.help(cmd.Client)
-		case client.CmdGetMessageFromMe:
-			// return Messages with limited from itself
-			s.getMessageFromMe(cmd.Client, cmd.Args)
-		case client.CmdGetMessageToMe:
-			// return Messages with limited from itself
-			s.getMessageToMe(cmd.Client, cmd.Args)
-		case client.CmdGetLast:
-			// return Messages with limited from itself
-			s.getLastMassge(cmd.Client, cmd.Args)
-		case client.CmdGetContains:
-			// return Messages with limited from itself
-			s.getContains(cmd.Client, cmd.Args)
+// runEvery calls fn with the current time at each interval, until the server stops
+func (s *server) runEvery(interval time.Duration, fn func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.getState() == stateStopping {
+			return
 		}
+		fn(time.Now())
+	}
+}
+
+// function to process commands and tasks until the server stops
+func (s *server) dispatch() {
+	metrics.SetQueueDepthFunc(func() int { return len(s.commands) })
+
+	// loop through incoming commands until the server shuts down
+	for {
+		select {
+		case cmd := <-s.commands:
+			s.handle(cmd)
+		case task := <-s.tasks:
+			task()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// function to execute a command
+func (s *server) handle(cmd client.Command) {
+	// answers of a request carry its id and end with a done event
+	if cmd.RequestID != 0 {
+		cmd.Client.SetRequest(cmd.RequestID)
+		defer func() {
+			cmd.Client.SetRequest(0)
+			cmd.Client.Send(cmd.Client, client.Event{Type: client.EventDone, ID: cmd.RequestID})
+		}()
+	}
+
+	// drop commands over the rate limits
+	if !s.allow(cmd) {
+		return
+	}
+	metrics.Commands.WithLabelValues(cmd.ID.String()).Inc()
+	cmd.Client.LastActive = time.Now()
+
+	// queries of the command are canceled when it runs out of time
+	ctx, cancel := s.deadline()
+	defer cancel()
+	defer s.timedOut(ctx, cmd)
 
+	// based on the command id, execute desired functions
+	switch cmd.ID {
+	case client.CmdName:
+		// update client name to input
+		s.name(ctx, cmd.Client, cmd.Args)
+	case client.CmdJoin:
+		// update client contact to input
+		s.join(cmd.Client, cmd.Args)
+	case client.CmdList:
+		// return list of users (clients) connected to the server
+		s.list(ctx, cmd.Client)
+	case client.CmdMsg:
+		// send input to client contact
+		s.msg(ctx, cmd.Client, cmd.Args)
+	case client.CmdQuit:
+		// quit chat system
+		s.quit(cmd.Client)
+	case client.CmdHelp:
+		// return command list
+		s.help(cmd.Client)
+	case client.CmdGetMessageFromMe:
+		// return Messages with limited from itself
+		s.getMessageFromMe(ctx, cmd.Client, cmd.Args)
+	case client.CmdGetMessageToMe:
+		// return Messages with limited from itself
+		s.getMessageToMe(ctx, cmd.Client, cmd.Args)
+	case client.CmdGetLast:
+		// return Messages with limited from itself
+		s.getLastMassge(ctx, cmd.Client, cmd.Args)
+	case client.CmdGetContains:
+		// return Messages with limited from itself
+		s.getContains(ctx, cmd.Client, cmd.Args)
+	case client.CmdReply:
+		// send a reply to a message
+		s.reply(ctx, cmd.Client, cmd.Args)
+	case client.CmdThread:
+		// return reply chain of a message
+		s.thread(ctx, cmd.Client, cmd.Args)
+	case client.CmdFileOffer:
+		// offer a file to client contact
+		s.fileOffer(ctx, cmd.Client, cmd.Args)
+	case client.CmdFileAccept:
+		// accept an offered file
+		s.fileAccept(cmd.Client, cmd.Args)
+	case client.CmdFileDecline:
+		// decline an offered file
+		s.fileDecline(cmd.Client, cmd.Args)
+	case client.CmdFileChunk:
+		// pass a part of a file to the recipient
+		s.fileChunk(cmd.Client, cmd.Args)
+	case client.CmdFileDone:
+		// complete a file transfer
+		s.fileDone(cmd.Client, cmd.Args)
+	case client.CmdBlock:
+		// stop receiving messages from a user
+		s.block(ctx, cmd.Client, cmd.Args)
+	case client.CmdUnblock:
+		// receive messages from a user again
+		s.unblock(ctx, cmd.Client, cmd.Args)
+	case client.CmdAdmin:
+		// authenticate as an admin
+		s.admin(cmd.Client, cmd.Args)
+	case client.CmdKick:
+		// disconnect a user
+		s.kick(cmd.Client, cmd.Args)
+	case client.CmdBan:
+		// ban a user or an address
+		s.ban(ctx, cmd.Client, cmd.Args)
+	case client.CmdUnban:
+		// lift a ban
+		s.unban(ctx, cmd.Client, cmd.Args)
+	case client.CmdBroadcast:
+		// send a message to all users
+		s.broadcast(cmd.Client, cmd.Args)
+	case client.CmdWho:
+		// return connection details of users
+		s.who(cmd.Client)
+	case client.CmdResume:
+		// resume a session from a new connection
+		s.resume(ctx, cmd.Client, cmd.Args)
+	case client.CmdProto:
+		// choose the protocol of the client
+		s.proto(cmd.Client, cmd.Args)
+	case client.CmdBot:
+		// mark the client as a bot
+		s.bot(cmd.Client, cmd.Args)
+	case client.CmdSchedule:
+		// send a message to the contact later
+		s.schedule(ctx, cmd.Client, cmd.Args)
+	case client.CmdScheduled:
+		// return scheduled messages of the client
+		s.scheduled(ctx, cmd.Client)
+	case client.CmdUnschedule:
+		// cancel a scheduled message
+		s.unschedule(ctx, cmd.Client, cmd.Args)
+	case client.CmdMsgTTL:
+		// send a message which expires
+		s.msgTTL(ctx, cmd.Client, cmd.Args)
+	case client.CmdTTL:
+		// show or set the time to live of the conversation
+		s.ttl(ctx, cmd.Client, cmd.Args)
+	case client.CmdExport:
+		// send the message history of the client as a file
+		s.export(cmd.Client, cmd.Args)
+	case client.CmdDisconnect:
+		// remove a client whose connection is closed, keeping its session
+		s.detach(cmd.Client)
+	case client.CmdUnknown:
+		// answer and audit a command which does not exist
+		s.unknown(cmd.Client, cmd.Args)
 	}
 }
 
 // function to instantiate new client :
 // called when a new client joins the server
-func (s *server) NewClient(conn net.Conn) {
+func (s *server) NewClient(conn net.Conn) error {
+	id := atomic.AddUint64(&s.nextConnID, 1)
+	log := logrus.WithFields(logrus.Fields{
+		"conn_id":     id,
+		"remote_addr": conn.RemoteAddr().String(),
+	})
+
+	// refuse clients which are disconnected for abuse
+	if s.limiter != nil && s.limiter.Banned(host(conn)) {
+		log.Info("refused banned client")
+		s.recordConn(id, conn, audit.EventRefused, map[string]string{"reason": "rate limit ban"})
+		conn.Write([]byte("err: too many requests, try again later\n"))
+		conn.Close()
+		return nil
+	}
+
+	// refuse banned addresses, bans can only be checked once the repository is connected
+	if s.getState() == stateRunning {
+		ctx, cancel := context.WithTimeout(context.Background(), s.commandTimeout())
+		ban, ok := s.activeBan(ctx, "", host(conn))
+		cancel()
+		if ok {
+			log.Info("refused banned client")
+			s.recordConn(id, conn, audit.EventRefused, map[string]string{"reason": "banned", "ban": ban.ToString()})
+			conn.Write([]byte("err: you are banned from this server: " + ban.ToString() + "\n"))
+			conn.Close()
+			return nil
+		}
+	}
 
 	// generate RSA keys
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		logrus.WithError(err).Fatal("RSA key generate fail in NewClient")
+		conn.Write([]byte("err: unable to create session, try again later\n"))
+		conn.Close()
+		return fmt.Errorf("RSA key generate fail in NewClient: %v", err)
 	}
 
 	// instantiate client
 	c := &client.Client{
 		Conn:     conn,
+		ID:       id,
 		Name:     "anonymous",
 		Commands: s.commands,
 		Contact:  "",
 		Private:  privateKey,
 		Public:   privateKey.PublicKey,
+
+		ConnectedAt: time.Now(),
+		LastActive:  time.Now(),
+
+		MaxLineSize:  s.Config.MaxLineSize,
+		ReadTimeout:  s.Config.ReadTimeout,
+		WriteTimeout: s.Config.WriteTimeout,
+	}
+	c.Log().Info("new client has joined")
+	s.record(c, audit.EventConnect, nil)
+	if motd := s.motd(); motd != "" {
+		c.Msg(c, motd)
 	}
-	logrus.Info("new client has joined : ", conn.RemoteAddr().String())
 
 	// start reading for input ( this is a blocking call on a separte go routine )
+	metrics.ConnectedClients.Inc()
+	defer metrics.ConnectedClients.Dec()
+	done := make(chan struct{})
+	go s.heartbeat(c, done)
 	c.ReadInput()
+	close(done)
+	s.record(c, audit.EventDisconnect, map[string]string{"duration": time.Since(c.ConnectedAt).Truncate(time.Second).String()})
+	return nil
 }
 
 // function to assign an identifer (name) to a newly created client
-func (s *server) name(c *client.Client, args []string) {
+func (s *server) name(ctx context.Context, c *client.Client, args []string) {
 	if len(args) > 2 || len(args) < 2 {
 		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/name Selahattin")
 		return
 	}
-	// assign name to client
-	c.Name = args[1]
+	name := args[1]
 
 	// update server guest list i.e currently connected users (clients)
 	// Control for client name
 	// Client name can not be equal to any clients name
-	for _, y := range s.contacts {
-		if y.Name == c.Name {
-			c.Msg(c, "There is a user which is used for this name. Please choose another name")
-			return
-		}
+	_, away := s.detachedSession(name)
+	if y, ok := s.contacts[name]; (ok && y != c) || away {
+		s.record(c, audit.EventNameRefused, map[string]string{"name": name, "reason": "taken"})
+		c.Msg(c, "There is a user which is used for this name. Please choose another name")
+		return
 	}
-	s.contacts[args[1]] = c
+
+	// banned users and addresses can not join with a name
+	if ban, ok := s.activeBan(ctx, name, host(c.Conn)); ok {
+		c.Log().Info("refused banned client")
+		s.record(c, audit.EventNameRefused, map[string]string{"name": name, "reason": "banned", "ban": ban.ToString()})
+		c.Msg(c, "You are banned from this server: "+ban.ToString())
+		s.disconnect(c)
+		return
+	}
+
+	// a renamed client is not known by its old name anymore
+	if y, ok := s.contacts[c.Name]; ok && y == c {
+		delete(s.contacts, c.Name)
+		c.Admin = false
+		s.dropTransfers(c.Name)
+		s.notifyUser(c, webhook.EventDisconnect)
+	}
+	s.endSession(c)
+
+	// assign name to client
+	s.record(c, audit.EventName, map[string]string{"name": name})
+	c.SetName(name)
+	s.contacts[name] = c
+	s.startSession(c, nil)
+	s.notifyUser(c, webhook.EventConnect)
 
 	// give user feedback message
-	c.Msg(c, fmt.Sprintf("you will be known as %s", args[1]))
+	c.Msg(c, fmt.Sprintf("you will be known as %s", name))
 }
 
 // function to assign contact ( who a client is currently talkig to ) :
@@ -163,8 +516,12 @@ func (s *server) join(c *client.Client, args []string) {
 		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/join Selahattin")
 		return
 	}
-	// check if a user for given name exists on the server contacts map
+	// check if a user for given name exists on the server contacts map,
+	// users which are reconnecting can be joined too
 	_, ok := s.contacts[args[1]]
+	if !ok {
+		_, ok = s.detachedSession(args[1])
+	}
 
 	// if so...
 	if ok && args[1] != "" {
@@ -184,91 +541,234 @@ func (s *server) join(c *client.Client, args []string) {
 
 // function to display list of connected users :
 // these clients are who you (a client) can join and then msg
-func (s *server) list(c *client.Client) {
+func (s *server) list(ctx context.Context, c *client.Client) {
 
 	var contacts []string
 
+	// users blocked by the client are not listed
+	hidden := make(map[string]bool)
+	blocked, err := s.Service.GetBlockService().GetBlocked(ctx, c.Name)
+	if err != nil {
+		c.Log().WithError(err).Info("unable to get blocked users")
+	}
+	for _, name := range blocked {
+		hidden[name] = true
+	}
+
 	// loop through available users
 	for name := range s.contacts {
 
 		// fetch all users except current client
-		if name != c.Name {
+		if name != c.Name && !hidden[name] {
+			if s.contacts[name].Bot {
+				name += " (bot)"
+			}
 			contacts = append(contacts, name)
 		}
 
 	}
 
+	// users which are reconnecting can still be messaged
+	s.expireSessions()
+	for name, sess := range s.sessions {
+		if sess.client == nil && !hidden[name] {
+			contacts = append(contacts, name+" (reconnecting)")
+		}
+	}
+
 	// pass message
-	c.Msg(c, fmt.Sprintf("available users: %s", strings.Join(contacts, ", ")))
+	c.Send(c, client.Event{Type: client.EventUsers, Users: contacts})
 }
 
 // function to pass a message to specified user (client)
-func (s *server) msg(c *client.Client, args []string) {
+func (s *server) msg(ctx context.Context, c *client.Client, args []string) {
 
-	// check if a user for given name exists on the server contacts map
+	// check if a user for given name exists on the server contacts map,
+	// messages of a user which is reconnecting are kept for its session
 	_, ok := s.contacts[c.Contact]
+	_, away := s.detachedSession(c.Contact)
 
 	// is so...
-	if ok && c.Contact != "" {
+	if (ok || away) && c.Contact != "" {
+
+		// send the entire mesage
+		s.send(ctx, c, model.Message{From: c.Name, To: c.Contact, Text: strings.Join(args[1:], " ")})
+	} else {
+
+		// otherwise, prompt user to join to a user
+		c.Msg(c, "no one hears you. follow below steps to get started :\n\n* use '/list' command to check, available users.\n* use '/join' command to select who you want to chat to.\n* use '/msg'  command to send message to selected user.\n")
+	}
 
-		// join the entire mesage
-		msg := strings.Join(args[1:], " ")
-		msg = c.Name + " : " + msg
+}
 
-		// fetch public key of recepient of message
-		publicKey := s.contacts[c.Contact].Public
+// send passes a message to its recipient, or keeps it for the session of a recipient
+// which is reconnecting, and stores it : c is the client of the sender,
+// nil when the sender is offline like for scheduled messages
+func (s *server) send(ctx context.Context, c *client.Client, m model.Message) {
+	log := senderLog(c, m.From).WithField("to", m.To)
+	recipient, ok := s.contacts[m.To]
+	sess, away := s.detachedSession(m.To)
 
-		// encrypt data
-		eMsg := crypto.Encrypt(msg, publicKey)
-		logrus.Info("encrypting messages... from client:", c.Name)
-
-		// send the message
-		c.Msg(s.contacts[c.Contact], eMsg)
-		logrus.Info("sending message to ", c.Contact)
-		message := &model.Message{
-			From: c.Name,
-			To:   c.Contact,
-			Text: strings.Join(args[1:], " "),
+	// messages of blocked senders are dropped without telling them
+	if s.blocked(ctx, m.To, m.From) {
+		log.Info("dropped message of a blocked user")
+		return
+	}
+
+	// messages expire with their conversation unless they have their own time to live
+	if m.ExpiresAt == nil {
+		m.ExpiresAt = s.conversationExpiry(ctx, m.From, m.To)
+	}
+
+	// the message is stored first, so its id is known to the recipient and can be expired
+	id, err := s.Service.GetMessageService().StoreMessage(ctx, m)
+	if err != nil {
+		log.WithError(err).Info("Message not saved to db")
+		// the sender is told to try again when the command ran out of time,
+		// so delivering the message would send it twice
+		if ctx.Err() != nil {
+			return
 		}
+		id = 0
+	}
 
-		_, err := s.Service.GetMessageService().StoreMessage(*message)
-		if err != nil {
-			logrus.WithError(err).Info("Message not saved to db")
+	// send the message, or keep it until the recipient resumes
+	msg := client.Event{Type: client.EventMessage, From: m.From, Text: m.Text, MessageID: id, ExpiresAt: m.ExpiresAt}
+	if ok {
+		if err := s.deliver(c, recipient, msg); err != nil {
+			sess, away = s.detachedSession(m.To)
+			if !away {
+				if c != nil {
+					c.Msg(c, fmt.Sprintf("message could not be delivered to %s", m.To))
+				}
+				s.unstore(ctx, log, id)
+				return
+			}
 		}
+	}
+	if away {
+		s.queue(sess, msg)
+	}
+	log.Info("sending message")
+	metrics.Messages.Inc()
+	metrics.MessageBytes.Add(float64(len(msg.Text)))
+	s.notify(webhook.Event{Type: webhook.EventMessage, User: m.From, To: m.To, Text: m.Text, MessageID: id})
+}
+
+// unstore removes a stored message which was not delivered
+func (s *server) unstore(ctx context.Context, log *logrus.Entry, id int64) {
+	if id == 0 {
+		return
+	}
+	if _, err := s.Service.GetMessageService().DeleteMessages(ctx, []int64{id}); err != nil {
+		log.WithError(err).Info("Message not removed from db")
+	}
+}
+
+// senderLog returns the log entry of a sender, which may be offline
+func senderLog(c *client.Client, name string) *logrus.Entry {
+	if c != nil {
+		return c.Log()
+	}
+	return logrus.WithField("user", name)
+}
+
+// deliver encrypts the text of an event with the public key of the recipient and sends it :
+// a recipient whose connection is broken is removed from the server,
+// the error is returned so only the sender is told about it.
+// c is nil when the sender is offline, then the text is written to the recipient as it is
+func (s *server) deliver(c *client.Client, recipient *client.Client, e client.Event) error {
+
+	sender := c
+	if sender == nil {
+		// an offline sender has no key pair, the recipient writes the message itself
+		sender = recipient
 	} else {
 
-		// otherwise, prompt user to join to a user
-		c.Msg(c, "no one hears you. follow below steps to get started :\n\n* use '/list' command to check, available users.\n* use '/join' command to select who you want to chat to.\n* use '/msg'  command to send message to selected user.\n")
+		// encrypt data
+		start := time.Now()
+		eMsg, err := crypto.Encrypt(e.Text, recipient.Public)
+		metrics.ObserveCrypto("encrypt", start)
+		if err != nil {
+			c.Log().WithError(err).Info("unable to encrypt message")
+			return err
+		}
+		c.Log().Debug("encrypting messages...")
+		e.Text = eMsg
 	}
 
+	err := sender.Send(recipient, e)
+	if err != nil {
+		recipient.Log().WithError(err).Info("unable to deliver message")
+		s.detach(recipient)
+		return err
+	}
+	return nil
 }
 
 // function to exit from chat
 func (s *server) quit(c *client.Client) {
-	logrus.Info("client has left the chat: ", c.Conn.RemoteAddr().String())
-
-	// remove user from server contact list
-	_, ok := s.contacts[c.Name]
-	if ok {
-		delete(s.contacts, c.Name)
-	}
+	c.Log().Info("client has left the chat")
 
 	// pass message
 	c.Msg(c, "We will miss you...")
-	// close client connection
-	c.Conn.Close()
+
+	// remove user from server contact list and close client connection
+	s.disconnect(c)
+}
+
+// function to choose the protocol of the client :
+// /proto json
+// answers are sent in the new protocol, starting with the confirmation
+func (s *server) proto(c *client.Client, args []string) {
+	if len(args) != 2 || (args[1] != client.ProtoText && args[1] != client.ProtoJSON) {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/proto json")
+		return
+	}
+	c.SetProto(args[1])
+	c.Send(c, client.Event{Type: client.EventProto, Text: args[1]})
+}
+
+// function to mark a client as a bot :
+// bots are shown as "<name> (bot)" in /list, so users know they talk to a program
+func (s *server) bot(c *client.Client, args []string) {
+	if len(args) != 1 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/bot")
+		return
+	}
+	c.Bot = true
+	c.Log().Info("client is marked as a bot")
+	c.Msg(c, "You are marked as a bot")
 }
 
 // function to return command list
 func (s *server) help(c *client.Client) {
 
 	// pass message
-	c.Msg(c, "Picus Chat Platform\n\n Usage : /<command> [arguments]\n\n* name : Specify your name.\n* list : List connected users.\n* join : Specify message recepient.\n* msg  : Send message to recepient.\n* quit : Exit Chat App.\n* help : List help commands.\n* get-last : List of last sended messages.\n* get-contains : List of messages which is include this word.\n* get-m-to-me : lists all messages sent to me.\n* get-m-from-me : Lists all the messages I've sent.\n")
+	c.Msg(c, "Picus Chat Platform\n\n Usage : /<command> [arguments]\n\n* name : Specify your name.\n* list : List connected users.\n* join : Specify message recepient.\n* msg  : Send message to recepient.\n* msg-ttl : Send message to recepient which is deleted after a duration.\n* ttl : Show or set the time to live of messages with recepient.\n* quit : Exit Chat App.\n* help : List help commands.\n* get-last : List of last sended messages.\n* get-contains : List of messages which is include this word.\n* get-m-to-me : lists all messages sent to me.\n* get-m-from-me : Lists all the messages I've sent.\n* reply : Reply to a message with its id.\n* schedule : Send a message to recepient later, after a duration or at a time.\n* scheduled : List your scheduled messages.\n* unschedule : Cancel a scheduled message.\n* thread : List the reply chain of a message.\n* export : Download your message history as a file, jsonl or csv.\n* file-accept : Accept an offered file.\n* file-decline : Decline an offered file.\n* resume : Resume a dropped session with its token.\n* proto : Choose the protocol, text or json.\n* bot : Mark yourself as a bot in the user list.\n* block : Stop receiving messages from a user.\n* unblock : Receive messages from a blocked user again.\n* admin : Authenticate as admin.\n")
+	if c.Admin {
+		c.Msg(c, "Admin commands\n\n* kick : Disconnect a user.\n* ban : Ban a user or an ip address, optionally for a duration.\n* unban : Lift a ban.\n* broadcast : Send a message to all users.\n* who : List connection details of users.\n")
+	}
+
+}
+
+// function to answer a command which does not exist :
+// only names of commands are recorded, text without a slash may be a message
+func (s *server) unknown(c *client.Client, args []string) {
+	details := map[string]string{}
+	if strings.HasPrefix(args[0], "/") {
+		details["command"] = args[0]
+	}
+	s.record(c, audit.EventUnknownCommand, details)
 
+	c.Err(fmt.Errorf("unknown command: %s", args[0]))
+	if c.Proto() != client.ProtoJSON {
+		c.Msg(c, "* use '/help' to   command to send message to selected user")
+	}
 }
 
 // For write to msg last X messages whic is sendend from me
-func (s *server) getMessageFromMe(c *client.Client, args []string) {
+func (s *server) getMessageFromMe(ctx context.Context, c *client.Client, args []string) {
 	if len(args)-1%2 == 1 {
 		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/getMessageFromMe 10")
 		return
@@ -278,25 +778,23 @@ func (s *server) getMessageFromMe(c *client.Client, args []string) {
 		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
 		return
 	}
-	messages, err := s.Service.GetMessageService().GetAllMessages(c.Name)
+	messages, err := s.Service.GetMessageService().GetAllMessages(ctx, c.Name)
 	if err != nil {
-		logrus.WithError(err).Info("GetMessageFromMe error user:", c.Name)
+		c.Log().WithError(err).Info("GetMessageFromMe error")
+		s.historyFailed(ctx, c)
+		return
 	}
 	if len(messages) == 0 {
-		c.Msg(c, "You haven't sent a message yet. Now it's time to talk to someone")
+		s.noMessages(c)
 		return
 	}
 	messages = combination(messages, args)
-	messageString := ""
-	for _, message := range messages {
-		messageString += message.ToString()
-	}
-	c.Msg(c, messageString)
+	c.Send(c, client.Event{Type: client.EventMessages, Messages: messages})
 
 }
 
 // For write to msg last X messages whic is recived to me
-func (s *server) getMessageToMe(c *client.Client, args []string) {
+func (s *server) getMessageToMe(ctx context.Context, c *client.Client, args []string) {
 	if len(args)-1%2 == 1 {
 		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/getMessageTomMe 10")
 		return
@@ -306,25 +804,23 @@ func (s *server) getMessageToMe(c *client.Client, args []string) {
 		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
 		return
 	}
-	messages, err := s.Service.GetMessageService().GetAllMessagesToMe(c.Name)
+	messages, err := s.Service.GetMessageService().GetAllMessagesToMe(ctx, c.Name)
 	if err != nil {
-		logrus.WithError(err).Info("GetMessageFromMe error user:", c.Name)
+		c.Log().WithError(err).Info("GetMessageFromMe error")
+		s.historyFailed(ctx, c)
+		return
 	}
 	if len(messages) == 0 {
-		c.Msg(c, "You haven't sent a message yet. Now it's time to talk to someone")
+		s.noMessages(c)
 		return
 	}
 	messages = combination(messages, args)
-	messageString := ""
-	for _, message := range messages {
-		messageString += message.ToString()
-	}
-	c.Msg(c, messageString)
+	c.Send(c, client.Event{Type: client.EventMessages, Messages: messages})
 
 }
 
 // For to write to msg which is last X messages
-func (s *server) getLastMassge(c *client.Client, args []string) {
+func (s *server) getLastMassge(ctx context.Context, c *client.Client, args []string) {
 	if len(args)%2 == 1 {
 		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/get-last 10")
 		return
@@ -340,25 +836,23 @@ func (s *server) getLastMassge(c *client.Client, args []string) {
 		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/get-last 10")
 		return
 	}
-	messages, err := s.Service.GetMessageService().GetLast(c.Name, args[1])
+	messages, err := s.Service.GetMessageService().GetLast(ctx, c.Name, args[1])
 	if err != nil {
-		logrus.WithError(err).Info("GetMessageFromMe error user:", c.Name)
+		c.Log().WithError(err).Info("GetMessageFromMe error")
+		s.historyFailed(ctx, c)
+		return
 	}
 	if len(messages) == 0 {
-		c.Msg(c, "You haven't sent a message yet. Now it's time to talk to someone")
+		s.noMessages(c)
 		return
 	}
 	messages = combination(messages, args)
-	messageString := ""
-	for _, message := range messages {
-		messageString += message.ToString()
-	}
-	c.Msg(c, messageString)
+	c.Send(c, client.Event{Type: client.EventMessages, Messages: messages})
 
 }
 
 // For to write to msg which is contains a word
-func (s *server) getContains(c *client.Client, args []string) {
+func (s *server) getContains(ctx context.Context, c *client.Client, args []string) {
 	if len(args)%2 == 1 {
 		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/get-last 10")
 		return
@@ -369,20 +863,157 @@ func (s *server) getContains(c *client.Client, args []string) {
 		return
 	}
 
-	messages, err := s.Service.GetMessageService().GetContains(c.Name, args[1])
+	messages, err := s.Service.GetMessageService().GetContains(ctx, c.Name, args[1])
 	if err != nil {
-		logrus.WithError(err).Info("GetMessageFromMe error user:", c.Name)
+		c.Log().WithError(err).Info("GetMessageFromMe error")
+		s.historyFailed(ctx, c)
+		return
 	}
 	if len(messages) == 0 {
-		c.Msg(c, "You haven't sent a message yet. Now it's time to talk to someone")
+		s.noMessages(c)
 		return
 	}
-	messageString := ""
-	for _, message := range messages {
-		messageString += message.ToString()
+	c.Send(c, client.Event{Type: client.EventMessages, Messages: messages})
+
+}
+
+// function to reply a message :
+// the reply is sent to the other side of the conversation which the parent belongs to
+func (s *server) reply(ctx context.Context, c *client.Client, args []string) {
+	if len(args) < 3 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/reply 10 Hello")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		// otherwise, prompt user to join to a user
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	parentID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/reply 10 Hello")
+		return
+	}
+
+	parent, err := s.Service.GetMessageService().GetMessage(ctx, parentID)
+	// users can only reply to messages of their own conversations
+	if err != nil || (parent.From != c.Name && parent.To != c.Name) {
+		if err != nil && err != message.ErrNotFound {
+			c.Log().WithError(err).Info("Reply error")
+		}
+		c.Msg(c, "No such message exists.")
+		return
+	}
+
+	to := parent.From
+	if parent.From == c.Name {
+		to = parent.To
+	}
+	recipient, ok := s.contacts[to]
+	sess, away := s.detachedSession(to)
+	if !ok && !away {
+		c.Msg(c, fmt.Sprintf("%s is not online.", to))
+		return
+	}
+	reply := model.Message{
+		From:     c.Name,
+		To:       to,
+		Text:     strings.Join(args[2:], " "),
+		ParentID: parent.ID,
+	}
+	reply.ExpiresAt = s.conversationExpiry(ctx, c.Name, to)
+	reply.ID, err = s.Service.GetMessageService().StoreMessage(ctx, reply)
+	if err != nil {
+		c.Log().WithError(err).Info("Message not saved to db")
+		c.Msg(c, "Reply could not be sent. Please try again.")
+		return
+	}
+	confirm := client.Event{Type: client.EventText, Text: fmt.Sprintf("reply #%d sent to %s", reply.ID, to), MessageID: reply.ID}
+
+	// replies of blocked senders are dropped without telling them :
+	// the stored reply gave them an id like any other reply
+	if s.blocked(ctx, to, c.Name) {
+		log := c.Log().WithField("to", to)
+		log.Info("dropped reply of a blocked user")
+		s.unstore(ctx, log, reply.ID)
+		c.Send(c, confirm)
+		return
+	}
+
+	// quote the parent message so the recipient knows what is replied
+	msg := client.Event{
+		Type:      client.EventMessage,
+		From:      c.Name,
+		Text:      reply.Text,
+		MessageID: reply.ID,
+		ParentID:  parent.ID,
+		ExpiresAt: reply.ExpiresAt,
+		Quote:     fmt.Sprintf("[#%d re #%d %s: \"%s\"]", reply.ID, parent.ID, parent.From, parent.Snippet(quoteLength)),
+	}
+	if ok {
+		if err := s.deliver(c, recipient, msg); err != nil {
+			sess, away = s.detachedSession(to)
+			if !away {
+				c.Msg(c, fmt.Sprintf("reply #%d could not be delivered to %s", reply.ID, to))
+				s.unstore(ctx, c.Log().WithField("to", to), reply.ID)
+				return
+			}
+		}
+	}
+	if away {
+		s.queue(sess, msg)
+	}
+	c.Log().WithField("to", to).Info("sending reply")
+	c.Send(c, confirm)
+	metrics.Messages.Inc()
+	metrics.MessageBytes.Add(float64(len(msg.Text)))
+	s.notify(webhook.Event{Type: webhook.EventMessage, User: c.Name, To: to, Text: reply.Text, MessageID: reply.ID, ParentID: parent.ID})
+}
+
+// noMessages answers a history command which found no message :
+// an empty list, which text clients see as a notice
+func (s *server) noMessages(c *client.Client) {
+	c.Send(c, client.Event{Type: client.EventMessages, Text: "You haven't sent a message yet. Now it's time to talk to someone"})
+}
+
+// historyFailed answers a history command whose query failed, a command which ran out
+// of time is answered when it ends
+func (s *server) historyFailed(ctx context.Context, c *client.Client) {
+	if ctx.Err() != nil {
+		return
+	}
+	c.Msg(c, "Messages could not be read. Please try again.")
+}
+
+// For to write to msg the reply chain of a message
+func (s *server) thread(ctx context.Context, c *client.Client, args []string) {
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/thread 10")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		// otherwise, prompt user to join to a user
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/thread 10")
+		return
 	}
-	c.Msg(c, messageString)
 
+	messages, err := s.Service.GetMessageService().GetThread(ctx, id)
+	if err != nil && err != message.ErrNotFound {
+		c.Log().WithError(err).Info("Thread error")
+		s.historyFailed(ctx, c)
+		return
+	}
+	// a thread always stays between the same two users
+	if len(messages) == 0 || (messages[0].From != c.Name && messages[0].To != c.Name) {
+		c.Msg(c, "No such message exists.")
+		return
+	}
+	c.Send(c, client.Event{Type: client.EventMessages, Messages: messages})
 }
 
 func combination(messages []model.Message, args []string) []model.Message {
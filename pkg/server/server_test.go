@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/memory"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer creates a server backed by an in memory repository
+func newTestServer(t *testing.T) (*server, *memory.Repository) {
+	repo := memory.NewRepository()
+	s := NewServer(&Config{WriteTimeout: time.Second})
+	var err error
+	s.Service, err = service.NewProvider(&service.Config{}, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, repo
+}
+
+// newTestClient adds a named client to the server, connected through a pipe :
+// lines written to the client are sent to the returned channel
+func newTestClient(t *testing.T, s *server, name string) (*client.Client, net.Conn, chan string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close(); server.Close() })
+	c := &client.Client{
+		Conn:         server,
+		Name:         name,
+		Commands:     s.commands,
+		Private:      key,
+		Public:       key.PublicKey,
+		WriteTimeout: time.Second,
+	}
+	s.contacts[name] = c
+
+	lines := make(chan string, 10)
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+	return c, conn, lines
+}
+
+// expectLine waits for a line written to a client
+func expectLine(t *testing.T, lines chan string, want string) {
+	select {
+	case line := <-lines:
+		assert.Equal(t, want, line)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected line %q", want)
+	}
+}
+
+func TestServer_msg(t *testing.T) {
+	s, repo := newTestServer(t)
+	sender, _, _ := newTestClient(t, s, "Test")
+	_, _, lines := newTestClient(t, s, "Test2")
+	sender.Contact = "Test2"
+
+	s.msg(context.Background(), sender, []string{"/msg", "Test", "Text"})
+
+	expectLine(t, lines, "> Test : [#1] Test Text\n")
+	assert.Equal(t, []model.Message{{ID: 1, From: "Test", To: "Test2", Text: "Test Text"}}, repo.Messages.All())
+}
+
+func TestServer_reply(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	other, _, otherLines := newTestClient(t, s, "Test2")
+	other.Contact = "Test"
+
+	s.msg(context.Background(), other, []string{"/msg", "Hello"})
+	expectLine(t, lines, "> Test2 : [#1] Hello\n")
+
+	// the reply quotes its parent and the sender is told its id
+	s.reply(context.Background(), c, []string{"/reply", "1", "Hi", "there"})
+	expectLine(t, otherLines, "> Test : [#2 re #1 Test2: \"Hello\"] Hi there\n")
+	expectLine(t, lines, "> reply #2 sent to Test2\n")
+	if assert.Len(t, repo.Messages.All(), 2) {
+		assert.Equal(t, int64(1), repo.Messages.All()[1].ParentID)
+	}
+
+	s.reply(context.Background(), c, []string{"/reply", "5", "Hi"})
+	expectLine(t, lines, "> No such message exists.\n")
+}
+
+func TestServer_msgClosedConnection(t *testing.T) {
+	s, repo := newTestServer(t)
+	sender, _, senderLines := newTestClient(t, s, "Test")
+	_, conn, _ := newTestClient(t, s, "Test2")
+	sender.Contact = "Test2"
+
+	// recipient disconnects without quitting
+	conn.Close()
+
+	s.msg(context.Background(), sender, []string{"/msg", "Test", "Text"})
+
+	// only the sender is told, the broken recipient is removed
+	expectLine(t, senderLines, "> message could not be delivered to Test2\n")
+	_, ok := s.contacts["Test2"]
+	assert.False(t, ok)
+	_, ok = s.contacts["Test"]
+	assert.True(t, ok)
+	assert.Empty(t, repo.Messages.All())
+
+	// following messages are not sent to the removed client
+	s.msg(context.Background(), sender, []string{"/msg", "Test", "Text"})
+	expectLine(t, senderLines, "> no one hears you. follow below steps to get started :\n")
+}
+
+func TestServer_replyClosedConnection(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	other, conn, otherLines := newTestClient(t, s, "Test2")
+	other.Contact = "Test"
+
+	s.msg(context.Background(), other, []string{"/msg", "Hello"})
+	expectLine(t, lines, "> Test2 : [#1] Hello\n")
+	conn.Close()
+	for range otherLines {
+	}
+
+	// the reply which was not delivered is not kept
+	s.reply(context.Background(), c, []string{"/reply", "1", "Hi"})
+	expectLine(t, lines, "> reply #2 could not be delivered to Test2\n")
+	assert.Len(t, repo.Messages.All(), 1)
+}
+
+func TestServer_quitClosedConnection(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, conn, _ := newTestClient(t, s, "Test")
+
+	conn.Close()
+	s.quit(c)
+
+	_, ok := s.contacts["Test"]
+	assert.False(t, ok)
+}
+
+func TestServer_disconnectKeepsNewClient(t *testing.T) {
+	s, _ := newTestServer(t)
+	old, conn, _ := newTestClient(t, s, "Test")
+	conn.Close()
+	newClient, _, _ := newTestClient(t, s, "Test")
+
+	// a stale client must not remove another client using the same name
+	s.disconnect(old)
+	assert.Equal(t, newClient, s.contacts["Test"])
+}
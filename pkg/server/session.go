@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/audit"
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/webhook"
+)
+
+// defaults of session resumption
+const (
+	// time a dropped client can resume its session
+	defaultSessionGracePeriod = 2 * time.Minute
+
+	// number of messages kept for a dropped client
+	defaultSessionMaxPending = 100
+)
+
+// session keeps the state of a named client, so it can be resumed
+// from a new connection for a grace period after its connection drops
+type session struct {
+	// secret which resumes the session
+	token string
+
+	// current client, nil while the session is detached
+	client *client.Client
+
+	// state of the client restored on resume
+	contact string
+	admin   bool
+	bot     bool
+
+	// messages which arrived while the session was detached
+	pending []client.Event
+
+	// time a detached session is dropped
+	expires time.Time
+}
+
+func (s *server) sessionGracePeriod() time.Duration {
+	if s.Config.SessionGracePeriod > 0 {
+		return s.Config.SessionGracePeriod
+	}
+	return defaultSessionGracePeriod
+}
+
+func (s *server) sessionMaxPending() int {
+	if s.Config.SessionMaxPending > 0 {
+		return s.Config.SessionMaxPending
+	}
+	return defaultSessionMaxPending
+}
+
+// newToken returns a random session token
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// startSession creates a session for a named client and sends its token :
+// /session <name> <token>
+func (s *server) startSession(c *client.Client, sess *session) {
+	s.expireSessions()
+	token, err := newToken()
+	if err != nil {
+		c.Log().WithError(err).Info("unable to create session token")
+		return
+	}
+	if sess == nil {
+		sess = &session{}
+	}
+	sess.token = token
+	sess.client = c
+	sess.pending = nil
+	s.sessions[c.Name] = sess
+	c.Send(c, client.Event{Type: client.EventSession, Name: c.Name, Token: token})
+}
+
+// endSession drops the session of a client, so its name is free again
+func (s *server) endSession(c *client.Client) {
+	if sess, ok := s.sessions[c.Name]; ok && sess.client == c {
+		delete(s.sessions, c.Name)
+	}
+}
+
+// expireSession drops a detached session which was not resumed in time,
+// the file transfers kept for it are dropped with it
+func (s *server) expireSession(name string) {
+	delete(s.sessions, name)
+	s.dropTransfers(name)
+}
+
+// detachedSession returns the session of a name whose client is gone,
+// if it has not expired yet
+func (s *server) detachedSession(name string) (*session, bool) {
+	sess, ok := s.sessions[name]
+	if !ok || sess.client != nil {
+		return nil, false
+	}
+	if time.Now().After(sess.expires) {
+		s.expireSession(name)
+		return nil, false
+	}
+	return sess, true
+}
+
+// expireSessions drops detached sessions which were not resumed in time
+func (s *server) expireSessions() {
+	now := time.Now()
+	for name, sess := range s.sessions {
+		if sess.client == nil && now.After(sess.expires) {
+			s.expireSession(name)
+		}
+	}
+}
+
+// detach removes a client whose connection dropped :
+// its session and file transfers are kept for the grace period, clients without a session are disconnected
+func (s *server) detach(c *client.Client) {
+	sess, ok := s.sessions[c.Name]
+	if !ok || sess.client != c {
+		s.disconnect(c)
+		return
+	}
+	sess.client = nil
+	sess.contact = c.Contact
+	sess.admin = c.Admin
+	sess.bot = c.Bot
+	sess.expires = time.Now().Add(s.sessionGracePeriod())
+	s.remove(c)
+	s.pauseTransfers(c.Name)
+	s.expireSessions()
+	c.Log().Info("session detached, waiting for the client to resume")
+}
+
+// queue keeps a message for a detached session, the oldest message is dropped when it is full
+func (s *server) queue(sess *session, msg client.Event) {
+	if len(sess.pending) >= s.sessionMaxPending() {
+		sess.pending = sess.pending[1:]
+	}
+	sess.pending = append(sess.pending, msg)
+}
+
+// function to resume a session from a new connection :
+// /resume <name> <token>
+// messages which arrived meanwhile are sent and a new token is issued
+func (s *server) resume(ctx context.Context, c *client.Client, args []string) {
+	if len(args) != 3 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/resume Selahattin <token>")
+		return
+	}
+	if c.Name != "anonymous" {
+		c.Msg(c, "You already have a name, only new connections can resume a session.")
+		return
+	}
+	name := args[1]
+	sess, ok := s.sessions[name]
+	if ok && sess.client == nil && time.Now().After(sess.expires) {
+		s.expireSession(name)
+		ok = false
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(sess.token), []byte(args[2])) != 1 {
+		s.record(c, audit.EventResumeFailed, map[string]string{"name": name})
+		c.Msg(c, "session expired or invalid, use /name to join again")
+		return
+	}
+
+	// banned users and addresses can not resume
+	if ban, banned := s.activeBan(ctx, name, host(c.Conn)); banned {
+		c.Log().Info("refused banned client")
+		s.record(c, audit.EventNameRefused, map[string]string{"name": name, "reason": "banned", "ban": ban.ToString()})
+		c.Msg(c, "You are banned from this server: "+ban.ToString())
+		s.expireSession(name)
+		s.disconnect(c)
+		return
+	}
+
+	// the old connection may not be noticed as dropped yet
+	if old := sess.client; old != nil {
+		sess.contact = old.Contact
+		sess.admin = old.Admin
+		sess.bot = old.Bot
+		sess.client = nil
+		s.remove(old)
+		s.pauseTransfers(name)
+	}
+
+	pending := sess.pending
+	c.SetName(name)
+	c.Contact = sess.contact
+	c.Admin = sess.admin
+	c.Bot = sess.bot
+	s.contacts[name] = c
+	s.record(c, audit.EventResume, map[string]string{"pending": fmt.Sprint(len(pending))})
+	s.startSession(c, sess)
+	s.notifyUser(c, webhook.EventConnect)
+
+	c.Msg(c, fmt.Sprintf("session resumed, you will be known as %s", name))
+	now := time.Now()
+	for _, msg := range pending {
+		// expired messages are deleted meanwhile
+		if msg.Expired(now) {
+			continue
+		}
+		c.Send(c, msg)
+	}
+	s.resumeTransfers(name)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// nameTestClient names a client and returns its session token
+func nameTestClient(t *testing.T, s *server, lines chan string, name string) string {
+	line := <-lines
+	prefix := "> /session " + name + " "
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("expected session token, got %q", line)
+	}
+	expectLine(t, lines, "> you will be known as "+name+"\n")
+	return strings.TrimSpace(strings.TrimPrefix(line, prefix))
+}
+
+func TestServer_resume(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), c, []string{"/name", "Test"})
+	token := nameTestClient(t, s, lines, "Test")
+	c.Contact = "Test2"
+
+	sender, _, senderLines := newTestClient(t, s, "Test2")
+	sender.Contact = "Test"
+
+	// the connection drops, the name stays reserved and messages are kept
+	s.detach(c)
+	_, ok := s.contacts["Test"]
+	assert.False(t, ok)
+
+	s.list(context.Background(), sender)
+	expectLine(t, senderLines, "> available users: Test (reconnecting)\n")
+
+	other, _, otherLines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), other, []string{"/name", "Test"})
+	expectLine(t, otherLines, "> There is a user which is used for this name. Please choose another name\n")
+	s.msg(context.Background(), sender, []string{"/msg", "Hello"})
+
+	// a wrong token does not resume
+	s.resume(context.Background(), other, []string{"/resume", "Test", "wrong"})
+	expectLine(t, otherLines, "> session expired or invalid, use /name to join again\n")
+
+	resumed, _, resumedLines := newTestClient(t, s, "anonymous")
+	s.resume(context.Background(), resumed, []string{"/resume", "Test", token})
+
+	line := <-resumedLines
+	assert.True(t, strings.HasPrefix(line, "> /session Test "), line)
+	assert.NotEqual(t, "> /session Test "+token+"\n", line)
+	expectLine(t, resumedLines, "> session resumed, you will be known as Test\n")
+	expectLine(t, resumedLines, "> Test2 : [#1] Hello\n")
+	assert.Equal(t, resumed, s.contacts["Test"])
+	assert.Equal(t, "Test2", resumed.Contact)
+
+	// the token is used once
+	s.resume(context.Background(), other, []string{"/resume", "Test", token})
+	expectLine(t, otherLines, "> session expired or invalid, use /name to join again\n")
+}
+
+func TestServer_resumeExpired(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), c, []string{"/name", "Test"})
+	token := nameTestClient(t, s, lines, "Test")
+
+	s.detach(c)
+	s.sessions["Test"].expires = time.Now().Add(-time.Second)
+
+	resumed, _, resumedLines := newTestClient(t, s, "anonymous")
+	s.resume(context.Background(), resumed, []string{"/resume", "Test", token})
+	expectLine(t, resumedLines, "> session expired or invalid, use /name to join again\n")
+
+	// the name is free again
+	s.name(context.Background(), resumed, []string{"/name", "Test"})
+	nameTestClient(t, s, resumedLines, "Test")
+}
+
+func TestServer_quitEndsSession(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), c, []string{"/name", "Test"})
+	token := nameTestClient(t, s, lines, "Test")
+
+	s.quit(c)
+	// the reader reports the closed connection afterwards
+	s.detach(c)
+
+	resumed, _, resumedLines := newTestClient(t, s, "anonymous")
+	s.resume(context.Background(), resumed, []string{"/resume", "Test", token})
+	expectLine(t, resumedLines, "> session expired or invalid, use /name to join again\n")
+}
+
+func TestServer_queueLimit(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Config.SessionMaxPending = 2
+	sess := &session{}
+
+	for _, text := range []string{"1", "2", "3"} {
+		s.queue(sess, client.Event{Type: client.EventMessage, From: "Test", Text: text})
+	}
+
+	if assert.Len(t, sess.pending, 2) {
+		assert.Equal(t, "2", sess.pending[0].Text)
+		assert.Equal(t, "3", sess.pending[1].Text)
+	}
+}
@@ -0,0 +1,544 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/crypto"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxFileSize is used when max_file_size is not configured
+	defaultMaxFileSize = 10 << 20
+
+	// maxFileNameLength is the maximum length of an offered file name in bytes
+	maxFileNameLength = 48
+
+	// FileChunkSize is the maximum size of a single file chunk :
+	// a chunk line has to fit in the default max_line_size once it is base64 encoded
+	FileChunkSize = 2048
+
+	// number of chunks which wait to be written to the recipient of a file
+	fileQueueSize = 16
+
+	// time a chunk can wait for room in the queue of its file
+	fileQueueTimeout = 5 * time.Second
+)
+
+// errRelayStopped is returned when chunks are passed to a relay which does not write anymore
+var errRelayStopped = errors.New("file is not received anymore")
+
+// structure of a file transfer between two clients
+type transfer struct {
+	ID   int64
+	From string
+	To   string
+	Name string
+	Size int64
+	Hash string
+
+	// set when the recipient accepts the file
+	Accepted bool
+
+	// set when a side of an accepted transfer dropped, the recipient accepts
+	// the file again from its partial download once both sides are back
+	Paused bool
+
+	// next expected byte of the file
+	Offset int64
+
+	// temporary file which holds a file the server sends itself, like a history export,
+	// empty for files between users
+	Path string
+
+	// writes the chunks to the recipient, nil until the file is accepted
+	relay *relay
+}
+
+// involves reports whether a user takes part in a transfer
+func (t *transfer) involves(name string) bool {
+	return t.To == name || (t.Path == "" && t.From == name)
+}
+
+// chunk is a part of a file at its offset, a chunk without data completes the file
+type chunk struct {
+	offset int64
+	data   []byte
+}
+
+// relay writes the chunks of an accepted file to the recipient from its own go routine,
+// so encrypting and writing them does not hold up the dispatcher
+type relay struct {
+	id int64
+	to *client.Client
+
+	// key the chunks are encrypted with and the key the recipient opens them with
+	sealKey []byte
+	openKey []byte
+
+	chunks chan chunk
+	// closed by the dispatcher to stop the relay, chunks which wait are dropped
+	stop chan struct{}
+	// closed when the relay returned
+	done chan struct{}
+}
+
+// exchangeKey creates the key of a transfer and passes it to the recipient like the text of a
+// message : it is encrypted with the public key of the recipient and opened with its private key.
+// RSA is used once per transfer, the chunks are encrypted with the key
+func exchangeKey(to *client.Client) ([]byte, []byte, error) {
+	key, err := crypto.NewChunkKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	start := time.Now()
+	sealed, err := crypto.Encrypt(base64.StdEncoding.EncodeToString(key), to.Public)
+	metrics.ObserveCrypto("encrypt", start)
+	if err != nil {
+		return nil, nil, err
+	}
+	start = time.Now()
+	opened, err := crypto.Decrypt(sealed, *to.Private)
+	metrics.ObserveCrypto("decrypt", start)
+	if err != nil {
+		return nil, nil, err
+	}
+	openKey, err := base64.StdEncoding.DecodeString(opened)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, openKey, nil
+}
+
+// startRelay starts writing the chunks of an accepted file to the recipient,
+// once the relay of a previous accept of the file wrote its last chunk
+func (s *server) startRelay(t *transfer, to *client.Client) error {
+	sealKey, openKey, err := exchangeKey(to)
+	if err != nil {
+		return err
+	}
+	r := &relay{
+		id:      t.ID,
+		to:      to,
+		sealKey: sealKey,
+		openKey: openKey,
+		chunks:  make(chan chunk, fileQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	previous := t.relay
+	t.relay = r
+	go func() {
+		if previous != nil {
+			previous.halt()
+			<-previous.done
+		}
+		r.run()
+	}()
+	return nil
+}
+
+// halt stops the relay, it is called by the dispatcher
+func (r *relay) halt() {
+	if r == nil {
+		return
+	}
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+}
+
+// run writes chunks until the file is complete, the relay is stopped or the recipient is gone
+func (r *relay) run() {
+	defer close(r.done)
+	for {
+		select {
+		case <-r.stop:
+			return
+		case ch := <-r.chunks:
+			if ch.data == nil {
+				r.to.Msg(r.to, fmt.Sprintf("/file-done %d", r.id))
+				return
+			}
+			if err := r.write(ch); err != nil {
+				r.to.Log().WithError(err).WithField("transfer_id", r.id).Info("unable to write file chunk")
+				return
+			}
+		}
+	}
+}
+
+// write encrypts a chunk with the key of the transfer and sends it to the recipient,
+// which opens it with its key
+func (r *relay) write(ch chunk) error {
+	start := time.Now()
+	sealed, err := crypto.SealChunk(r.sealKey, ch.data)
+	metrics.ObserveCrypto("seal_chunk", start)
+	if err != nil {
+		return err
+	}
+	start = time.Now()
+	data, err := crypto.OpenChunk(r.openKey, sealed)
+	metrics.ObserveCrypto("open_chunk", start)
+	if err != nil {
+		return err
+	}
+	return r.to.Msg(r.to, fmt.Sprintf("/file-chunk %d %d %s", r.id, ch.offset, base64.StdEncoding.EncodeToString(data)))
+}
+
+// queue waits until a chunk is queued for the recipient or the relay stopped,
+// it is used by the go routines which send the files of the server
+func (r *relay) queue(ch chunk) error {
+	select {
+	case r.chunks <- ch:
+		return nil
+	case <-r.stop:
+		return errRelayStopped
+	case <-r.done:
+		return errRelayStopped
+	}
+}
+
+// push queues a chunk for the recipient, waiting at most for the timeout
+// when the recipient reads slower than the file is sent
+func (r *relay) push(ch chunk, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r.chunks <- ch:
+		return nil
+	case <-r.stop:
+		return errRelayStopped
+	case <-r.done:
+		return errRelayStopped
+	case <-timer.C:
+		return fmt.Errorf("recipient did not read the file for %s", timeout)
+	}
+}
+
+// maxFileSize returns the configured file size limit
+func (s *server) maxFileSize() int64 {
+	if s.Config.MaxFileSize > 0 {
+		return s.Config.MaxFileSize
+	}
+	return defaultMaxFileSize
+}
+
+// sendControl encrypts a control line to the recipient, the same way messages are sent
+func (s *server) sendControl(c *client.Client, to *client.Client, line string) error {
+	return s.deliver(c, to, client.Event{Type: client.EventText, Text: line})
+}
+
+// function to offer a file to the current contact :
+// /file-offer <size> <sha256> <name>
+func (s *server) fileOffer(ctx context.Context, c *client.Client, args []string) {
+	if len(args) < 4 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/file-offer 1024 <sha256> report.pdf")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	recipient, ok := s.contacts[c.Contact]
+	if !ok || c.Contact == "" {
+		c.Msg(c, "no one hears you. use '/join' command to select who you want to send the file to.")
+		return
+	}
+
+	size, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || size <= 0 {
+		c.Msg(c, "Comand Error: file size must be a positive number")
+		return
+	}
+	if size > s.maxFileSize() {
+		c.Msg(c, fmt.Sprintf("/file-error 0 file is too large, limit is %d bytes", s.maxFileSize()))
+		return
+	}
+	hash := strings.ToLower(args[2])
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+		c.Msg(c, "Comand Error: file hash must be a hex encoded sha256 sum")
+		return
+	}
+	name := strings.Join(args[3:], " ")
+	if len(name) > maxFileNameLength || strings.ContainsAny(name, "/\\") {
+		c.Msg(c, fmt.Sprintf("Comand Error: file name must be at most %d characters without path separators", maxFileNameLength))
+		return
+	}
+
+	s.nextTransferID++
+
+	// offers of blocked senders look sent but never reach the recipient
+	if s.blocked(ctx, recipient.Name, c.Name) {
+		c.Log().WithField("to", recipient.Name).Info("dropped file offer of a blocked user")
+		c.Msg(c, fmt.Sprintf("/file-offered %d %s", s.nextTransferID, hash))
+		return
+	}
+
+	t := &transfer{
+		ID:   s.nextTransferID,
+		From: c.Name,
+		To:   recipient.Name,
+		Name: name,
+		Size: size,
+		Hash: hash,
+	}
+	s.transfers[t.ID] = t
+	c.Log().WithFields(logrus.Fields{"transfer_id": t.ID, "to": t.To}).Info("file transfer offered")
+
+	c.Msg(c, fmt.Sprintf("/file-offered %d %s", t.ID, t.Hash))
+	err = s.sendControl(c, recipient, fmt.Sprintf("/file-offer %d %s %d %s %s", t.ID, t.From, t.Size, t.Hash, t.Name))
+	if err == nil {
+		err = s.sendControl(c, recipient, fmt.Sprintf("%s wants to send you %s (%d bytes). use '/file-accept %d' or '/file-decline %d'", t.From, t.Name, t.Size, t.ID, t.ID))
+	}
+	if err != nil {
+		delete(s.transfers, t.ID)
+		c.Msg(c, fmt.Sprintf("/file-error %d file offer could not be delivered to %s", t.ID, t.To))
+	}
+}
+
+// function to accept an offered file :
+// /file-accept <id> [offset]
+// offset is used to resume a partially received file
+func (s *server) fileAccept(c *client.Client, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/file-accept 1")
+		return
+	}
+	t := s.findTransfer(c, args[1], false)
+	if t == nil {
+		return
+	}
+	if t.Accepted {
+		c.Msg(c, fmt.Sprintf("/file-error %d file is already being sent", t.ID))
+		return
+	}
+	var offset int64
+	if len(args) == 3 {
+		var err error
+		offset, err = strconv.ParseInt(args[2], 10, 64)
+		if err != nil || offset < 0 || offset > t.Size {
+			c.Msg(c, "Comand Error: invalid file offset")
+			return
+		}
+	}
+	if t.Path != "" {
+		if err := s.startRelay(t, c); err != nil {
+			c.Log().WithError(err).Info("unable to start file transfer")
+			c.Msg(c, fmt.Sprintf("/file-error %d file could not be sent, please try again", t.ID))
+			return
+		}
+		t.Accepted = true
+		t.Paused = false
+		t.Offset = offset
+		c.Msg(c, fmt.Sprintf("receiving %s from %s", t.Name, t.From))
+		go s.sendServerFile(t.ID, t.Path, offset, t.relay)
+		return
+	}
+	sender, ok := s.contacts[t.From]
+	if !ok {
+		// the file continues once the sender resumes its session
+		if _, away := s.detachedSession(t.From); away {
+			t.Paused = true
+			c.Msg(c, fmt.Sprintf("/file-paused %d %s is reconnecting", t.ID, t.From))
+			return
+		}
+		delete(s.transfers, t.ID)
+		c.Msg(c, fmt.Sprintf("/file-error %d %s is not online anymore", t.ID, t.From))
+		return
+	}
+	if err := s.sendControl(c, sender, fmt.Sprintf("/file-accepted %d %d", t.ID, offset)); err != nil {
+		delete(s.transfers, t.ID)
+		c.Msg(c, fmt.Sprintf("/file-error %d %s is not online anymore", t.ID, t.From))
+		return
+	}
+	if err := s.startRelay(t, c); err != nil {
+		c.Log().WithError(err).Info("unable to start file transfer")
+		s.dropTransfer(t, "file could not be sent, please try again")
+		return
+	}
+	t.Accepted = true
+	t.Paused = false
+	t.Offset = offset
+	c.Msg(c, fmt.Sprintf("receiving %s from %s", t.Name, t.From))
+}
+
+// function to decline an offered file
+func (s *server) fileDecline(c *client.Client, args []string) {
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/file-decline 1")
+		return
+	}
+	t := s.findTransfer(c, args[1], false)
+	if t == nil {
+		return
+	}
+	s.deleteTransfer(t)
+	c.Msg(c, fmt.Sprintf("declined %s from %s", t.Name, t.From))
+	if sender, ok := s.contacts[t.From]; ok && t.Path == "" {
+		s.sendControl(c, sender, fmt.Sprintf("/file-declined %d", t.ID))
+	}
+}
+
+// function to pass a chunk of a file to the recipient :
+// /file-chunk <id> <offset> <base64 data>
+func (s *server) fileChunk(c *client.Client, args []string) {
+	if len(args) != 4 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/file-chunk 1 0 <base64>")
+		return
+	}
+	t := s.findTransfer(c, args[1], true)
+	if t == nil {
+		return
+	}
+	// chunks sent before the pause was noticed are sent again from the accepted offset
+	if t.Paused {
+		return
+	}
+	if !t.Accepted {
+		c.Msg(c, fmt.Sprintf("/file-error %d file is not accepted yet", t.ID))
+		return
+	}
+	offset, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || offset != t.Offset {
+		c.Msg(c, fmt.Sprintf("/file-error %d expected offset %d", t.ID, t.Offset))
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(args[3])
+	if err != nil || len(data) == 0 || len(data) > FileChunkSize || offset+int64(len(data)) > t.Size {
+		c.Msg(c, fmt.Sprintf("/file-error %d invalid file chunk", t.ID))
+		return
+	}
+	if err := t.relay.push(chunk{offset: offset, data: data}, fileQueueTimeout); err != nil {
+		c.Log().WithError(err).WithField("transfer_id", t.ID).Info("unable to pass file chunk")
+		s.pauseTransfer(t, fmt.Sprintf("%s is not receiving the file", t.To))
+		return
+	}
+	t.Offset += int64(len(data))
+}
+
+// function to complete a file transfer :
+// the sender is told once the last chunk is passed on
+func (s *server) fileDone(c *client.Client, args []string) {
+	if len(args) != 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/file-done 1")
+		return
+	}
+	t := s.findTransfer(c, args[1], true)
+	if t == nil || t.Paused {
+		return
+	}
+	if !t.Accepted {
+		c.Msg(c, fmt.Sprintf("/file-error %d file is not accepted yet", t.ID))
+		return
+	}
+	if t.Offset != t.Size {
+		c.Msg(c, fmt.Sprintf("/file-error %d file is incomplete, %d of %d bytes received", t.ID, t.Offset, t.Size))
+		return
+	}
+	if err := t.relay.push(chunk{offset: t.Offset}, fileQueueTimeout); err != nil {
+		c.Log().WithError(err).WithField("transfer_id", t.ID).Info("unable to complete file transfer")
+		s.pauseTransfer(t, fmt.Sprintf("%s is not receiving the file", t.To))
+		return
+	}
+	delete(s.transfers, t.ID)
+	c.Log().WithFields(logrus.Fields{"transfer_id": t.ID, "to": t.To}).Info("file transfer completed")
+	c.Msg(c, fmt.Sprintf("/file-sent %d", t.ID))
+}
+
+// findTransfer returns the transfer with given id if the client takes part in it :
+// sender decides which side of the transfer the client has to be
+func (s *server) findTransfer(c *client.Client, arg string, sender bool) *transfer {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err == nil {
+		t, ok := s.transfers[id]
+		// nobody sends chunks of the files of the server
+		if ok && ((sender && t.From == c.Name && t.Path == "") || (!sender && t.To == c.Name)) {
+			return t
+		}
+	}
+	c.Msg(c, fmt.Sprintf("/file-error %s no such file transfer", arg))
+	return nil
+}
+
+// pauseTransfer stops an accepted transfer until the recipient accepts it again,
+// both sides are told why
+func (s *server) pauseTransfer(t *transfer, reason string) {
+	t.relay.halt()
+	t.Accepted = false
+	t.Paused = true
+	line := fmt.Sprintf("/file-paused %d %s", t.ID, reason)
+	for _, name := range []string{t.From, t.To} {
+		if c, ok := s.contacts[name]; ok && t.involves(name) {
+			c.Msg(c, line)
+		}
+	}
+}
+
+// pauseTransfers pauses the accepted transfers of a user whose connection dropped,
+// they are kept for its session
+func (s *server) pauseTransfers(name string) {
+	for _, t := range s.transfers {
+		if t.Accepted && t.involves(name) {
+			s.pauseTransfer(t, fmt.Sprintf("%s is reconnecting", name))
+		}
+	}
+}
+
+// resumeTransfers offers the paused transfers of a user who resumed its session
+// to their recipients again, which accept them from their partial downloads
+func (s *server) resumeTransfers(name string) {
+	for _, t := range s.transfers {
+		if !t.Paused || !t.involves(name) {
+			continue
+		}
+		recipient, ok := s.contacts[t.To]
+		if _, online := s.contacts[t.From]; !ok || (t.Path == "" && !online) {
+			continue
+		}
+		recipient.Msg(recipient, fmt.Sprintf("/file-offer %d %s %d %s %s", t.ID, t.From, t.Size, t.Hash, t.Name))
+	}
+}
+
+// deleteTransfer stops and removes a transfer with the temporary file of the server it sends
+func (s *server) deleteTransfer(t *transfer) {
+	t.relay.halt()
+	delete(s.transfers, t.ID)
+	if t.Path != "" {
+		if err := os.Remove(t.Path); err != nil {
+			logrus.WithError(err).WithField("transfer_id", t.ID).Info("unable to remove file")
+		}
+	}
+}
+
+// dropTransfer removes a transfer, the users which take part in it are told why
+func (s *server) dropTransfer(t *transfer, reason string) {
+	s.deleteTransfer(t)
+	line := fmt.Sprintf("/file-error %d %s", t.ID, reason)
+	for _, name := range []string{t.From, t.To} {
+		if c, ok := s.contacts[name]; ok && t.involves(name) {
+			c.Msg(c, line)
+		}
+	}
+}
+
+// dropTransfers removes all transfers of a user who left the chat
+func (s *server) dropTransfers(name string) {
+	for _, t := range s.transfers {
+		if t.involves(name) {
+			s.dropTransfer(t, fmt.Sprintf("%s is not online anymore", name))
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fileHash returns the hash a file is offered with
+func fileHash(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestServer_fileTransfer(t *testing.T) {
+	s, _ := newTestServer(t)
+	sender, _, senderLines := newTestClient(t, s, "Test")
+	recipient, _, recipientLines := newTestClient(t, s, "Test2")
+	sender.Contact = "Test2"
+	hash := fileHash("hello")
+
+	s.fileOffer(context.Background(), sender, []string{"/file-offer", "5", hash, "hello.txt"})
+	expectLine(t, senderLines, "> /file-offered 1 "+hash+"\n")
+	expectLine(t, recipientLines, "> /file-offer 1 Test 5 "+hash+" hello.txt\n")
+	expectLine(t, recipientLines, "> Test wants to send you hello.txt (5 bytes). use '/file-accept 1' or '/file-decline 1'\n")
+
+	s.fileChunk(sender, []string{"/file-chunk", "1", "0", "aGVs"})
+	expectLine(t, senderLines, "> /file-error 1 file is not accepted yet\n")
+
+	// only the recipient accepts the file
+	s.fileAccept(sender, []string{"/file-accept", "1"})
+	expectLine(t, senderLines, "> /file-error 1 no such file transfer\n")
+	s.fileAccept(recipient, []string{"/file-accept", "1", "6"})
+	expectLine(t, recipientLines, "> Comand Error: invalid file offset\n")
+	s.fileAccept(recipient, []string{"/file-accept", "1"})
+	expectLine(t, senderLines, "> /file-accepted 1 0\n")
+	expectLine(t, recipientLines, "> receiving hello.txt from Test\n")
+	s.fileAccept(recipient, []string{"/file-accept", "1"})
+	expectLine(t, recipientLines, "> /file-error 1 file is already being sent\n")
+
+	// chunks follow each other
+	s.fileChunk(sender, []string{"/file-chunk", "1", "3", "bG8="})
+	expectLine(t, senderLines, "> /file-error 1 expected offset 0\n")
+	s.fileChunk(sender, []string{"/file-chunk", "1", "0", "aGVs"})
+	expectLine(t, recipientLines, "> /file-chunk 1 0 aGVs\n")
+	s.fileDone(sender, []string{"/file-done", "1"})
+	expectLine(t, senderLines, "> /file-error 1 file is incomplete, 3 of 5 bytes received\n")
+	s.fileChunk(sender, []string{"/file-chunk", "1", "3", "bG8hIQ=="})
+	expectLine(t, senderLines, "> /file-error 1 invalid file chunk\n")
+	s.fileChunk(sender, []string{"/file-chunk", "1", "3", "bG8="})
+	expectLine(t, recipientLines, "> /file-chunk 1 3 bG8=\n")
+
+	s.fileDone(sender, []string{"/file-done", "1"})
+	expectLine(t, senderLines, "> /file-sent 1\n")
+	expectLine(t, recipientLines, "> /file-done 1\n")
+	assert.Empty(t, s.transfers)
+}
+
+func TestServer_fileOfferTooLarge(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Config.MaxFileSize = 4
+	sender, _, senderLines := newTestClient(t, s, "Test")
+	newTestClient(t, s, "Test2")
+	sender.Contact = "Test2"
+
+	s.fileOffer(context.Background(), sender, []string{"/file-offer", "5", fileHash("hello"), "hello.txt"})
+	expectLine(t, senderLines, "> /file-error 0 file is too large, limit is 4 bytes\n")
+	assert.Empty(t, s.transfers)
+}
+
+func TestServer_fileTransferResume(t *testing.T) {
+	s, _ := newTestServer(t)
+	sender, _, senderLines := newTestClient(t, s, "Test")
+	recipient, _, recipientLines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), recipient, []string{"/name", "Test2"})
+	token := nameTestClient(t, s, recipientLines, "Test2")
+	sender.Contact = "Test2"
+	hash := fileHash("hello")
+
+	s.fileOffer(context.Background(), sender, []string{"/file-offer", "5", hash, "hello.txt"})
+	expectLine(t, senderLines, "> /file-offered 1 "+hash+"\n")
+	expectLine(t, recipientLines, "> /file-offer 1 Test 5 "+hash+" hello.txt\n")
+	expectLine(t, recipientLines, "> Test wants to send you hello.txt (5 bytes). use '/file-accept 1' or '/file-decline 1'\n")
+	s.fileAccept(recipient, []string{"/file-accept", "1"})
+	expectLine(t, senderLines, "> /file-accepted 1 0\n")
+	expectLine(t, recipientLines, "> receiving hello.txt from Test\n")
+	s.fileChunk(sender, []string{"/file-chunk", "1", "0", "aGVs"})
+	expectLine(t, recipientLines, "> /file-chunk 1 0 aGVs\n")
+
+	// the connection of the recipient drops, the transfer is kept for its session
+	s.detach(recipient)
+	expectLine(t, senderLines, "> /file-paused 1 Test2 is reconnecting\n")
+	s.fileChunk(sender, []string{"/file-chunk", "1", "3", "bG8="})
+	expectNoLine(t, senderLines)
+
+	// the recipient resumes and accepts the file again from its partial download
+	resumed, _, resumedLines := newTestClient(t, s, "anonymous")
+	s.resume(context.Background(), resumed, []string{"/resume", "Test2", token})
+	<-resumedLines
+	expectLine(t, resumedLines, "> session resumed, you will be known as Test2\n")
+	expectLine(t, resumedLines, "> /file-offer 1 Test 5 "+hash+" hello.txt\n")
+	s.fileAccept(resumed, []string{"/file-accept", "1", "3"})
+	expectLine(t, senderLines, "> /file-accepted 1 3\n")
+	expectLine(t, resumedLines, "> receiving hello.txt from Test\n")
+	s.fileChunk(sender, []string{"/file-chunk", "1", "3", "bG8="})
+	expectLine(t, resumedLines, "> /file-chunk 1 3 bG8=\n")
+	s.fileDone(sender, []string{"/file-done", "1"})
+	expectLine(t, senderLines, "> /file-sent 1\n")
+	expectLine(t, resumedLines, "> /file-done 1\n")
+
+	// transfers end with the session of a user who quits
+	s.fileOffer(context.Background(), sender, []string{"/file-offer", "5", hash, "hello.txt"})
+	expectLine(t, senderLines, "> /file-offered 2 "+hash+"\n")
+	expectLine(t, resumedLines, "> /file-offer 2 Test 5 "+hash+" hello.txt\n")
+	expectLine(t, resumedLines, "> Test wants to send you hello.txt (5 bytes). use '/file-accept 2' or '/file-decline 2'\n")
+	s.disconnect(resumed)
+	expectLine(t, senderLines, "> /file-error 2 Test2 is not online anymore\n")
+	assert.Empty(t, s.transfers)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/sirupsen/logrus"
+)
+
+// defaults of expiring messages
+const (
+	// interval between checks for expired messages
+	reapInterval = time.Second
+
+	// number of expired messages deleted per check
+	reapBatchSize = 100
+
+	// time the dispatcher has to tell clients about expired messages, and a check has to delete them
+	reapTimeout = 5 * time.Second
+
+	// shortest time to live of a message
+	minTTL = time.Second
+)
+
+// parseTTL returns the time to live of a message, a duration like 30s or 1h30m
+func parseTTL(value string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a duration", value)
+	}
+	if ttl < minTTL {
+		return 0, fmt.Errorf("%s is shorter than %s", value, minTTL)
+	}
+	return ttl, nil
+}
+
+// conversationExpiry returns the time a message between two users expires
+// with the time to live of their conversation, nil when it is kept
+func (s *server) conversationExpiry(ctx context.Context, from string, to string) *time.Time {
+	ttl, err := s.Service.GetConversationService().GetTTL(ctx, from, to)
+	if err != nil {
+		logrus.WithError(err).WithField("user", from).Error("unable to get time to live of conversation")
+		return nil
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	at := time.Now().Add(ttl).Truncate(time.Second)
+	return &at
+}
+
+// function to send a message which is deleted after a duration :
+// /msg-ttl <duration> <text>
+func (s *server) msgTTL(ctx context.Context, c *client.Client, args []string) {
+	if len(args) < 3 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/msg-ttl 30s Hello\n/msg-ttl 1h Hello")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	ttl, err := parseTTL(args[1])
+	if err != nil {
+		c.Msg(c, "Comand Error: "+err.Error()+"\nCorrect Comamnd Example\n\n/msg-ttl 30s Hello\n/msg-ttl 1h Hello")
+		return
+	}
+	_, ok := s.contacts[c.Contact]
+	_, away := s.detachedSession(c.Contact)
+	if (!ok && !away) || c.Contact == "" {
+		c.Msg(c, "no one hears you. use '/join' command to select who you want to send the message to.")
+		return
+	}
+
+	at := time.Now().Add(ttl).Truncate(time.Second)
+	s.send(ctx, c, model.Message{From: c.Name, To: c.Contact, Text: strings.Join(args[2:], " "), ExpiresAt: &at})
+}
+
+// function to show or set the default time to live of the messages with the contact :
+// /ttl [duration|off]
+func (s *server) ttl(ctx context.Context, c *client.Client, args []string) {
+	if len(args) > 2 {
+		c.Msg(c, "Comand Error: \nCorrect Comamnd Example\n\n/ttl\n/ttl 1h\n/ttl off")
+		return
+	}
+	if c.Name == "" || c.Name == "anonymous" {
+		c.Msg(c, "Okey I got your request but I dont know you.\nPlease Describe your self\n\nHint:)\nname : Specify your name.\n")
+		return
+	}
+	if c.Contact == "" {
+		c.Msg(c, "no one hears you. use '/join' command to select the conversation.")
+		return
+	}
+	conversations := s.Service.GetConversationService()
+
+	if len(args) == 1 {
+		ttl, err := conversations.GetTTL(ctx, c.Name, c.Contact)
+		if err != nil {
+			c.Log().WithError(err).Info("Conversation ttl error")
+			c.Msg(c, "Time to live could not be read. Please try again.")
+			return
+		}
+		if ttl <= 0 {
+			c.Msg(c, fmt.Sprintf("messages with %s are kept", c.Contact))
+			return
+		}
+		c.Msg(c, fmt.Sprintf("messages with %s expire after %s", c.Contact, ttl))
+		return
+	}
+
+	var ttl time.Duration
+	if args[1] != "off" {
+		var err error
+		ttl, err = parseTTL(args[1])
+		if err != nil {
+			c.Msg(c, "Comand Error: "+err.Error()+"\nCorrect Comamnd Example\n\n/ttl\n/ttl 1h\n/ttl off")
+			return
+		}
+	}
+	notice := fmt.Sprintf("%s set messages with %s to expire after %s", c.Name, c.Contact, ttl)
+	if ttl <= 0 {
+		notice = fmt.Sprintf("%s set messages with %s to be kept", c.Name, c.Contact)
+	}
+
+	// blocked users can not change the conversation, they are answered like it was changed
+	if s.blocked(ctx, c.Contact, c.Name) {
+		c.Log().WithField("contact", c.Contact).Info("dropped conversation ttl of a blocked user")
+		c.Msg(c, notice)
+		return
+	}
+	if err := conversations.SetTTL(ctx, c.Name, c.Contact, ttl); err != nil {
+		c.Log().WithError(err).Info("Conversation ttl not saved to db")
+		c.Msg(c, "Time to live could not be set. Please try again.")
+		return
+	}
+	c.Log().WithFields(logrus.Fields{"contact": c.Contact, "ttl": ttl}).Info("conversation ttl set")
+
+	// both users of the conversation are told, their messages expire alike
+	c.Msg(c, notice)
+	if other, ok := s.contacts[c.Contact]; ok {
+		other.Msg(other, notice)
+	}
+}
+
+// reapExpired deletes the messages which are expired at now,
+// online users of their conversations are told by the dispatcher to remove them
+func (s *server) reapExpired(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), reapTimeout)
+	defer cancel()
+	messages := s.Service.GetMessageService()
+	expired, err := messages.GetExpired(ctx, now, reapBatchSize)
+	if err != nil {
+		logrus.WithError(err).Error("unable to get expired messages")
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+	ids := make([]int64, len(expired))
+	for i, m := range expired {
+		ids[i] = m.ID
+	}
+	count, err := messages.DeleteMessages(ctx, ids)
+	if err != nil {
+		logrus.WithError(err).Error("unable to delete expired messages")
+		return
+	}
+	metrics.ExpiredMessages.Add(float64(count))
+	logrus.WithField("count", count).Debug("expired messages deleted")
+
+	if err := s.do(func() { s.tellExpired(expired) }, reapTimeout); err != nil {
+		logrus.WithError(err).Info("unable to tell clients about expired messages")
+	}
+}
+
+// tellExpired tells the online users of conversations that their messages expired
+func (s *server) tellExpired(expired []model.Message) {
+	for _, m := range expired {
+		e := client.Event{Type: client.EventExpired, MessageID: m.ID}
+		for _, name := range []string{m.From, m.To} {
+			if c, ok := s.contacts[name]; ok {
+				c.Send(c, e)
+			}
+		}
+	}
+}
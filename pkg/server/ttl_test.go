@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTTL(t *testing.T) {
+	ttl, err := parseTTL("1h30m")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, ttl)
+
+	for _, value := range []string{"-5m", "0s", "500ms", "soon"} {
+		_, err := parseTTL(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestServer_msgTTL(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	_, _, otherLines := newTestClient(t, s, "Test2")
+
+	s.msgTTL(context.Background(), c, []string{"/msg-ttl", "1m", "Hello"})
+	expectLine(t, lines, "> no one hears you. use '/join' command to select who you want to send the message to.\n")
+
+	c.Contact = "Test2"
+	s.msgTTL(context.Background(), c, []string{"/msg-ttl", "later", "Hello"})
+	expectLine(t, lines, "> Comand Error: later is not a duration\n")
+	expectLine(t, lines, "Correct Comamnd Example\n")
+	expectLine(t, lines, "\n")
+	expectLine(t, lines, "/msg-ttl 30s Hello\n")
+	expectLine(t, lines, "/msg-ttl 1h Hello\n")
+
+	s.msgTTL(context.Background(), c, []string{"/msg-ttl", "1m", "Hello", "there"})
+	if !assert.Len(t, repo.Messages.All(), 1) {
+		return
+	}
+	m := repo.Messages.All()[0]
+	assert.Equal(t, "Hello there", m.Text)
+	if assert.NotNil(t, m.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Minute), *m.ExpiresAt, 2*time.Second)
+		expectLine(t, otherLines, "> Test : [#1 expires "+m.ExpiresAt.Format(time.RFC3339)+"] Hello there\n")
+	}
+}
+
+func TestServer_ttl(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	other, _, otherLines := newTestClient(t, s, "Test2")
+	c.Contact = "Test2"
+
+	s.ttl(context.Background(), c, []string{"/ttl"})
+	expectLine(t, lines, "> messages with Test2 are kept\n")
+
+	s.ttl(context.Background(), c, []string{"/ttl", "1h"})
+	expectLine(t, lines, "> Test set messages with Test2 to expire after 1h0m0s\n")
+	expectLine(t, otherLines, "> Test set messages with Test2 to expire after 1h0m0s\n")
+
+	// the setting belongs to both users of the conversation
+	other.Contact = "Test"
+	s.ttl(context.Background(), other, []string{"/ttl"})
+	expectLine(t, otherLines, "> messages with Test expire after 1h0m0s\n")
+
+	s.msg(context.Background(), other, []string{"/msg", "Hi"})
+	<-lines
+	if assert.Len(t, repo.Messages.All(), 1) && assert.NotNil(t, repo.Messages.All()[0].ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *repo.Messages.All()[0].ExpiresAt, 2*time.Second)
+	}
+
+	// a message keeps its own time to live
+	s.msgTTL(context.Background(), c, []string{"/msg-ttl", "10s", "Bye"})
+	<-otherLines
+	if assert.Len(t, repo.Messages.All(), 2) && assert.NotNil(t, repo.Messages.All()[1].ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(10*time.Second), *repo.Messages.All()[1].ExpiresAt, 2*time.Second)
+	}
+
+	s.ttl(context.Background(), c, []string{"/ttl", "off"})
+	expectLine(t, lines, "> Test set messages with Test2 to be kept\n")
+	<-otherLines
+	s.msg(context.Background(), c, []string{"/msg", "Kept"})
+	<-otherLines
+	if assert.Len(t, repo.Messages.All(), 3) {
+		assert.Nil(t, repo.Messages.All()[2].ExpiresAt)
+	}
+}
+
+func TestServer_ttlBlocked(t *testing.T) {
+	s, repo := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "Test")
+	other, _, otherLines := newTestClient(t, s, "Test2")
+	c.Contact = "Test2"
+	other.Contact = "Test"
+
+	s.block(context.Background(), other, []string{"/block", "Test"})
+	expectLine(t, otherLines, "> Test is blocked, you will not receive messages from Test\n")
+
+	// the blocked user is answered like the conversation was changed
+	s.ttl(context.Background(), c, []string{"/ttl", "1h"})
+	expectLine(t, lines, "> Test set messages with Test2 to expire after 1h0m0s\n")
+	expectNoLine(t, otherLines)
+
+	ttl, err := repo.Conversations.GetTTL(context.Background(), "Test2", "Test")
+	assert.NoError(t, err)
+	assert.Zero(t, ttl)
+}
+
+func TestServer_reapExpired(t *testing.T) {
+	s, repo := newTestServer(t)
+	_, _, lines := newTestClient(t, s, "Test")
+	_, _, otherLines := newTestClient(t, s, "Test2")
+	go s.dispatch()
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	repo.Messages.Store(context.Background(), model.Message{From: "Test", To: "Test2", Text: "Gone", ExpiresAt: &past})
+	repo.Messages.Store(context.Background(), model.Message{From: "Test", To: "Test2", Text: "Later", ExpiresAt: &future})
+	repo.Messages.Store(context.Background(), model.Message{From: "Test", To: "Test2", Text: "Kept"})
+
+	// expired messages are never returned, even before they are deleted
+	messages, err := s.Service.GetMessageService().GetAllMessages(context.Background(), "Test")
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	_, err = s.Service.GetMessageService().GetMessage(context.Background(), 1)
+	assert.Error(t, err)
+
+	s.reapExpired(time.Now())
+	expectLine(t, lines, "> message #1 expired\n")
+	expectLine(t, otherLines, "> message #1 expired\n")
+	if assert.Len(t, repo.Messages.All(), 2) {
+		assert.Equal(t, "Later", repo.Messages.All()[0].Text)
+		assert.Equal(t, "Kept", repo.Messages.All()[1].Text)
+	}
+
+	// nothing else is expired
+	s.reapExpired(time.Now())
+	expectNoLine(t, lines)
+}
+
+func TestServer_resumeExpiredMessages(t *testing.T) {
+	s, _ := newTestServer(t)
+	c, _, lines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), c, []string{"/name", "Test2"})
+	token := nameTestClient(t, s, lines, "Test2")
+	s.detach(c)
+
+	// messages which expire while the recipient is away are not sent
+	sess, _ := s.detachedSession("Test2")
+	past := time.Now().Add(-time.Second)
+	s.queue(sess, client.Event{Type: client.EventMessage, From: "Test", Text: "Gone", MessageID: 1, ExpiresAt: &past})
+	s.queue(sess, client.Event{Type: client.EventMessage, From: "Test", Text: "Hello", MessageID: 2})
+
+	resumed, _, resumedLines := newTestClient(t, s, "anonymous")
+	s.resume(context.Background(), resumed, []string{"/resume", "Test2", token})
+	<-resumedLines
+	expectLine(t, resumedLines, "> session resumed, you will be known as Test2\n")
+	expectLine(t, resumedLines, "> Test : [#2] Hello\n")
+	expectNoLine(t, resumedLines)
+}
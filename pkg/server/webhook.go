@@ -0,0 +1,29 @@
+package server
+
+import (
+	"github.com/Selahattinn/picus-tcp-message/pkg/client"
+	"github.com/Selahattinn/picus-tcp-message/pkg/webhook"
+)
+
+// startWebhooks starts posting events to the configured webhooks,
+// deliveries are kept by the webhook service
+func (s *server) startWebhooks() {
+	s.webhooks = webhook.NewDispatcher(s.Service.GetWebhookService(), s.Config.Webhooks)
+	go s.webhooks.Run()
+}
+
+// notify posts an event to the webhooks, if they are started
+func (s *server) notify(e webhook.Event) {
+	if s.webhooks == nil {
+		return
+	}
+	s.webhooks.Emit(e)
+}
+
+// notifyUser posts a connect or disconnect event of a named client
+func (s *server) notifyUser(c *client.Client, event string) {
+	if c.Name == "" || c.Name == "anonymous" {
+		return
+	}
+	s.notify(webhook.Event{Type: event, User: c.Name})
+}
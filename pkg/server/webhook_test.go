@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_webhooks(t *testing.T) {
+	events := make(chan webhook.Event, 10)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !webhook.Verify("secret", body, r.Header.Get(webhook.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var e webhook.Event
+		json.Unmarshal(body, &e)
+		events <- e
+	}))
+	defer endpoint.Close()
+	expectEvent := func(event string, user string) webhook.Event {
+		select {
+		case e := <-events:
+			assert.Equal(t, event, e.Type)
+			assert.Equal(t, user, e.User)
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %s event", event)
+		}
+		return webhook.Event{}
+	}
+
+	s, _ := newTestServer(t)
+	s.Config.Webhooks = []webhook.Config{{URL: endpoint.URL, Secret: "secret", Keywords: []string{"deploy"}}}
+	s.startWebhooks()
+	defer s.webhooks.Close()
+
+	c, _, lines := newTestClient(t, s, "anonymous")
+	s.name(context.Background(), c, []string{"/name", "Test"})
+	<-lines
+	<-lines
+	expectEvent(webhook.EventConnect, "Test")
+
+	newTestClient(t, s, "Test2")
+	c.Contact = "Test2"
+	s.msg(context.Background(), c, []string{"/msg", "deploy", "done"})
+	e := expectEvent(webhook.EventMessage, "Test")
+	assert.Equal(t, "Test2", e.To)
+	assert.Equal(t, "deploy done", e.Text)
+	assert.Equal(t, int64(1), e.MessageID)
+	assert.Equal(t, "deploy", expectEvent(webhook.EventKeyword, "Test").Keyword)
+
+	s.quit(c)
+	expectEvent(webhook.EventDisconnect, "Test")
+}
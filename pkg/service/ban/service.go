@@ -0,0 +1,60 @@
+package ban
+
+import (
+	"context"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository/ban"
+)
+
+type Service struct {
+	repository repository.Repository
+}
+
+func NewService(repo repository.Repository) (*Service, error) {
+	return &Service{
+		repository: repo,
+	}, nil
+}
+
+// GetActiveBan returns the ban of a target if there is one which is not expired,
+// ok is false when the target is not banned
+func (s *Service) GetActiveBan(ctx context.Context, kind string, target string) (model.Ban, bool, error) {
+	b, err := s.repository.GetBanRepository().GetActive(ctx, kind, target, time.Now())
+	if err == ban.ErrNotFound {
+		return b, false, nil
+	}
+	if err != nil {
+		return b, false, err
+	}
+	return b, true, nil
+}
+
+// Ban stores a ban of a target, duration zero means a permanent ban
+func (s *Service) Ban(ctx context.Context, kind string, target string, duration time.Duration, by string) (model.Ban, error) {
+	b := model.Ban{
+		Kind:   kind,
+		Target: target,
+		By:     by,
+	}
+	if duration > 0 {
+		b.Until = time.Now().Add(duration)
+	}
+	id, err := s.repository.GetBanRepository().Store(ctx, b)
+	if err != nil {
+		return b, err
+	}
+	b.ID = id
+	return b, nil
+}
+
+// Unban removes all bans of a target, it reports whether the target was banned
+func (s *Service) Unban(ctx context.Context, kind string, target string) (bool, error) {
+	count, err := s.repository.GetBanRepository().Delete(ctx, kind, target)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
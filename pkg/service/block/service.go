@@ -0,0 +1,40 @@
+package block
+
+import (
+	"context"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+)
+
+type Service struct {
+	repository repository.Repository
+}
+
+func NewService(repo repository.Repository) (*Service, error) {
+	return &Service{
+		repository: repo,
+	}, nil
+}
+
+// Block stops messages of a user from being delivered to another one
+func (s *Service) Block(ctx context.Context, user string, blocked string) error {
+	return s.repository.GetBlockRepository().Store(ctx, user, blocked)
+}
+
+// Unblock allows a blocked user again, it reports whether the user was blocked
+func (s *Service) Unblock(ctx context.Context, user string, blocked string) (bool, error) {
+	count, err := s.repository.GetBlockRepository().Delete(ctx, user, blocked)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// IsBlocked reports whether a user blocked another one
+func (s *Service) IsBlocked(ctx context.Context, user string, blocked string) (bool, error) {
+	return s.repository.GetBlockRepository().IsBlocked(ctx, user, blocked)
+}
+
+// GetBlocked returns the names which are blocked by a user
+func (s *Service) GetBlocked(ctx context.Context, user string) ([]string, error) {
+	return s.repository.GetBlockRepository().GetBlocked(ctx, user)
+}
@@ -0,0 +1,34 @@
+package conversation
+
+import (
+	"context"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+)
+
+type Service struct {
+	repository repository.Repository
+}
+
+func NewService(repo repository.Repository) (*Service, error) {
+	return &Service{
+		repository: repo,
+	}, nil
+}
+
+// GetTTL returns the default time to live of the messages between two users,
+// zero when their messages are kept
+func (s *Service) GetTTL(ctx context.Context, user string, other string) (time.Duration, error) {
+	return s.repository.GetConversationRepository().GetTTL(ctx, user, other)
+}
+
+// SetTTL sets the default time to live of the messages between two users,
+// zero removes it so messages are kept again
+func (s *Service) SetTTL(ctx context.Context, user string, other string, ttl time.Duration) error {
+	if ttl <= 0 {
+		_, err := s.repository.GetConversationRepository().DeleteTTL(ctx, user, other)
+		return err
+	}
+	return s.repository.GetConversationRepository().SetTTL(ctx, user, other, ttl)
+}
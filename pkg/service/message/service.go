@@ -1,6 +1,10 @@
 package message
 
 import (
+	"context"
+	"sort"
+	"time"
+
 	"github.com/Selahattinn/picus-tcp-message/pkg/model"
 	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
 )
@@ -16,8 +20,8 @@ func NewService(repo repository.Repository) (*Service, error) {
 }
 
 // GetAllMessages returns all messages which is sended
-func (s *Service) GetAllMessages(from_client string) ([]model.Message, error) {
-	messages, err := s.repository.GetMessageRepository().GetAll(from_client)
+func (s *Service) GetAllMessages(ctx context.Context, from_client string) ([]model.Message, error) {
+	messages, err := s.repository.GetMessageRepository().GetAll(ctx, from_client)
 	if err != nil {
 		return nil, err
 	}
@@ -25,8 +29,8 @@ func (s *Service) GetAllMessages(from_client string) ([]model.Message, error) {
 }
 
 // GetAllMessagesToMe returns all messages which is recived
-func (s *Service) GetAllMessagesToMe(from_client string) ([]model.Message, error) {
-	messages, err := s.repository.GetMessageRepository().GetAllToMe(from_client)
+func (s *Service) GetAllMessagesToMe(ctx context.Context, from_client string) ([]model.Message, error) {
+	messages, err := s.repository.GetMessageRepository().GetAllToMe(ctx, from_client)
 	if err != nil {
 		return nil, err
 	}
@@ -34,8 +38,8 @@ func (s *Service) GetAllMessagesToMe(from_client string) ([]model.Message, error
 }
 
 // GetLast returns last X messages
-func (s *Service) GetLast(from_client string, limit string) ([]model.Message, error) {
-	messages, err := s.repository.GetMessageRepository().GetLast(from_client, limit)
+func (s *Service) GetLast(ctx context.Context, from_client string, limit string) ([]model.Message, error) {
+	messages, err := s.repository.GetMessageRepository().GetLast(ctx, from_client, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -43,8 +47,8 @@ func (s *Service) GetLast(from_client string, limit string) ([]model.Message, er
 }
 
 // GetLast returns all messages which is containts a word
-func (s *Service) GetContains(from_client string, word string) ([]model.Message, error) {
-	messages, err := s.repository.GetMessageRepository().GetContains(from_client, word)
+func (s *Service) GetContains(ctx context.Context, from_client string, word string) ([]model.Message, error) {
+	messages, err := s.repository.GetMessageRepository().GetContains(ctx, from_client, word)
 	if err != nil {
 		return nil, err
 	}
@@ -52,11 +56,75 @@ func (s *Service) GetContains(from_client string, word string) ([]model.Message,
 }
 
 // StoreMessage for storing a message
-func (s *Service) StoreMessage(message model.Message) (int64, error) {
-	id, err := s.repository.GetMessageRepository().Store(message)
+func (s *Service) StoreMessage(ctx context.Context, message model.Message) (int64, error) {
+	id, err := s.repository.GetMessageRepository().Store(ctx, message)
 	if err != nil {
 		return -1, err
 	}
 	return id, nil
 
 }
+
+// GetMessage returns the message with the given id
+func (s *Service) GetMessage(ctx context.Context, id int64) (model.Message, error) {
+	return s.repository.GetMessageRepository().Get(ctx, id)
+}
+
+// GetThread returns the whole reply chain which the message belongs to,
+// starting from the root message and ordered by id
+func (s *Service) GetThread(ctx context.Context, id int64) ([]model.Message, error) {
+	root, err := s.repository.GetMessageRepository().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// walk up to the first message of the thread
+	for root.ParentID != 0 {
+		root, err = s.repository.GetMessageRepository().Get(ctx, root.ParentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// collect all replies below the root
+	thread := []model.Message{root}
+	for i := 0; i < len(thread); i++ {
+		replies, err := s.repository.GetMessageRepository().GetReplies(ctx, thread[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		thread = append(thread, replies...)
+	}
+	sort.Slice(thread, func(i, j int) bool { return thread[i].ID < thread[j].ID })
+	return thread, nil
+}
+
+// GetExpired returns the messages which are expired at now, at most limit of them
+func (s *Service) GetExpired(ctx context.Context, now time.Time, limit int) ([]model.Message, error) {
+	return s.repository.GetMessageRepository().GetExpired(ctx, now, limit)
+}
+
+// DeleteMessages removes messages by their ids and returns how many were removed
+func (s *Service) DeleteMessages(ctx context.Context, ids []int64) (int64, error) {
+	return s.repository.GetMessageRepository().Delete(ctx, ids)
+}
+
+// GetPurgeable returns the ids of the oldest messages a retention filter purges, at most limit of them
+func (s *Service) GetPurgeable(ctx context.Context, f model.RetentionFilter, limit int) ([]int64, error) {
+	return s.repository.GetMessageRepository().GetPurgeable(ctx, f, limit)
+}
+
+// CountPurgeable returns the number of messages a retention filter purges
+func (s *Service) CountPurgeable(ctx context.Context, f model.RetentionFilter) (int64, error) {
+	return s.repository.GetMessageRepository().CountPurgeable(ctx, f)
+}
+
+// GetHistory returns the messages of a history filter with an id after afterID, at most limit of them
+func (s *Service) GetHistory(ctx context.Context, f model.HistoryFilter, afterID int64, limit int) ([]model.Message, error) {
+	return s.repository.GetMessageRepository().GetHistory(ctx, f, afterID, limit)
+}
+
+// ResealMessages encrypts the messages with an id after afterID with the active key, at most limit of them,
+// and returns the last id it read, 0 when none is left, and how many messages were updated
+func (s *Service) ResealMessages(ctx context.Context, afterID int64, limit int) (int64, int64, error) {
+	return s.repository.GetMessageRepository().Reseal(ctx, afterID, limit)
+}
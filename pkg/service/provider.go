@@ -1,11 +1,26 @@
 package service
 
-import "github.com/Selahattinn/picus-tcp-message/pkg/service/message"
+import (
+	"context"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/ban"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/block"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/conversation"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/message"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/schedule"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/webhook"
+)
 
 type Config struct{}
 
 type Service interface {
 	GetConfig() *Config
 	GetMessageService() *message.Service
+	GetBanService() *ban.Service
+	GetBlockService() *block.Service
+	GetWebhookService() *webhook.Service
+	GetScheduleService() *schedule.Service
+	GetConversationService() *conversation.Service
+	Ping(ctx context.Context) error
 	Shutdown()
 }
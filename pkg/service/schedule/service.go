@@ -0,0 +1,43 @@
+package schedule
+
+import (
+	"context"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+)
+
+type Service struct {
+	repository repository.Repository
+}
+
+func NewService(repo repository.Repository) (*Service, error) {
+	return &Service{
+		repository: repo,
+	}, nil
+}
+
+// Schedule keeps a message until its time comes, its id is returned
+func (s *Service) Schedule(ctx context.Context, m model.ScheduledMessage) (int64, error) {
+	return s.repository.GetScheduleRepository().Store(ctx, m)
+}
+
+// GetScheduled returns the messages a user scheduled, the next one first
+func (s *Service) GetScheduled(ctx context.Context, from string) ([]model.ScheduledMessage, error) {
+	return s.repository.GetScheduleRepository().GetAll(ctx, from)
+}
+
+// Due returns the messages which should be sent at now
+func (s *Service) Due(ctx context.Context, now time.Time, limit int) ([]model.ScheduledMessage, error) {
+	return s.repository.GetScheduleRepository().GetDue(ctx, now, limit)
+}
+
+// Cancel removes a scheduled message of a user, it reports whether there was one
+func (s *Service) Cancel(ctx context.Context, id int64, from string) (bool, error) {
+	count, err := s.repository.GetScheduleRepository().Delete(ctx, id, from)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -1,14 +1,26 @@
 package service
 
 import (
+	"context"
+
 	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/ban"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/block"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/conversation"
 	"github.com/Selahattinn/picus-tcp-message/pkg/service/message"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/schedule"
+	"github.com/Selahattinn/picus-tcp-message/pkg/service/webhook"
 )
 
 type Provider struct {
-	cfg            *Config
-	repository     repository.Repository
-	messageService *message.Service
+	cfg                 *Config
+	repository          repository.Repository
+	messageService      *message.Service
+	banService          *ban.Service
+	blockService        *block.Service
+	webhookService      *webhook.Service
+	scheduleService     *schedule.Service
+	conversationService *conversation.Service
 }
 
 func NewProvider(cfg *Config, repo repository.Repository) (*Provider, error) {
@@ -16,10 +28,35 @@ func NewProvider(cfg *Config, repo repository.Repository) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
+	banService, err := ban.NewService(repo)
+	if err != nil {
+		return nil, err
+	}
+	blockService, err := block.NewService(repo)
+	if err != nil {
+		return nil, err
+	}
+	webhookService, err := webhook.NewService(repo)
+	if err != nil {
+		return nil, err
+	}
+	scheduleService, err := schedule.NewService(repo)
+	if err != nil {
+		return nil, err
+	}
+	conversationService, err := conversation.NewService(repo)
+	if err != nil {
+		return nil, err
+	}
 	return &Provider{
-		cfg:            cfg,
-		repository:     repo,
-		messageService: messageService,
+		cfg:                 cfg,
+		repository:          repo,
+		messageService:      messageService,
+		banService:          banService,
+		blockService:        blockService,
+		webhookService:      webhookService,
+		scheduleService:     scheduleService,
+		conversationService: conversationService,
 	}, nil
 }
 
@@ -29,6 +66,24 @@ func (p *Provider) GetConfig() *Config {
 func (p *Provider) GetMessageService() *message.Service {
 	return p.messageService
 }
+func (p *Provider) GetBanService() *ban.Service {
+	return p.banService
+}
+func (p *Provider) GetBlockService() *block.Service {
+	return p.blockService
+}
+func (p *Provider) GetWebhookService() *webhook.Service {
+	return p.webhookService
+}
+func (p *Provider) GetScheduleService() *schedule.Service {
+	return p.scheduleService
+}
+func (p *Provider) GetConversationService() *conversation.Service {
+	return p.conversationService
+}
+func (p *Provider) Ping(ctx context.Context) error {
+	return p.repository.Ping(ctx)
+}
 func (p *Provider) Shutdown() {
 	p.repository.Shutdown()
 }
@@ -0,0 +1,39 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/Selahattinn/picus-tcp-message/pkg/repository"
+)
+
+type Service struct {
+	repository repository.Repository
+}
+
+func NewService(repo repository.Repository) (*Service, error) {
+	return &Service{
+		repository: repo,
+	}, nil
+}
+
+// Enqueue keeps a delivery until it is sent
+func (s *Service) Enqueue(ctx context.Context, d model.Delivery) (int64, error) {
+	return s.repository.GetWebhookRepository().Store(ctx, d)
+}
+
+// Due returns the deliveries which should be attempted at now, oldest first
+func (s *Service) Due(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error) {
+	return s.repository.GetWebhookRepository().GetDue(ctx, now, limit)
+}
+
+// Retry schedules the next attempt of a delivery which failed
+func (s *Service) Retry(ctx context.Context, id int64, attempts int, next time.Time) error {
+	return s.repository.GetWebhookRepository().Retry(ctx, id, attempts, next)
+}
+
+// Done removes a delivery which is sent or given up
+func (s *Service) Done(ctx context.Context, id int64) error {
+	return s.repository.GetWebhookRepository().Delete(ctx, id)
+}
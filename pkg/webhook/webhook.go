@@ -0,0 +1,409 @@
+// Package webhook posts events of the server, like sent messages, to configured URLs.
+//
+// Events are stored in a persistent queue before they are sent, so deliveries survive
+// a restart. A failed delivery is retried with exponential backoff until it succeeds
+// or runs out of attempts. Each request is signed with the secret of its webhook :
+// the X-Webhook-Signature header is "sha256=" followed by the hex HMAC-SHA256 of the body.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/metrics"
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/sirupsen/logrus"
+)
+
+// events which can be posted
+const (
+	EventMessage    = "message"
+	EventConnect    = "connect"
+	EventDisconnect = "disconnect"
+	EventKeyword    = "keyword"
+)
+
+// headers of a request
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// defaults of the dispatcher
+const (
+	// limit of a request when the webhook has no timeout
+	defaultTimeout = 10 * time.Second
+
+	// a delivery is dropped after this many failed attempts
+	defaultMaxAttempts = 8
+
+	// backoff between attempts of a delivery
+	retryMinDelay = time.Second
+	retryMaxDelay = time.Hour
+
+	// interval between checks for deliveries to retry
+	pollInterval = time.Second
+
+	// number of deliveries attempted per check
+	batchSize = 100
+
+	// number of events which can wait to be queued
+	bufferSize = 1024
+
+	// limit of an operation of the queue
+	queueTimeout = 5 * time.Second
+)
+
+// Config defines a URL which events are posted to
+type Config struct {
+	URL string `yaml:"url"`
+
+	// key of the signature of requests
+	Secret string `yaml:"secret"`
+
+	// events posted to the URL, all of them when empty
+	Events []string `yaml:"events"`
+
+	// words which trigger a keyword event when a message contains one, case insensitive
+	Keywords []string `yaml:"keywords"`
+
+	// limit of a request, 10s when zero
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// wants reports whether an event is posted to the webhook
+func (c Config) wants(event string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// keyword returns the first keyword a text contains
+func (c Config) keyword(text string) (string, bool) {
+	text = strings.ToLower(text)
+	for _, k := range c.Keywords {
+		if k != "" && strings.Contains(text, strings.ToLower(k)) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// KnownEvent reports whether an event can be posted
+func KnownEvent(event string) bool {
+	switch event {
+	case EventMessage, EventConnect, EventDisconnect, EventKeyword:
+		return true
+	}
+	return false
+}
+
+// Event is the JSON body of a request
+type Event struct {
+	Type string    `json:"event"`
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+
+	// recipient and text of messages
+	To        string `json:"to,omitempty"`
+	Text      string `json:"text,omitempty"`
+	MessageID int64  `json:"message_id,omitempty"`
+	ParentID  int64  `json:"parent_id,omitempty"`
+
+	// word which triggered a keyword event
+	Keyword string `json:"keyword,omitempty"`
+}
+
+// Sign returns the signature of a body : "sha256=" followed by its hex HMAC-SHA256
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether a signature matches a body, for receivers of webhooks
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Queue keeps deliveries until they are sent
+type Queue interface {
+	Enqueue(ctx context.Context, d model.Delivery) (int64, error)
+	Due(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error)
+	Retry(ctx context.Context, id int64, attempts int, next time.Time) error
+	Done(ctx context.Context, id int64) error
+}
+
+// Dispatcher posts events to webhooks, create it with NewDispatcher and start it with Run
+type Dispatcher struct {
+	queue  Queue
+	client *http.Client
+
+	// events waiting to be queued
+	events chan Event
+
+	// guards hooks
+	mu    sync.RWMutex
+	hooks []Config
+
+	// settings replaced in tests
+	now         func() time.Time
+	maxAttempts int
+	minDelay    time.Duration
+	maxDelay    time.Duration
+	poll        time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewDispatcher returns a dispatcher of the webhooks, which keeps deliveries in queue
+func NewDispatcher(queue Queue, hooks []Config) *Dispatcher {
+	return &Dispatcher{
+		queue:       queue,
+		client:      &http.Client{},
+		events:      make(chan Event, bufferSize),
+		hooks:       hooks,
+		now:         time.Now,
+		maxAttempts: defaultMaxAttempts,
+		minDelay:    retryMinDelay,
+		maxDelay:    retryMaxDelay,
+		poll:        pollInterval,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// SetHooks replaces the webhooks, queued deliveries of removed webhooks are dropped
+func (d *Dispatcher) SetHooks(hooks []Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks = hooks
+}
+
+func (d *Dispatcher) getHooks() []Config {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.hooks
+}
+
+// hook returns the webhook of a URL
+func (d *Dispatcher) hook(url string) (Config, bool) {
+	for _, h := range d.getHooks() {
+		if h.URL == url {
+			return h, true
+		}
+	}
+	return Config{}, false
+}
+
+// Emit posts an event to the webhooks which want it, it never blocks :
+// the event is dropped when too many events are waiting
+func (d *Dispatcher) Emit(e Event) {
+	if len(d.getHooks()) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = d.now().UTC()
+	}
+	select {
+	case d.events <- e:
+	default:
+		logrus.WithField("event", e.Type).Warn("webhook queue is full, event dropped")
+	}
+}
+
+// Run queues and sends events until Close is called
+func (d *Dispatcher) Run() {
+	defer close(d.done)
+	ticker := time.NewTicker(d.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-d.events:
+			d.enqueue(e)
+			// queue the events which arrived meanwhile before sending
+			for pending := true; pending; {
+				select {
+				case e := <-d.events:
+					d.enqueue(e)
+				default:
+					pending = false
+				}
+			}
+			d.flush()
+		case <-ticker.C:
+			d.flush()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Close stops the dispatcher, queued deliveries are sent after a restart
+func (d *Dispatcher) Close() {
+	close(d.stop)
+	<-d.done
+}
+
+// enqueue stores a delivery of an event for each webhook which wants it
+func (d *Dispatcher) enqueue(e Event) {
+	for _, h := range d.getHooks() {
+		if h.wants(e.Type) {
+			d.store(h.URL, e)
+		}
+		if e.Type != EventMessage || !h.wants(EventKeyword) {
+			continue
+		}
+		if keyword, ok := h.keyword(e.Text); ok {
+			k := e
+			k.Type = EventKeyword
+			k.Keyword = keyword
+			d.store(h.URL, k)
+		}
+	}
+}
+
+func (d *Dispatcher) store(url string, e Event) {
+	log := logrus.WithFields(logrus.Fields{"url": url, "event": e.Type})
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.WithError(err).Error("unable to encode webhook event")
+		return
+	}
+	now := d.now()
+	ctx, cancel := context.WithTimeout(context.Background(), queueTimeout)
+	defer cancel()
+	_, err = d.queue.Enqueue(ctx, model.Delivery{
+		URL:         url,
+		Event:       e.Type,
+		Payload:     string(payload),
+		NextAttempt: now,
+		CreatedAt:   now,
+	})
+	if err != nil {
+		log.WithError(err).Error("unable to queue webhook delivery")
+	}
+}
+
+// flush attempts the deliveries which are due
+func (d *Dispatcher) flush() {
+	if len(d.getHooks()) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queueTimeout)
+	defer cancel()
+	deliveries, err := d.queue.Due(ctx, d.now(), batchSize)
+	if err != nil {
+		logrus.WithError(err).Error("unable to get webhook deliveries")
+		return
+	}
+	for _, delivery := range deliveries {
+		d.attempt(delivery)
+	}
+}
+
+// attempt sends a delivery, it is retried later when it fails
+func (d *Dispatcher) attempt(delivery model.Delivery) {
+	log := logrus.WithFields(logrus.Fields{"url": delivery.URL, "event": delivery.Event, "delivery": delivery.ID})
+	h, ok := d.hook(delivery.URL)
+	if !ok {
+		log.Info("webhook is removed, delivery dropped")
+		d.finish(log, delivery, "dropped")
+		return
+	}
+
+	err := d.send(h, delivery)
+	if err == nil {
+		d.finish(log, delivery, "sent")
+		return
+	}
+	attempts := delivery.Attempts + 1
+	if attempts >= d.maxAttempts {
+		log.WithError(err).WithField("attempts", attempts).Error("webhook delivery failed, giving up")
+		d.finish(log, delivery, "dropped")
+		return
+	}
+	next := d.now().Add(d.backoff(attempts))
+	log.WithError(err).WithField("attempts", attempts).Info("webhook delivery failed, retrying")
+	metrics.WebhookDeliveries.WithLabelValues("retried").Inc()
+	ctx, cancel := context.WithTimeout(context.Background(), queueTimeout)
+	defer cancel()
+	if err := d.queue.Retry(ctx, delivery.ID, attempts, next); err != nil {
+		log.WithError(err).Error("unable to retry webhook delivery")
+	}
+}
+
+// finish removes a delivery from the queue
+func (d *Dispatcher) finish(log *logrus.Entry, delivery model.Delivery, result string) {
+	metrics.WebhookDeliveries.WithLabelValues(result).Inc()
+	ctx, cancel := context.WithTimeout(context.Background(), queueTimeout)
+	defer cancel()
+	if err := d.queue.Done(ctx, delivery.ID); err != nil {
+		log.WithError(err).Error("unable to remove webhook delivery")
+	}
+}
+
+// backoff returns the delay before the next attempt, doubled on each failure
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.minDelay
+	for i := 1; i < attempts && delay < d.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.maxDelay {
+		delay = d.maxDelay
+	}
+	return delay
+}
+
+// send posts a delivery to its webhook, any status but 2xx is an error
+func (d *Dispatcher) send(h Config, delivery model.Delivery) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tcp-message-webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(h.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Selahattinn/picus-tcp-message/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryQueue keeps deliveries in memory
+type memoryQueue struct {
+	mu         sync.Mutex
+	nextID     int64
+	deliveries []model.Delivery
+}
+
+func (q *memoryQueue) Enqueue(ctx context.Context, d model.Delivery) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	d.ID = q.nextID
+	q.deliveries = append(q.deliveries, d)
+	return d.ID, nil
+}
+
+func (q *memoryQueue) Due(ctx context.Context, now time.Time, limit int) ([]model.Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var due []model.Delivery
+	for _, d := range q.deliveries {
+		if !d.NextAttempt.After(now) && len(due) < limit {
+			due = append(due, d)
+		}
+	}
+	return due, nil
+}
+
+func (q *memoryQueue) Retry(ctx context.Context, id int64, attempts int, next time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.deliveries {
+		if q.deliveries[i].ID == id {
+			q.deliveries[i].Attempts = attempts
+			q.deliveries[i].NextAttempt = next
+		}
+	}
+	return nil
+}
+
+func (q *memoryQueue) Done(ctx context.Context, id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, d := range q.deliveries {
+		if d.ID == id {
+			q.deliveries = append(q.deliveries[:i], q.deliveries[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (q *memoryQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.deliveries)
+}
+
+// request is a request received by a test endpoint
+type request struct {
+	header http.Header
+	body   []byte
+	event  Event
+}
+
+// newEndpoint starts an HTTP server which answers requests with the given statuses in order,
+// then with 200 : received requests are sent to the returned channel
+func newEndpoint(t *testing.T, statuses ...int) (*httptest.Server, chan request) {
+	requests := make(chan request, 10)
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var e Event
+		json.Unmarshal(body, &e)
+		requests <- request{header: r.Header, body: body, event: e}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+// startDispatcher runs a dispatcher which retries quickly until the test ends
+func startDispatcher(t *testing.T, queue Queue, hooks ...Config) *Dispatcher {
+	d := NewDispatcher(queue, hooks)
+	d.minDelay = 10 * time.Millisecond
+	d.maxDelay = 40 * time.Millisecond
+	d.poll = 10 * time.Millisecond
+	go d.Run()
+	t.Cleanup(d.Close)
+	return d
+}
+
+func expectRequest(t *testing.T, requests chan request) request {
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("no request received")
+	}
+	return request{}
+}
+
+func expectNoRequest(t *testing.T, requests chan request) {
+	select {
+	case r := <-requests:
+		t.Fatalf("unexpected %s request", r.event.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// waitEmpty waits until all deliveries of a queue are done
+func waitEmpty(t *testing.T, queue *memoryQueue) {
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("deliveries are still queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"message"}`)
+	signature := Sign("secret", body)
+
+	assert.Equal(t, "sha256=", signature[:7])
+	assert.True(t, Verify("secret", body, signature))
+	assert.False(t, Verify("other", body, signature))
+	assert.False(t, Verify("secret", []byte(`{"event":"connect"}`), signature))
+}
+
+func TestDispatcher_post(t *testing.T) {
+	srv, requests := newEndpoint(t)
+	queue := &memoryQueue{}
+	d := startDispatcher(t, queue, Config{URL: srv.URL, Secret: "secret"})
+
+	d.Emit(Event{Type: EventMessage, User: "alice", To: "bob", Text: "hello", MessageID: 3})
+	r := expectRequest(t, requests)
+	assert.Equal(t, "application/json", r.header.Get("Content-Type"))
+	assert.Equal(t, EventMessage, r.header.Get(EventHeader))
+	assert.Equal(t, "1", r.header.Get(DeliveryHeader))
+	assert.True(t, Verify("secret", r.body, r.header.Get(SignatureHeader)))
+	assert.Equal(t, "alice", r.event.User)
+	assert.Equal(t, "bob", r.event.To)
+	assert.Equal(t, "hello", r.event.Text)
+	assert.Equal(t, int64(3), r.event.MessageID)
+	assert.False(t, r.event.Time.IsZero())
+	waitEmpty(t, queue)
+}
+
+func TestDispatcher_events(t *testing.T) {
+	srv, requests := newEndpoint(t)
+	queue := &memoryQueue{}
+	d := startDispatcher(t, queue, Config{
+		URL:      srv.URL,
+		Secret:   "secret",
+		Events:   []string{EventConnect, EventKeyword},
+		Keywords: []string{"Outage", "down"},
+	})
+
+	// messages are only posted for their keywords
+	d.Emit(Event{Type: EventMessage, User: "alice", Text: "all good"})
+	expectNoRequest(t, requests)
+	d.Emit(Event{Type: EventMessage, User: "alice", Text: "the OUTAGE is over"})
+	r := expectRequest(t, requests)
+	assert.Equal(t, EventKeyword, r.event.Type)
+	assert.Equal(t, "Outage", r.event.Keyword)
+	assert.Equal(t, "the OUTAGE is over", r.event.Text)
+
+	d.Emit(Event{Type: EventDisconnect, User: "alice"})
+	expectNoRequest(t, requests)
+	d.Emit(Event{Type: EventConnect, User: "alice"})
+	assert.Equal(t, EventConnect, expectRequest(t, requests).event.Type)
+}
+
+func TestDispatcher_retry(t *testing.T) {
+	srv, requests := newEndpoint(t, http.StatusInternalServerError, http.StatusBadGateway)
+	queue := &memoryQueue{}
+	d := startDispatcher(t, queue, Config{URL: srv.URL, Secret: "secret"})
+
+	d.Emit(Event{Type: EventConnect, User: "alice"})
+	first := expectRequest(t, requests)
+	second := expectRequest(t, requests)
+	third := expectRequest(t, requests)
+
+	// the same delivery is sent again
+	assert.Equal(t, first.body, second.body)
+	assert.Equal(t, first.body, third.body)
+	assert.Equal(t, first.header.Get(DeliveryHeader), third.header.Get(DeliveryHeader))
+	waitEmpty(t, queue)
+	expectNoRequest(t, requests)
+}
+
+func TestDispatcher_giveUp(t *testing.T) {
+	srv, requests := newEndpoint(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	queue := &memoryQueue{}
+	d := NewDispatcher(queue, []Config{{URL: srv.URL, Secret: "secret"}})
+	d.minDelay = 10 * time.Millisecond
+	d.poll = 10 * time.Millisecond
+	d.maxAttempts = 2
+	go d.Run()
+	t.Cleanup(d.Close)
+
+	d.Emit(Event{Type: EventConnect, User: "alice"})
+	expectRequest(t, requests)
+	expectRequest(t, requests)
+	waitEmpty(t, queue)
+	expectNoRequest(t, requests)
+}
+
+func TestDispatcher_persisted(t *testing.T) {
+	srv, requests := newEndpoint(t)
+	hook := Config{URL: srv.URL, Secret: "secret"}
+
+	// deliveries queued before a restart are sent, unless their webhook is removed
+	queue := &memoryQueue{}
+	now := time.Now()
+	queue.Enqueue(context.Background(), model.Delivery{URL: srv.URL, Event: EventConnect, Payload: `{"event":"connect","user":"alice"}`, NextAttempt: now})
+	queue.Enqueue(context.Background(), model.Delivery{URL: "http://removed.local", Event: EventConnect, Payload: `{}`, NextAttempt: now})
+	startDispatcher(t, queue, hook)
+
+	r := expectRequest(t, requests)
+	assert.Equal(t, "alice", r.event.User)
+	waitEmpty(t, queue)
+}
+
+func TestDispatcher_backoff(t *testing.T) {
+	d := NewDispatcher(&memoryQueue{}, nil)
+
+	assert.Equal(t, time.Second, d.backoff(1))
+	assert.Equal(t, 2*time.Second, d.backoff(2))
+	assert.Equal(t, 64*time.Second, d.backoff(7))
+	assert.Equal(t, time.Hour, d.backoff(40))
+}